@@ -0,0 +1,247 @@
+// Package assets builds a project from what is running in an AWS
+// account: instances, EBS volumes, Elastic IPs, NAT gateways and load
+// balancers. They are read from the EC2 and load balancing APIs, or
+// from saved describe-* output so that this works offline.
+package assets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"io/ioutil"
+	"nephomancy/aws/resources"
+	"nephomancy/common/fetch"
+	"path/filepath"
+	"sort"
+)
+
+// The resources in one region.
+type Inventory struct {
+	Region        string
+	Instances     []*ec2.Instance
+	Volumes       []*ec2.Volume
+	Addresses     []*ec2.Address
+	NatGateways   []*ec2.NatGateway
+	LoadBalancers []*elbv2.LoadBalancer
+	// Classic load balancers.
+	ClassicLoadBalancers []*elb.LoadBalancerDescription
+}
+
+// The parts of the describe-* outputs that go into an inventory. Every
+// output has exactly one of these, so any of them can be decoded into
+// this and the file names don't matter.
+type describeOutput struct {
+	Reservations             []*ec2.Reservation
+	Volumes                  []*ec2.Volume
+	Addresses                []*ec2.Address
+	NatGateways              []*ec2.NatGateway
+	LoadBalancers            []*elbv2.LoadBalancer
+	LoadBalancerDescriptions []*elb.LoadBalancerDescription
+}
+
+func (inv *Inventory) add(out *describeOutput) {
+	for _, r := range out.Reservations {
+		inv.Instances = append(inv.Instances, r.Instances...)
+	}
+	inv.Volumes = append(inv.Volumes, out.Volumes...)
+	inv.Addresses = append(inv.Addresses, out.Addresses...)
+	inv.NatGateways = append(inv.NatGateways, out.NatGateways...)
+	inv.LoadBalancers = append(inv.LoadBalancers, out.LoadBalancers...)
+	inv.ClassicLoadBalancers = append(inv.ClassicLoadBalancers, out.LoadBalancerDescriptions...)
+}
+
+// Reads saved output of the AWS CLI, one subdirectory per region, e.g.
+//
+//	aws ec2 describe-instances --region us-east-1 > dir/us-east-1/instances.json
+//
+// The CLI has to print json, which is its default. Each region can have
+// the output of describe-instances, describe-volumes, describe-addresses
+// and describe-nat-gateways, and of describe-load-balancers for both elbv2
+// and elb. Files that are missing are taken to be empty.
+func ReadInventory(dir string) ([]*Inventory, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var invs []*Inventory
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		inv := &Inventory{Region: e.Name()}
+		files, err := filepath.Glob(filepath.Join(dir, e.Name(), "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			var out describeOutput
+			if err = json.Unmarshal(data, &out); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", f, err)
+			}
+			inv.add(&out)
+		}
+		invs = append(invs, inv)
+	}
+	if len(invs) == 0 {
+		return nil, fmt.Errorf("no region directories in %s", dir)
+	}
+	return invs, nil
+}
+
+// Reads the inventory of a region from the APIs.
+func FetchInventory(ctx context.Context, region string) (*Inventory, error) {
+	sess := resources.NewSession(region)
+	out := &describeOutput{}
+	if err := describeInstances(ctx, ec2.New(sess), out); err != nil {
+		return nil, fmt.Errorf("could not describe instances in %s: %v", region, err)
+	}
+	if err := describeVolumes(ctx, ec2.New(sess), out); err != nil {
+		return nil, fmt.Errorf("could not describe volumes in %s: %v", region, err)
+	}
+	if err := describeAddresses(ctx, ec2.New(sess), out); err != nil {
+		return nil, fmt.Errorf("could not describe addresses in %s: %v", region, err)
+	}
+	if err := describeNatGateways(ctx, ec2.New(sess), out); err != nil {
+		return nil, fmt.Errorf("could not describe nat gateways in %s: %v", region, err)
+	}
+	if err := describeLoadBalancers(ctx, elbv2.New(sess), out); err != nil {
+		return nil, fmt.Errorf("could not describe load balancers in %s: %v", region, err)
+	}
+	if err := describeClassicLoadBalancers(ctx, elb.New(sess), out); err != nil {
+		return nil, fmt.Errorf("could not describe classic load balancers in %s: %v", region, err)
+	}
+	inv := &Inventory{Region: region}
+	inv.add(out)
+	return inv, nil
+}
+
+func describeInstances(ctx context.Context, svc *ec2.EC2, out *describeOutput) error {
+	request := &ec2.DescribeInstancesInput{
+		MaxResults: aws.Int64(100),
+	}
+	for {
+		var page *ec2.DescribeInstancesOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			page, err = svc.DescribeInstancesWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.Reservations = append(out.Reservations, page.Reservations...)
+		if page.NextToken == nil {
+			return nil
+		}
+		request.NextToken = page.NextToken
+	}
+}
+
+func describeVolumes(ctx context.Context, svc *ec2.EC2, out *describeOutput) error {
+	request := &ec2.DescribeVolumesInput{
+		MaxResults: aws.Int64(100),
+	}
+	for {
+		var page *ec2.DescribeVolumesOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			page, err = svc.DescribeVolumesWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.Volumes = append(out.Volumes, page.Volumes...)
+		if page.NextToken == nil {
+			return nil
+		}
+		request.NextToken = page.NextToken
+	}
+}
+
+// DescribeAddresses is not paginated.
+func describeAddresses(ctx context.Context, svc *ec2.EC2, out *describeOutput) error {
+	var page *ec2.DescribeAddressesOutput
+	err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+		var err error
+		page, err = svc.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	out.Addresses = page.Addresses
+	return nil
+}
+
+func describeNatGateways(ctx context.Context, svc *ec2.EC2, out *describeOutput) error {
+	request := &ec2.DescribeNatGatewaysInput{
+		MaxResults: aws.Int64(100),
+	}
+	for {
+		var page *ec2.DescribeNatGatewaysOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			page, err = svc.DescribeNatGatewaysWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.NatGateways = append(out.NatGateways, page.NatGateways...)
+		if page.NextToken == nil {
+			return nil
+		}
+		request.NextToken = page.NextToken
+	}
+}
+
+func describeLoadBalancers(ctx context.Context, svc *elbv2.ELBV2, out *describeOutput) error {
+	request := &elbv2.DescribeLoadBalancersInput{}
+	for {
+		var page *elbv2.DescribeLoadBalancersOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			page, err = svc.DescribeLoadBalancersWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.LoadBalancers = append(out.LoadBalancers, page.LoadBalancers...)
+		if page.NextMarker == nil {
+			return nil
+		}
+		request.Marker = page.NextMarker
+	}
+}
+
+func describeClassicLoadBalancers(ctx context.Context, svc *elb.ELB, out *describeOutput) error {
+	request := &elb.DescribeLoadBalancersInput{}
+	for {
+		var page *elb.DescribeLoadBalancersOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			page, err = svc.DescribeLoadBalancersWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		out.LoadBalancerDescriptions = append(out.LoadBalancerDescriptions,
+			page.LoadBalancerDescriptions...)
+		if page.NextMarker == nil {
+			return nil
+		}
+		request.Marker = page.NextMarker
+	}
+}
@@ -0,0 +1,42 @@
+package assets
+
+import (
+	"context"
+	"nephomancy/aws/fake"
+	"nephomancy/aws/resources"
+	"testing"
+)
+
+func TestFetchInventoryFromFake(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	resources.Endpoint = server.URL
+	defer func() { resources.Endpoint = "" }()
+
+	inv, err := FetchInventory(context.Background(), "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Instances) != 3 {
+		t.Errorf("expected 3 instances from two pages, got %d", len(inv.Instances))
+	}
+	if len(inv.Volumes) != 1 || len(inv.Addresses) != 1 || len(inv.NatGateways) != 1 ||
+		len(inv.LoadBalancers) != 1 || len(inv.ClassicLoadBalancers) != 0 {
+		t.Errorf("unexpected inventory %+v", inv)
+	}
+	p, err := BuildProject("shop", []*Inventory{inv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.InstanceSets) != 2 || p.InstanceSets[0].Count != 2 {
+		t.Errorf("expected the two web servers in one of two instance sets, got %v",
+			p.InstanceSets)
+	}
+	if avm := vmDetails(t, p.InstanceSets[1]); avm.Tenancy != "Dedicated" || avm.Os != "Windows" {
+		t.Errorf("expected a dedicated windows instance, got %+v", avm)
+	}
+	if len(p.Networks) != 1 || p.Networks[0].IpAddresses != 1 ||
+		len(p.Networks[0].Subnetworks[0].Gateways) != 2 {
+		t.Errorf("expected one network with an address and two gateways, got %v", p.Networks)
+	}
+}
@@ -0,0 +1,395 @@
+package assets
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/anypb"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"sort"
+	"strings"
+)
+
+type projectInProgress struct {
+	project *common.Project
+	// Map fingerprints to sets
+	instanceSets map[string]*common.InstanceSet
+	diskSets     map[string]*common.DiskSet
+	// Map vpc ids to networks. Networks without a vpc are
+	// keyed by region.
+	networks map[string]*common.Network
+	// Map instance ids and Elastic IP allocation ids to vpc ids,
+	// for finding the network of an Elastic IP.
+	vpcs map[string]string
+}
+
+// Builds a project from the inventories of one or more regions.
+// Identical instances end up in the same instance set, and volumes
+// of the same type and size in the same disk set, unless their tags
+// differ. Only running and pending instances and volumes that still
+// exist are included. Each VPC becomes a network with a subnetwork
+// for its region, which has the NAT gateways and load balancers as
+// gateways. Elastic IPs count towards the network of the instance or
+// NAT gateway they are associated with.
+func BuildProject(name string, invs []*Inventory) (*common.Project, error) {
+	pip := &projectInProgress{
+		project: &common.Project{
+			Name:         name,
+			InstanceSets: make([]*common.InstanceSet, 0),
+			DiskSets:     make([]*common.DiskSet, 0),
+		},
+		instanceSets: make(map[string]*common.InstanceSet),
+		diskSets:     make(map[string]*common.DiskSet),
+		networks:     make(map[string]*common.Network),
+		vpcs:         make(map[string]string),
+	}
+	for _, inv := range invs {
+		for _, instance := range inv.Instances {
+			if err := pip.addInstance(inv.Region, instance); err != nil {
+				return nil, err
+			}
+		}
+		for _, volume := range inv.Volumes {
+			if err := pip.addVolume(inv.Region, volume); err != nil {
+				return nil, err
+			}
+		}
+		for _, gw := range inv.NatGateways {
+			state := aws.StringValue(gw.State)
+			if state != "pending" && state != "available" {
+				continue
+			}
+			vpc := aws.StringValue(gw.VpcId)
+			for _, a := range gw.NatGatewayAddresses {
+				if a.AllocationId != nil {
+					pip.vpcs[*a.AllocationId] = vpc
+				}
+			}
+			if err := pip.addGateway(inv.Region, vpc, "NAT Gateway",
+				aws.StringValue(gw.NatGatewayId)); err != nil {
+				return nil, err
+			}
+		}
+		for _, lb := range inv.LoadBalancers {
+			if lb.State != nil && aws.StringValue(lb.State.Code) == "failed" {
+				continue
+			}
+			family, err := loadBalancerFamily(aws.StringValue(lb.Type))
+			if err != nil {
+				return nil, err
+			}
+			if err = pip.addGateway(inv.Region, aws.StringValue(lb.VpcId), family,
+				aws.StringValue(lb.LoadBalancerName)); err != nil {
+				return nil, err
+			}
+		}
+		for _, lb := range inv.ClassicLoadBalancers {
+			if err := pip.addGateway(inv.Region, aws.StringValue(lb.VPCId), "Load Balancer",
+				aws.StringValue(lb.LoadBalancerName)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Addresses last, once the vpcs of instances and gateways are known.
+	for _, inv := range invs {
+		for _, a := range inv.Addresses {
+			if err := pip.addAddress(inv.Region, a); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pip.project, nil
+}
+
+// Tags become labels, except for Name, which would keep identical
+// instances apart, and the ones AWS sets.
+func tagsToLabels(tags []*ec2.Tag) map[string]string {
+	var labels map[string]string
+	for _, t := range tags {
+		k := aws.StringValue(t.Key)
+		if k == "Name" || strings.HasPrefix(k, "aws:") {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = aws.StringValue(t.Value)
+	}
+	return labels
+}
+
+func nameTag(tags []*ec2.Tag) string {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == "Name" {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
+}
+
+// Instances only end up in the same set if they have the same labels.
+func fingerprintLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var fp strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&fp, ":%s=%s", k, labels[k])
+	}
+	return fp.String()
+}
+
+// The fingerprints are internal only, like the gcloud ones. Instances
+// with the same fingerprint cost the same.
+func fingerprintVM(avm *resources.Ec2VM) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s", avm.Region, avm.InstanceType,
+		avm.TermType, avm.Tenancy, avm.Os, avm.LicenseModel)
+}
+
+func fingerprintDisk(dsk *resources.Ec2Disk) string {
+	return fmt.Sprintf("%s:%s:%d:%d:%d", dsk.Region, dsk.VolumeType,
+		dsk.ActualSizeGb, dsk.Iops, dsk.Throughput)
+}
+
+func (pip *projectInProgress) addInstance(region string, i *ec2.Instance) error {
+	if i.State != nil {
+		state := aws.StringValue(i.State.Name)
+		if state != "pending" && state != "running" {
+			return nil
+		}
+	}
+	if i.InstanceType == nil {
+		return fmt.Errorf("missing instance type for instance %s",
+			aws.StringValue(i.InstanceId))
+	}
+	if i.VpcId != nil {
+		pip.vpcs[aws.StringValue(i.InstanceId)] = *i.VpcId
+		if _, err := pip.network(region, *i.VpcId); err != nil {
+			return err
+		}
+	}
+	avm := createVM(region, i)
+	labels := tagsToLabels(i.Tags)
+	fp := fingerprintVM(avm) + fingerprintLabels(labels)
+	if vmset := pip.instanceSets[fp]; vmset != nil {
+		vmset.Count++
+		return nil
+	}
+	details, err := ptypes.MarshalAny(avm)
+	if err != nil {
+		return err
+	}
+	name := nameTag(i.Tags)
+	if name == "" {
+		name = aws.StringValue(i.InstanceId)
+	}
+	vmset := &common.InstanceSet{
+		Name: name,
+		Template: &common.Instance{
+			ProviderDetails: map[string]*anypb.Any{
+				resources.AwsProvider: details,
+			},
+		},
+		Count:  1,
+		Labels: labels,
+	}
+	pip.instanceSets[fp] = vmset
+	pip.project.InstanceSets = append(pip.project.InstanceSets, vmset)
+	return nil
+}
+
+func createVM(region string, i *ec2.Instance) *resources.Ec2VM {
+	avm := &resources.Ec2VM{
+		InstanceType: aws.StringValue(i.InstanceType),
+		Region:       region,
+		TermType:     "OnDemand",
+		Tenancy:      "Shared",
+		Os:           "Linux",
+		LicenseModel: "No License required",
+	}
+	if aws.StringValue(i.InstanceLifecycle) == "spot" {
+		avm.TermType = "Spot"
+	}
+	if i.Placement != nil {
+		switch aws.StringValue(i.Placement.Tenancy) {
+		case "dedicated":
+			avm.Tenancy = "Dedicated"
+		case "host":
+			avm.Tenancy = "Host"
+		}
+	}
+	// The platform is only set for Windows. This SDK version doesn't
+	// have the platform details, so RHEL, SUSE and BYOL instances show
+	// up as Linux and licensed Windows; edit the provider details for
+	// those.
+	if aws.StringValue(i.Platform) == "windows" {
+		avm.Os = "Windows"
+	}
+	return avm
+}
+
+func (pip *projectInProgress) addVolume(region string, v *ec2.Volume) error {
+	state := aws.StringValue(v.State)
+	if state == "deleting" || state == "deleted" || state == "error" {
+		return nil
+	}
+	dsk := &resources.Ec2Disk{
+		VolumeType:   aws.StringValue(v.VolumeType),
+		ActualSizeGb: uint64(aws.Int64Value(v.Size)),
+		Region:       region,
+	}
+	// gp2 volumes report the IOPS they get for their size, and so do
+	// gp3 volumes that were not provisioned with more than the baseline.
+	switch dsk.VolumeType {
+	case "io1", "io2":
+		dsk.Iops = uint32(aws.Int64Value(v.Iops))
+	case "gp3":
+		if iops := aws.Int64Value(v.Iops); iops > 3000 {
+			dsk.Iops = uint32(iops)
+		}
+		if tp := aws.Int64Value(v.Throughput); tp > 125 {
+			dsk.Throughput = uint32(tp)
+		}
+	}
+	labels := tagsToLabels(v.Tags)
+	fp := fingerprintDisk(dsk) + fingerprintLabels(labels)
+	if dset := pip.diskSets[fp]; dset != nil {
+		dset.Count++
+		return nil
+	}
+	details, err := ptypes.MarshalAny(dsk)
+	if err != nil {
+		return err
+	}
+	name := nameTag(v.Tags)
+	if name == "" {
+		name = aws.StringValue(v.VolumeId)
+	}
+	dset := &common.DiskSet{
+		Name: name,
+		Template: &common.Disk{
+			ProviderDetails: map[string]*anypb.Any{
+				resources.AwsProvider: details,
+			},
+		},
+		Count:  1,
+		Labels: labels,
+	}
+	pip.diskSets[fp] = dset
+	pip.project.DiskSets = append(pip.project.DiskSets, dset)
+	return nil
+}
+
+// Maps the elbv2 load balancer type to the product family in the
+// price list.
+func loadBalancerFamily(lbType string) (string, error) {
+	switch lbType {
+	case "application", "":
+		return "Load Balancer-Application", nil
+	case "network":
+		return "Load Balancer-Network", nil
+	case "gateway":
+		return "Load Balancer-Gateway", nil
+	}
+	return "", fmt.Errorf("unknown load balancer type %s", lbType)
+}
+
+// Returns the network for the vpc, creating it if necessary. Every
+// network has one subnetwork, since a vpc is in a single region.
+func (pip *projectInProgress) network(region string, vpc string) (*common.Network, error) {
+	key := vpc
+	if key == "" {
+		// Only classic networking and unassociated Elastic IPs have no vpc.
+		key = region
+	}
+	if nw := pip.networks[key]; nw != nil {
+		return nw, nil
+	}
+	nwDetails, err := ptypes.MarshalAny(&resources.Ec2Network{VpcId: vpc})
+	if err != nil {
+		return nil, err
+	}
+	snwDetails, err := ptypes.MarshalAny(&resources.Ec2Subnetwork{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	nw := &common.Network{
+		Name: key,
+		Subnetworks: []*common.Subnetwork{
+			&common.Subnetwork{
+				Name: region,
+				ProviderDetails: map[string]*anypb.Any{
+					resources.AwsProvider: snwDetails,
+				},
+			},
+		},
+		ProviderDetails: map[string]*anypb.Any{
+			resources.AwsProvider: nwDetails,
+		},
+	}
+	pip.networks[key] = nw
+	pip.project.Networks = append(pip.project.Networks, nw)
+	return nw, nil
+}
+
+func (pip *projectInProgress) addGateway(region string, vpc string, family string,
+	name string) error {
+	nw, err := pip.network(region, vpc)
+	if err != nil {
+		return err
+	}
+	details, err := ptypes.MarshalAny(&resources.Ec2Gateway{
+		ProductFamily: family,
+		Name:          name,
+	})
+	if err != nil {
+		return err
+	}
+	snw := nw.Subnetworks[0]
+	snw.Gateways = append(snw.Gateways, &common.Gateway{
+		ProviderDetails: map[string]*anypb.Any{
+			resources.AwsProvider: details,
+		},
+	})
+	return nil
+}
+
+// Elastic IPs that are not associated with an instance or NAT gateway
+// in the inventory count towards the first network in their region.
+func (pip *projectInProgress) addAddress(region string, a *ec2.Address) error {
+	vpc, ok := pip.vpcs[aws.StringValue(a.InstanceId)]
+	if !ok {
+		vpc, ok = pip.vpcs[aws.StringValue(a.AllocationId)]
+	}
+	if !ok {
+		for _, nw := range pip.project.Networks {
+			var snw resources.Ec2Subnetwork
+			details := nw.Subnetworks[0].ProviderDetails[resources.AwsProvider]
+			if err := ptypes.UnmarshalAny(details, &snw); err != nil {
+				return err
+			}
+			if snw.Region == region {
+				var anw resources.Ec2Network
+				if err := ptypes.UnmarshalAny(nw.ProviderDetails[resources.AwsProvider],
+					&anw); err != nil {
+					return err
+				}
+				vpc = anw.VpcId
+				break
+			}
+		}
+	}
+	nw, err := pip.network(region, vpc)
+	if err != nil {
+		return err
+	}
+	nw.IpAddresses++
+	return nil
+}
@@ -0,0 +1,122 @@
+package assets
+
+import (
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/ptypes"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"testing"
+)
+
+func vmDetails(t *testing.T, vmset *common.InstanceSet) *resources.Ec2VM {
+	var avm resources.Ec2VM
+	if err := ptypes.UnmarshalAny(vmset.Template.ProviderDetails[resources.AwsProvider], &avm); err != nil {
+		t.Fatal(err)
+	}
+	return &avm
+}
+
+func gatewayFamilies(t *testing.T, snw *common.Subnetwork) []string {
+	var families []string
+	for _, gw := range snw.Gateways {
+		var agw resources.Ec2Gateway
+		if err := ptypes.UnmarshalAny(gw.ProviderDetails[resources.AwsProvider], &agw); err != nil {
+			t.Fatal(err)
+		}
+		families = append(families, agw.ProductFamily+" "+agw.Name)
+	}
+	return families
+}
+
+func TestBuildProjectFromSavedOutput(t *testing.T) {
+	invs, err := ReadInventory("testdata/inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invs) != 2 || invs[0].Region != "eu-central-1" || invs[1].Region != "us-east-1" {
+		t.Fatalf("expected inventories for eu-central-1 and us-east-1, got %d", len(invs))
+	}
+	if len(invs[1].Instances) != 5 || len(invs[1].ClassicLoadBalancers) != 1 {
+		t.Errorf("expected 5 instances and 1 classic load balancer in us-east-1, got %d and %d",
+			len(invs[1].Instances), len(invs[1].ClassicLoadBalancers))
+	}
+	p, err := BuildProject("shop", invs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "shop" {
+		t.Errorf("expected project shop, got %s", p.Name)
+	}
+
+	type set struct {
+		Name   string
+		Count  uint32
+		Labels map[string]string
+		VM     *resources.Ec2VM
+	}
+	var sets []set
+	for _, vmset := range p.InstanceSets {
+		sets = append(sets, set{vmset.Name, vmset.Count, vmset.Labels, vmDetails(t, vmset)})
+	}
+	linux := func(it, region, term, tenancy string) *resources.Ec2VM {
+		return &resources.Ec2VM{InstanceType: it, Region: region, TermType: term,
+			Tenancy: tenancy, Os: "Linux", LicenseModel: "No License required"}
+	}
+	windows := linux("m5.large", "us-east-1", "OnDemand", "Shared")
+	windows.Os = "Windows"
+	want := []set{
+		{"batch", 1, nil, linux("m5.large", "eu-central-1", "OnDemand", "Dedicated")},
+		// The terminated instance is left out, the Name and aws: tags
+		// don't keep the web servers apart.
+		{"web", 2, map[string]string{"team": "frontend"}, linux("t3.micro", "us-east-1", "OnDemand", "Shared")},
+		{"reports", 1, nil, windows},
+		{"i-0a1b2c3d4e5f60004", 1, map[string]string{"team": "frontend"},
+			linux("t3.micro", "us-east-1", "Spot", "Shared")},
+	}
+	if diff := deep.Equal(want, sets); diff != nil {
+		t.Errorf("unexpected instance sets: %v", diff)
+	}
+
+	var disks []string
+	for _, dset := range p.DiskSets {
+		var dsk resources.Ec2Disk
+		if err := ptypes.UnmarshalAny(dset.Template.ProviderDetails[resources.AwsProvider], &dsk); err != nil {
+			t.Fatal(err)
+		}
+		disks = append(disks, fmt.Sprintf("%s %s %d", dset.Name, fingerprintDisk(&dsk), dset.Count))
+	}
+	// gp3 at the baseline has no provisioned IOPS or throughput.
+	wantDisks := []string{"vol-0001 us-east-1:gp3:8:0:0 2", "reports-data us-east-1:io2:100:5000:0 1"}
+	if diff := deep.Equal(wantDisks, disks); diff != nil {
+		t.Errorf("unexpected disk sets: %v", diff)
+	}
+
+	if len(p.Networks) != 2 {
+		t.Fatalf("expected networks for two vpcs, got %d", len(p.Networks))
+	}
+	eu, shop := p.Networks[0], p.Networks[1]
+	if eu.Name != "vpc-0eu" || eu.IpAddresses != 1 || len(eu.Subnetworks[0].Gateways) != 0 {
+		t.Errorf("expected vpc-0eu with the unassociated address, got %s with %d",
+			eu.Name, eu.IpAddresses)
+	}
+	// One address on an instance, one on the NAT gateway, one unassociated.
+	if shop.Name != "vpc-0shop" || shop.IpAddresses != 3 {
+		t.Errorf("expected vpc-0shop with 3 addresses, got %s with %d",
+			shop.Name, shop.IpAddresses)
+	}
+	if snw := shop.Subnetworks[0]; snw.Name != "us-east-1" {
+		t.Errorf("expected a subnetwork in us-east-1, got %s", snw.Name)
+	}
+	wantGateways := []string{"NAT Gateway nat-0001", "Load Balancer-Application web",
+		"Load Balancer legacy"}
+	if diff := deep.Equal(wantGateways, gatewayFamilies(t, shop.Subnetworks[0])); diff != nil {
+		t.Errorf("unexpected gateways: %v", diff)
+	}
+}
+
+func TestReadInventoryWithoutRegions(t *testing.T) {
+	if _, err := ReadInventory("testdata/inventory/us-east-1"); err == nil {
+		t.Errorf("expected an error for a directory without region subdirectories")
+	}
+}
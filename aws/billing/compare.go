@@ -0,0 +1,173 @@
+package billing
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"nephomancy/aws/cache"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"nephomancy/common/utils"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Estimated and actual cost of an instance set or of unmatched spend, in
+// USD over the report's period.
+type Variance struct {
+	// The instance set name, or the product code and usage type.
+	Name        string
+	Description string
+	Estimated   float64
+	Actual      float64
+}
+
+type Report struct {
+	// The usage period the line items cover. Estimates are scaled from
+	// monthly costs to it.
+	Start time.Time
+	End   time.Time
+	Sets  []Variance
+	// Spend that does not belong to any instance set, per usage type.
+	Unmatched []Variance
+}
+
+// An instance set with the details line items are matched against.
+type instanceSet struct {
+	*common.InstanceSet
+	avm      resources.Ec2VM
+	variance *Variance
+}
+
+// Matches instance usage in the line items to the project's instance sets,
+// by instance type, region, and whether the usage is on demand, spot or
+// dedicated. Usage that only matches by instance type and region goes to
+// those sets. When several sets match, the cost is split between them by
+// instance count. Estimates are the projected costs per set in lines, as
+// returned by the provider's GetCost.
+func Compare(p *common.Project, lines [][]string, items []LineItem) (*Report, error) {
+	r := &Report{}
+	for _, li := range items {
+		if !li.UsageStart.IsZero() && (r.Start.IsZero() || li.UsageStart.Before(r.Start)) {
+			r.Start = li.UsageStart
+		}
+		if li.UsageEnd.After(r.End) {
+			r.End = li.UsageEnd
+		}
+	}
+	if r.Start.IsZero() || !r.End.After(r.Start) {
+		return nil, fmt.Errorf("the line items need usage start and end times")
+	}
+	scale := r.End.Sub(r.Start).Hours() / 730
+
+	var sets []*instanceSet
+	for _, vmset := range p.InstanceSets {
+		details := vmset.Template.ProviderDetails[resources.AwsProvider]
+		if details == nil {
+			continue
+		}
+		s := &instanceSet{InstanceSet: vmset}
+		if err := ptypes.UnmarshalAny(details, &s.avm); err != nil {
+			return nil, fmt.Errorf("instance set %s: %v", vmset.Name, err)
+		}
+		var setLines [][]string
+		for _, line := range lines {
+			if len(line) > 2 && line[2] == vmset.Name {
+				setLines = append(setLines, line)
+			}
+		}
+		_, projected := utils.SumCosts(setLines)
+		for cur, amount := range projected {
+			if cur != "USD" && amount != 0 {
+				return nil, fmt.Errorf("estimate for %s in %s, expected USD", vmset.Name, cur)
+			}
+		}
+		s.variance = &Variance{
+			Name:        vmset.Name,
+			Description: fmt.Sprintf("%d %s in %s", vmset.Count, s.avm.InstanceType, s.avm.Region),
+			Estimated:   projected["USD"] * scale,
+		}
+		sets = append(sets, s)
+	}
+
+	unmatched := make(map[string]*Variance)
+	for _, li := range items {
+		if li.Currency != "" && li.Currency != "USD" {
+			return nil, fmt.Errorf("line item for %s in %s, expected USD", li.UsageType, li.Currency)
+		}
+		matched := matchingSets(sets, li)
+		if len(matched) == 0 {
+			key := li.UsageType
+			if li.ProductCode != "" {
+				key = li.ProductCode + " " + li.UsageType
+			}
+			if li.LineItemType == "Tax" || li.LineItemType == "Credit" || li.LineItemType == "Refund" {
+				key = li.LineItemType
+			}
+			u := unmatched[key]
+			if u == nil {
+				u = &Variance{Name: key, Description: li.Description}
+				unmatched[key] = u
+			}
+			u.Actual += li.Cost
+			continue
+		}
+		var instances uint32
+		for _, s := range matched {
+			instances += s.Count
+		}
+		for _, s := range matched {
+			if instances > 0 {
+				s.variance.Actual += li.Cost * float64(s.Count) / float64(instances)
+			} else {
+				s.variance.Actual += li.Cost / float64(len(matched))
+			}
+		}
+	}
+
+	for _, s := range sets {
+		r.Sets = append(r.Sets, *s.variance)
+	}
+	for _, u := range unmatched {
+		r.Unmatched = append(r.Unmatched, *u)
+	}
+	sort.Slice(r.Unmatched, func(i, j int) bool {
+		if r.Unmatched[i].Actual != r.Unmatched[j].Actual {
+			return r.Unmatched[i].Actual > r.Unmatched[j].Actual
+		}
+		return r.Unmatched[i].Name < r.Unmatched[j].Name
+	})
+	return r, nil
+}
+
+// Returns the instance sets a line item is for, preferring the ones whose
+// term type and tenancy match the usage.
+func matchingSets(sets []*instanceSet, li LineItem) []*instanceSet {
+	if li.ProductCode != "" && li.ProductCode != "AmazonEC2" {
+		return nil
+	}
+	usage, err := cache.ParseUsageType(li.UsageType)
+	if err != nil || !strings.HasSuffix(usage, "Usage") {
+		return nil
+	}
+	itype := li.InstanceType
+	if itype == "" {
+		itype = li.UsageType[strings.Index(li.UsageType, ":")+1:]
+	}
+	var loose, strict []*instanceSet
+	for _, s := range sets {
+		if s.avm.InstanceType != itype || (li.Region != "" && s.avm.Region != li.Region) {
+			continue
+		}
+		loose = append(loose, s)
+		spot := strings.HasPrefix(usage, "Spot")
+		dedicated := strings.HasPrefix(usage, "Dedicated")
+		if spot == (s.avm.TermType == "Spot") && dedicated == (s.avm.Tenancy == "Dedicated") {
+			strict = append(strict, s)
+		}
+	}
+	if len(strict) > 0 {
+		return strict
+	}
+	return loose
+}
@@ -0,0 +1,72 @@
+package billing
+
+import (
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/anypb"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func ec2Set(t *testing.T, name string, count uint32, avm *resources.Ec2VM) *common.InstanceSet {
+	details, err := ptypes.MarshalAny(avm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &common.InstanceSet{
+		Name:  name,
+		Count: count,
+		Template: &common.Instance{
+			ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: details},
+		},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	items, err := ReadCurFile("testdata/cur.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &common.Project{
+		Name: "shop",
+		InstanceSets: []*common.InstanceSet{
+			ec2Set(t, "web", 3, &resources.Ec2VM{InstanceType: "t3.medium",
+				Region: "us-east-1", TermType: "OnDemand", Tenancy: "Shared"}),
+			ec2Set(t, "workers", 2, &resources.Ec2VM{InstanceType: "t3.medium",
+				Region: "us-east-1", TermType: "Spot", Tenancy: "Shared"}),
+			ec2Set(t, "db", 1, &resources.Ec2VM{InstanceType: "m5.large",
+				Region: "eu-central-1", TermType: "Reserved", Tenancy: "Shared"}),
+		},
+	}
+	// The report covers 730 hours, so the estimates are not scaled.
+	lines := [][]string{
+		{"shop", "aws", "web", "VM", "3", "", "", "90.00 USD", "", "85.00 USD"},
+		{"shop", "aws", "workers", "VM", "2", "", "", "30.00 USD", "", "10.00 USD"},
+		{"shop", "aws", "db", "VM", "1", "", "", "50.00 USD", "", "50.00 USD"},
+	}
+	r, err := Compare(p, lines, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(precision int) { deep.FloatPrecision = precision }(deep.FloatPrecision)
+	deep.FloatPrecision = 6
+	wantSets := []Variance{
+		{"web", "3 t3.medium in us-east-1", 85, 80},
+		{"workers", "2 t3.medium in us-east-1", 10, 9},
+		{"db", "1 m5.large in eu-central-1", 50, 55},
+	}
+	if diff := deep.Equal(r.Sets, wantSets); diff != nil {
+		t.Errorf("sets: %v", diff)
+	}
+	var unmatched []string
+	for _, u := range r.Unmatched {
+		unmatched = append(unmatched, u.Name)
+	}
+	wantUnmatched := []string{"AmazonEC2 BoxUsage:c5.xlarge", "AmazonEC2 EBS:VolumeUsage.gp2",
+		"AmazonEC2 DataTransfer-Out-Bytes", "Tax", "AmazonS3 TimedStorage-ByteHrs"}
+	if diff := deep.Equal(unmatched, wantUnmatched); diff != nil {
+		t.Errorf("unmatched: %v", diff)
+	}
+}
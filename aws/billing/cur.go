@@ -0,0 +1,176 @@
+// Package billing reads AWS Cost and Usage Reports and compares what was
+// billed with what nephomancy estimated.
+package billing
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One line item of a Cost and Usage Report.
+type LineItem struct {
+	// Usage, DiscountedUsage, SavingsPlanCoveredUsage, Tax, Credit etc.
+	LineItemType string
+	ProductCode  string
+	UsageType    string
+	Operation    string
+	Description  string
+	ResourceId   string
+	// The product's instance type and region, empty for most products
+	// other than instances.
+	InstanceType string
+	Region       string
+	UsageStart   time.Time
+	UsageEnd     time.Time
+	UsageAmount  float64
+	// Amortized: reserved instance and savings plan usage is charged at
+	// its effective cost, and their fees are left out.
+	Cost     float64
+	Currency string
+}
+
+// Reads a report saved as .csv, .csv.gz or .parquet, the way AWS
+// delivers them to S3.
+func ReadCurFile(path string) ([]LineItem, error) {
+	lower := strings.ToLower(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(lower, ".parquet") {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		items, err := ReadParquet(f, fi.Size())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		return items, nil
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(lower, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ReadCsv(r)
+}
+
+// Column names are lineItem/UsageType in reports delivered to S3 and
+// line_item_usage_type in reports for Athena, so both are normalized to
+// lineitemusagetype.
+func normalizeColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer("/", "", "_", "", ":", "").Replace(name)
+}
+
+// Reads a report as csv.
+func ReadCsv(r io.Reader) ([]LineItem, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header: %v", err)
+	}
+	return readItems(header, 2, cr.Read)
+}
+
+// Reads line items from records with the columns in header, until next
+// returns io.EOF. Errors give the line number, starting at firstLine.
+func readItems(header []string, firstLine int, next func() ([]string, error)) ([]LineItem, error) {
+	columns := make(map[string]int)
+	for idx, name := range header {
+		columns[normalizeColumn(name)] = idx
+	}
+	for _, required := range []string{"lineitemusagetype", "lineitemunblendedcost"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %s", required)
+		}
+	}
+	var items []LineItem
+	for lineNo := firstLine; ; lineNo++ {
+		record, err := next()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		number := func(name string) (float64, error) {
+			v := value(name)
+			if v == "" {
+				return 0, nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %s: %v", lineNo, name, err)
+			}
+			return f, nil
+		}
+		li := LineItem{
+			LineItemType: value("lineitemlineitemtype"),
+			ProductCode:  value("lineitemproductcode"),
+			UsageType:    value("lineitemusagetype"),
+			Operation:    value("lineitemoperation"),
+			Description:  value("lineitemlineitemdescription"),
+			ResourceId:   value("lineitemresourceid"),
+			InstanceType: value("productinstancetype"),
+			Region:       value("productregion"),
+			Currency:     value("lineitemcurrencycode"),
+		}
+		if li.UsageAmount, err = number("lineitemusageamount"); err != nil {
+			return nil, err
+		}
+		costColumn := "lineitemunblendedcost"
+		switch li.LineItemType {
+		case "DiscountedUsage":
+			costColumn = "reservationeffectivecost"
+		case "SavingsPlanCoveredUsage":
+			costColumn = "savingsplansavingsplaneffectivecost"
+		case "RIFee", "SavingsPlanRecurringFee", "SavingsPlanNegation":
+			// Already in the effective costs.
+			continue
+		}
+		if li.Cost, err = number(costColumn); err != nil {
+			return nil, err
+		}
+		if li.UsageStart, err = parseTime(value("lineitemusagestartdate")); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if li.UsageEnd, err = parseTime(value("lineitemusageenddate")); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		items = append(items, li)
+	}
+}
+
+// Reports delivered to S3 use RFC 3339, Athena uses a space instead of T.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05.000",
+		"2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", value)
+}
@@ -0,0 +1,79 @@
+package billing
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestReadCurFile(t *testing.T) {
+	items, err := ReadCurFile("testdata/cur.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The RIFee and SavingsPlanNegation lines are left out.
+	if len(items) != 10 {
+		t.Fatalf("expected 10 line items, got %d", len(items))
+	}
+	if li := items[2]; li.LineItemType != "SavingsPlanCoveredUsage" || li.Cost != 20 {
+		t.Errorf("expected the savings plan effective cost, got %+v", li)
+	}
+	if li := items[4]; li.LineItemType != "DiscountedUsage" || li.Cost != 55 ||
+		li.InstanceType != "m5.large" || li.Region != "eu-central-1" {
+		t.Errorf("expected the reservation effective cost, got %+v", li)
+	}
+
+	// Reports are delivered gzipped.
+	data, err := ioutil.ReadFile("testdata/cur.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "cur")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gzFile := filepath.Join(dir, "cur-00001.csv.gz")
+	f, err := os.Create(gzFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write(data)
+	gz.Close()
+	f.Close()
+	gzItems, err := ReadCurFile(gzFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(gzItems, items); diff != nil {
+		t.Error(diff)
+	}
+
+	// The same report in Parquet as Athena names the columns, with a map
+	// of resource tags that is left out.
+	for _, name := range []string{"cur.snappy.parquet", "cur-v2.gz.parquet"} {
+		pqItems, err := ReadCurFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(pqItems, items); diff != nil {
+			t.Errorf("%s: %v", name, diff)
+		}
+	}
+	if _, err = ReadCurFile(gzFile + ".parquet"); err == nil {
+		t.Error("expected an error for a missing parquet file")
+	}
+}
+
+func TestNormalizeColumn(t *testing.T) {
+	for _, name := range []string{"lineItem/UsageType", "line_item_usage_type"} {
+		if got := normalizeColumn(name); got != "lineitemusagetype" {
+			t.Errorf("normalizeColumn(%q) = %q", name, got)
+		}
+	}
+}
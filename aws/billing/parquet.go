@@ -0,0 +1,998 @@
+package billing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A reader for the Parquet files that Cost and Usage Reports are
+// delivered as. It handles what reports use: flat columns of strings,
+// numbers and timestamps, plain, dictionary and delta encodings, data
+// pages of both versions, and Snappy or gzip compression. Repeated columns, such
+// as resource tags in a map, are left out.
+
+var parquetMagic = []byte("PAR1")
+
+// More rows than any report has in a row group, so that a corrupt count
+// fails instead of running out of memory.
+const maxParquetRows = 1 << 24
+
+// Parquet physical types.
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// A column of the file, with how its values are formatted as strings.
+type parquetColumn struct {
+	Name       string
+	Type       int64
+	TypeLength int
+	// Levels above which the value is null or repeated.
+	MaxDef int
+	MaxRep int
+	// "decimal", "date", "millis", "micros", "nanos" or "".
+	Format string
+	Scale  int
+}
+
+// Reads the line items of a report in Parquet format. Columns are named
+// by their path joined with /, e.g. line_item_usage_type. Only one row
+// group is held in memory at a time.
+func ReadParquet(r io.ReaderAt, size int64) ([]LineItem, error) {
+	columns, rowGroups, err := readParquetFooter(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var header []string
+	var flat []int
+	for idx, c := range columns {
+		if c.MaxRep == 0 {
+			header = append(header, c.Name)
+			flat = append(flat, idx)
+		}
+	}
+	var values [][]string
+	group, row, numRows := 0, 0, 0
+	return readItems(header, 1, func() ([]string, error) {
+		for row == numRows {
+			if group == len(rowGroups) {
+				return nil, io.EOF
+			}
+			var err error
+			if values, numRows, err = readRowGroup(r, size, rowGroups[group], columns, flat); err != nil {
+				return nil, err
+			}
+			group++
+			row = 0
+		}
+		record := make([]string, len(flat))
+		for i := range flat {
+			record[i] = values[i][row]
+		}
+		row++
+		return record, nil
+	})
+}
+
+// Reads the values of the given columns in a row group, and returns
+// them with the number of rows.
+func readRowGroup(r io.ReaderAt, size int64, rg thriftStruct, columns []parquetColumn,
+	flat []int) ([][]string, int, error) {
+	chunks := rg.list(1)
+	if len(chunks) != len(columns) {
+		return nil, 0, fmt.Errorf("row group has %d columns but the schema %d",
+			len(chunks), len(columns))
+	}
+	numRows := rg.int(3)
+	if numRows < 0 || numRows > maxParquetRows {
+		return nil, 0, fmt.Errorf("row group has %d rows", numRows)
+	}
+	values := make([][]string, len(flat))
+	for i, idx := range flat {
+		cc, ok := chunks[idx].(thriftStruct)
+		if !ok {
+			return nil, 0, fmt.Errorf("malformed column chunk")
+		}
+		var err error
+		if values[i], err = readParquetColumn(r, size, cc, columns[idx], int(numRows)); err != nil {
+			return nil, 0, fmt.Errorf("column %s: %v", columns[idx].Name, err)
+		}
+	}
+	return values, int(numRows), nil
+}
+
+// Reads the file metadata at the end of the file: the leaf columns of
+// the schema and the row groups.
+func readParquetFooter(r io.ReaderAt, size int64) ([]parquetColumn, []thriftStruct, error) {
+	if size < 12 {
+		return nil, nil, fmt.Errorf("too short for a parquet file")
+	}
+	tail := make([]byte, 8)
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(tail[4:], parquetMagic) {
+		return nil, nil, fmt.Errorf("not a parquet file")
+	}
+	length := int64(binary.LittleEndian.Uint32(tail))
+	if length > size-12 {
+		return nil, nil, fmt.Errorf("footer of %d bytes is longer than the file", length)
+	}
+	footer := make([]byte, length)
+	if _, err := r.ReadAt(footer, size-8-length); err != nil {
+		return nil, nil, err
+	}
+	meta, err := (&thriftReader{buf: footer}).readStruct()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the file metadata: %v", err)
+	}
+	columns, err := flattenSchema(meta.list(2))
+	if err != nil {
+		return nil, nil, err
+	}
+	var rowGroups []thriftStruct
+	for _, rg := range meta.list(4) {
+		s, ok := rg.(thriftStruct)
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed row group")
+		}
+		rowGroups = append(rowGroups, s)
+	}
+	return columns, rowGroups, nil
+}
+
+// Returns the leaf columns of a schema, which is a tree flattened depth
+// first, with the root first.
+func flattenSchema(elements []interface{}) ([]parquetColumn, error) {
+	var columns []parquetColumn
+	pos := 0
+	var walk func(path []string, def int, rep int) error
+	walk = func(path []string, def int, rep int) error {
+		if pos >= len(elements) {
+			return fmt.Errorf("schema ends early")
+		}
+		e, ok := elements[pos].(thriftStruct)
+		if !ok {
+			return fmt.Errorf("malformed schema element")
+		}
+		if pos > 0 {
+			path = append(path[:len(path):len(path)], e.str(4))
+		}
+		pos++
+		switch e.int(3) {
+		case 1: // optional
+			def++
+		case 2: // repeated
+			def++
+			rep++
+		}
+		children := int(e.int(5))
+		if children == 0 {
+			if !e.has(1) {
+				return nil
+			}
+			if e.int(1) == parquetFixedLenByteArray && e.int(2) <= 0 {
+				return fmt.Errorf("fixed length column of %d bytes", e.int(2))
+			}
+			c := parquetColumn{Name: strings.Join(path, "/"), Type: e.int(1),
+				TypeLength: int(e.int(2)), MaxDef: def, MaxRep: rep, Scale: int(e.int(7))}
+			logical := e.st(10)
+			switch {
+			case e.int(6) == 5 || logical.has(5):
+				c.Format = "decimal"
+				if logical.has(5) {
+					c.Scale = int(logical.st(5).int(1))
+				}
+			case e.int(6) == 6 || logical.has(6):
+				c.Format = "date"
+			case e.int(6) == 9:
+				c.Format = "millis"
+			case e.int(6) == 10:
+				c.Format = "micros"
+			case logical.has(8):
+				unit := logical.st(8).st(2)
+				switch {
+				case unit.has(1):
+					c.Format = "millis"
+				case unit.has(2):
+					c.Format = "micros"
+				case unit.has(3):
+					c.Format = "nanos"
+				}
+			}
+			// Decimals have at most 38 digits.
+			if c.Scale < 0 || c.Scale > 38 {
+				return fmt.Errorf("decimal scale %d", c.Scale)
+			}
+			columns = append(columns, c)
+			return nil
+		}
+		for i := 0; i < children; i++ {
+			if err := walk(path, def, rep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("no schema")
+	}
+	// The root's repetition doesn't count.
+	root, _ := elements[0].(thriftStruct)
+	delete(root, 3)
+	if err := walk(nil, 0, 0); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// Reads the values of a flat column chunk as strings, with "" for nulls.
+// The chunk must have one value for each of the numRows rows.
+func readParquetColumn(r io.ReaderAt, size int64, cc thriftStruct, c parquetColumn,
+	numRows int) ([]string, error) {
+	meta := cc.st(3)
+	if meta == nil {
+		return nil, fmt.Errorf("column chunk without metadata")
+	}
+	codec := meta.int(4)
+	if numValues := meta.int(5); numValues != int64(numRows) {
+		return nil, fmt.Errorf("column chunk has %d values for %d rows", numValues, numRows)
+	}
+	start := meta.int(9)
+	if dict := meta.int(11); meta.has(11) && dict > 0 && dict < start {
+		start = dict
+	}
+	length := meta.int(7)
+	if start < 0 || length < 0 || length > size-start {
+		return nil, fmt.Errorf("column chunk of %d bytes at %d is outside the file", length, start)
+	}
+	buf := make([]byte, length)
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	tr := &thriftReader{buf: buf}
+	var dict []string
+	values := make([]string, 0, numRows)
+	for len(values) < numRows {
+		h, err := tr.readStruct()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page header: %v", err)
+		}
+		compressed := int(h.int(3))
+		if compressed < 0 || tr.pos+compressed > len(buf) {
+			return nil, fmt.Errorf("page of %d bytes is longer than the column chunk", compressed)
+		}
+		page := buf[tr.pos : tr.pos+compressed]
+		tr.pos += compressed
+		uncompressed := int(h.int(2))
+		// The number of values of a data page, which can't be more than
+		// are left in the chunk.
+		pageValues := func(dh thriftStruct) (int, error) {
+			n := dh.int(1)
+			if n < 0 || n > int64(numRows-len(values)) {
+				return 0, fmt.Errorf("page has %d values, %d are left in the chunk",
+					n, numRows-len(values))
+			}
+			return int(n), nil
+		}
+		switch h.int(1) {
+		case 2: // dictionary page
+			data, err := decompress(codec, page, uncompressed)
+			if err != nil {
+				return nil, err
+			}
+			n := h.st(7).int(1)
+			if n < 0 || n > int64(len(data))*8 {
+				return nil, fmt.Errorf("dictionary of %d values in %d bytes", n, len(data))
+			}
+			if dict, err = decodePlain(data, c, int(n)); err != nil {
+				return nil, err
+			}
+		case 0: // data page
+			dh := h.st(5)
+			data, err := decompress(codec, page, uncompressed)
+			if err != nil {
+				return nil, err
+			}
+			n, err := pageValues(dh)
+			if err != nil {
+				return nil, err
+			}
+			var levels []int
+			if c.MaxDef > 0 {
+				if len(data) < 4 {
+					return nil, fmt.Errorf("data page too short")
+				}
+				length := int(binary.LittleEndian.Uint32(data))
+				// Some writers put empty repetition levels first even
+				// though the column isn't repeated. Levels of any values
+				// take at least a byte, so this can't be them.
+				if length == 0 && c.MaxRep == 0 && n > 0 && len(data) >= 8 {
+					data = data[4:]
+					length = int(binary.LittleEndian.Uint32(data))
+				}
+				if 4+length > len(data) {
+					return nil, fmt.Errorf("definition levels longer than the page")
+				}
+				if levels, err = decodeHybrid(data[4:4+length], bits.Len(uint(c.MaxDef)), n); err != nil {
+					return nil, err
+				}
+				data = data[4+length:]
+			}
+			if values, err = appendPage(values, data, dh.int(2), levels, n, c, dict); err != nil {
+				return nil, err
+			}
+		case 3: // data page v2, levels are never compressed
+			dh := h.st(8)
+			n, err := pageValues(dh)
+			if err != nil {
+				return nil, err
+			}
+			if dh.int(6) < 0 || dh.int(5) < 0 || dh.int(6) > int64(len(page)) ||
+				dh.int(5) > int64(len(page))-dh.int(6) {
+				return nil, fmt.Errorf("levels longer than the page")
+			}
+			repLength := int(dh.int(6))
+			defLength := int(dh.int(5))
+			var levels []int
+			if c.MaxDef > 0 {
+				levels, err = decodeHybrid(page[repLength:repLength+defLength],
+					bits.Len(uint(c.MaxDef)), n)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data := page[repLength+defLength:]
+			if !dh.has(7) || dh.bool(7) {
+				data, err = decompress(codec, data, uncompressed-repLength-defLength)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if values, err = appendPage(values, data, dh.int(4), levels, n, c, dict); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// Decodes the values of a data page and appends them, with "" where the
+// definition level says the value is null.
+func appendPage(values []string, data []byte, encoding int64, levels []int, n int,
+	c parquetColumn, dict []string) ([]string, error) {
+	present := n
+	if levels != nil {
+		present = 0
+		for _, l := range levels {
+			if l == c.MaxDef {
+				present++
+			}
+		}
+	}
+	var decoded []string
+	switch encoding {
+	case 0: // plain
+		var err error
+		if decoded, err = decodePlain(data, c, present); err != nil {
+			return nil, err
+		}
+	case 2, 8: // plain or rle dictionary
+		if len(data) == 0 {
+			if present > 0 {
+				return nil, fmt.Errorf("empty dictionary indices")
+			}
+			break
+		}
+		if data[0] > 32 {
+			return nil, fmt.Errorf("dictionary indices of %d bits", data[0])
+		}
+		indices, err := decodeHybrid(data[1:], int(data[0]), present)
+		if err != nil {
+			return nil, err
+		}
+		for _, idx := range indices {
+			if idx >= len(dict) {
+				return nil, fmt.Errorf("dictionary index %d out of range", idx)
+			}
+			decoded = append(decoded, dict[idx])
+		}
+	case 5: // delta binary packed
+		ints, _, err := decodeDeltaBinary(data, present)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range ints {
+			if c.Type == parquetInt32 {
+				v = int64(int32(v))
+			}
+			decoded = append(decoded, c.formatInt(big.NewInt(v)))
+		}
+	case 6: // delta length byte array
+		lengths, pos, err := decodeDeltaBinary(data, present)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lengths {
+			if l < 0 || int64(len(data)-pos) < l {
+				return nil, fmt.Errorf("values end early")
+			}
+			decoded = append(decoded, c.formatBytes(data[pos:pos+int(l)]))
+			pos += int(l)
+		}
+	case 7: // delta byte array, as prefix lengths and suffixes
+		prefixes, pos, err := decodeDeltaBinary(data, present)
+		if err != nil {
+			return nil, err
+		}
+		lengths, n, err := decodeDeltaBinary(data[pos:], present)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		if len(lengths) != len(prefixes) {
+			return nil, fmt.Errorf("%d prefixes for %d suffixes", len(prefixes), len(lengths))
+		}
+		var last []byte
+		for i, l := range lengths {
+			if l < 0 || int64(len(data)-pos) < l || prefixes[i] < 0 ||
+				prefixes[i] > int64(len(last)) {
+				return nil, fmt.Errorf("values end early")
+			}
+			v := append(append([]byte{}, last[:prefixes[i]]...), data[pos:pos+int(l)]...)
+			pos += int(l)
+			decoded = append(decoded, c.formatBytes(v))
+			last = v
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if len(decoded) < present {
+		return nil, fmt.Errorf("page has %d values, expected %d", len(decoded), present)
+	}
+	next := 0
+	for i := 0; i < n; i++ {
+		if levels != nil && levels[i] != c.MaxDef {
+			values = append(values, "")
+			continue
+		}
+		values = append(values, decoded[next])
+		next++
+	}
+	return values, nil
+}
+
+// Decompresses a page to the size given in its header.
+func decompress(codec int64, data []byte, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("page of %d bytes", size)
+	}
+	switch codec {
+	case 0:
+		return data, nil
+	case 1:
+		return snappyDecode(data, size)
+	case 2:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		out, err := ioutil.ReadAll(io.LimitReader(gz, int64(size)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > size {
+			return nil, fmt.Errorf("gzip: more than %d bytes", size)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// Decodes the Snappy block format, of at most size bytes.
+func snappyDecode(src []byte, size int) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, fmt.Errorf("snappy: bad length")
+	}
+	if n > uint64(size) {
+		return nil, fmt.Errorf("snappy: %d bytes, expected at most %d", n, size)
+	}
+	dst := make([]byte, 0, n)
+	s := k
+	for s < len(src) {
+		tag := src[s]
+		var length, offset int
+		switch tag & 3 {
+		case 0: // literal
+			length = int(tag >> 2)
+			s++
+			if length >= 60 {
+				extra := length - 59
+				if s+extra > len(src) {
+					return nil, fmt.Errorf("snappy: truncated literal")
+				}
+				length = 0
+				for i := 0; i < extra; i++ {
+					length |= int(src[s+i]) << (8 * i)
+				}
+				s += extra
+			}
+			length++
+			if length < 1 || length > len(src)-s {
+				return nil, fmt.Errorf("snappy: truncated literal")
+			}
+			if uint64(len(dst)+length) > n {
+				return nil, fmt.Errorf("snappy: more than %d bytes", n)
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, fmt.Errorf("snappy: truncated copy")
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case 2:
+			if s+3 > len(src) {
+				return nil, fmt.Errorf("snappy: truncated copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 3:
+			if s+5 > len(src) {
+				return nil, fmt.Errorf("snappy: truncated copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, fmt.Errorf("snappy: bad offset")
+		}
+		if uint64(len(dst)+length) > n {
+			return nil, fmt.Errorf("snappy: more than %d bytes", n)
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, fmt.Errorf("snappy: got %d bytes, expected %d", len(dst), n)
+	}
+	return dst, nil
+}
+
+// Decodes n values of the RLE and bit-packing hybrid encoding that
+// levels and dictionary indices use.
+func decodeHybrid(data []byte, bitWidth int, n int) ([]int, error) {
+	out := make([]int, 0, n)
+	byteWidth := (bitWidth + 7) / 8
+	pos := 0
+	for len(out) < n {
+		header, k := binary.Uvarint(data[pos:])
+		if k <= 0 || header>>1 == 0 {
+			return nil, fmt.Errorf("bad run header")
+		}
+		pos += k
+		if header&1 == 0 {
+			if pos+byteWidth > len(data) {
+				return nil, fmt.Errorf("truncated run")
+			}
+			v := 0
+			for i := 0; i < byteWidth; i++ {
+				v |= int(data[pos+i]) << (8 * i)
+			}
+			pos += byteWidth
+			for i := uint64(0); i < header>>1 && len(out) < n; i++ {
+				out = append(out, v)
+			}
+			continue
+		}
+		if bitWidth > 0 && header>>1 > uint64((len(data)-pos)/bitWidth) {
+			return nil, fmt.Errorf("truncated bit-packed run")
+		}
+		groups := int(header >> 1)
+		for i := 0; i/8 < groups && len(out) < n; i++ {
+			v := 0
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				if data[pos+bit/8]>>(bit%8)&1 == 1 {
+					v |= 1 << b
+				}
+			}
+			out = append(out, v)
+		}
+		pos += groups * bitWidth
+	}
+	return out, nil
+}
+
+// Decodes n plain encoded values and formats them.
+func decodePlain(data []byte, c parquetColumn, n int) ([]string, error) {
+	if n > len(data)*8 {
+		return nil, fmt.Errorf("%d values in %d bytes", n, len(data))
+	}
+	out := make([]string, 0, n)
+	pos := 0
+	take := func(size int) ([]byte, error) {
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("values end early")
+		}
+		pos += size
+		return data[pos-size : pos], nil
+	}
+	for i := 0; i < n; i++ {
+		var v string
+		switch c.Type {
+		case parquetBoolean:
+			if i/8 >= len(data) {
+				return nil, fmt.Errorf("values end early")
+			}
+			v = strconv.FormatBool(data[i/8]>>(i%8)&1 == 1)
+		case parquetInt32:
+			b, err := take(4)
+			if err != nil {
+				return nil, err
+			}
+			v = c.formatInt(big.NewInt(int64(int32(binary.LittleEndian.Uint32(b)))))
+		case parquetInt64:
+			b, err := take(8)
+			if err != nil {
+				return nil, err
+			}
+			v = c.formatInt(big.NewInt(int64(binary.LittleEndian.Uint64(b))))
+		case parquetInt96:
+			b, err := take(12)
+			if err != nil {
+				return nil, err
+			}
+			// Nanoseconds of the day and the Julian day.
+			nanos := int64(binary.LittleEndian.Uint64(b))
+			days := int64(binary.LittleEndian.Uint32(b[8:])) - 2440588
+			v = time.Unix(days*86400, nanos).UTC().Format(time.RFC3339Nano)
+		case parquetFloat:
+			b, err := take(4)
+			if err != nil {
+				return nil, err
+			}
+			f := math.Float32frombits(binary.LittleEndian.Uint32(b))
+			v = strconv.FormatFloat(float64(f), 'g', -1, 32)
+		case parquetDouble:
+			b, err := take(8)
+			if err != nil {
+				return nil, err
+			}
+			v = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+		case parquetByteArray, parquetFixedLenByteArray:
+			size := c.TypeLength
+			if c.Type == parquetByteArray {
+				b, err := take(4)
+				if err != nil {
+					return nil, err
+				}
+				size = int(binary.LittleEndian.Uint32(b))
+			}
+			b, err := take(size)
+			if err != nil {
+				return nil, err
+			}
+			v = c.formatBytes(b)
+		default:
+			return nil, fmt.Errorf("unknown type %d", c.Type)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// Decodes the delta binary packed encoding of at most max values.
+// Returns the values and the number of bytes they took.
+func decodeDeltaBinary(data []byte, max int) ([]int64, int, error) {
+	pos := 0
+	uvarint := func() (uint64, error) {
+		v, k := binary.Uvarint(data[pos:])
+		if k <= 0 {
+			return 0, fmt.Errorf("bad delta encoding")
+		}
+		pos += k
+		return v, nil
+	}
+	zigzag := func(v uint64) int64 {
+		return int64(v>>1) ^ -int64(v&1)
+	}
+	var header [4]uint64
+	for i := range header {
+		var err error
+		if header[i], err = uvarint(); err != nil {
+			return nil, 0, err
+		}
+	}
+	blockSize, miniblocks, total := header[0], header[1], header[2]
+	if miniblocks == 0 || blockSize > 1<<20 || blockSize%miniblocks != 0 ||
+		(blockSize/miniblocks)%8 != 0 {
+		return nil, 0, fmt.Errorf("bad delta block size %d with %d miniblocks", blockSize, miniblocks)
+	}
+	if total > uint64(max) {
+		return nil, 0, fmt.Errorf("%d delta encoded values, expected at most %d", total, max)
+	}
+	perMiniblock := int(blockSize / miniblocks)
+	var values []int64
+	if total == 0 {
+		return values, pos, nil
+	}
+	last := zigzag(header[3])
+	values = append(values, last)
+	for uint64(len(values)) < total {
+		v, err := uvarint()
+		if err != nil {
+			return nil, 0, err
+		}
+		minDelta := zigzag(v)
+		if miniblocks > uint64(len(data)-pos) {
+			return nil, 0, fmt.Errorf("delta block ends early")
+		}
+		widths := data[pos : pos+int(miniblocks)]
+		pos += int(miniblocks)
+		// Miniblocks after the last value are left out.
+		for _, w := range widths {
+			if uint64(len(values)) >= total {
+				break
+			}
+			width := int(w)
+			size := perMiniblock * width / 8
+			if width > 64 || pos+size > len(data) {
+				return nil, 0, fmt.Errorf("delta miniblock ends early")
+			}
+			for i := 0; i < perMiniblock && uint64(len(values)) < total; i++ {
+				var delta uint64
+				for b := 0; b < width; b++ {
+					bit := i*width + b
+					if data[pos+bit/8]>>(bit%8)&1 == 1 {
+						delta |= 1 << uint(b)
+					}
+				}
+				last = int64(uint64(last) + uint64(minDelta) + delta)
+				values = append(values, last)
+			}
+			pos += size
+		}
+	}
+	return values, pos, nil
+}
+
+// Formats a byte array as a string, or a decimal if the column is one.
+func (c parquetColumn) formatBytes(b []byte) string {
+	if c.Format != "decimal" {
+		return string(b)
+	}
+	// Big-endian two's complement.
+	unscaled := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return c.formatInt(unscaled)
+}
+
+// Formats an integer as a decimal, date or timestamp, depending on the
+// column.
+func (c parquetColumn) formatInt(i *big.Int) string {
+	switch c.Format {
+	case "decimal":
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.Scale)), nil)
+		return new(big.Rat).SetFrac(i, scale).FloatString(c.Scale)
+	case "date":
+		return time.Unix(i.Int64()*86400, 0).UTC().Format("2006-01-02")
+	case "millis":
+		return time.Unix(0, i.Int64()*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	case "micros":
+		return time.Unix(0, i.Int64()*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+	case "nanos":
+		return time.Unix(0, i.Int64()).UTC().Format(time.RFC3339Nano)
+	}
+	return i.String()
+}
+
+// A struct in the Thrift compact protocol, which Parquet metadata is
+// written in, by field id. Integers are int64, lists []interface{} and
+// structs thriftStruct. Maps are skipped.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftStruct) bool(id int16) bool {
+	v, _ := s[id].(bool)
+	return v
+}
+
+func (s thriftStruct) str(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftStruct) st(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+type thriftReader struct {
+	buf []byte
+	pos int
+	// How many structs, lists and maps the reader is in.
+	depth int
+}
+
+func (t *thriftReader) byte() (byte, error) {
+	if t.pos >= len(t.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	t.pos++
+	return t.buf[t.pos-1], nil
+}
+
+func (t *thriftReader) uvarint() (uint64, error) {
+	v, k := binary.Uvarint(t.buf[t.pos:])
+	if k <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	t.pos += k
+	return v, nil
+}
+
+func (t *thriftReader) varint() (int64, error) {
+	v, err := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (t *thriftReader) readStruct() (thriftStruct, error) {
+	s := make(thriftStruct)
+	var id int16
+	for {
+		b, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := t.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		var v interface{}
+		switch typ := b & 0x0f; typ {
+		case 1, 2: // booleans are in the type
+			v = typ == 1
+		default:
+			if v, err = t.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+		if v != nil {
+			s[id] = v
+		}
+	}
+}
+
+func (t *thriftReader) readValue(typ byte) (interface{}, error) {
+	if typ >= 9 {
+		// Parquet metadata is only a few levels deep.
+		if t.depth >= 32 {
+			return nil, fmt.Errorf("thrift values nested too deep")
+		}
+		t.depth++
+		defer func() { t.depth-- }()
+	}
+	switch typ {
+	case 1, 2: // a boolean in a list, 1 is true
+		b, err := t.byte()
+		return b == 1, err
+	case 3:
+		b, err := t.byte()
+		return int64(int8(b)), err
+	case 4, 5, 6:
+		return t.varint()
+	case 7:
+		if t.pos+8 > len(t.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		t.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(t.buf[t.pos-8:])), nil
+	case 8:
+		n, err := t.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(t.buf)-t.pos) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		t.pos += int(n)
+		return t.buf[t.pos-int(n) : t.pos], nil
+	case 9, 10: // list, set
+		b, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(b >> 4)
+		if n == 15 {
+			if n, err = t.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(t.buf)-t.pos) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		l := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := t.readValue(b & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case 11: // map
+		n, err := t.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		types, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(t.buf)-t.pos) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := t.readValue(types >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := t.readValue(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case 12:
+		return t.readStruct()
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
@@ -0,0 +1,38 @@
+package billing
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadParquetCorrupt(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/cur.snappy.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every byte flipped in turn, sizes and counts in the metadata too.
+	for i := range data {
+		corrupt := append([]byte{}, data...)
+		corrupt[i] ^= 0xff
+		ReadParquet(bytes.NewReader(corrupt), int64(len(corrupt)))
+	}
+	if _, err := ReadParquet(bytes.NewReader(data[:len(data)/2]), int64(len(data)/2)); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
+
+func FuzzReadParquet(f *testing.F) {
+	for _, name := range []string{"cur.snappy.parquet", "cur-v2.gz.parquet"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Corrupt files are errors, never panics.
+		ReadParquet(bytes.NewReader(data), int64(len(data)))
+	})
+}
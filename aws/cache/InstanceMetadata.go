@@ -8,21 +8,74 @@ import (
 	"log"
 	"nephomancy/aws/resources"
 	common "nephomancy/common/resources"
+	"sort"
+	"strings"
 )
 
-func checkVmSpec(db *sql.DB, avm resources.Ec2VM, spec common.Instance) error {
+func checkVmSpec(db *sql.DB, avm *resources.Ec2VM, spec *common.Instance) error {
 	avmLocation, err := resolveLocation(avm.Region)
 	if err != nil {
 		return err
 	}
 	if l := spec.Location; l != nil {
-		if err := common.CheckLocation(avmLocation, *l); err != nil {
+		if err := common.CheckLocation(&avmLocation, l); err != nil {
 			return err
 		}
 	}
+	if po := spec.PurchaseOption; po != "" && avm.TermType != "" {
+		want := resources.Ec2VM{}
+		setPurchaseOption(&want, po)
+		termType := avm.TermType
+		// Savings Plans are commitments like reservations.
+		if termType == "SavingsPlan" {
+			termType = "Reserved"
+		}
+		if want.TermType != termType ||
+			want.LeaseContractLength != avm.LeaseContractLength {
+			return fmt.Errorf("%s vm term type %s %s does not match spec purchase option %s",
+				resources.AwsProvider, avm.TermType, avm.LeaseContractLength, po)
+		}
+	}
 	return nil
 }
 
+// Maps a common purchase option to the AWS term type. Commitments
+// become standard reserved instances with no upfront payment; edit the
+// provider details if you want something else.
+func setPurchaseOption(avm *resources.Ec2VM, po string) {
+	switch po {
+	case "Spot":
+		avm.TermType = "Spot"
+	case "Commit1Yr", "Commit3Yr":
+		avm.TermType = "Reserved"
+		avm.PurchaseOption = "No Upfront"
+		avm.OfferingClass = "standard"
+		if po == "Commit1Yr" {
+			avm.LeaseContractLength = "1yr"
+		} else {
+			avm.LeaseContractLength = "3yr"
+		}
+	default:
+		avm.TermType = "OnDemand"
+	}
+}
+
+// Maps an os from the spec to the price list's operating system: Linux,
+// RHEL, SUSE or Windows. Other Linux distributions are free and priced
+// like Amazon Linux.
+func ec2Os(os string) string {
+	os = strings.ToLower(os)
+	switch {
+	case strings.HasPrefix(os, "windows"):
+		return "Windows"
+	case strings.HasPrefix(os, "rhel"), strings.HasPrefix(os, "red hat"):
+		return "RHEL"
+	case strings.HasPrefix(os, "suse"), strings.HasPrefix(os, "sles"):
+		return "SUSE"
+	}
+	return "Linux"
+}
+
 func resolveLocation(region string) (common.Location, error) {
 	cc := CountryByRegion(region)
 	if cc == "Unknown" {
@@ -31,7 +84,35 @@ func resolveLocation(region string) (common.Location, error) {
 	return common.CountryCodeToLocation(cc)
 }
 
+func checkLocation(region string, spec *common.Location) error {
+	loc, err := resolveLocation(region)
+	if err != nil {
+		return err
+	}
+	return common.CheckLocation(&loc, spec)
+}
+
+// Returns the regions consistent with loc, for placing resources in
+// a region of choice.
+func PlacementRegions(loc *common.Location) []string {
+	var regions []string
+	for _, r := range RegionsForLocation(loc, "") {
+		if checkLocation(r, loc) == nil {
+			regions = append(regions, r)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
 func FillInProviderDetails(db *sql.DB, p *common.Project) error {
+	return FillInProviderDetailsIn(db, p, "")
+}
+
+// Like FillInProviderDetails, but places everything in the given region,
+// which has to be consistent with the spec locations. An empty region
+// leaves the choice to the tool.
+func FillInProviderDetailsIn(db *sql.DB, p *common.Project, region string) error {
 	locations := make(map[string]string)
 	for _, vmset := range p.InstanceSets {
 		if vmset.Template.Location == nil {
@@ -43,6 +124,9 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 		if vmset.Template.Os == "" {
 			return fmt.Errorf("missing vmset os information")
 		}
+		if err := common.CheckPurchaseOption(vmset.Template.PurchaseOption); err != nil {
+			return err
+		}
 		if vmset.Template.ProviderDetails == nil {
 			vmset.Template.ProviderDetails = make(map[string](*anypb.Any))
 		}
@@ -54,43 +138,308 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 			if err != nil {
 				return err
 			}
-			if err = checkVmSpec(db, avm, *vmset.Template); err != nil {
+			if err = checkVmSpec(db, &avm, vmset.Template); err != nil {
 				return err
 			}
 			log.Printf("Instance Set %s already has details for provider %s, leaving them a they are.\n",
 				vmset.Name, resources.AwsProvider)
-			locstring := common.PrintLocation(*vmset.Template.Location)
+			locstring := common.PrintLocation(vmset.Template.Location)
 			if locations[locstring] == "" {
 				locations[locstring] = avm.Region
 			}
 		} else { // no provider details yet
-			regions := RegionsForLocation(*vmset.Template.Location, "")
+			regions := RegionsForLocation(vmset.Template.Location, "")
+			if region != "" {
+				if err := checkLocation(region, vmset.Template.Location); err != nil {
+					return fmt.Errorf("region %s does not match location %v: %v",
+						region, vmset.Template.Location, err)
+				}
+				regions = []string{region}
+			}
 			if len(regions) == 0 {
 				return fmt.Errorf("provider %s does not support regions matching location %v",
 					resources.AwsProvider, vmset.Template.Location)
 			}
-			it, r, err := getInstanceTypeForSpec(db, *vmset.Template.Type, regions)
+			it, r, err := getInstanceTypeForSpec(db, vmset.Template.Type,
+				vmset.Template.PurchaseOption, regions)
 			if err != nil {
 				return err
 			}
-			// vmset.Template.Os
-
-			details, err := ptypes.MarshalAny(&resources.Ec2VM{
+			avm := &resources.Ec2VM{
 				InstanceType: it,
 				Region:       r[0],
-			})
+				Tenancy:      "Shared",
+				Os:           ec2Os(vmset.Template.Os),
+				LicenseModel: "No License required",
+			}
+			setPurchaseOption(avm, vmset.Template.PurchaseOption)
+			details, err := ptypes.MarshalAny(avm)
 			if err != nil {
 				return err
 			}
 			vmset.Template.ProviderDetails[resources.AwsProvider] = details
+			locstring := common.PrintLocation(vmset.Template.Location)
+			if locations[locstring] == "" {
+				locations[locstring] = avm.Region
+			}
 		}
 	}
 	for _, nw := range p.Networks {
-		_ = nw
+		if nw.ProviderDetails == nil {
+			nw.ProviderDetails = make(map[string](*anypb.Any))
+		}
+		if nw.ProviderDetails[resources.AwsProvider] != nil {
+			log.Printf("Network %s already has details for provider %s, leaving them as they are.\n",
+				nw.Name, resources.AwsProvider)
+		} else {
+			// A new vpc, there is nothing to choose.
+			details, err := ptypes.MarshalAny(&resources.Ec2Network{})
+			if err != nil {
+				return err
+			}
+			nw.ProviderDetails[resources.AwsProvider] = details
+		}
+		for _, snw := range nw.Subnetworks {
+			if err := fillInSubnetwork(snw, locations, region); err != nil {
+				return err
+			}
+		}
 	}
 	for _, dset := range p.DiskSets {
-		_ = dset
+		if dset.Template.Location == nil {
+			return fmt.Errorf("missing diskset location information")
+		}
+		if dset.Template.Type == nil {
+			return fmt.Errorf("missing diskset type information")
+		}
+		if dset.Template.ProviderDetails == nil {
+			dset.Template.ProviderDetails = make(map[string](*anypb.Any))
+		}
+		if dset.Template.ProviderDetails[resources.AwsProvider] != nil {
+			var dsk resources.Ec2Disk
+			err := ptypes.UnmarshalAny(dset.Template.ProviderDetails[resources.AwsProvider], &dsk)
+			if err != nil {
+				return err
+			}
+			if err = checkLocation(dsk.Region, dset.Template.Location); err != nil {
+				return err
+			}
+			log.Printf("Disk Set %s already has details for provider %s, leaving them as they are.\n",
+				dset.Name, resources.AwsProvider)
+			continue
+		}
+		// Disks go in the same region as instances in the same location.
+		regions := PlacementRegions(dset.Template.Location)
+		if r := locations[common.PrintLocation(dset.Template.Location)]; r != "" {
+			regions = append([]string{r}, regions...)
+		}
+		if region != "" {
+			if err := checkLocation(region, dset.Template.Location); err != nil {
+				return fmt.Errorf("region %s does not match location %v: %v",
+					region, dset.Template.Location, err)
+			}
+			regions = []string{region}
+		}
+		var dsk *resources.Ec2Disk
+		for _, r := range regions {
+			var err error
+			if dsk, err = volumeForDiskType(db, dset.Template.Type, r); err != nil {
+				return err
+			}
+			if dsk != nil {
+				break
+			}
+		}
+		if dsk == nil {
+			return fmt.Errorf("no EBS volume type for %s in %s, is the price list in the cache?",
+				common.PrintDiskType(dset.Template.Type),
+				common.PrintLocation(dset.Template.Location))
+		}
+		details, err := ptypes.MarshalAny(dsk)
+		if err != nil {
+			return err
+		}
+		dset.Template.ProviderDetails[resources.AwsProvider] = details
+	}
+
+	return nil
+}
+
+// Places a subnetwork in a region, the one of the instances in the same
+// location if there are any, and its gateways without provider details
+// on NAT gateways.
+func fillInSubnetwork(snw *common.Subnetwork, locations map[string]string, region string) error {
+	if snw.Location == nil {
+		return fmt.Errorf("missing subnetwork location information")
+	}
+	if snw.ProviderDetails == nil {
+		snw.ProviderDetails = make(map[string](*anypb.Any))
 	}
+	if snw.ProviderDetails[resources.AwsProvider] != nil {
+		var asnw resources.Ec2Subnetwork
+		err := ptypes.UnmarshalAny(snw.ProviderDetails[resources.AwsProvider], &asnw)
+		if err != nil {
+			return err
+		}
+		if err = checkLocation(asnw.Region, snw.Location); err != nil {
+			return err
+		}
+		log.Printf("Subnetwork %s already has details for provider %s, leaving them as they are.\n",
+			snw.Name, resources.AwsProvider)
+	} else {
+		regions := PlacementRegions(snw.Location)
+		if r := locations[common.PrintLocation(snw.Location)]; r != "" {
+			regions = []string{r}
+		}
+		if region != "" {
+			if err := checkLocation(region, snw.Location); err != nil {
+				return fmt.Errorf("region %s does not match location %v: %v",
+					region, snw.Location, err)
+			}
+			regions = []string{region}
+		}
+		if len(regions) == 0 {
+			return fmt.Errorf("provider %s does not support regions matching location %v",
+				resources.AwsProvider, snw.Location)
+		}
+		details, err := ptypes.MarshalAny(&resources.Ec2Subnetwork{Region: regions[0]})
+		if err != nil {
+			return err
+		}
+		snw.ProviderDetails[resources.AwsProvider] = details
+	}
+	for _, gw := range snw.Gateways {
+		if gw.ProviderDetails == nil {
+			gw.ProviderDetails = make(map[string](*anypb.Any))
+		}
+		if gw.ProviderDetails[resources.AwsProvider] != nil {
+			continue
+		}
+		details, err := ptypes.MarshalAny(&resources.Ec2Gateway{ProductFamily: "NAT Gateway"})
+		if err != nil {
+			return err
+		}
+		gw.ProviderDetails[resources.AwsProvider] = details
+	}
+	return nil
+}
 
+// Fills in the spec from the provider details, for projects that were
+// built from what is running in an account. Parts of the spec that are
+// already there are left alone.
+func FillInSpec(db *sql.DB, p *common.Project) error {
+	for _, vmset := range p.InstanceSets {
+		details := vmset.Template.ProviderDetails[resources.AwsProvider]
+		if details == nil {
+			return fmt.Errorf("missing provider details for instance set %s", vmset.Name)
+		}
+		var avm resources.Ec2VM
+		if err := ptypes.UnmarshalAny(details, &avm); err != nil {
+			return err
+		}
+		if vmset.Template.Location == nil {
+			loc, err := resolveLocation(avm.Region)
+			if err != nil {
+				return err
+			}
+			vmset.Template.Location = &loc
+		}
+		if vmset.Template.Type == nil {
+			mt, err := getMachineType(db, avm.InstanceType)
+			if err != nil {
+				return err
+			}
+			vmset.Template.Type = mt
+		}
+		if vmset.Template.Os == "" {
+			vmset.Template.Os = "linux"
+			if avm.Os == "Windows" {
+				vmset.Template.Os = "windows"
+			}
+		}
+		if vmset.Template.PurchaseOption == "" {
+			switch {
+			case avm.TermType == "Spot":
+				vmset.Template.PurchaseOption = "Spot"
+			case avm.TermType == "Reserved", avm.TermType == "SavingsPlan":
+				switch avm.LeaseContractLength {
+				case "1yr":
+					vmset.Template.PurchaseOption = "Commit1Yr"
+				case "3yr":
+					vmset.Template.PurchaseOption = "Commit3Yr"
+				}
+			}
+		}
+	}
+	for _, dset := range p.DiskSets {
+		details := dset.Template.ProviderDetails[resources.AwsProvider]
+		if details == nil {
+			return fmt.Errorf("missing provider details for disk set %s", dset.Name)
+		}
+		var dsk resources.Ec2Disk
+		if err := ptypes.UnmarshalAny(details, &dsk); err != nil {
+			return err
+		}
+		if dset.Template.Location == nil {
+			loc, err := resolveLocation(dsk.Region)
+			if err != nil {
+				return err
+			}
+			dset.Template.Location = &loc
+		}
+		if dset.Template.Type == nil {
+			tech := "SSD"
+			switch dsk.VolumeType {
+			case "st1", "sc1", "standard":
+				tech = "Standard"
+			}
+			dset.Template.Type = &common.DiskType{
+				SizeGb:          uint32(dsk.ActualSizeGb),
+				DiskTech:        tech,
+				Iops:            dsk.Iops,
+				ThroughputMibps: dsk.Throughput,
+			}
+		}
+	}
+	for _, nw := range p.Networks {
+		for _, snw := range nw.Subnetworks {
+			details := snw.ProviderDetails[resources.AwsProvider]
+			if details == nil {
+				return fmt.Errorf("missing provider details for subnetwork %s", snw.Name)
+			}
+			var asnw resources.Ec2Subnetwork
+			if err := ptypes.UnmarshalAny(details, &asnw); err != nil {
+				return err
+			}
+			if snw.Location == nil {
+				loc, err := resolveLocation(asnw.Region)
+				if err != nil {
+					return err
+				}
+				snw.Location = &loc
+			}
+		}
+	}
 	return nil
 }
+
+// Returns the spec of an instance type in the cache.
+func getMachineType(db *sql.DB, instanceType string) (*common.MachineType, error) {
+	var cpu, memory uint32
+	var gpu sql.NullInt64
+	var arch sql.NullString
+	err := db.QueryRow(`SELECT CPU, Memory, GPU, Architecture FROM InstanceTypes
+	WHERE InstanceType=?`, instanceType).Scan(&cpu, &memory, &gpu, &arch)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown instance type %s, is the cache populated?", instanceType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &common.MachineType{
+		CpuCount:        cpu,
+		MemoryGb:        memory / 1024,
+		GpuCount:        uint32(gpu.Int64),
+		CpuArchitecture: arch.String,
+	}, nil
+}
@@ -2,40 +2,73 @@ package cache
 
 import (
 	"database/sql"
+	"nephomancy/common/metadata"
+	"nephomancy/common/migrate"
 )
 
+// Schema migrations for the AWS cache, see common/migrate.
+var migrations = []migrate.Step{
+	{Version: 1, Description: "create tables", Apply: createTables},
+	{Version: 2, Description: "add Architecture to InstanceTypes", Apply: addArchitectureColumn},
+	{Version: 3, Description: "create CacheMetadata", Apply: metadata.CreateTable},
+	{Version: 4, Description: "create price list tables", Apply: createPriceListTables},
+	{Version: 5, Description: "key VolumeTypes by API name", Apply: rekeyVolumeTypes},
+	{Version: 6, Description: "create Savings Plans tables", Apply: createSavingsPlansTables},
+	{Version: 7, Description: "create SpotPrices", Apply: createSpotPricesTable},
+}
+
 func CreateOrUpdateDatabase(db *sql.DB) error {
-	if err := createRegionsTable(db); err != nil {
+	return migrate.Run(db, migrations)
+}
+
+// Returns the schema version caches are migrated to.
+func LatestSchemaVersion() int {
+	return migrate.Latest(migrations)
+}
+
+// Returns the cache's schema version and the latest schema version.
+func SchemaVersion(db *sql.DB) (int, int, error) {
+	v, err := migrate.Version(db)
+	return v, migrate.Latest(migrations), err
+}
+
+func createTables(tx *sql.Tx) error {
+	if err := createRegionsTable(tx); err != nil {
 		return err
 	}
-	if err := createInstanceTypesTable(db); err != nil {
+	if err := createInstanceTypesTable(tx); err != nil {
 		return err
 	}
-	if err := createValidCoreCountTable(db); err != nil {
+	if err := createValidCoreCountTable(tx); err != nil {
 		return err
 	}
-	if err := createVolumeTypesTable(db); err != nil {
+	if err := createVolumeTypesTable(tx); err != nil {
 		return err
 	}
-	if err := createTypeByRegionTables(db); err != nil {
+	if err := createTypeByRegionTables(tx); err != nil {
 		return err
 	}
-	if err := createSkuTable(db); err != nil {
+	if err := createSkuTable(tx); err != nil {
 		return err
 	}
 	return nil
 }
 
-func createTable(db *sql.DB, ct string) error {
-	stmt, err := db.Prepare(ct)
-	if err != nil {
+// Caches created before the Architecture column existed need it added.
+func addArchitectureColumn(tx *sql.Tx) error {
+	has, err := migrate.HasColumn(tx, "InstanceTypes", "Architecture")
+	if err != nil || has {
 		return err
 	}
-	_, err = stmt.Exec()
+	return createTable(tx, `ALTER TABLE InstanceTypes ADD COLUMN "Architecture" TEXT;`)
+}
+
+func createTable(tx *sql.Tx, ct string) error {
+	_, err := tx.Exec(ct)
 	return err
 }
 
-func createRegionsTable(db *sql.DB) error {
+func createRegionsTable(tx *sql.Tx) error {
 	createRegionsTableSQL := `CREATE TABLE IF NOT EXISTS Regions (
 		"ID" TEXT NOT NULL PRIMARY KEY,
 		"DisplayName" TEXT NOT NULL,
@@ -43,7 +76,7 @@ func createRegionsTable(db *sql.DB) error {
 		"Continent" TEXT,
 		"Special" INTEGER
 	);`
-	if err := createTable(db, createRegionsTableSQL); err != nil {
+	if err := createTable(tx, createRegionsTableSQL); err != nil {
 		return err
 	}
 	return nil
@@ -60,7 +93,8 @@ func createRegionsTable(db *sql.DB) error {
 // GPU is just the number of GPU cores you can get.
 // NetworkPerformanceGBit is a bit of guesswork because there is (afaik)
 // no definitive documentation on what "Moderate" etc. mean.
-func createInstanceTypesTable(db *sql.DB) error {
+// Architecture is "arm64" (Graviton) or "x86_64".
+func createInstanceTypesTable(tx *sql.Tx) error {
 	createInstanceTypesTableSQL := `CREATE TABLE IF NOT EXISTS InstanceTypes (
 		"InstanceType" TEXT NOT NULL PRIMARY KEY,
 		"CPU" INTEGER NOT NULL,
@@ -70,15 +104,16 @@ func createInstanceTypesTable(db *sql.DB) error {
 		"StorageType" TEXT,
 		"StorageAmount" INTEGER,
 		"SupportsSpot" INTEGER,
-		"SupportsOnDemand" INTEGER
+		"SupportsOnDemand" INTEGER,
+		"Architecture" TEXT
 	);`
-	if err := createTable(db, createInstanceTypesTableSQL); err != nil {
+	if err := createTable(tx, createInstanceTypesTableSQL); err != nil {
 		return err
 	}
 	return nil
 }
 
-func createValidCoreCountTable(db *sql.DB) error {
+func createValidCoreCountTable(tx *sql.Tx) error {
 	createValidCoreCountTableSQL := `CREATE TABLE IF NOT EXISTS CoreCount (
 		"InstanceType" TEXT NOT NULL,
 		"CoreCount" INTEGER NOT NULL,
@@ -88,7 +123,7 @@ func createValidCoreCountTable(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, createValidCoreCountTableSQL); err != nil {
+	if err := createTable(tx, createValidCoreCountTableSQL); err != nil {
 		return err
 	}
 	return nil
@@ -98,7 +133,7 @@ func createValidCoreCountTable(db *sql.DB) error {
 // the instance type. Might want to include the EC2-supported parts
 // of Amazon S3 storage here as well? But they don't have sizes or
 // storage media, so no need really.
-func createVolumeTypesTable(db *sql.DB) error {
+func createVolumeTypesTable(tx *sql.Tx) error {
 	createVolumeTypesTableSQL := `CREATE TABLE IF NOT EXISTS VolumeTypes (
 		"VolumeType" TEXT NOT NULL PRIMARY KEY,
 		"StorageMedia" TEXT,
@@ -108,13 +143,13 @@ func createVolumeTypesTable(db *sql.DB) error {
 		"MultiAttach" INTEGER,
 		"VolumeApiType" STRING
 	);`
-	if err := createTable(db, createVolumeTypesTableSQL); err != nil {
+	if err := createTable(tx, createVolumeTypesTableSQL); err != nil {
 		return err
 	}
 	return nil
 }
 
-func createTypeByRegionTables(db *sql.DB) error {
+func createTypeByRegionTables(tx *sql.Tx) error {
 	createInstanceTypeRegionTableSQL := `CREATE TABLE IF NOT EXISTS InstanceTypeByRegion (
 		"InstanceType" NOT NULL,
 		"Region" TEXT NOT NULL,
@@ -128,7 +163,7 @@ func createTypeByRegionTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, createInstanceTypeRegionTableSQL); err != nil {
+	if err := createTable(tx, createInstanceTypeRegionTableSQL); err != nil {
 		return err
 	}
 	return nil
@@ -146,7 +181,7 @@ func createTypeByRegionTables(db *sql.DB) error {
 // The operation for instances is of the form "RunInstances[:[0-9]4]?" or "Hourly"
 // The code after RunInstances means:
 // empty: just Linux
-// 0002: bare metal
+// 0002: Windows
 // 0004: Linux with SQL Server
 // 0006: Windows with SQL Server
 // 0010: Red Hat Enterprise Linux | RHEL
@@ -160,7 +195,7 @@ func createTypeByRegionTables(db *sql.DB) error {
 // There are also codes of the form RunInstances:FFP-<code> but they are all for
 // things running in GovCloud.
 // Hourly looks to be for something measured in Mbps, probably network bandwidth.
-func createSkuTable(db *sql.DB) error {
+func createSkuTable(tx *sql.Tx) error {
 	createSkuTableSQL := `CREATE TABLE IF NOT EXISTS Sku (
 		"Sku" TEXT NOT NULL PRIMARY KEY,
 		"ProductType" TEXT NOT NULL,
@@ -176,8 +211,196 @@ func createSkuTable(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, createSkuTableSQL); err != nil {
+	if err := createTable(tx, createSkuTableSQL); err != nil {
 		return err
 	}
 	return nil
 }
+
+// The products and terms of the EC2 price list, see IngestPriceList.
+// Products have the attributes needed for finding their prices, the
+// ones that don't apply to a product family are NULL. Region is NULL
+// for locations that aren't regions, e.g. Local Zones. Terms are
+// OnDemand or Reserved, the last three columns are for Reserved only.
+// An EndRange of NULL means no upper limit.
+func createPriceListTables(tx *sql.Tx) error {
+	createProductsTableSQL := `CREATE TABLE IF NOT EXISTS Products (
+		"Sku" TEXT NOT NULL PRIMARY KEY,
+		"ProductFamily" TEXT NOT NULL,
+		"Region" TEXT,
+		"UsageType" TEXT,
+		"Operation" TEXT,
+		"InstanceType" TEXT,
+		"Tenancy" TEXT,
+		"OperatingSystem" TEXT,
+		"LicenseModel" TEXT,
+		"PreInstalledSw" TEXT,
+		"CapacityStatus" TEXT,
+		"VolumeApiName" TEXT,
+		"TransferType" TEXT,
+		"FromRegion" TEXT,
+		"ToRegion" TEXT
+	);`
+	if err := createTable(tx, createProductsTableSQL); err != nil {
+		return err
+	}
+	if err := createTable(tx, `CREATE INDEX IF NOT EXISTS ProductsByFamily
+	ON Products (ProductFamily, Region);`); err != nil {
+		return err
+	}
+	createTermsTableSQL := `CREATE TABLE IF NOT EXISTS Terms (
+		"Sku" TEXT NOT NULL,
+		"OfferTermCode" TEXT NOT NULL,
+		"TermType" TEXT NOT NULL,
+		"EffectiveDate" TEXT,
+		"LeaseContractLength" TEXT,
+		"OfferingClass" TEXT,
+		"PurchaseOption" TEXT,
+		PRIMARY KEY (Sku, OfferTermCode)
+		FOREIGN KEY (Sku)
+		REFERENCES Products (Sku)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+	);`
+	if err := createTable(tx, createTermsTableSQL); err != nil {
+		return err
+	}
+	createPriceDimensionsTableSQL := `CREATE TABLE IF NOT EXISTS PriceDimensions (
+		"RateCode" TEXT NOT NULL PRIMARY KEY,
+		"Sku" TEXT NOT NULL,
+		"OfferTermCode" TEXT NOT NULL,
+		"Description" TEXT,
+		"Unit" TEXT,
+		"BeginRange" REAL,
+		"EndRange" REAL,
+		"PricePerUnit" REAL NOT NULL,
+		"Currency" TEXT NOT NULL,
+		FOREIGN KEY (Sku, OfferTermCode)
+		REFERENCES Terms (Sku, OfferTermCode)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+	);`
+	if err := createTable(tx, createPriceDimensionsTableSQL); err != nil {
+		return err
+	}
+	if err := createTable(tx, `CREATE INDEX IF NOT EXISTS PriceDimensionsBySku
+	ON PriceDimensions (Sku);`); err != nil {
+		return err
+	}
+	// Which volume types are available where.
+	createVolumeTypeRegionTableSQL := `CREATE TABLE IF NOT EXISTS VolumeTypeByRegion (
+		"VolumeType" TEXT NOT NULL,
+		"Region" TEXT NOT NULL,
+		UNIQUE (VolumeType, Region)
+		FOREIGN KEY (VolumeType)
+		REFERENCES VolumeTypes (VolumeType)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+		FOREIGN KEY (Region)
+		REFERENCES Regions (ID)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+	);`
+	return createTable(tx, createVolumeTypeRegionTableSQL)
+}
+
+// VolumeTypes used to be keyed by name, which several volume types
+// share, e.g. gp2 and gp3 are both "General Purpose". The tables only
+// hold the standard volume types and what the price list adds, so they
+// are recreated rather than migrated; the next init fills in the regions.
+func rekeyVolumeTypes(tx *sql.Tx) error {
+	if err := createTable(tx, `DROP TABLE IF EXISTS VolumeTypeByRegion;`); err != nil {
+		return err
+	}
+	if err := createTable(tx, `DROP TABLE IF EXISTS VolumeTypes;`); err != nil {
+		return err
+	}
+	createVolumeTypesTableSQL := `CREATE TABLE VolumeTypes (
+		"VolumeApiType" TEXT NOT NULL PRIMARY KEY,
+		"VolumeType" TEXT NOT NULL,
+		"StorageMedia" TEXT,
+		"MinVolumeSize" INTEGER NOT NULL DEFAULT 1,
+		"MaxVolumeSize" INTEGER,
+		"MaxIOPS" INTEGER,
+		"MaxThroughput" INTEGER,
+		"MultiAttach" INTEGER
+	);`
+	if err := createTable(tx, createVolumeTypesTableSQL); err != nil {
+		return err
+	}
+	createVolumeTypeRegionTableSQL := `CREATE TABLE VolumeTypeByRegion (
+		"VolumeApiType" TEXT NOT NULL,
+		"Region" TEXT NOT NULL,
+		UNIQUE (VolumeApiType, Region)
+		FOREIGN KEY (VolumeApiType)
+		REFERENCES VolumeTypes (VolumeApiType)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+		FOREIGN KEY (Region)
+		REFERENCES Regions (ID)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+	);`
+	if err := createTable(tx, createVolumeTypeRegionTableSQL); err != nil {
+		return err
+	}
+	return populateVolumeTypes(tx)
+}
+
+// Savings Plans are in their own price list, see IngestSavingsPlans.
+// PlanType is the product family, ComputeSavingsPlans or
+// EC2InstanceSavingsPlans. The rates are per hour of the discounted
+// EC2 sku, for the plan's term and purchase option.
+func createSavingsPlansTables(tx *sql.Tx) error {
+	createSavingsPlansTableSQL := `CREATE TABLE IF NOT EXISTS SavingsPlans (
+		"Sku" TEXT NOT NULL PRIMARY KEY,
+		"PlanType" TEXT NOT NULL,
+		"LeaseContractLength" TEXT NOT NULL,
+		"PurchaseOption" TEXT NOT NULL,
+		"InstanceFamily" TEXT,
+		"Region" TEXT
+	);`
+	if err := createTable(tx, createSavingsPlansTableSQL); err != nil {
+		return err
+	}
+	createSavingsPlanRatesTableSQL := `CREATE TABLE IF NOT EXISTS SavingsPlanRates (
+		"RateCode" TEXT NOT NULL PRIMARY KEY,
+		"Sku" TEXT NOT NULL,
+		"DiscountedSku" TEXT NOT NULL,
+		"Unit" TEXT,
+		"Price" REAL NOT NULL,
+		"Currency" TEXT NOT NULL,
+		FOREIGN KEY (Sku)
+		REFERENCES SavingsPlans (Sku)
+		ON DELETE CASCADE
+		ON UPDATE NO ACTION
+	);`
+	if err := createTable(tx, createSavingsPlanRatesTableSQL); err != nil {
+		return err
+	}
+	return createTable(tx, `CREATE INDEX IF NOT EXISTS SavingsPlanRatesByDiscountedSku
+	ON SavingsPlanRates (DiscountedSku);`)
+}
+
+// Percentiles of the spot price history of an instance type in an
+// availability zone, weighted by how long each price held. Since and
+// Until are the RFC 3339 times the history covers.
+func createSpotPricesTable(tx *sql.Tx) error {
+	createSpotPricesTableSQL := `CREATE TABLE IF NOT EXISTS SpotPrices (
+		"InstanceType" TEXT NOT NULL,
+		"AvailabilityZone" TEXT NOT NULL,
+		"ProductDescription" TEXT NOT NULL,
+		"Percentile" INTEGER NOT NULL,
+		"Region" TEXT NOT NULL,
+		"Price" REAL NOT NULL,
+		"Samples" INTEGER NOT NULL,
+		"Since" TEXT NOT NULL,
+		"Until" TEXT NOT NULL,
+		PRIMARY KEY (InstanceType, AvailabilityZone, ProductDescription, Percentile)
+	);`
+	if err := createTable(tx, createSpotPricesTableSQL); err != nil {
+		return err
+	}
+	return createTable(tx, `CREATE INDEX IF NOT EXISTS SpotPricesByRegion
+	ON SpotPrices (Region, InstanceType);`)
+}
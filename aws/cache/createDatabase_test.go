@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"database/sql"
+	"nephomancy/common/migrate"
+	"testing"
+)
+
+func TestMigrateOldCache(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	// InstanceTypes as it was before the Architecture column.
+	_, err = db.Exec(`CREATE TABLE InstanceTypes (
+		"InstanceType" TEXT NOT NULL PRIMARY KEY,
+		"MemoryMiB" INTEGER NOT NULL
+	);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CreateOrUpdateDatabase(db); err != nil {
+		t.Fatalf("failed to migrate old cache: %v", err)
+	}
+	if has, err := migrate.HasColumn(db, "InstanceTypes", "Architecture"); !has || err != nil {
+		t.Errorf("Architecture column was not added: %v", err)
+	}
+	version, latest, err := SchemaVersion(db)
+	if err != nil || version != latest {
+		t.Errorf("expected schema version %d but got %d (%v)", latest, version, err)
+	}
+}
@@ -1,17 +1,19 @@
 // Without requiring authentication, download pricing list in bulk
-// via https. For EC2 prices, the volume of the download is about
-// 1GB. The json returned by the bulk API is one object with a very
-// large map inside it, so the code has to load the entire Json into
-// memory before parsing it. If this turns out to be a problem, will
-// have to call GetAttributeValues on the endpoint with pagination,
-// but that does require authentication.
+// via https. For EC2 prices, the volume of the download is several GB,
+// most of it one very large map of products and one of terms. These are
+// decoded one entry at a time as they are read, so memory use does not
+// grow with the size of the price list, and written to the cache in
+// batched transactions.
 package cache
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"nephomancy/aws/resources"
+	"nephomancy/common/fetch"
 	"net/http"
 	"os"
 	"regexp"
@@ -20,201 +22,481 @@ import (
 	"time"
 )
 
-func getPricesInBulk(db *sql.DB, url string, filename string) error {
-	var decoded map[string]interface{}
-	if url != "" {
-		c := &http.Client{Timeout: 200 * time.Second}
-		//response, err := c.Get("https://pricing.us-east-1.amazonaws.com/offers/v1.0/aws/AmazonEC2/current/index.json")
-		response, err := c.Get(url)
+// Maximum time for downloading and decoding a bulk price list. A failed
+// download is retried like any other call.
+var DownloadTimeout = 20 * time.Minute
 
+// The EC2 price list for all regions, relative to the price list
+// endpoint. There are smaller ones for single regions at
+// .../current/<region>/index.json.
+const PriceListPath = "/offers/v1.0/aws/AmazonEC2/current/index.json"
+
+// Rows written per transaction while ingesting a price list.
+var batchSize = 10000
+
+// Returns the URL of the EC2 price list, on resources.Endpoint if it
+// is set.
+func PriceListUrl() string {
+	if resources.Endpoint != "" {
+		return strings.TrimSuffix(resources.Endpoint, "/") + PriceListPath
+	}
+	return "https://pricing.us-east-1.amazonaws.com" + PriceListPath
+}
+
+// What was ingested from a price list.
+type PriceList struct {
+	// The URL or file the price list was read from.
+	Source          string
+	Version         string
+	PublicationDate time.Time
+	Products        int
+	Prices          int
+}
+
+// The parts of a product in the price list that are ingested. All the
+// attribute values are strings.
+type product struct {
+	Sku           string
+	ProductFamily string
+	Attributes    map[string]string
+}
+
+type offerTerm struct {
+	OfferTermCode   string
+	Sku             string
+	EffectiveDate   string
+	PriceDimensions map[string]priceDimension
+	// LeaseContractLength, OfferingClass and PurchaseOption, for
+	// reserved terms.
+	TermAttributes map[string]string
+}
+
+type priceDimension struct {
+	RateCode     string
+	Description  string
+	BeginRange   string
+	EndRange     string
+	Unit         string
+	PricePerUnit map[string]string
+}
+
+// Ingests all products and their OnDemand and Reserved terms from the
+// EC2 price list at location, which is a URL or a local file. Products
+// and prices already in the cache are removed first.
+func IngestPriceList(ctx context.Context, db *sql.DB, location string) (*PriceList, error) {
+	if location == "" {
+		return nil, fmt.Errorf("need url or filename for getting prices")
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ingestPriceList(ctx, db, location, file)
+	}
+	// location is e.g. https://pricing.us-east-1.amazonaws.com/offers/v1.0/aws/AmazonEC2/current/index.json
+	// A retry starts over, which is fine since rows are replaced.
+	var pl *PriceList
+	err := get(ctx, location, func(r io.Reader) error {
+		var err error
+		pl, err = ingestPriceList(ctx, db, location, r)
+		return err
+	})
+	return pl, err
+}
+
+// Calls f with the body of the response to a GET request, retrying
+// transient errors. A retry calls f again with the new response.
+func get(ctx context.Context, location string, f func(r io.Reader) error) error {
+	return fetch.RetryWithin(ctx, DownloadTimeout, fetch.Transient, func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
 		if err != nil {
 			return err
 		}
-		defer response.Body.Close()
-		err = json.NewDecoder(response.Body).Decode(&decoded)
+		response, err := http.DefaultClient.Do(request)
 		if err != nil {
 			return err
 		}
-	} else if filename != "" {
-		file, _ := os.Open(filename)
-		defer file.Close()
-		decoder := json.NewDecoder(file)
-		for decoder.More() {
-			fmt.Println("one item in decoder")
-			decoder.Decode(&decoded)
+		defer response.Body.Close()
+		if err = fetch.CheckStatus(response); err != nil {
+			return err
+		}
+		return f(response.Body)
+	})
+}
+
+func ingestPriceList(ctx context.Context, db *sql.DB, source string, r io.Reader) (
+	*PriceList, error) {
+	pl := &PriceList{Source: source}
+	w := &batchWriter{ctx: ctx, db: db}
+	// The new list replaces the old one, so that products and offers
+	// that were dropped or reissued under a new sku don't linger.
+	for _, table := range []string{"PriceDimensions", "Terms", "Products", "Sku",
+		"VolumeTypeByRegion"} {
+		if err := w.exec("DELETE FROM " + table + ";"); err != nil {
+			w.rollback()
+			return nil, err
 		}
-	} else {
-		return fmt.Errorf("need url or filename for getting prices")
 	}
-	publicationDate, _ := decoded["publicationDate"].(string)
-	t, err := time.Parse("2006-01-02T15:04:05Z", publicationDate)
-	if err != nil {
-		return err
+	if err := decodePriceList(json.NewDecoder(r), w, pl); err != nil {
+		w.rollback()
+		return nil, err
+	}
+	if err := w.commit(); err != nil {
+		return nil, err
 	}
-	var counter uint32
-	products, _ := decoded["products"].(map[string]interface{})
-	fmt.Printf("publication date: %+v has %d products\n", t, len(products))
-	for sku, product := range products {
-		counter++
-		if counter > 10 {
-			break
-		}
-		if counter%1000 == 0 {
-			fmt.Printf("counter: %d\n", counter)
-		}
-		p, _ := product.(map[string]interface{})
-		pf, _ := p["productFamily"].(string)
-		attributes, hasAttr := p["attributes"].(map[string]interface{})
-		// Maybe it would be better to get the instance types by
-		// running describe-instance-types.
-		if hasAttr {
-			// Not covering "Dedicated Host" for now.
-			if pf == "Compute Instance" || pf == "Compute Instance (bare metal)" {
-				fmt.Printf("sku %s is for an instance with attributes %+v\n",
-					sku, attributes)
-				/*
-					if err := insertInstanceSku(db, sku, attributes); err != nil {
-						return err
+	if pl.PublicationDate.IsZero() {
+		return nil, fmt.Errorf("price list %s has no publication date", source)
+	}
+	return pl, nil
+}
+
+// Reads the price list's top level object. The products and terms are
+// decoded entry by entry, everything else that isn't needed is skipped.
+func decodePriceList(dec *json.Decoder, w *batchWriter, pl *PriceList) error {
+	return eachEntry(dec, func(key string) error {
+		switch key {
+		case "version":
+			return dec.Decode(&pl.Version)
+		case "publicationDate":
+			var date string
+			if err := dec.Decode(&date); err != nil {
+				return err
+			}
+			t, err := time.Parse(time.RFC3339, date)
+			if err != nil {
+				return err
+			}
+			pl.PublicationDate = t
+			return nil
+		case "products":
+			return eachEntry(dec, func(sku string) error {
+				var p product
+				if err := dec.Decode(&p); err != nil {
+					return fmt.Errorf("product %s: %v", sku, err)
+				}
+				pl.Products++
+				return insertProduct(w, sku, p)
+			})
+		case "terms":
+			return eachEntry(dec, func(termType string) error {
+				return eachEntry(dec, func(sku string) error {
+					var offers map[string]offerTerm
+					if err := dec.Decode(&offers); err != nil {
+						return fmt.Errorf("%s terms for %s: %v", termType, sku, err)
 					}
-				*/
-			} else if pf == "Storage" {
-				fmt.Printf("sku %s storage: %v\n", sku, attributes)
-				/*
-					if err := insertVolumeType(db, attributes); err != nil {
-						return err
+					for _, offer := range offers {
+						n, err := insertOfferTerm(w, termType, offer)
+						if err != nil {
+							return err
+						}
+						pl.Prices += n
 					}
-				*/
+					return nil
+				})
+			})
+		}
+		var skip json.RawMessage
+		return dec.Decode(&skip)
+	})
+}
 
-			} else {
-				fmt.Printf(
-					"sku %s: missing handler for product family %s\n", sku, pf)
-			}
-		} else {
-			fmt.Printf("no attr on %+v\n", p)
+// Calls f with each key of the object that comes next in dec. f has to
+// consume the value.
+func eachEntry(dec *json.Decoder, f func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected an object key but got %v", t)
+		}
+		if err = f(key); err != nil {
+			return err
 		}
-		// populate pricing tiers
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v but got %v", delim, t)
 	}
 	return nil
 }
 
-func insertVolumeType(db *sql.DB, attributes map[string]interface{}) error {
-	vtype, _ := attributes["volumeType"].(string) // is this right?
-	media, _ := attributes["storageMedia"].(string)
+// Writes rows in transactions of batchSize rows. A price list has
+// millions of rows, which is too many for one transaction and too
+// slow with one transaction each.
+type batchWriter struct {
+	ctx   context.Context
+	db    *sql.DB
+	tx    *sql.Tx
+	stmts map[string]*sql.Stmt
+	rows  int
+}
 
-	rawSize, _ := attributes["maxVolumeSize"].(string)
-	var size uint32
-	var sizeGb uint32
-	res, err := fmt.Sscanf(rawSize, "%d TiB", &size)
-	if err != nil && res == 1 {
-		sizeGb = size * 1000 // or 1024?
-	} else {
-		return fmt.Errorf("failed to parse maxVolumeSize %s: %v %d\n", rawSize, err, res)
+func (w *batchWriter) exec(query string, args ...interface{}) error {
+	if w.tx == nil {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		tx, err := w.db.BeginTx(w.ctx, nil)
+		if err != nil {
+			return err
+		}
+		w.tx = tx
+		w.stmts = make(map[string]*sql.Stmt)
 	}
-	rawIops, _ := attributes["maxIopsvolume"].(string)
-	var iops uint32
-	tmp, err := strconv.Atoi(rawIops)
-	iops = uint32(tmp)
-	if err != nil {
-		var tmp int
-		res, err = fmt.Sscanf(rawIops, "%d - based on %d MiB I/O size", &iops, &tmp)
-		if err != nil || res != 1 {
-			res, err = fmt.Sscanf(rawIops, "%d - %d", &tmp, &iops)
-			if err != nil || res != 1 {
-				return fmt.Errorf("failed to parse maxIopsvolume %s\n", rawIops)
-			}
+	stmt := w.stmts[query]
+	if stmt == nil {
+		var err error
+		if stmt, err = w.tx.Prepare(query); err != nil {
+			return err
 		}
-		log.Printf("parsed %d iops out of %s\n", iops, rawIops)
+		w.stmts[query] = stmt
 	}
-	rawThroughput, _ := attributes["maxThroughputvolume"].(string)
-	var throughput uint32
-	res, err = fmt.Sscanf(rawThroughput, "%d MiB/s", &throughput)
-	if err != nil || res != 1 {
-		return fmt.Errorf("failed to parse throughput %s\n", rawThroughput)
+	if _, err := stmt.Exec(args...); err != nil {
+		return err
+	}
+	w.rows++
+	if w.rows >= batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *batchWriter) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx = nil
+	w.rows = 0
+	return err
+}
+
+func (w *batchWriter) rollback() {
+	if w.tx != nil {
+		w.tx.Rollback()
+		w.tx = nil
+		w.rows = 0
+	}
+}
+
+// Returns nil for an empty string, so that missing attributes are
+// stored as NULL.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
 	}
-	insert := `REPLACE INTO VolumeTypes (VolumeType, StorageMedia,
-	MaxVolumeSize, MaxIOPS, MaxThroughput) VALUES (?, ?, ?, ?, ?);`
-	stmt, err := db.Prepare(insert)
-	_, err = stmt.Exec(vtype, media, sizeGb, iops, throughput)
+	return s
+}
+
+func insertProduct(w *batchWriter, sku string, p product) error {
+	a := p.Attributes
+	region := getRegion(a["regionCode"], a["location"], a["locationType"])
+	fromRegion := getRegion(a["fromRegionCode"], a["fromLocation"], a["fromLocationType"])
+	toRegion := getRegion(a["toRegionCode"], a["toLocation"], a["toLocationType"])
+	err := w.exec(`REPLACE INTO Products (Sku, ProductFamily, Region,
+	UsageType, Operation, InstanceType, Tenancy, OperatingSystem,
+	LicenseModel, PreInstalledSw, CapacityStatus, VolumeApiName,
+	TransferType, FromRegion, ToRegion)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		sku, p.ProductFamily, nullable(region), nullable(a["usagetype"]),
+		nullable(a["operation"]), nullable(a["instanceType"]), nullable(a["tenancy"]),
+		nullable(a["operatingSystem"]), nullable(a["licenseModel"]),
+		nullable(a["preInstalledSw"]), nullable(a["capacitystatus"]),
+		nullable(a["volumeApiName"]), nullable(a["transferType"]),
+		nullable(fromRegion), nullable(toRegion))
 	if err != nil {
 		return err
 	}
-	region, err := getRegion(db, attributes)
+	// Not covering "Dedicated Host" for now.
+	switch p.ProductFamily {
+	case "Compute Instance", "Compute Instance (bare metal)":
+		if region != "" {
+			return insertInstanceSku(w, sku, region, a)
+		}
+	case "Storage":
+		return insertVolumeType(w, region, a)
+	}
+	return nil
+}
+
+// Inserts the term and its price dimensions. Returns the number of
+// price dimensions.
+func insertOfferTerm(w *batchWriter, termType string, offer offerTerm) (int, error) {
+	ta := offer.TermAttributes
+	err := w.exec(`REPLACE INTO Terms (Sku, OfferTermCode, TermType,
+	EffectiveDate, LeaseContractLength, OfferingClass, PurchaseOption)
+	VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		offer.Sku, offer.OfferTermCode, termType, nullable(offer.EffectiveDate),
+		nullable(ta["LeaseContractLength"]), nullable(ta["OfferingClass"]),
+		nullable(ta["PurchaseOption"]))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if region != "" {
-		regionInsert := `REPLACE INTO VolumeTypeByRegion (
-			VolumeType, Region) VALUES (?, ?);`
-		stmt, err = db.Prepare(regionInsert)
-		_, err = stmt.Exec(vtype, region)
+	for _, pd := range offer.PriceDimensions {
+		// Prices are in USD, except in China, where they are in CNY.
+		currency := "USD"
+		price, ok := pd.PricePerUnit[currency]
+		if !ok {
+			for currency, price = range pd.PricePerUnit {
+				break
+			}
+		}
+		ppu, err := strconv.ParseFloat(price, 64)
 		if err != nil {
-			return err
+			return 0, fmt.Errorf("price dimension %s: %v", pd.RateCode, err)
+		}
+		var begin, end interface{}
+		if pd.BeginRange != "" {
+			if begin, err = strconv.ParseFloat(pd.BeginRange, 64); err != nil {
+				return 0, fmt.Errorf("price dimension %s: %v", pd.RateCode, err)
+			}
+		}
+		// An end of Inf is stored as NULL.
+		if pd.EndRange != "" && pd.EndRange != "Inf" {
+			if end, err = strconv.ParseFloat(pd.EndRange, 64); err != nil {
+				return 0, fmt.Errorf("price dimension %s: %v", pd.RateCode, err)
+			}
+		}
+		err = w.exec(`REPLACE INTO PriceDimensions (RateCode, Sku, OfferTermCode,
+		Description, Unit, BeginRange, EndRange, PricePerUnit, Currency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+			pd.RateCode, offer.Sku, offer.OfferTermCode, pd.Description, pd.Unit,
+			begin, end, ppu, currency)
+		if err != nil {
+			return 0, err
 		}
-	} else {
-		fmt.Printf("missing handler for location type %s for volume type %s\n",
-			attributes["locationType"], vtype)
 	}
-	return nil
+	return len(offer.PriceDimensions), nil
 }
 
-func insertInstanceSku(db *sql.DB, sku string, attributes map[string]interface{}) error {
-	var itype string
-	itype, ok := attributes["instanceType"].(string)
-	if !ok {
+// Maxima are e.g. "16 TiB", "64000", "500 - based on 1 MiB I/O size",
+// "40 - 200" or "1000 MiB/s". Returns 0 if there is no number.
+func parseMaximum(value string) uint32 {
+	numbers := regexp.MustCompile(`[0-9]+`).FindAllString(value, -1)
+	if len(numbers) == 0 {
+		return 0
+	}
+	n := numbers[len(numbers)-1]
+	if strings.Contains(value, "based on") {
+		n = numbers[0]
+	}
+	max, _ := strconv.Atoi(n)
+	if strings.HasSuffix(value, "TiB") {
+		max *= 1024
+	}
+	return uint32(max)
+}
+
+// The price list has the current maxima of the volume types, the
+// minimum size and multi-attach only come from StandardVolumeTypes.
+func insertVolumeType(w *batchWriter, region string, attributes map[string]string) error {
+	apiType := attributes["volumeApiName"]
+	if apiType == "" {
+		return nil
+	}
+	err := w.exec(`INSERT INTO VolumeTypes (VolumeApiType, VolumeType,
+	StorageMedia, MaxVolumeSize, MaxIOPS, MaxThroughput) VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (VolumeApiType) DO UPDATE SET VolumeType=excluded.VolumeType,
+	StorageMedia=excluded.StorageMedia, MaxVolumeSize=excluded.MaxVolumeSize,
+	MaxIOPS=excluded.MaxIOPS, MaxThroughput=excluded.MaxThroughput;`,
+		apiType, attributes["volumeType"], attributes["storageMedia"],
+		parseMaximum(attributes["maxVolumeSize"]), parseMaximum(attributes["maxIopsvolume"]),
+		parseMaximum(attributes["maxThroughputvolume"]))
+	if err != nil {
+		return err
+	}
+	if region == "" {
+		return nil
+	}
+	return w.exec(`REPLACE INTO VolumeTypeByRegion (VolumeApiType, Region)
+	VALUES (?, ?);`, apiType, region)
+}
+
+func insertInstanceSku(w *batchWriter, sku string, region string,
+	attributes map[string]string) error {
+	itype := attributes["instanceType"]
+	if itype == "" {
 		return fmt.Errorf("attribute map %v for sku %s missing instance type", attributes, sku)
 	}
-	region, err := getRegion(db, attributes)
+	usage, err := ParseUsageType(attributes["usagetype"])
 	if err != nil {
 		return err
 	}
+	operation, err := ParseOperation(attributes["operation"])
+	if err != nil {
+		return err
+	}
+	if operation == "" {
+		// RunInstances:FFP codes only exist in GovCloud, not supported.
+		return nil
+	}
+	return w.exec(`REPLACE INTO Sku (Sku, ProductType, Region, Usage, Operation)
+	VALUES (?, ?, ?, ?, ?);`, sku, itype, region, usage, operation)
+}
+
+// Usage types are of the form "[ShortLocation-]Usage:instance type", as in
+// the price list and in Cost and Usage Reports. Returns the usage, e.g.
+// BoxUsage or SpotUsage.
+func ParseUsageType(usageType string) (string, error) {
 	// regexp for usage: shortlocation-Usage:instance type
 	re := regexp.MustCompile(`([A-Z1-9-]+-)?([^:]+):.*`)
-	usagePieces := re.FindStringSubmatch(attributes["usagetype"].(string))
-	usage := "BoxUsage"
+	usagePieces := re.FindStringSubmatch(usageType)
 	if len(usagePieces) == 3 {
-		usage = usagePieces[2]
+		return usagePieces[2], nil
 	} else if len(usagePieces) == 2 {
-		usage = usagePieces[1]
-	} else {
-		return fmt.Errorf("Failed to parse usage type %s\n", attributes["usagetype"].(string))
-	}
-	operation := "RunInstances"
-	op, _ := attributes["operation"].(string)
-	if op == "Hourly" {
-		operation = "Hourly"
-	} else {
-		// regexp for operation: Hourly or RunInstances[:[0-9]4]?.
-		parts := strings.Split(op, ":")
-		if len(parts) != 2 {
-			return fmt.Errorf("operation format not recognised: %s\n", op)
-		}
-		if strings.HasPrefix(parts[1], "FFP") {
-			log.Printf("RunInstances:FFP codes only exist in GovCloud, not supported.\n")
-			return nil
-		}
-		operation = parts[1]
+		return usagePieces[1], nil
 	}
-	insert := `REPLACE INTO Sku (Sku, ProductType, Region, Usage, Operation)
-	VALUES (?, ?, ?, ?, ?);`
-	stmt, err := db.Prepare(insert)
-	_, err = stmt.Exec(sku, itype, region, usage, operation)
-	if err != nil {
-		return err
+	return "", fmt.Errorf("Failed to parse usage type %s\n", usageType)
+}
+
+// Returns "Hourly", "RunInstances" or the code after "RunInstances:",
+// see createSkuTable. Returns an empty operation for GovCloud codes.
+func ParseOperation(op string) (string, error) {
+	if op == "Hourly" || op == "RunInstances" {
+		return op, nil
 	}
-	return nil
+	// regexp for operation: Hourly or RunInstances[:[0-9]4]?.
+	parts := strings.Split(op, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("operation format not recognised: %s\n", op)
+	}
+	if strings.HasPrefix(parts[1], "FFP") {
+		return "", nil
+	}
+	return parts[1], nil
 }
 
-func getRegion(db *sql.DB, attributes map[string]interface{}) (string, error) {
-	if attributes["locationType"].(string) == "AWS Region" {
-		regionId := RegionByDisplayName(attributes["location"].(string))
-		if regionId == "" {
-			log.Printf("region %s is not supported.\n",
-				attributes["location"].(string))
-			return "", nil
-		}
-		return regionId, nil
+// Returns the region for a location in the price list, or "" if the
+// location is not a supported region. Newer price lists have the region
+// code, older ones only the display name, which sometimes differs from
+// the one in the SDK, e.g. "EU (Frankfurt)" vs. "Europe (Frankfurt)".
+func getRegion(code string, location string, locationType string) string {
+	if locationType != "AWS Region" {
+		return ""
+	}
+	if _, ok := Regions[code]; ok && code != "" {
+		return code
+	}
+	if region := RegionByDisplayName(location); region != "" {
+		return region
+	}
+	if strings.HasPrefix(location, "EU (") {
+		return RegionByDisplayName("Europe (" + strings.TrimPrefix(location, "EU ("))
 	}
-	log.Printf("unsupported location type: %s\n",
-		attributes["locationType"].(string))
-	return "", nil
+	return ""
 }
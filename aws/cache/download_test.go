@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"nephomancy/aws/fake"
+	"nephomancy/aws/resources"
+	"nephomancy/common/metadata"
+	"path/filepath"
+	"testing"
+)
+
+const priceListFile = "../fake/testdata/offers/v1.0/aws/AmazonEC2/current/index.json"
+
+func emptyDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = CreateOrUpdateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestIngestPriceListFromFile(t *testing.T) {
+	db := emptyDb(t)
+	defer db.Close()
+	// Small batches so that the fixture takes several transactions.
+	defer func(n int) { batchSize = n }(batchSize)
+	batchSize = 7
+
+	pl, err := IngestPriceList(context.Background(), db, priceListFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Products != 36 || pl.Prices != 52 || pl.Version != "20261001120000" {
+		t.Errorf("expected 36 products and 52 prices of version 20261001120000, got %+v", pl)
+	}
+	var n int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM PriceDimensions`).Scan(&n); err != nil || n != 52 {
+		t.Errorf("expected 52 price dimensions, got %d (%v)", n, err)
+	}
+	var price float64
+	err = db.QueryRow(`SELECT pd.PricePerUnit FROM Products p
+	JOIN Terms t ON p.Sku=t.Sku JOIN PriceDimensions pd
+	ON t.Sku=pd.Sku AND t.OfferTermCode=pd.OfferTermCode
+	WHERE p.InstanceType='m5.large' AND p.Region='eu-central-1'
+	AND p.Tenancy='Shared' AND t.TermType='OnDemand'`).Scan(&price)
+	if err != nil || price != 0.115 {
+		t.Errorf("expected 0.115 for m5.large in eu-central-1, got %f (%v)", price, err)
+	}
+	var reserved int
+	err = db.QueryRow(`SELECT COUNT(*) FROM Terms WHERE Sku='T3MICROUSE1LNX'
+	AND TermType='Reserved' AND LeaseContractLength='1yr'`).Scan(&reserved)
+	if err != nil || reserved != 4 {
+		t.Errorf("expected 4 one year reserved terms for t3.micro, got %d (%v)", reserved, err)
+	}
+	var region sql.NullString
+	err = db.QueryRow(`SELECT Region FROM Products WHERE Sku='T3MICROLAXLNX'`).Scan(&region)
+	if err != nil || region.Valid {
+		t.Errorf("expected no region for a Local Zone, got %v (%v)", region, err)
+	}
+	// Local Zones aren't in the Sku table either.
+	if err = db.QueryRow(`SELECT COUNT(*) FROM Sku`).Scan(&n); err != nil || n != 13 {
+		t.Errorf("expected 13 instance skus, got %d (%v)", n, err)
+	}
+	// gp2 and gp3 are both General Purpose. The minimum size comes from
+	// the standard volume types.
+	var maxThroughput, minSize int
+	err = db.QueryRow(`SELECT MaxThroughput FROM VolumeTypes
+	WHERE VolumeApiType='gp2'`).Scan(&maxThroughput)
+	if err != nil || maxThroughput != 250 {
+		t.Errorf("expected gp2 up to 250 MiB/s, got %d (%v)", maxThroughput, err)
+	}
+	err = db.QueryRow(`SELECT MaxThroughput, MinVolumeSize FROM VolumeTypes
+	WHERE VolumeApiType='st1'`).Scan(&maxThroughput, &minSize)
+	if err != nil || maxThroughput != 500 || minSize != 125 {
+		t.Errorf("expected st1 from 125 GiB up to 500 MiB/s, got %d and %d (%v)",
+			minSize, maxThroughput, err)
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM VolumeTypeByRegion
+	WHERE Region='us-east-1'`).Scan(&n)
+	if err != nil || n != 6 {
+		t.Errorf("expected 6 volume types in us-east-1, got %d (%v)", n, err)
+	}
+
+	rates, err := ListRates(db)
+	if err != nil || len(rates) != 52 {
+		t.Errorf("expected 52 rates, got %d (%v)", len(rates), err)
+	}
+
+	if err = RecordRefresh(db, pl); err != nil {
+		t.Fatal(err)
+	}
+	md, err := metadata.Read(db)
+	if err != nil || md.SourceDate != "2026-10-01" || md.SourceVersion != pl.Version {
+		t.Errorf("expected the price list's date and version, got %+v (%v)", md, err)
+	}
+}
+
+// A reissued sku replaces the old one rather than adding a second price.
+func TestIngestPriceListTwice(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	data, err := ioutil.ReadFile(priceListFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reissued := filepath.Join(t.TempDir(), "index.json")
+	data = bytes.ReplaceAll(data, []byte("T3MICROUSE1LNX"), []byte("T3MICROUSE1LNX2"))
+	if err = ioutil.WriteFile(reissued, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = IngestPriceList(context.Background(), db, reissued); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM Products`).Scan(&n); err != nil || n != 36 {
+		t.Errorf("expected 36 products after the second price list, got %d (%v)", n, err)
+	}
+	price, err := getOnDemandPrice(db, &resources.Ec2VM{InstanceType: "t3.micro",
+		Region: "us-east-1"})
+	if err != nil || rateSku(*price) != "T3MICROUSE1LNX2" {
+		t.Errorf("expected the price of the reissued sku, got %v (%v)", price, err)
+	}
+}
+
+func TestIngestPriceListFromFake(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	resources.Endpoint = server.URL
+	defer func() { resources.Endpoint = "" }()
+	db := emptyDb(t)
+	defer db.Close()
+
+	// The first attempt fails and is retried.
+	server.FailNext(1)
+	pl, err := IngestPriceList(context.Background(), db, PriceListUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Products != 36 || pl.Source != server.URL+PriceListPath {
+		t.Errorf("expected 36 products from the fake, got %+v", pl)
+	}
+}
+
+func TestIngestPriceListMissingFile(t *testing.T) {
+	db := emptyDb(t)
+	defer db.Close()
+	if _, err := IngestPriceList(context.Background(), db, "testdata/nonexistent.json"); err == nil {
+		t.Errorf("expected an error for a missing price list")
+	}
+}
+
+func TestParseUsageType(t *testing.T) {
+	for usageType, want := range map[string]string{
+		"BoxUsage:t3.medium":           "BoxUsage",
+		"EUC1-BoxUsage:m5.large":       "BoxUsage",
+		"USW2-SpotUsage:c5.xlarge":     "SpotUsage",
+		"APN1-DedicatedUsage:r5.large": "DedicatedUsage",
+		"EBS:VolumeUsage.gp2":          "EBS",
+	} {
+		got, err := ParseUsageType(usageType)
+		if err != nil || got != want {
+			t.Errorf("ParseUsageType(%q) = %q, %v, want %q", usageType, got, err, want)
+		}
+	}
+	if _, err := ParseUsageType("DataTransfer-Out-Bytes"); err == nil {
+		t.Error("expected an error for a usage type without an instance type")
+	}
+}
+
+func TestParseOperation(t *testing.T) {
+	for op, want := range map[string]string{
+		"RunInstances":          "RunInstances",
+		"RunInstances:0002":     "0002",
+		"Hourly":                "Hourly",
+		"RunInstances:FFP-0010": "",
+	} {
+		got, err := ParseOperation(op)
+		if err != nil || got != want {
+			t.Errorf("ParseOperation(%q) = %q, %v, want %q", op, got, err, want)
+		}
+	}
+	if _, err := ParseOperation("CreateVolume-Gp2"); err == nil {
+		t.Error("expected an error for an operation that is not for instances")
+	}
+}
+
+func TestParseMaximum(t *testing.T) {
+	for value, want := range map[string]uint32{
+		"16 TiB":                        16384,
+		"64000":                         64000,
+		"1000 MiB/s":                    1000,
+		"40 - 200":                      200,
+		"500 - based on 1 MiB I/O size": 500,
+		"":                              0,
+	} {
+		if got := parseMaximum(value); got != want {
+			t.Errorf("parseMaximum(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestGetRegion(t *testing.T) {
+	for _, c := range []struct{ code, location, locationType, want string }{
+		{"eu-central-1", "EU (Frankfurt)", "AWS Region", "eu-central-1"},
+		// Older price lists only have the display name.
+		{"", "EU (Frankfurt)", "AWS Region", "eu-central-1"},
+		{"", "US East (N. Virginia)", "AWS Region", "us-east-1"},
+		{"us-east-1-lax-1", "US West (Los Angeles)", "AWS Local Zone", ""},
+		{"", "Any", "Other", ""},
+	} {
+		if got := getRegion(c.code, c.location, c.locationType); got != c.want {
+			t.Errorf("getRegion(%q, %q, %q) = %q, want %q", c.code, c.location,
+				c.locationType, got, c.want)
+		}
+	}
+}
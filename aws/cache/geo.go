@@ -236,7 +236,7 @@ func RegionByDisplayName(name string) string {
 // Returns all regions consistent with loc.
 // If preferred is not empty, and is contained in the possible regions,
 // return only preferred region.
-func RegionsForLocation(loc common.Location, preferred string) []string {
+func RegionsForLocation(loc *common.Location, preferred string) []string {
 	var regions []string
 	if loc.CountryCode != "" {
 		regions = RegionsByCountry(loc.CountryCode)
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
+	"nephomancy/common/query"
+	"nephomancy/common/registry"
 	common "nephomancy/common/resources"
 	"strings"
 )
@@ -14,32 +16,29 @@ import (
 // the list of matching regions where it is available.
 // If several instance types match the spec, the smallest one is returned.
 // If there are several smallest types, you get one of them.
-// TODO: take more features into account, like scheduling.
-// TODO: also look at gpu count and local storage support.
-func getInstanceTypeForSpec(db *sql.DB, mt common.MachineType, r []string) (
+// For the Spot purchase option, only instance types that can run as spot
+// instances are considered.
+// TODO: also look at gpu count.
+func getInstanceTypeForSpec(db *sql.DB, mt *common.MachineType, po string, r []string) (
 	string, []string, error) {
-	var regionsClause strings.Builder
-	fmt.Fprintf(&regionsClause, "(")
-	for idx, region := range r {
-		fmt.Fprintf(&regionsClause, "'%s'", region)
-		if idx < len(r)-1 {
-			fmt.Fprintf(&regionsClause, ",")
-		}
+	if err := common.CheckMachineType(mt); err != nil {
+		return "", nil, err
 	}
-	fmt.Fprintf(&regionsClause, ")")
-	queryMachineType := fmt.Sprintf(`SELECT DISTINCT it.InstanceType, r.Region
+	q := query.New(`SELECT DISTINCT it.InstanceType, r.Region
 	FROM InstanceTypes it join InstanceTypeByRegion r ON
 	it.InstanceType=r.InstanceType
 	JOIN CoreCount c on it.InstanceType=c.InstanceType
-	WHERE r.Region in %s AND c.CoreCount >= %d AND c.CoreCount <= %d
-	AND it.Memory >= %d AND it.Memory <= %d
-	ORDER BY c.CoreCount ASC, it.Memory ASC, it.InstanceType ASC LIMIT 1;`,
-		regionsClause.String(), mt.CpuCount, mt.CpuCount*2,
-		mt.MemoryGb*1000, mt.MemoryGb*2000)
-
-	fmt.Printf("query: %s\n", queryMachineType)
+	WHERE c.CoreCount >= ? AND c.CoreCount <= ?
+	AND it.Memory >= ? AND it.Memory <= ?`,
+		mt.CpuCount, mt.CpuCount*2, mt.MemoryGb*1000, mt.MemoryGb*2000)
+	q.In(" AND", "r.Region", r)
+	addFeatures(q, mt)
+	if po == "Spot" {
+		q.Add(" AND it.SupportsSpot=1")
+	}
+	q.Add(" ORDER BY c.CoreCount ASC, it.Memory ASC, it.InstanceType ASC LIMIT 1;")
 
-	res, err := db.Query(queryMachineType)
+	res, err := q.Query(db)
 	if err != nil {
 		return "", []string{}, err
 	}
@@ -58,3 +57,76 @@ func getInstanceTypeForSpec(db *sql.DB, mt common.MachineType, r []string) (
 	}
 	return "", nil, fmt.Errorf("Failed to find a suitable machine type for %v in %v", mt, r)
 }
+
+// Lists the instance types satisfying the spec and purchase option in the
+// regions for loc, smallest first. These are the instance types
+// getInstanceTypeForSpec chooses from. The cpu count is the smallest
+// matching core count, since that is what specs are matched on.
+func ListInstanceTypes(db *sql.DB, mt *common.MachineType, po string, loc *common.Location) (
+	[]registry.MachineType, error) {
+	if err := common.CheckMachineType(mt); err != nil {
+		return nil, err
+	}
+	q := query.New(`SELECT it.InstanceType, MIN(c.CoreCount), it.Memory, r.Region
+	FROM InstanceTypes it join InstanceTypeByRegion r ON
+	it.InstanceType=r.InstanceType
+	JOIN CoreCount c on it.InstanceType=c.InstanceType
+	WHERE c.CoreCount >= ? AND c.CoreCount <= ?
+	AND it.Memory >= ? AND it.Memory <= ?`,
+		mt.CpuCount, mt.CpuCount*2, mt.MemoryGb*1000, mt.MemoryGb*2000)
+	q.In(" AND", "r.Region", RegionsForLocation(loc, ""))
+	addFeatures(q, mt)
+	if po == "Spot" {
+		q.Add(" AND it.SupportsSpot=1")
+	}
+	q.Add(" GROUP BY it.InstanceType, r.Region")
+	q.Add(" ORDER BY MIN(c.CoreCount) ASC, it.Memory ASC, it.InstanceType ASC, r.Region ASC;")
+
+	res, err := q.Query(db)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	its := make([]registry.MachineType, 0)
+	for res.Next() {
+		var it registry.MachineType
+		var reg string
+		if err = res.Scan(&it.Name, &it.CpuCount, &it.MemoryMb, &reg); err != nil {
+			return nil, err
+		}
+		if last := len(its) - 1; last >= 0 && its[last].Name == it.Name {
+			its[last].Regions = append(its[last].Regions, reg)
+			continue
+		}
+		it.Regions = []string{reg}
+		its = append(its, it)
+	}
+	return its, res.Err()
+}
+
+// Adds conditions on the InstanceTypes table for the optional
+// parts of the spec (cpu architecture, local ssd, network performance).
+// Instance types that were cached without an architecture are assumed
+// to be x86_64.
+func addFeatures(q *query.Builder, mt *common.MachineType) {
+	if common.IsArmArchitecture(mt.CpuArchitecture) {
+		q.Add(" AND it.Architecture='arm64'")
+	} else if common.IsX86Architecture(mt.CpuArchitecture) {
+		q.Add(" AND IFNULL(it.Architecture, 'x86_64')='x86_64'")
+	}
+	if mt.LocalSsd {
+		// Instance storage that isn't NVMe is SATA, which is the
+		// closest thing AWS has to SCSI.
+		switch strings.ToLower(mt.LocalSsdInterface) {
+		case "nvme":
+			q.Add(" AND it.StorageType='nvme ssd'")
+		case "scsi":
+			q.Add(" AND it.StorageType='ssd'")
+		default:
+			q.Add(" AND it.StorageType IN ('ssd', 'nvme ssd')")
+		}
+	}
+	if mt.NetworkGbps > 0 {
+		q.Add(" AND it.NetworkPerformance >= ?", mt.NetworkGbps)
+	}
+}
@@ -30,7 +30,7 @@ func TestGetInstanceTypeForSpec(t *testing.T) {
 		CpuCount: 1,
 		MemoryGb: 1,
 	}
-	it, regions, err := getInstanceTypeForSpec(db, spec, []string{"us-east-1"})
+	it, regions, err := getInstanceTypeForSpec(db, spec, "", []string{"us-east-1"})
 	if err != nil {
 		t.Errorf("getInstanceTypeForSpec failed: %v\n", err)
 	}
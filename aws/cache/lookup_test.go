@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"database/sql"
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/anypb"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"testing"
+)
+
+// Returns an in-memory cache with a few instance types in us-east-1.
+func memoryDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = CreateOrUpdateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	itypes := []resources.InstanceType{
+		{Name: "t3.micro", MemoryMiB: 1024, DefaultCpuCount: 2, ValidCores: []uint32{1},
+			CpuArchitecture: "x86_64", SupportedUsageClasses: []string{"on-demand", "spot"}},
+		{Name: "t4g.micro", MemoryMiB: 1024, DefaultCpuCount: 2, ValidCores: []uint32{1},
+			CpuArchitecture: "arm64", SupportedUsageClasses: []string{"on-demand"}},
+	}
+	names := make([]string, 0)
+	for _, it := range itypes {
+		if err = InsertInstanceType(db, it); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, it.Name)
+	}
+	if err = InsertInstanceTypesForRegion(db, names, "us-east-1"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestGetInstanceTypeForSpecInMemory(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	spec := &common.MachineType{CpuCount: 1, MemoryGb: 1, CpuArchitecture: "arm64"}
+	it, regions, err := getInstanceTypeForSpec(db, spec, "", []string{"us-east-1"})
+	if err != nil || it != "t4g.micro" || len(regions) != 1 {
+		t.Errorf("expected t4g.micro in us-east-1 but got %s %v (%v)", it, regions, err)
+	}
+	// t4g.micro can't run as a spot instance in this cache.
+	if _, _, err = getInstanceTypeForSpec(db, spec, "Spot", []string{"us-east-1"}); err == nil {
+		t.Errorf("expected no arm64 spot instance type")
+	}
+}
+
+func TestGetInstanceTypeForSpecHostileRegion(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	spec := &common.MachineType{CpuCount: 1, MemoryGb: 1}
+	// Pasted into the SQL, this region would match every region.
+	hostile := "nowhere') OR ('1'='1"
+	it, _, err := getInstanceTypeForSpec(db, spec, "", []string{hostile})
+	if err == nil {
+		t.Errorf("expected no instance type for region %s but got %s", hostile, it)
+	}
+}
+
+func TestFillInSpec(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	avm, _ := ptypes.MarshalAny(&resources.Ec2VM{InstanceType: "t4g.micro", Region: "us-east-1",
+		TermType: "Spot", Os: "Linux"})
+	dsk, _ := ptypes.MarshalAny(&resources.Ec2Disk{VolumeType: "st1", ActualSizeGb: 500,
+		Region: "us-east-1"})
+	p := &common.Project{
+		InstanceSets: []*common.InstanceSet{{Name: "web", Count: 1, Template: &common.Instance{
+			ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: avm}}}},
+		DiskSets: []*common.DiskSet{{Name: "logs", Count: 1, Template: &common.Disk{
+			ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: dsk}}}},
+	}
+	if err := FillInSpec(db, p); err != nil {
+		t.Fatal(err)
+	}
+	vm := p.InstanceSets[0].Template
+	want := &common.MachineType{CpuCount: 2, MemoryGb: 1, CpuArchitecture: "arm64"}
+	if diff := deep.Equal(want, vm.Type); diff != nil {
+		t.Errorf("unexpected machine type: %v", diff)
+	}
+	if vm.Os != "linux" || vm.PurchaseOption != "Spot" || vm.Location.CountryCode != "US" {
+		t.Errorf("unexpected spec %v", vm)
+	}
+	d := p.DiskSets[0].Template
+	if d.Type.DiskTech != "Standard" || d.Type.SizeGb != 500 || d.Location.CountryCode != "US" {
+		t.Errorf("unexpected disk spec %v", d)
+	}
+	// Savings Plans are commitments.
+	avm, _ = ptypes.MarshalAny(&resources.Ec2VM{InstanceType: "t4g.micro", Region: "us-east-1",
+		TermType: "SavingsPlan", SavingsPlanType: "Compute", LeaseContractLength: "3yr"})
+	p.InstanceSets[0].Template = &common.Instance{
+		ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: avm}}
+	if err := FillInSpec(db, p); err != nil {
+		t.Fatal(err)
+	}
+	if po := p.InstanceSets[0].Template.PurchaseOption; po != "Commit3Yr" {
+		t.Errorf("expected purchase option Commit3Yr, got %s", po)
+	}
+	// The spec of an instance type that is not in the cache is unknown.
+	avm, _ = ptypes.MarshalAny(&resources.Ec2VM{InstanceType: "x9.huge", Region: "us-east-1"})
+	p.InstanceSets[0].Template = &common.Instance{
+		ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: avm}}
+	if err := FillInSpec(db, p); err == nil {
+		t.Errorf("expected an error for an unknown instance type")
+	}
+}
+
+func TestFillInProviderDetailsOs(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	for os, want := range map[string]string{
+		"linux": "Linux", "ubuntu": "Linux", "windows": "Windows",
+		"Windows Server 2019": "Windows", "rhel": "RHEL", "sles": "SUSE",
+	} {
+		p := &common.Project{InstanceSets: []*common.InstanceSet{{Name: "web", Count: 1,
+			Template: &common.Instance{Os: os,
+				Location: &common.Location{CountryCode: "US"},
+				Type:     &common.MachineType{CpuCount: 1, MemoryGb: 1}}}}}
+		if err := FillInProviderDetailsIn(db, p, "us-east-1"); err != nil {
+			t.Fatal(err)
+		}
+		var avm resources.Ec2VM
+		if err := ptypes.UnmarshalAny(p.InstanceSets[0].Template.ProviderDetails[resources.AwsProvider], &avm); err != nil {
+			t.Fatal(err)
+		}
+		if avm.Os != want || avm.Tenancy != "Shared" || avm.LicenseModel != "No License required" {
+			t.Errorf("os %s: expected a shared %s instance, got %+v", os, want, &avm)
+		}
+	}
+}
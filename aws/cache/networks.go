@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"math"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"sort"
+	"strings"
+)
+
+// The usage types of a gateway's hourly charge and of its charge per GB
+// processed. Load balancers other than the classic one charge for
+// capacity units instead of data processed, which depend on traffic
+// patterns that aren't in the spec.
+type gatewayUsage struct {
+	Hours     string
+	Processed string
+}
+
+var gatewayUsageTypes = map[string]gatewayUsage{
+	"NAT Gateway":               {"NatGateway-Hours", "NatGateway-Bytes"},
+	"Load Balancer":             {"LoadBalancerUsage", "DataProcessing-Bytes"},
+	"Load Balancer-Application": {"LoadBalancerUsage", ""},
+	"Load Balancer-Network":     {"LoadBalancerUsage", ""},
+	"Load Balancer-Gateway":     {"LoadBalancerUsage", ""},
+}
+
+// Public IPv4 addresses are charged by the hour whether they are Elastic
+// IPs or not.
+const ipAddressUsageType = "PublicIPv4:InUseAddress"
+
+// Returns true if a network has been placed with another provider,
+// which may only have put details on its subnetworks or gateways.
+func placedElsewhere(nw *common.Network) bool {
+	if common.OtherProviders(resources.AwsProvider, nw.ProviderDetails) {
+		return true
+	}
+	for _, snw := range nw.Subnetworks {
+		if common.OtherProviders(resources.AwsProvider, snw.ProviderDetails) {
+			return true
+		}
+		for _, gw := range snw.Gateways {
+			if common.OtherProviders(resources.AwsProvider, gw.ProviderDetails) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the on-demand rates of a product in the region. Usage types
+// outside us-east-1 start with a region prefix such as EUC1-.
+func getProductRates(db *sql.DB, family string, region string, usageType string) ([]rate, error) {
+	rates, err := getRates(db, `SELECT pd.RateCode, IFNULL(pd.BeginRange, 0),
+	pd.EndRange, pd.PricePerUnit, pd.Currency, pd.Unit
+	FROM Products p JOIN Terms t ON p.Sku=t.Sku
+	JOIN PriceDimensions pd ON t.Sku=pd.Sku AND t.OfferTermCode=pd.OfferTermCode
+	WHERE p.ProductFamily=? AND p.Region=?
+	AND (p.UsageType=? OR p.UsageType LIKE '%-' || ?)
+	AND t.TermType='OnDemand' ORDER BY pd.BeginRange;`,
+		family, region, usageType, usageType)
+	if err != nil {
+		return nil, err
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no on-demand price for %s %s in %s, is the price list in the cache?",
+			family, usageType, region)
+	}
+	for _, r := range rates {
+		if rateSku(r) != rateSku(rates[0]) {
+			return nil, fmt.Errorf("expected one on-demand price for %s %s in %s but got several",
+				family, usageType, region)
+		}
+	}
+	return rates, nil
+}
+
+// Rate codes start with the sku.
+func rateSku(r rate) string {
+	return strings.SplitN(r.RateCode, ".", 2)[0]
+}
+
+// Returns the on-demand rates per GB for the transfer type out of the
+// region, by destination region. The destination of traffic to the
+// internet is "".
+func getTransferRates(db *sql.DB, transferType string, fromRegion string) (map[string][]rate, error) {
+	res, err := db.Query(`SELECT IFNULL(p.ToRegion, ''), pd.RateCode,
+	IFNULL(pd.BeginRange, 0), pd.EndRange, pd.PricePerUnit, pd.Currency, pd.Unit
+	FROM Products p JOIN Terms t ON p.Sku=t.Sku
+	JOIN PriceDimensions pd ON t.Sku=pd.Sku AND t.OfferTermCode=pd.OfferTermCode
+	WHERE p.ProductFamily='Data Transfer' AND p.TransferType=? AND p.FromRegion=?
+	AND t.TermType='OnDemand' AND pd.Unit='GB' ORDER BY pd.BeginRange;`,
+		transferType, fromRegion)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	rates := make(map[string][]rate)
+	for res.Next() {
+		var to string
+		var r rate
+		var end sql.NullFloat64
+		if err = res.Scan(&to, &r.RateCode, &r.Begin, &end, &r.Price, &r.Currency,
+			&r.Unit); err != nil {
+			return nil, err
+		}
+		r.End = math.Inf(1)
+		if end.Valid {
+			r.End = end.Float64
+		}
+		rates[to] = append(rates[to], r)
+	}
+	return rates, res.Err()
+}
+
+// Converts traffic in the spec to GB, which data transfer is priced in.
+func gbitsToGb(gbits uint64) float64 {
+	return float64(gbits) / 8
+}
+
+// Returns the region a network is priced in, the one of its first
+// subnetwork. Addresses and the vpc itself belong to a single region.
+func networkRegion(nw *common.Network) (string, error) {
+	if len(nw.Subnetworks) == 0 {
+		return "", fmt.Errorf("network %s has no subnetwork to place it in a region", nw.Name)
+	}
+	var asnw resources.Ec2Subnetwork
+	if err := subnetworkDetails(nw.Subnetworks[0], &asnw); err != nil {
+		return "", err
+	}
+	return asnw.Region, nil
+}
+
+func subnetworkDetails(snw *common.Subnetwork, asnw *resources.Ec2Subnetwork) error {
+	details := snw.ProviderDetails[resources.AwsProvider]
+	if details == nil {
+		return fmt.Errorf("missing %s provider details for subnetwork %s",
+			resources.AwsProvider, snw.Name)
+	}
+	return ptypes.UnmarshalAny(details, asnw)
+}
+
+// Returns the traffic to the internet in Gbit per month from each region,
+// summed over all subnetworks of the project. Egress is tiered by the
+// total out of a region, not by what each subnetwork sends.
+func externalEgress(p *common.Project) (map[string]uint64, error) {
+	egress := make(map[string]uint64)
+	for _, nw := range p.Networks {
+		if placedElsewhere(nw) {
+			continue
+		}
+		for _, snw := range nw.Subnetworks {
+			var asnw resources.Ec2Subnetwork
+			if err := subnetworkDetails(snw, &asnw); err != nil {
+				return nil, fmt.Errorf("network %s: %v", nw.Name, err)
+			}
+			egress[asnw.Region] += snw.ExternalEgressGbitsPerMonth
+		}
+	}
+	return egress, nil
+}
+
+// Prices the public addresses of a network, and the traffic and gateways
+// of its subnetworks. Traffic into a region is free. Traffic to other
+// regions is priced at the most expensive destination, since the spec
+// doesn't say where it goes. Vpcs and subnets themselves are free.
+// Egress is the internet egress of the project by region, see
+// externalEgress.
+func networkCost(db *sql.DB, projectName string, nw *common.Network,
+	egress map[string]uint64) ([][]string, error) {
+	costs := make([][]string, 0)
+	if nw.IpAddresses > 0 {
+		region, err := networkRegion(nw)
+		if err != nil {
+			return nil, err
+		}
+		rates, err := getProductRates(db, "IP Address", region, ipAddressUsageType)
+		if err != nil {
+			return nil, err
+		}
+		hours := float64(hoursPerMonth * nw.IpAddresses)
+		cost := fmt.Sprintf("%.2f %s", tieredCost(rates, hours), rates[0].Currency)
+		// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
+		costs = append(costs, []string{
+			projectName, resources.AwsProvider, nw.Name,
+			"IP address",
+			fmt.Sprintf("%d", nw.IpAddresses),
+			fmt.Sprintf("public IPv4 address in %s", region),
+			fmt.Sprintf("%.0f h per month", hours), cost,
+			fmt.Sprintf("%.0f h per month", hours), cost,
+		})
+	}
+	for _, snw := range nw.Subnetworks {
+		scosts, err := subnetworkCost(db, snw, egress)
+		if err != nil {
+			return nil, fmt.Errorf("subnetwork %s: %v", snw.Name, err)
+		}
+		for _, sc := range scosts {
+			costs = append(costs, append([]string{projectName, resources.AwsProvider, snw.Name}, sc...))
+		}
+	}
+	return costs, nil
+}
+
+func subnetworkCost(db *sql.DB, snw *common.Subnetwork, egress map[string]uint64) ([][]string, error) {
+	var asnw resources.Ec2Subnetwork
+	if err := subnetworkDetails(snw, &asnw); err != nil {
+		return nil, err
+	}
+	region := asnw.Region
+	// Traffic is what the spec says it is, so the maximum is the same as
+	// the projection.
+	line := func(resource string, spec string, amount float64, unit string, cost float64,
+		currency string) []string {
+		// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
+		usage := fmt.Sprintf("%g %s per month", amount, unit)
+		c := fmt.Sprintf("%.2f %s", cost, currency)
+		return []string{resource, "1", spec, usage, c, usage, c}
+	}
+	costs := make([][]string, 0)
+	if snw.ExternalEgressGbitsPerMonth > 0 {
+		rates, err := getTransferRates(db, "AWS Outbound", region)
+		if err != nil {
+			return nil, err
+		}
+		if len(rates[""]) == 0 {
+			return nil, fmt.Errorf("no on-demand price for internet egress from %s, is the price list in the cache?",
+				region)
+		}
+		// The subnetwork pays its share of the tiered cost of all
+		// egress from the region.
+		gb := gbitsToGb(snw.ExternalEgressGbitsPerMonth)
+		total := gbitsToGb(egress[region])
+		spec := fmt.Sprintf("internet egress from %s", region)
+		if total > gb {
+			spec = fmt.Sprintf("%s, share of %g GB from the region", spec, total)
+		}
+		costs = append(costs, line("Network", spec,
+			gb, "GB", tieredCost(rates[""], total)*gb/total, rates[""][0].Currency))
+	}
+	if snw.InternalEgressGbitsPerMonth > 0 {
+		rates, err := getTransferRates(db, "InterRegion Outbound", region)
+		if err != nil {
+			return nil, err
+		}
+		if len(rates) == 0 {
+			return nil, fmt.Errorf("no on-demand price for egress from %s to other regions, is the price list in the cache?",
+				region)
+		}
+		gb := gbitsToGb(snw.InternalEgressGbitsPerMonth)
+		destinations := make([]string, 0, len(rates))
+		for to := range rates {
+			destinations = append(destinations, to)
+		}
+		sort.Strings(destinations)
+		var highest float64
+		var to string
+		for _, d := range destinations {
+			if c := tieredCost(rates[d], gb); to == "" || c > highest {
+				highest = c
+				to = d
+			}
+		}
+		costs = append(costs, line("Network",
+			fmt.Sprintf("egress from %s to other regions, priced as to %s", region, to),
+			gb, "GB", highest, rates[to][0].Currency))
+	}
+	// Usually only known from observed traffic.
+	if snw.SameRegionEgressGbitsPerMonth > 0 {
+		rates, err := getTransferRates(db, "IntraRegion", region)
+		if err != nil {
+			return nil, err
+		}
+		if len(rates[region]) == 0 {
+			return nil, fmt.Errorf("no on-demand price for traffic between zones in %s, is the price list in the cache?",
+				region)
+		}
+		gb := gbitsToGb(snw.SameRegionEgressGbitsPerMonth)
+		costs = append(costs, line("Network", fmt.Sprintf("traffic between zones in %s", region),
+			gb, "GB", tieredCost(rates[region], gb), rates[region][0].Currency))
+	}
+	// Gateways that charge for the data they process share the traffic
+	// in and out of the subnetwork.
+	gateways := make([]*resources.Ec2Gateway, len(snw.Gateways))
+	processing := 0
+	for i, gw := range snw.Gateways {
+		details := gw.ProviderDetails[resources.AwsProvider]
+		if details == nil {
+			return nil, fmt.Errorf("missing %s provider details for gateway", resources.AwsProvider)
+		}
+		gateways[i] = &resources.Ec2Gateway{}
+		if err := ptypes.UnmarshalAny(details, gateways[i]); err != nil {
+			return nil, err
+		}
+		usage, ok := gatewayUsageTypes[gateways[i].ProductFamily]
+		if !ok {
+			return nil, fmt.Errorf("no prices for gateways of product family %s",
+				gateways[i].ProductFamily)
+		}
+		if usage.Processed != "" {
+			processing++
+		}
+	}
+	for _, agw := range gateways {
+		usage := gatewayUsageTypes[agw.ProductFamily]
+		spec := agw.ProductFamily
+		if agw.Name != "" {
+			spec = fmt.Sprintf("%s %s", spec, agw.Name)
+		}
+		spec = fmt.Sprintf("%s in %s", spec, region)
+		if usage.Processed == "" {
+			spec += ", without capacity units"
+		}
+		rates, err := getProductRates(db, agw.ProductFamily, region, usage.Hours)
+		if err != nil {
+			return nil, err
+		}
+		costs = append(costs, line("Gateway", spec, hoursPerMonth, "h",
+			tieredCost(rates, hoursPerMonth), rates[0].Currency))
+		gb := gbitsToGb(snw.IngressGbitsPerMonth + snw.ExternalEgressGbitsPerMonth)
+		if usage.Processed == "" || gb == 0 {
+			continue
+		}
+		rates, err = getProductRates(db, agw.ProductFamily, region, usage.Processed)
+		if err != nil {
+			return nil, err
+		}
+		gb /= float64(processing)
+		costs = append(costs, line("Gateway data", spec, gb, "GB",
+			tieredCost(rates, gb), rates[0].Currency))
+	}
+	return costs, nil
+}
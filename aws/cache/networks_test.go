@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/anypb"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"testing"
+)
+
+func awsDetails(t *testing.T, m proto.Message) map[string]*anypb.Any {
+	details, err := ptypes.MarshalAny(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*anypb.Any{resources.AwsProvider: details}
+}
+
+func TestGetCostNetworks(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	p := &common.Project{Name: "shop", Networks: []*common.Network{{
+		Name:            "vpc",
+		IpAddresses:     2,
+		ProviderDetails: awsDetails(t, &resources.Ec2Network{VpcId: "vpc-1"}),
+		Subnetworks: []*common.Subnetwork{{
+			Name:            "main",
+			ProviderDetails: awsDetails(t, &resources.Ec2Subnetwork{Region: "us-east-1"}),
+			// 20000 GB to the internet, 10 GB to other regions and 1 GB
+			// between zones.
+			ExternalEgressGbitsPerMonth:   160000,
+			InternalEgressGbitsPerMonth:   80,
+			SameRegionEgressGbitsPerMonth: 8,
+			Gateways: []*common.Gateway{
+				{ProviderDetails: awsDetails(t, &resources.Ec2Gateway{
+					ProductFamily: "NAT Gateway", Name: "nat-1"})},
+				{ProviderDetails: awsDetails(t, &resources.Ec2Gateway{
+					ProductFamily: "Load Balancer-Application"})},
+			},
+		}},
+	}}}
+	costs, err := GetCost(db, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"shop", "aws", "vpc", "IP address", "2", "public IPv4 address in us-east-1",
+			"1460 h per month", "7.30 USD", "1460 h per month", "7.30 USD"},
+		// 10240 GB in the first tier and the rest in the second.
+		{"shop", "aws", "main", "Network", "1", "internet egress from us-east-1",
+			"20000 GB per month", "1751.20 USD", "20000 GB per month", "1751.20 USD"},
+		{"shop", "aws", "main", "Network", "1",
+			"egress from us-east-1 to other regions, priced as to eu-central-1",
+			"10 GB per month", "0.20 USD", "10 GB per month", "0.20 USD"},
+		{"shop", "aws", "main", "Network", "1", "traffic between zones in us-east-1",
+			"1 GB per month", "0.01 USD", "1 GB per month", "0.01 USD"},
+		{"shop", "aws", "main", "Gateway", "1", "NAT Gateway nat-1 in us-east-1",
+			"730 h per month", "32.85 USD", "730 h per month", "32.85 USD"},
+		{"shop", "aws", "main", "Gateway data", "1", "NAT Gateway nat-1 in us-east-1",
+			"20000 GB per month", "900.00 USD", "20000 GB per month", "900.00 USD"},
+		{"shop", "aws", "main", "Gateway", "1",
+			"Load Balancer-Application in us-east-1, without capacity units",
+			"730 h per month", "16.43 USD", "730 h per month", "16.43 USD"},
+	}
+	if diff := deep.Equal(want, costs); diff != nil {
+		t.Errorf("unexpected costs: %v", diff)
+	}
+
+	snw := p.Networks[0].Subnetworks[0]
+	snw.Gateways[1].ProviderDetails = awsDetails(t, &resources.Ec2Gateway{
+		ProductFamily: "Transit Gateway"})
+	if _, err = GetCost(db, p); err == nil {
+		t.Errorf("expected an error for a gateway of an unknown product family")
+	}
+	// There are no network prices for eu-central-1 in the fixture.
+	snw.Gateways = nil
+	snw.ProviderDetails = awsDetails(t, &resources.Ec2Subnetwork{Region: "eu-central-1"})
+	if _, err = GetCost(db, p); err == nil {
+		t.Errorf("expected an error for a network without prices")
+	}
+	// Networks placed with another provider are not priced here.
+	p.Networks[0].ProviderDetails["gcloud"] = p.Networks[0].ProviderDetails[resources.AwsProvider]
+	delete(p.Networks[0].ProviderDetails, resources.AwsProvider)
+	if costs, err = GetCost(db, p); err != nil || len(costs) != 0 {
+		t.Errorf("expected no costs for a network placed elsewhere, got %v (%v)", costs, err)
+	}
+}
+
+func TestGetCostEgressTiers(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	// 6000 GB each, 12000 GB out of the region together.
+	subnetwork := func(name string) *common.Subnetwork {
+		return &common.Subnetwork{
+			Name:                        name,
+			ProviderDetails:             awsDetails(t, &resources.Ec2Subnetwork{Region: "us-east-1"}),
+			ExternalEgressGbitsPerMonth: 48000,
+		}
+	}
+	p := &common.Project{Name: "shop", Networks: []*common.Network{
+		{Name: "vpc", Subnetworks: []*common.Subnetwork{subnetwork("web")}},
+		{Name: "other", Subnetworks: []*common.Subnetwork{subnetwork("db")}},
+	}}
+	costs, err := GetCost(db, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10240 GB in the first tier and 1760 GB in the second, split evenly.
+	want := [][]string{
+		{"shop", "aws", "web", "Network", "1",
+			"internet egress from us-east-1, share of 12000 GB from the region",
+			"6000 GB per month", "535.60 USD", "6000 GB per month", "535.60 USD"},
+		{"shop", "aws", "db", "Network", "1",
+			"internet egress from us-east-1, share of 12000 GB from the region",
+			"6000 GB per month", "535.60 USD", "6000 GB per month", "535.60 USD"},
+	}
+	if diff := deep.Equal(want, costs); diff != nil {
+		t.Errorf("unexpected costs: %v", diff)
+	}
+}
+
+func TestFillInProviderDetailsNetworks(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	us := &common.Location{CountryCode: "US"}
+	p := &common.Project{
+		InstanceSets: []*common.InstanceSet{{Name: "web", Count: 1,
+			Template: &common.Instance{Location: us, Os: "Linux", Type: &common.MachineType{},
+				ProviderDetails: awsDetails(t, &resources.Ec2VM{
+					InstanceType: "t3.micro", Region: "us-west-1"})}}},
+		Networks: []*common.Network{{Name: "default",
+			Subnetworks: []*common.Subnetwork{
+				{Name: "us", Location: us, Gateways: []*common.Gateway{{}}},
+				{Name: "de", Location: &common.Location{CountryCode: "DE"}},
+			}}},
+	}
+	if err := FillInProviderDetails(db, p); err != nil {
+		t.Fatal(err)
+	}
+	nw := p.Networks[0]
+	if nw.ProviderDetails[resources.AwsProvider] == nil {
+		t.Errorf("expected provider details for the network")
+	}
+	// Subnetworks go in the region of the instances in the same location.
+	for i, want := range []string{"us-west-1", "eu-central-1"} {
+		var asnw resources.Ec2Subnetwork
+		if err := subnetworkDetails(nw.Subnetworks[i], &asnw); err != nil {
+			t.Fatal(err)
+		}
+		if asnw.Region != want {
+			t.Errorf("expected subnetwork %s in %s, got %s", nw.Subnetworks[i].Name,
+				want, asnw.Region)
+		}
+	}
+	var agw resources.Ec2Gateway
+	if err := ptypes.UnmarshalAny(
+		nw.Subnetworks[0].Gateways[0].ProviderDetails[resources.AwsProvider], &agw); err != nil {
+		t.Fatal(err)
+	}
+	if agw.ProductFamily != "NAT Gateway" {
+		t.Errorf("expected a NAT gateway, got %s", agw.ProductFamily)
+	}
+
+	nw.Subnetworks[1].ProviderDetails = nil
+	if err := FillInProviderDetailsIn(db, p, "us-east-1"); err == nil {
+		t.Errorf("expected an error placing a subnetwork in DE in us-east-1")
+	}
+}
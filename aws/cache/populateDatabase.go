@@ -1,9 +1,15 @@
 package cache
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"nephomancy/aws/ec2"
 	"nephomancy/aws/resources"
+	"nephomancy/common/metadata"
+	"nephomancy/common/migrate"
+	"time"
 )
 
 func PopulateDatabase(db *sql.DB) error {
@@ -37,21 +43,23 @@ func populateRegions(db *sql.DB) error {
 	return nil
 }
 
-func populateVolumeTypes(db *sql.DB) error {
-	insert := `REPLACE INTO VolumeTypes(VolumeType, StorageMedia,
-	MaxVolumeSize, MaxIOPS, MaxThroughput, MultiAttach, VolumeApiType)
-	VALUES(?, ?, ?, ?, ?, ?, ?);`
+func populateVolumeTypes(db migrate.DB) error {
+	insert := `REPLACE INTO VolumeTypes(VolumeApiType, VolumeType, StorageMedia,
+	MinVolumeSize, MaxVolumeSize, MaxIOPS, MaxThroughput, MultiAttach)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?);`
 	stmt, err := db.Prepare(insert)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
 	for _, vt := range resources.StandardVolumeTypes() {
 		multiAttach := 0
 		if vt.MultiAttach {
 			multiAttach = 1
 		}
-		_, err = stmt.Exec(vt.Name, vt.Media, vt.MaxVolumeSizeGiB, vt.MaxIOPSPerVolumeKiB,
-			vt.MaxThroughputPerVolumeMiBs, multiAttach, vt.VolumeApiType)
+		_, err = stmt.Exec(vt.VolumeApiType, vt.Name, vt.Media, vt.MinVolumeSizeGiB,
+			vt.MaxVolumeSizeGiB, vt.MaxIOPSPerVolumeKiB, vt.MaxThroughputPerVolumeMiBs,
+			multiAttach)
 		if err != nil {
 			return err
 		}
@@ -59,6 +67,34 @@ func populateVolumeTypes(db *sql.DB) error {
 	return nil
 }
 
+// Fetches the instance types, and the regions that offer them, from the
+// EC2 API into the database.
+func FetchInstanceTypes(ctx context.Context, db *sql.DB) error {
+	sendToDb := make(chan *resources.InstanceType, 1)
+	okFromDb := make(chan error, 1)
+	retval := make(chan error, 1)
+	defer close(sendToDb)
+	defer close(okFromDb)
+
+	// Assume us-east-1 has all instance types that exist.
+	go ec2.DescribeInstanceTypes(ctx, nil, "us-east-1", sendToDb, okFromDb, retval)
+	go InsertInstanceTypes(db, sendToDb, okFromDb)
+	if err := <-retval; err != nil {
+		return fmt.Errorf("could not get instance type descriptions: %v", err)
+	}
+
+	for _, r := range AllRegions(true) {
+		itypes, err := ec2.ListInstanceTypesByLocation(ctx, r)
+		if err != nil {
+			return fmt.Errorf("failed to list instance types for %s: %v", r, err)
+		}
+		if err = InsertInstanceTypesForRegion(db, itypes, r); err != nil {
+			return fmt.Errorf("failed to insert instance types for %s: %v", r, err)
+		}
+	}
+	return nil
+}
+
 func InsertInstanceTypes(db *sql.DB, fromEc2 <-chan *resources.InstanceType, toEc2 chan<- error) {
 	for {
 		select {
@@ -81,7 +117,7 @@ func InsertInstanceTypes(db *sql.DB, fromEc2 <-chan *resources.InstanceType, toE
 func InsertInstanceType(db *sql.DB, itype resources.InstanceType) error {
 	insert := `REPLACE INTO InstanceTypes(InstanceType, CPU, Memory,
 	GPU, NetworkPerformance, StorageType, StorageAmount, SupportsSpot,
-	SupportsOndemand) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	SupportsOndemand, Architecture) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 	stmt, err := db.Prepare(insert)
 	if err != nil {
 		return err
@@ -103,7 +139,8 @@ func InsertInstanceType(db *sql.DB, itype resources.InstanceType) error {
 	}
 	_, err = stmt.Exec(itype.Name, itype.DefaultCpuCount,
 		itype.MemoryMiB, itype.GpuCount, itype.NetworkPerformanceGbit,
-		instanceStorage, itype.InstanceStorageMaxSizeGb, supportsSpot, supportsOnDemand)
+		instanceStorage, itype.InstanceStorageMaxSizeGb, supportsSpot, supportsOnDemand,
+		itype.CpuArchitecture)
 	if err != nil {
 		return err
 	}
@@ -136,3 +173,20 @@ func InsertInstanceTypesForRegion(db *sql.DB, itypes []string, region string) er
 	}
 	return nil
 }
+
+// Records that the instance types, and the prices if pl is not nil,
+// were just refreshed. Call this once everything has been inserted.
+func RecordRefresh(db *sql.DB, pl *PriceList) error {
+	if pl == nil {
+		return metadata.Record(db, metadata.Metadata{
+			Refreshed: time.Now(),
+			Source:    "EC2 DescribeInstanceTypes API",
+		})
+	}
+	return metadata.Record(db, metadata.Metadata{
+		Refreshed:     time.Now(),
+		Source:        "EC2 DescribeInstanceTypes API and price list " + pl.Source,
+		SourceVersion: pl.Version,
+		SourceDate:    pl.PublicationDate.Format("2006-01-02"),
+	})
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"nephomancy/aws/fake"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"testing"
+)
+
+// Builds a complete cache from the fake APIs in an in-memory database,
+// the way init does.
+func TestPopulateDatabaseFromFake(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	resources.Endpoint = server.URL
+	defer func() { resources.Endpoint = "" }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err = CreateOrUpdateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = PopulateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = FetchInstanceTypes(context.Background(), db); err != nil {
+		t.Fatalf("FetchInstanceTypes failed: %v", err)
+	}
+
+	var n int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM InstanceTypes`).Scan(&n); err != nil || n != 7 {
+		t.Errorf("expected 7 instance types from two pages, got %d (%v)", n, err)
+	}
+	var storage string
+	var size int
+	err = db.QueryRow(`SELECT StorageType, StorageAmount FROM InstanceTypes
+	WHERE InstanceType='m5d.large'`).Scan(&storage, &size)
+	if err != nil || storage != "nvme ssd" || size != 75 {
+		t.Errorf("expected 75 GB nvme ssd on m5d.large, got %d GB %s (%v)", size, storage, err)
+	}
+
+	spec := &common.MachineType{CpuCount: 2, MemoryGb: 4, CpuArchitecture: "arm64"}
+	it, regions, err := getInstanceTypeForSpec(db, spec, "", []string{"us-east-1"})
+	if err != nil || it != "c6g.large" || len(regions) != 1 {
+		t.Errorf("expected c6g.large in us-east-1 but got %s %v (%v)", it, regions, err)
+	}
+	// eu-central-1 offers no arm64 instance types in the fixtures.
+	if _, _, err = getInstanceTypeForSpec(db, spec, "", []string{"eu-central-1"}); err == nil {
+		t.Errorf("expected no arm64 instance type in eu-central-1")
+	}
+	spec = &common.MachineType{CpuCount: 2, MemoryGb: 16}
+	it, _, err = getInstanceTypeForSpec(db, spec, "", []string{"eu-central-1"})
+	if err != nil || it != "m5.xlarge" {
+		t.Errorf("expected m5.xlarge in eu-central-1 but got %s (%v)", it, err)
+	}
+	spec = &common.MachineType{CpuCount: 1, MemoryGb: 8}
+	its, err := ListInstanceTypes(db, spec, "", &common.Location{CountryCode: "DE"})
+	if err != nil || len(its) != 2 || its[0].Name != "m5.large" || its[1].Name != "m5d.large" {
+		t.Errorf("expected m5.large and m5d.large in DE but got %+v (%v)", its, err)
+	}
+}
@@ -0,0 +1,563 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"math"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+)
+
+// Hours in an average month, as in the price list.
+const hoursPerMonth = 730
+
+// Prices the instance sets, disk sets and networks of the project with
+// the prices from the price list, see IngestPriceList. Each line has the
+// maximum cost, if the resources exist all month, and the projected cost
+// for their usage hours. It is an error if a price is missing.
+func GetCost(db *sql.DB, p *common.Project) ([][]string, error) {
+	costs := make([][]string, 0)
+	for _, vmset := range p.InstanceSets {
+		// Resources placed with other providers are left to them.
+		if common.OtherProviders(resources.AwsProvider, vmset.Template.ProviderDetails) {
+			continue
+		}
+		details := vmset.Template.ProviderDetails[resources.AwsProvider]
+		if details == nil {
+			return nil, fmt.Errorf("missing %s provider details for instance set %s",
+				resources.AwsProvider, vmset.Name)
+		}
+		var avm resources.Ec2VM
+		if err := ptypes.UnmarshalAny(details, &avm); err != nil {
+			return nil, err
+		}
+		vcosts, err := vmCostRange(db, vmset, &avm)
+		if err != nil {
+			return nil, fmt.Errorf("instance set %s: %v", vmset.Name, err)
+		}
+		for _, vc := range vcosts {
+			costs = append(costs, append([]string{p.Name, resources.AwsProvider, vmset.Name}, vc...))
+		}
+	}
+	for _, dset := range p.DiskSets {
+		if common.OtherProviders(resources.AwsProvider, dset.Template.ProviderDetails) {
+			continue
+		}
+		details := dset.Template.ProviderDetails[resources.AwsProvider]
+		if details == nil {
+			return nil, fmt.Errorf("missing %s provider details for disk set %s",
+				resources.AwsProvider, dset.Name)
+		}
+		var dsk resources.Ec2Disk
+		if err := ptypes.UnmarshalAny(details, &dsk); err != nil {
+			return nil, err
+		}
+		dcosts, err := diskCostRange(db, dset, &dsk)
+		if err != nil {
+			return nil, fmt.Errorf("disk set %s: %v", dset.Name, err)
+		}
+		for _, dc := range dcosts {
+			costs = append(costs, append([]string{p.Name, resources.AwsProvider, dset.Name}, dc...))
+		}
+	}
+	egress, err := externalEgress(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, nw := range p.Networks {
+		if placedElsewhere(nw) {
+			continue
+		}
+		ncosts, err := networkCost(db, p.Name, nw, egress)
+		if err != nil {
+			return nil, fmt.Errorf("network %s: %v", nw.Name, err)
+		}
+		costs = append(costs, ncosts...)
+	}
+	return costs, nil
+}
+
+// A price dimension from the price list. Usage from Begin up to End is
+// charged at Price per unit. End is +Inf for the last tier.
+type rate struct {
+	RateCode string
+	Begin    float64
+	End      float64
+	Price    float64
+	Currency string
+	Unit     string
+}
+
+// Returns the rates from a query for RateCode, BeginRange, EndRange,
+// PricePerUnit, Currency and Unit, in the order of the query.
+func getRates(db *sql.DB, query string, args ...interface{}) ([]rate, error) {
+	res, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	rates := make([]rate, 0)
+	for res.Next() {
+		var r rate
+		var end sql.NullFloat64
+		if err = res.Scan(&r.RateCode, &r.Begin, &end, &r.Price, &r.Currency, &r.Unit); err != nil {
+			return nil, err
+		}
+		r.End = math.Inf(1)
+		if end.Valid {
+			r.End = end.Float64
+		}
+		rates = append(rates, r)
+	}
+	return rates, res.Err()
+}
+
+// Returns the cost of amount units, charging each tier for the part
+// of the amount that falls in its range.
+func tieredCost(rates []rate, amount float64) float64 {
+	var total float64
+	for _, r := range rates {
+		if amount <= r.Begin {
+			continue
+		}
+		total += (math.Min(amount, r.End) - r.Begin) * r.Price
+	}
+	return total
+}
+
+func vmCostRange(db *sql.DB, vmset *common.InstanceSet, avm *resources.Ec2VM) ([][]string, error) {
+	price, err := getOnDemandPrice(db, avm)
+	if err != nil {
+		return nil, err
+	}
+	spec := fmt.Sprintf("%s %s in %s", avm.InstanceType, osName(avm), avm.Region)
+	if t := tenancy(avm); t != "Shared" {
+		spec = fmt.Sprintf("%s (%s)", spec, t)
+	}
+	var c *commitment
+	switch avm.TermType {
+	case "", "OnDemand":
+		maxUsage := uint64(hoursPerMonth * vmset.Count)
+		projectedUsage := uint64(vmset.UsageHoursPerMonth * vmset.Count)
+		// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
+		return [][]string{{
+			"VM",
+			fmt.Sprintf("%d", vmset.Count),
+			spec,
+			fmt.Sprintf("%d h per month", maxUsage),
+			fmt.Sprintf("%.2f %s", price.Price*float64(maxUsage), price.Currency),
+			fmt.Sprintf("%d h per month", projectedUsage),
+			fmt.Sprintf("%.2f %s", price.Price*float64(projectedUsage), price.Currency),
+		}}, nil
+	case "Reserved":
+		c, err = getReservedPrice(db, avm)
+	case "SavingsPlan":
+		c, err = getSavingsPlanPrice(db, avm)
+	case "Spot":
+		return spotCost(db, vmset, spec, avm)
+	default:
+		return nil, fmt.Errorf("no prices for term type %s", avm.TermType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return commitmentCost(vmset, spec, *price, *c), nil
+}
+
+// What a reserved instance or a Savings Plan costs per instance. The
+// hourly rate is paid for every hour of the term, whether the instance
+// runs or not, and the upfront fee once for the whole term.
+type commitment struct {
+	Description string
+	Hourly      float64
+	Upfront     float64
+	Months      int
+	Currency    string
+}
+
+func leaseMonths(length string) (int, error) {
+	switch length {
+	case "1yr":
+		return 12, nil
+	case "3yr":
+		return 36, nil
+	}
+	return 0, fmt.Errorf("unknown lease contract length %q", length)
+}
+
+// Returns the hourly cost of the commitment, the upfront fee amortised
+// over the months of the term, and the on-demand cost of the instances
+// for comparison. The on-demand line says how many hours a month the
+// instances have to run for the commitment to pay off.
+func commitmentCost(vmset *common.InstanceSet, spec string, onDemand rate, c commitment) [][]string {
+	count := float64(vmset.Count)
+	billed := uint64(hoursPerMonth * vmset.Count)
+	projected := uint64(vmset.UsageHoursPerMonth * vmset.Count)
+	cspec := fmt.Sprintf("%s, %s", spec, c.Description)
+	hourly := fmt.Sprintf("%.2f %s", c.Hourly*float64(billed), c.Currency)
+	// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
+	costs := [][]string{{
+		"VM",
+		fmt.Sprintf("%d", vmset.Count),
+		cspec,
+		fmt.Sprintf("%d h per month", billed), hourly,
+		fmt.Sprintf("%d h per month", billed), hourly,
+	}}
+	if c.Upfront > 0 {
+		usage := fmt.Sprintf("%.2f %s over %d months", c.Upfront*count, c.Currency, c.Months)
+		amortised := fmt.Sprintf("%.2f %s", c.Upfront*count/float64(c.Months), c.Currency)
+		costs = append(costs, []string{
+			"VM upfront",
+			fmt.Sprintf("%d", vmset.Count),
+			cspec,
+			usage, amortised,
+			usage, amortised,
+		})
+	}
+	// The on-demand cost is only for comparison, so it goes in the spec
+	// and the cost columns stay empty; otherwise totals would count it.
+	monthly := c.Hourly*hoursPerMonth + c.Upfront/float64(c.Months)
+	// A commitment that costs more than a full month on demand never
+	// pays off.
+	breakEven := "never cheaper than on demand"
+	if hours := monthly / onDemand.Price; hours < hoursPerMonth {
+		breakEven = fmt.Sprintf("breaks even at %.0f h per month", hours)
+	}
+	odspec := fmt.Sprintf("%s on demand would cost %.2f %s, or %.2f %s projected; %s",
+		spec, onDemand.Price*float64(billed), onDemand.Currency,
+		onDemand.Price*float64(projected), onDemand.Currency, breakEven)
+	costs = append(costs, []string{
+		"Break-even",
+		fmt.Sprintf("%d", vmset.Count),
+		odspec,
+		fmt.Sprintf("%d h per month", billed), "",
+		fmt.Sprintf("%d h per month", projected), "",
+	})
+	return costs
+}
+
+// Provider details from before tenancy, os and license model were
+// filled in are shared Linux instances.
+func tenancy(avm *resources.Ec2VM) string {
+	if avm.Tenancy == "" {
+		return "Shared"
+	}
+	return avm.Tenancy
+}
+
+func osName(avm *resources.Ec2VM) string {
+	if avm.Os == "" {
+		return "Linux"
+	}
+	return avm.Os
+}
+
+// Returns the usage and operation of the instance's sku, see
+// createSkuTable.
+func skuUsageAndOperation(avm *resources.Ec2VM) (string, string, error) {
+	var usage string
+	switch tenancy(avm) {
+	case "Shared":
+		usage = "BoxUsage"
+	case "Dedicated":
+		usage = "DedicatedUsage"
+	default:
+		// Dedicated hosts are paid for per host, not per instance.
+		return "", "", fmt.Errorf("no prices for tenancy %s", avm.Tenancy)
+	}
+	byol := avm.LicenseModel == "Bring your own license"
+	var operation string
+	switch os := osName(avm); {
+	case os == "Linux" && !byol:
+		operation = "RunInstances"
+	case os == "RHEL" && !byol:
+		operation = "0010"
+	case os == "SUSE" && !byol:
+		operation = "000g"
+	case os == "Windows" && byol:
+		operation = "0800"
+	case os == "Windows":
+		operation = "0002"
+	default:
+		return "", "", fmt.Errorf("no prices for os %s with license model %s",
+			os, avm.LicenseModel)
+	}
+	return usage, operation, nil
+}
+
+// Returns the rates of the instance's sku for the term. Lease contract
+// length, offering class and purchase option are "" for on-demand terms.
+func getInstanceRates(db *sql.DB, avm *resources.Ec2VM, usage string, operation string,
+	termType string, lease string, class string, option string) ([]rate, error) {
+	return getRates(db, `SELECT pd.RateCode, IFNULL(pd.BeginRange, 0),
+	pd.EndRange, pd.PricePerUnit, pd.Currency, pd.Unit
+	FROM Sku s JOIN Terms t ON s.Sku=t.Sku
+	JOIN PriceDimensions pd ON t.Sku=pd.Sku AND t.OfferTermCode=pd.OfferTermCode
+	WHERE s.ProductType=? AND s.Region=? AND s.Usage=? AND s.Operation=?
+	AND t.TermType=? AND IFNULL(t.LeaseContractLength, '')=?
+	AND IFNULL(t.OfferingClass, '')=? AND IFNULL(t.PurchaseOption, '')=?
+	ORDER BY pd.RateCode;`,
+		avm.InstanceType, avm.Region, usage, operation, termType, lease, class, option)
+}
+
+// Returns the on-demand price per hour of an instance.
+func getOnDemandPrice(db *sql.DB, avm *resources.Ec2VM) (*rate, error) {
+	usage, operation, err := skuUsageAndOperation(avm)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := getInstanceRates(db, avm, usage, operation, "OnDemand", "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	prices := make([]rate, 0, 1)
+	for _, r := range rates {
+		if r.Unit == "Hrs" {
+			prices = append(prices, r)
+		}
+	}
+	if len(prices) == 0 {
+		return nil, fmt.Errorf("no on-demand price for %s %s %s in %s, is the price list in the cache?",
+			avm.InstanceType, usage, operation, avm.Region)
+	}
+	if len(prices) > 1 {
+		return nil, fmt.Errorf("expected one on-demand price for %s %s %s in %s but got %d",
+			avm.InstanceType, usage, operation, avm.Region, len(prices))
+	}
+	return &prices[0], nil
+}
+
+// Returns the price of a reserved instance. Reservations without an
+// offering class are standard ones.
+func getReservedPrice(db *sql.DB, avm *resources.Ec2VM) (*commitment, error) {
+	usage, operation, err := skuUsageAndOperation(avm)
+	if err != nil {
+		return nil, err
+	}
+	months, err := leaseMonths(avm.LeaseContractLength)
+	if err != nil {
+		return nil, err
+	}
+	class := avm.OfferingClass
+	if class == "" {
+		class = "standard"
+	}
+	rates, err := getInstanceRates(db, avm, usage, operation, "Reserved",
+		avm.LeaseContractLength, class, avm.PurchaseOption)
+	if err != nil {
+		return nil, err
+	}
+	c := &commitment{
+		Description: fmt.Sprintf("%s %s %s reservation", avm.LeaseContractLength, class,
+			avm.PurchaseOption),
+		Months: months,
+	}
+	hourly := 0
+	for _, r := range rates {
+		switch r.Unit {
+		case "Hrs":
+			c.Hourly = r.Price
+			hourly++
+		case "Quantity":
+			c.Upfront = r.Price
+		}
+		c.Currency = r.Currency
+	}
+	if hourly != 1 {
+		return nil, fmt.Errorf("expected one %s price for %s %s %s in %s but got %d, is the price list in the cache?",
+			c.Description, avm.InstanceType, usage, operation, avm.Region, hourly)
+	}
+	return c, nil
+}
+
+// Returns the price of an instance under a Savings Plan. The price list
+// has the effective rate per hour. All Upfront plans pay it for the
+// whole term upfront, Partial Upfront plans half of it.
+func getSavingsPlanPrice(db *sql.DB, avm *resources.Ec2VM) (*commitment, error) {
+	planType, ok := savingsPlanTypes[avm.SavingsPlanType]
+	if !ok {
+		return nil, fmt.Errorf("unknown Savings Plan type %q", avm.SavingsPlanType)
+	}
+	usage, operation, err := skuUsageAndOperation(avm)
+	if err != nil {
+		return nil, err
+	}
+	months, err := leaseMonths(avm.LeaseContractLength)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := getRates(db, `SELECT r.RateCode, 0, NULL, r.Price, r.Currency,
+	IFNULL(r.Unit, '') FROM Sku s JOIN SavingsPlanRates r ON s.Sku=r.DiscountedSku
+	JOIN SavingsPlans p ON r.Sku=p.Sku
+	WHERE s.ProductType=? AND s.Region=? AND s.Usage=? AND s.Operation=?
+	AND p.PlanType=? AND p.LeaseContractLength=? AND p.PurchaseOption=?
+	ORDER BY r.RateCode;`,
+		avm.InstanceType, avm.Region, usage, operation, planType,
+		avm.LeaseContractLength, avm.PurchaseOption)
+	if err != nil {
+		return nil, err
+	}
+	description := fmt.Sprintf("%s %s Savings Plan %s", avm.LeaseContractLength,
+		avm.SavingsPlanType, avm.PurchaseOption)
+	if len(rates) != 1 {
+		return nil, fmt.Errorf("expected one %s rate for %s %s %s in %s but got %d, are the Savings Plans prices in the cache?",
+			description, avm.InstanceType, usage, operation, avm.Region, len(rates))
+	}
+	total := rates[0].Price * hoursPerMonth * float64(months)
+	c := &commitment{
+		Description: description,
+		Months:      months,
+		Currency:    rates[0].Currency,
+	}
+	switch avm.PurchaseOption {
+	case "No Upfront":
+		c.Hourly = rates[0].Price
+	case "Partial Upfront":
+		c.Hourly = rates[0].Price / 2
+		c.Upfront = total / 2
+	case "All Upfront":
+		c.Upfront = total
+	default:
+		return nil, fmt.Errorf("unknown purchase option %q", avm.PurchaseOption)
+	}
+	return c, nil
+}
+
+// The monthly on-demand rates for an EBS volume's storage, provisioned
+// IOPS and provisioned throughput. They are separate product families
+// in the price list.
+type volumeRates struct {
+	Storage    []rate
+	Iops       []rate
+	Throughput []rate
+}
+
+// Returns the rates for the volume, or nil if the price list doesn't
+// have a rate the volume needs in its region.
+func getVolumeRates(db *sql.DB, dsk *resources.Ec2Disk) (*volumeRates, error) {
+	query := `SELECT pd.RateCode, IFNULL(pd.BeginRange, 0), pd.EndRange,
+	pd.PricePerUnit, pd.Currency, pd.Unit FROM Products p JOIN Terms t ON p.Sku=t.Sku
+	JOIN PriceDimensions pd ON t.Sku=pd.Sku AND t.OfferTermCode=pd.OfferTermCode
+	WHERE p.ProductFamily=? AND p.VolumeApiName=? AND p.Region=?
+	AND t.TermType='OnDemand' AND lower(pd.Unit)=? ORDER BY pd.BeginRange;`
+	var vr volumeRates
+	var err error
+	if vr.Storage, err = getRates(db, query, "Storage", dsk.VolumeType, dsk.Region,
+		"gb-mo"); err != nil || len(vr.Storage) == 0 {
+		return nil, err
+	}
+	iops, throughput := billablePerformance(dsk)
+	if iops > 0 {
+		if vr.Iops, err = getRates(db, query, "System Operation", dsk.VolumeType,
+			dsk.Region, "iops-mo"); err != nil || len(vr.Iops) == 0 {
+			return nil, err
+		}
+	}
+	if throughput > 0 {
+		if vr.Throughput, err = getRates(db, query, "Provisioned Throughput",
+			dsk.VolumeType, dsk.Region, "gibps-mo"); err != nil || len(vr.Throughput) == 0 {
+			return nil, err
+		}
+	}
+	return &vr, nil
+}
+
+// Returns the monthly cost of the volume's storage, IOPS and throughput.
+// Throughput is priced per GiB/s.
+func (vr volumeRates) monthly(dsk *resources.Ec2Disk) (float64, float64, float64) {
+	iops, throughput := billablePerformance(dsk)
+	return tieredCost(vr.Storage, float64(dsk.ActualSizeGb)),
+		tieredCost(vr.Iops, float64(iops)),
+		tieredCost(vr.Throughput, float64(throughput)/1024)
+}
+
+// EBS volumes are paid for while they exist, whether or not they are
+// attached to a running instance; the usage hours are how long they
+// exist each month.
+func diskCostRange(db *sql.DB, dset *common.DiskSet, dsk *resources.Ec2Disk) ([][]string, error) {
+	vr, err := getVolumeRates(db, dsk)
+	if err != nil {
+		return nil, err
+	}
+	if vr == nil {
+		return nil, fmt.Errorf("no on-demand price for %s volumes of %d GB in %s, is the price list in the cache?",
+			dsk.VolumeType, dsk.ActualSizeGb, dsk.Region)
+	}
+	storage, iopsCost, throughputCost := vr.monthly(dsk)
+	count := uint64(dset.Count)
+	fraction := float64(dset.UsageHoursPerMonth) / hoursPerMonth
+	currency := vr.Storage[0].Currency
+	line := func(resource string, amount uint64, unit string, cost float64) []string {
+		// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
+		return []string{
+			resource,
+			fmt.Sprintf("%d", dset.Count),
+			fmt.Sprintf("%s in %s", dsk.VolumeType, dsk.Region),
+			fmt.Sprintf("%d %s per month", amount*count, unit),
+			fmt.Sprintf("%.2f %s", cost*float64(count), currency),
+			fmt.Sprintf("%.0f %s per month", float64(amount*count)*fraction, unit),
+			fmt.Sprintf("%.2f %s", cost*float64(count)*fraction, currency),
+		}
+	}
+	costs := [][]string{line("Disk", dsk.ActualSizeGb, "GB", storage)}
+	iops, throughput := billablePerformance(dsk)
+	if iops > 0 {
+		costs = append(costs, line("Disk IOPS", uint64(iops), "IOPS", iopsCost))
+	}
+	if throughput > 0 {
+		costs = append(costs, line("Disk throughput", uint64(throughput), "MiB/s", throughputCost))
+	}
+	return costs, nil
+}
+
+// Returns the rates of every price dimension and Savings Plan in the
+// cache, keyed by rate code and description. In the price list every
+// tier of a price has its own rate code, so there is one rate per key.
+func ListRates(db *sql.DB) (map[string]string, error) {
+	res, err := db.Query(`SELECT pd.RateCode, IFNULL(pd.Description, ''),
+	IFNULL(pd.Unit, ''), pd.Currency, pd.PricePerUnit, IFNULL(pd.BeginRange, 0)
+	FROM PriceDimensions pd;`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	rates := make(map[string]string)
+	var rateCode, description, unit, currency string
+	var price, begin float64
+	for res.Next() {
+		if err = res.Scan(&rateCode, &description, &unit, &currency,
+			&price, &begin); err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s %s", rateCode, description)
+		rate := fmt.Sprintf("%.6f %s per %s from %g", price, currency, unit, begin)
+		rates[key] = rate
+	}
+	if err = res.Err(); err != nil {
+		return nil, err
+	}
+	return rates, listSavingsPlanRates(db, rates)
+}
+
+// Adds the Savings Plans rates to rates, keyed by rate code and plan.
+func listSavingsPlanRates(db *sql.DB, rates map[string]string) error {
+	res, err := db.Query(`SELECT r.RateCode, p.PlanType, p.LeaseContractLength,
+	p.PurchaseOption, IFNULL(r.Unit, ''), r.Currency, r.Price
+	FROM SavingsPlanRates r JOIN SavingsPlans p ON r.Sku=p.Sku;`)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	var rateCode, planType, lease, option, unit, currency string
+	var price float64
+	for res.Next() {
+		if err = res.Scan(&rateCode, &planType, &lease, &option, &unit, &currency,
+			&price); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s %s %s %s", rateCode, planType, lease, option)
+		rates[key] = fmt.Sprintf("%.6f %s per %s", price, currency, unit)
+	}
+	return res.Err()
+}
@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/anypb"
+	"math"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"nephomancy/common/utils"
+	"testing"
+)
+
+func vmSet(t *testing.T, name string, count uint32, hours uint32, avm *resources.Ec2VM) *common.InstanceSet {
+	details, err := ptypes.MarshalAny(avm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &common.InstanceSet{Name: name, Count: count, UsageHoursPerMonth: hours,
+		Template: &common.Instance{
+			ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: details}}}
+}
+
+func TestGetCost(t *testing.T) {
+	db := emptyDb(t)
+	defer db.Close()
+	if _, err := IngestPriceList(context.Background(), db, priceListFile); err != nil {
+		t.Fatal(err)
+	}
+	p := &common.Project{Name: "shop", InstanceSets: []*common.InstanceSet{
+		vmSet(t, "web", 2, 100, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "OnDemand", Tenancy: "Shared", Os: "Linux",
+			LicenseModel: "No License required"}),
+		vmSet(t, "reports", 1, 730, &resources.Ec2VM{InstanceType: "m5.large", Region: "us-east-1",
+			Tenancy: "Dedicated", Os: "Windows", LicenseModel: "No License required"}),
+		vmSet(t, "crm", 1, 730, &resources.Ec2VM{InstanceType: "m5.large", Region: "us-east-1",
+			Os: "RHEL"}),
+		// Provider details without tenancy and os are shared Linux.
+		vmSet(t, "batch", 1, 365, &resources.Ec2VM{InstanceType: "m5.large", Region: "eu-central-1"}),
+	}}
+	costs, err := GetCost(db, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"shop", "aws", "web", "VM", "2", "t3.micro Linux in us-east-1",
+			"1460 h per month", "15.18 USD", "200 h per month", "2.08 USD"},
+		{"shop", "aws", "reports", "VM", "1", "m5.large Windows in us-east-1 (Dedicated)",
+			"730 h per month", "144.54 USD", "730 h per month", "144.54 USD"},
+		{"shop", "aws", "crm", "VM", "1", "m5.large RHEL in us-east-1",
+			"730 h per month", "113.88 USD", "730 h per month", "113.88 USD"},
+		{"shop", "aws", "batch", "VM", "1", "m5.large Linux in eu-central-1",
+			"730 h per month", "83.95 USD", "365 h per month", "41.98 USD"},
+	}
+	if diff := deep.Equal(want, costs); diff != nil {
+		t.Errorf("unexpected costs: %v", diff)
+	}
+}
+
+func TestGetCostMissingPrice(t *testing.T) {
+	db := emptyDb(t)
+	defer db.Close()
+	if _, err := IngestPriceList(context.Background(), db, priceListFile); err != nil {
+		t.Fatal(err)
+	}
+	for name, avm := range map[string]*resources.Ec2VM{
+		"not in the price list": {InstanceType: "m5.large", Region: "us-west-2"},
+		"windows byol": {InstanceType: "m5.large", Region: "us-east-1", Os: "Windows",
+			LicenseModel: "Bring your own license"},
+		"dedicated host": {InstanceType: "m5.large", Region: "us-east-1", Tenancy: "Host"},
+		"spot":           {InstanceType: "t3.micro", Region: "us-east-1", TermType: "Spot"},
+	} {
+		p := &common.Project{Name: "shop",
+			InstanceSets: []*common.InstanceSet{vmSet(t, name, 1, 730, avm)}}
+		if costs, err := GetCost(db, p); err == nil {
+			t.Errorf("%s: expected an error but got %v", name, costs)
+		}
+	}
+}
+
+func TestGetCostWithoutPriceList(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	p := &common.Project{Name: "shop", InstanceSets: []*common.InstanceSet{
+		vmSet(t, "web", 1, 730, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1"}),
+	}}
+	if _, err := GetCost(db, p); err == nil {
+		t.Errorf("expected an error for a cache without prices")
+	}
+}
+
+func pricedDb(t *testing.T) *sql.DB {
+	db := emptyDb(t)
+	if _, err := IngestPriceList(context.Background(), db, priceListFile); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestVolumeForDiskType(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	for _, c := range []struct {
+		dt     *common.DiskType
+		region string
+		want   string
+	}{
+		{&common.DiskType{SizeGb: 100, DiskTech: "SSD"}, "us-east-1", "us-east-1:gp3:100:0:0"},
+		{&common.DiskType{SizeGb: 100, DiskTech: "SSD", Iops: 10000}, "us-east-1",
+			"us-east-1:gp3:100:10000:0"},
+		// Cheaper on io2 with its lower price above 32000 IOPS.
+		{&common.DiskType{SizeGb: 100, DiskTech: "SSD", Iops: 40000}, "us-east-1",
+			"us-east-1:io2:100:40000:0"},
+		// io1 would need 2000 IOPS for this.
+		{&common.DiskType{SizeGb: 100, DiskTech: "SSD", ThroughputMibps: 500}, "us-east-1",
+			"us-east-1:gp3:100:0:500"},
+		// gp3 needs a GiB per 500 IOPS, io1 per 50.
+		{&common.DiskType{SizeGb: 10, DiskTech: "SSD", Iops: 10000}, "us-east-1",
+			"us-east-1:gp3:20:10000:0"},
+		{&common.DiskType{SizeGb: 10, DiskTech: "SSD", Iops: 40000}, "us-east-1",
+			"us-east-1:io2:80:40000:0"},
+		// gp3 needs an IOPS per 0.25 MiB/s.
+		{&common.DiskType{SizeGb: 5, DiskTech: "SSD", ThroughputMibps: 1000}, "us-east-1",
+			"us-east-1:gp3:8:4000:1000"},
+		// sc1 has a minimum size.
+		{&common.DiskType{SizeGb: 100, DiskTech: "Standard"}, "us-east-1", "us-east-1:sc1:125:0:0"},
+		{&common.DiskType{SizeGb: 2000, DiskTech: "Standard", ThroughputMibps: 50}, "us-east-1",
+			"us-east-1:st1:2000:0:0"},
+		{&common.DiskType{SizeGb: 100, DiskTech: "SSD"}, "eu-central-1", "eu-central-1:gp3:100:0:0"},
+		// There are no HDD prices for eu-central-1 in the fixture.
+		{&common.DiskType{SizeGb: 100, DiskTech: "Standard"}, "eu-central-1", ""},
+		{&common.DiskType{SizeGb: 20000, DiskTech: "SSD"}, "us-east-1", ""},
+	} {
+		dsk, err := volumeForDiskType(db, c.dt, c.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if dsk != nil {
+			got = fmt.Sprintf("%s:%s:%d:%d:%d", dsk.Region, dsk.VolumeType,
+				dsk.ActualSizeGb, dsk.Iops, dsk.Throughput)
+		}
+		if got != c.want {
+			t.Errorf("%s in %s: expected %q but got %q", common.PrintDiskType(c.dt),
+				c.region, c.want, got)
+		}
+	}
+}
+
+func diskSet(t *testing.T, name string, count uint32, hours uint32, dsk *resources.Ec2Disk) *common.DiskSet {
+	details, err := ptypes.MarshalAny(dsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &common.DiskSet{Name: name, Count: count, UsageHoursPerMonth: hours,
+		Template: &common.Disk{
+			ProviderDetails: map[string]*anypb.Any{resources.AwsProvider: details}}}
+}
+
+func TestGetCostDiskSets(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	p := &common.Project{Name: "shop", DiskSets: []*common.DiskSet{
+		diskSet(t, "data", 2, 365, &resources.Ec2Disk{VolumeType: "gp3", ActualSizeGb: 100,
+			Region: "us-east-1", Iops: 4000, Throughput: 250}),
+		diskSet(t, "db", 1, 730, &resources.Ec2Disk{VolumeType: "io2", ActualSizeGb: 100,
+			Region: "us-east-1", Iops: 40000}),
+	}}
+	costs, err := GetCost(db, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"shop", "aws", "data", "Disk", "2", "gp3 in us-east-1",
+			"200 GB per month", "16.00 USD", "100 GB per month", "8.00 USD"},
+		// Above the 3000 IOPS and 125 MiB/s that come with gp3.
+		{"shop", "aws", "data", "Disk IOPS", "2", "gp3 in us-east-1",
+			"2000 IOPS per month", "10.00 USD", "1000 IOPS per month", "5.00 USD"},
+		{"shop", "aws", "data", "Disk throughput", "2", "gp3 in us-east-1",
+			"250 MiB/s per month", "10.00 USD", "125 MiB/s per month", "5.00 USD"},
+		{"shop", "aws", "db", "Disk", "1", "io2 in us-east-1",
+			"100 GB per month", "12.50 USD", "100 GB per month", "12.50 USD"},
+		// 32000 IOPS in the first tier and 8000 in the second.
+		{"shop", "aws", "db", "Disk IOPS", "1", "io2 in us-east-1",
+			"40000 IOPS per month", "2444.00 USD", "40000 IOPS per month", "2444.00 USD"},
+	}
+	if diff := deep.Equal(want, costs); diff != nil {
+		t.Errorf("unexpected costs: %v", diff)
+	}
+
+	p.DiskSets = []*common.DiskSet{diskSet(t, "logs", 1, 730, &resources.Ec2Disk{
+		VolumeType: "sc1", ActualSizeGb: 500, Region: "eu-central-1"})}
+	if _, err = GetCost(db, p); err == nil {
+		t.Errorf("expected an error for a volume without a price")
+	}
+}
+
+func TestFillInProviderDetailsDiskSets(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	us := &common.Location{CountryCode: "US"}
+	p := &common.Project{DiskSets: []*common.DiskSet{{Name: "data", Count: 1,
+		Template: &common.Disk{Location: us,
+			Type: &common.DiskType{SizeGb: 100, DiskTech: "SSD", Iops: 5000}}}}}
+	if err := FillInProviderDetails(db, p); err != nil {
+		t.Fatal(err)
+	}
+	var dsk resources.Ec2Disk
+	if err := ptypes.UnmarshalAny(p.DiskSets[0].Template.ProviderDetails[resources.AwsProvider], &dsk); err != nil {
+		t.Fatal(err)
+	}
+	if dsk.VolumeType != "gp3" || dsk.Region != "us-east-1" || dsk.Iops != 5000 {
+		t.Errorf("expected gp3 with 5000 IOPS in us-east-1, got %+v", &dsk)
+	}
+	// No HDD volume types have prices outside us-east-1 in the fixture.
+	p.DiskSets[0].Template = &common.Disk{Location: &common.Location{CountryCode: "DE"},
+		Type: &common.DiskType{SizeGb: 500, DiskTech: "Standard"}}
+	if err := FillInProviderDetails(db, p); err == nil {
+		t.Errorf("expected no HDD volume type in DE")
+	}
+}
+
+func TestGetCostCommitments(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	if _, err := IngestSavingsPlans(context.Background(), db, savingsPlansFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	p := &common.Project{Name: "shop", InstanceSets: []*common.InstanceSet{
+		vmSet(t, "web", 2, 365, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Reserved", LeaseContractLength: "1yr", OfferingClass: "standard",
+			PurchaseOption: "Partial Upfront"}),
+		// Reservations without an offering class are standard ones.
+		vmSet(t, "db", 1, 730, &resources.Ec2VM{InstanceType: "m5.large", Region: "us-east-1",
+			TermType: "Reserved", LeaseContractLength: "1yr", PurchaseOption: "All Upfront"}),
+		vmSet(t, "ci", 1, 200, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "SavingsPlan", SavingsPlanType: "Compute", LeaseContractLength: "1yr",
+			PurchaseOption: "No Upfront"}),
+		vmSet(t, "batch", 1, 100, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "SavingsPlan", SavingsPlanType: "EC2 Instance", LeaseContractLength: "1yr",
+			PurchaseOption: "Partial Upfront"}),
+	}}
+	costs, err := GetCost(db, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"shop", "aws", "web", "VM", "2",
+			"t3.micro Linux in us-east-1, 1yr standard Partial Upfront reservation",
+			"1460 h per month", "4.53 USD", "1460 h per month", "4.53 USD"},
+		{"shop", "aws", "web", "VM upfront", "2",
+			"t3.micro Linux in us-east-1, 1yr standard Partial Upfront reservation",
+			"54.00 USD over 12 months", "4.50 USD", "54.00 USD over 12 months", "4.50 USD"},
+		{"shop", "aws", "web", "Break-even", "2",
+			"t3.micro Linux in us-east-1 on demand would cost 15.18 USD, or 7.59 USD projected; breaks even at 434 h per month",
+			"1460 h per month", "", "730 h per month", ""},
+		{"shop", "aws", "db", "VM", "1",
+			"m5.large Linux in us-east-1, 1yr standard All Upfront reservation",
+			"730 h per month", "0.00 USD", "730 h per month", "0.00 USD"},
+		{"shop", "aws", "db", "VM upfront", "1",
+			"m5.large Linux in us-east-1, 1yr standard All Upfront reservation",
+			"493.00 USD over 12 months", "41.08 USD", "493.00 USD over 12 months", "41.08 USD"},
+		{"shop", "aws", "db", "Break-even", "1",
+			"m5.large Linux in us-east-1 on demand would cost 70.08 USD, or 70.08 USD projected; breaks even at 428 h per month",
+			"730 h per month", "", "730 h per month", ""},
+		{"shop", "aws", "ci", "VM", "1",
+			"t3.micro Linux in us-east-1, 1yr Compute Savings Plan No Upfront",
+			"730 h per month", "5.33 USD", "730 h per month", "5.33 USD"},
+		{"shop", "aws", "ci", "Break-even", "1",
+			"t3.micro Linux in us-east-1 on demand would cost 7.59 USD, or 2.08 USD projected; breaks even at 512 h per month",
+			"730 h per month", "", "200 h per month", ""},
+		// Half the rate for the whole term is paid upfront.
+		{"shop", "aws", "batch", "VM", "1",
+			"t3.micro Linux in us-east-1, 1yr EC2 Instance Savings Plan Partial Upfront",
+			"730 h per month", "2.26 USD", "730 h per month", "2.26 USD"},
+		{"shop", "aws", "batch", "VM upfront", "1",
+			"t3.micro Linux in us-east-1, 1yr EC2 Instance Savings Plan Partial Upfront",
+			"27.16 USD over 12 months", "2.26 USD", "27.16 USD over 12 months", "2.26 USD"},
+		{"shop", "aws", "batch", "Break-even", "1",
+			"t3.micro Linux in us-east-1 on demand would cost 7.59 USD, or 1.04 USD projected; breaks even at 435 h per month",
+			"730 h per month", "", "100 h per month", ""},
+	}
+	if diff := deep.Equal(want, costs); diff != nil {
+		t.Errorf("unexpected costs: %v", diff)
+	}
+	// A reservation costs its hourly rate and amortised upfront fee, the
+	// on-demand comparison doesn't count.
+	maxCosts, projectedCosts := utils.SumCosts(costs[:3])
+	if math.Abs(maxCosts["USD"]-9.03) > 0.005 || math.Abs(projectedCosts["USD"]-9.03) > 0.005 {
+		t.Errorf("expected the web set to total 9.03 USD, got %v and %v", maxCosts, projectedCosts)
+	}
+
+	for name, avm := range map[string]*resources.Ec2VM{
+		"reservation not in the price list": {InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Reserved", LeaseContractLength: "3yr", PurchaseOption: "No Upfront"},
+		"unknown lease": {InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Reserved", LeaseContractLength: "2yr", PurchaseOption: "No Upfront"},
+		"plan not in the price list": {InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "SavingsPlan", SavingsPlanType: "Compute", LeaseContractLength: "3yr",
+			PurchaseOption: "No Upfront"},
+		"unknown plan type": {InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "SavingsPlan", SavingsPlanType: "Fargate", LeaseContractLength: "1yr",
+			PurchaseOption: "No Upfront"},
+	} {
+		p := &common.Project{Name: "shop",
+			InstanceSets: []*common.InstanceSet{vmSet(t, name, 1, 730, avm)}}
+		if costs, err := GetCost(db, p); err == nil {
+			t.Errorf("%s: expected an error but got %v", name, costs)
+		}
+	}
+}
+
+func TestCommitmentNeverCheaper(t *testing.T) {
+	vmset := &common.InstanceSet{Name: "web", Count: 1, UsageHoursPerMonth: 730}
+	onDemand := rate{Price: 0.01, Currency: "USD", Unit: "Hrs"}
+	// 0.02 USD an hour costs twice as much as running on demand all month.
+	c := commitment{Description: "1yr standard No Upfront reservation",
+		Hourly: 0.02, Months: 12, Currency: "USD"}
+	costs := commitmentCost(vmset, "t3.nano Linux in us-east-1", onDemand, c)
+	want := "t3.nano Linux in us-east-1 on demand would cost 7.30 USD, or 7.30 USD projected; never cheaper than on demand"
+	if got := costs[len(costs)-1][2]; got != want {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+}
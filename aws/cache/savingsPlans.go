@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"nephomancy/aws/resources"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The index of the Savings Plans price lists, relative to the price list
+// endpoint. It points at one price list per region, which has both
+// Compute and EC2 Instance Savings Plans.
+const SavingsPlansIndexPath = "/savingsPlan/v1.0/aws/AWSComputeSavingsPlan/current/region_index.json"
+
+// Returns the URL of the Savings Plans index, on resources.Endpoint if
+// it is set.
+func SavingsPlansIndexUrl() string {
+	if resources.Endpoint != "" {
+		return strings.TrimSuffix(resources.Endpoint, "/") + SavingsPlansIndexPath
+	}
+	return "https://pricing.us-east-1.amazonaws.com" + SavingsPlansIndexPath
+}
+
+// Savings Plans product families in the price list, by the
+// savings_plan_type in Ec2VM.
+var savingsPlanTypes = map[string]string{
+	"Compute":      "ComputeSavingsPlans",
+	"EC2 Instance": "EC2InstanceSavingsPlans",
+}
+
+type savingsPlanProduct struct {
+	Sku           string
+	ProductFamily string
+	Attributes    map[string]string
+}
+
+type savingsPlanTerm struct {
+	Sku   string
+	Rates []struct {
+		RateCode       string
+		DiscountedSku  string
+		Unit           string
+		DiscountedRate struct {
+			Price    string
+			Currency string
+		}
+	}
+}
+
+// Ingests the Savings Plans rates for the regions. location is the
+// URL of the index, or a local file with the price list of a single
+// region, in which case regions is ignored. Products are the plans and
+// prices their rates.
+func IngestSavingsPlans(ctx context.Context, db *sql.DB, location string, regions []string) (
+	*PriceList, error) {
+	if location == "" {
+		return nil, fmt.Errorf("need url or filename for getting Savings Plans prices")
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		pl := &PriceList{Source: location}
+		if err = ingestSavingsPlans(ctx, db, file, pl); err != nil {
+			return nil, err
+		}
+		return pl, nil
+	}
+	var index struct {
+		Regions []struct {
+			RegionCode string
+			VersionUrl string
+		}
+	}
+	err := get(ctx, location, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&index)
+	})
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	pl := &PriceList{Source: location}
+	for _, region := range regions {
+		var versionUrl string
+		for _, r := range index.Regions {
+			if r.RegionCode == region {
+				versionUrl = r.VersionUrl
+			}
+		}
+		if versionUrl == "" {
+			return nil, fmt.Errorf("no Savings Plans price list for region %s in %s",
+				region, location)
+		}
+		u, err := base.Parse(versionUrl)
+		if err != nil {
+			return nil, err
+		}
+		// A retry starts over, which is fine since rows are replaced.
+		var rpl PriceList
+		err = get(ctx, u.String(), func(r io.Reader) error {
+			rpl = PriceList{}
+			return ingestSavingsPlans(ctx, db, r, &rpl)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Savings Plans for %s: %v", region, err)
+		}
+		pl.Version = rpl.Version
+		pl.PublicationDate = rpl.PublicationDate
+		pl.Products += rpl.Products
+		pl.Prices += rpl.Prices
+	}
+	return pl, nil
+}
+
+// Adds the plans and rates in one region's price list to pl.
+func ingestSavingsPlans(ctx context.Context, db *sql.DB, r io.Reader, pl *PriceList) error {
+	w := &batchWriter{ctx: ctx, db: db}
+	dec := json.NewDecoder(r)
+	err := eachEntry(dec, func(key string) error {
+		switch key {
+		case "version":
+			return dec.Decode(&pl.Version)
+		case "publicationDate":
+			var date string
+			if err := dec.Decode(&date); err != nil {
+				return err
+			}
+			t, err := time.Parse(time.RFC3339, date)
+			if err != nil {
+				return err
+			}
+			pl.PublicationDate = t
+			return nil
+		case "products":
+			return eachElement(dec, func() error {
+				var p savingsPlanProduct
+				if err := dec.Decode(&p); err != nil {
+					return err
+				}
+				pl.Products++
+				a := p.Attributes
+				return w.exec(`REPLACE INTO SavingsPlans (Sku, PlanType,
+				LeaseContractLength, PurchaseOption, InstanceFamily, Region)
+				VALUES (?, ?, ?, ?, ?, ?);`,
+					p.Sku, p.ProductFamily, a["purchaseTerm"], a["purchaseOption"],
+					nullable(a["instanceType"]), nullable(a["regionCode"]))
+			})
+		case "terms":
+			return eachEntry(dec, func(termType string) error {
+				return eachElement(dec, func() error {
+					var t savingsPlanTerm
+					if err := dec.Decode(&t); err != nil {
+						return err
+					}
+					for _, rate := range t.Rates {
+						price, err := strconv.ParseFloat(rate.DiscountedRate.Price, 64)
+						if err != nil {
+							return fmt.Errorf("rate %s: %v", rate.RateCode, err)
+						}
+						err = w.exec(`REPLACE INTO SavingsPlanRates (RateCode, Sku,
+						DiscountedSku, Unit, Price, Currency) VALUES (?, ?, ?, ?, ?, ?);`,
+							rate.RateCode, t.Sku, rate.DiscountedSku, nullable(rate.Unit),
+							price, rate.DiscountedRate.Currency)
+						if err != nil {
+							return err
+						}
+						pl.Prices++
+					}
+					return nil
+				})
+			})
+		}
+		var skip json.RawMessage
+		return dec.Decode(&skip)
+	})
+	if err != nil {
+		w.rollback()
+		return err
+	}
+	return w.commit()
+}
+
+// Calls f for each element of the array that comes next in dec. f has
+// to consume the element.
+func eachElement(dec *json.Decoder, f func() error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
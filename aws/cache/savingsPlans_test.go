@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"nephomancy/aws/fake"
+	"nephomancy/aws/resources"
+	"testing"
+)
+
+const savingsPlansFile = "../fake/testdata/savingsPlan/v1.0/aws/AWSComputeSavingsPlan/20261001120000/us-east-1/index.json"
+
+func TestIngestSavingsPlansFromFile(t *testing.T) {
+	db := emptyDb(t)
+	defer db.Close()
+	pl, err := IngestSavingsPlans(context.Background(), db, savingsPlansFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Products != 4 || pl.Prices != 7 || pl.Version != "20261001120000" {
+		t.Errorf("expected 4 plans and 7 rates of version 20261001120000, got %+v", pl)
+	}
+	var family string
+	var price float64
+	if err = db.QueryRow(`SELECT p.InstanceFamily, r.Price FROM SavingsPlans p
+	JOIN SavingsPlanRates r ON p.Sku=r.Sku WHERE p.PlanType='EC2InstanceSavingsPlans'
+	AND p.PurchaseOption='Partial Upfront' AND r.DiscountedSku='T3MICROUSE1LNX'`).Scan(
+		&family, &price); err != nil || family != "t3" || price != 0.0062 {
+		t.Errorf("expected the t3 rate of 0.0062, got %s %f (%v)", family, price, err)
+	}
+	rates, err := ListRates(db)
+	key := "ISPT31YRPARTIAL.T3MICROUSE1LNX EC2InstanceSavingsPlans 1yr Partial Upfront"
+	if err != nil || len(rates) != 7 || rates[key] != "0.006200 USD per Hrs" {
+		t.Errorf("expected 7 rates with %s, got %v (%v)", key, rates, err)
+	}
+}
+
+func TestIngestSavingsPlansFromFake(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	resources.Endpoint = server.URL
+	defer func() { resources.Endpoint = "" }()
+	db := emptyDb(t)
+	defer db.Close()
+
+	// The first attempt fails and is retried.
+	ctx := context.Background()
+	server.FailNext(1)
+	pl, err := IngestSavingsPlans(ctx, db, SavingsPlansIndexUrl(), []string{"us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Products != 4 || pl.Prices != 7 || pl.Source != server.URL+SavingsPlansIndexPath {
+		t.Errorf("expected 4 plans and 7 rates from the fake, got %+v", pl)
+	}
+	// In the index, but there is no fixture.
+	if _, err = IngestSavingsPlans(ctx, db, SavingsPlansIndexUrl(), []string{"eu-central-1"}); err == nil {
+		t.Errorf("expected an error for a missing regional price list")
+	}
+	if _, err = IngestSavingsPlans(ctx, db, SavingsPlansIndexUrl(), []string{"xx-nowhere-1"}); err == nil {
+		t.Errorf("expected an error for a region that isn't in the index")
+	}
+}
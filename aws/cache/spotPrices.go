@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"io/ioutil"
+	awsec2 "nephomancy/aws/ec2"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The percentiles of the spot price history that are kept, and the one
+// instance sets are priced at unless their spot_percentile says
+// otherwise. 100 is the highest price in the history.
+var spotPercentiles = []uint32{10, 25, 50, 75, 90, 95, 99, 100}
+
+const defaultSpotPercentile = 90
+
+// How much spot price history went into the cache.
+type SpotHistory struct {
+	Prices int // Price changes read
+	Series int // Instance type, availability zone and product combinations
+}
+
+// Fetches the spot price history of the last days from the EC2 API of
+// each region and stores its percentiles.
+func FetchSpotPriceHistory(ctx context.Context, db *sql.DB, regions []string, days int) (
+	*SpotHistory, error) {
+	until := time.Now().UTC()
+	since := until.AddDate(0, 0, -days)
+	sh := &SpotHistory{}
+	for _, region := range regions {
+		prices, err := awsec2.DescribeSpotPriceHistory(ctx, region, since, until)
+		if err != nil {
+			return nil, fmt.Errorf("could not get spot price history for %s: %v", region, err)
+		}
+		rsh, err := insertSpotPrices(ctx, db, prices, since, until)
+		if err != nil {
+			return nil, err
+		}
+		sh.Prices += rsh.Prices
+		sh.Series += rsh.Series
+	}
+	return sh, nil
+}
+
+// Reads the output of aws ec2 describe-spot-price-history saved to a
+// file and stores its percentiles. The history is taken to cover the
+// time from its first to its last price change.
+func ReadSpotPriceHistory(ctx context.Context, db *sql.DB, filename string) (*SpotHistory, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var out ec2.DescribeSpotPriceHistoryOutput
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+	var since, until time.Time
+	for _, sp := range out.SpotPriceHistory {
+		if sp.Timestamp == nil {
+			continue
+		}
+		if since.IsZero() || sp.Timestamp.Before(since) {
+			since = *sp.Timestamp
+		}
+		if sp.Timestamp.After(until) {
+			until = *sp.Timestamp
+		}
+	}
+	if until.IsZero() {
+		return nil, fmt.Errorf("no spot prices in %s", filename)
+	}
+	return insertSpotPrices(ctx, db, out.SpotPriceHistory, since.UTC(), until.UTC())
+}
+
+// A price in a spot price history, and for how long it held.
+type spotSample struct {
+	Time    time.Time
+	Price   float64
+	Seconds int64
+}
+
+type spotSeries struct {
+	InstanceType       string
+	AvailabilityZone   string
+	ProductDescription string
+}
+
+// Products in the EC2-Classic days had a VPC variant. Now all instances
+// are in a vpc, and the API leaves the suffix off.
+func spotProduct(description string) string {
+	return strings.TrimSuffix(description, " (Amazon VPC)")
+}
+
+// Matches the region at the start of an availability zone, including
+// local zones such as us-west-2-lax-1a.
+var regionOfZone = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]*)?-[a-z]+-[0-9]+`)
+
+func insertSpotPrices(ctx context.Context, db *sql.DB, prices []*ec2.SpotPrice,
+	since time.Time, until time.Time) (*SpotHistory, error) {
+	series := make(map[spotSeries][]spotSample)
+	for _, sp := range prices {
+		if sp.InstanceType == nil || sp.AvailabilityZone == nil ||
+			sp.ProductDescription == nil || sp.SpotPrice == nil || sp.Timestamp == nil {
+			return nil, fmt.Errorf("incomplete spot price %v", sp)
+		}
+		price, err := strconv.ParseFloat(*sp.SpotPrice, 64)
+		if err != nil {
+			return nil, fmt.Errorf("spot price of %s in %s: %v", *sp.InstanceType,
+				*sp.AvailabilityZone, err)
+		}
+		key := spotSeries{*sp.InstanceType, *sp.AvailabilityZone,
+			spotProduct(*sp.ProductDescription)}
+		series[key] = append(series[key], spotSample{Time: *sp.Timestamp, Price: price})
+	}
+	w := &batchWriter{ctx: ctx, db: db}
+	for key, samples := range series {
+		region := regionOfZone.FindString(key.AvailabilityZone)
+		if region == "" {
+			w.rollback()
+			return nil, fmt.Errorf("no region for availability zone %s", key.AvailabilityZone)
+		}
+		weighSpotSamples(samples, since, until)
+		for _, p := range spotPercentiles {
+			err := w.exec(`REPLACE INTO SpotPrices (InstanceType, AvailabilityZone,
+			ProductDescription, Percentile, Region, Price, Samples, Since, Until)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+				key.InstanceType, key.AvailabilityZone, key.ProductDescription, p,
+				region, spotPercentile(samples, p), len(samples),
+				since.Format(time.RFC3339), until.Format(time.RFC3339))
+			if err != nil {
+				w.rollback()
+				return nil, err
+			}
+		}
+	}
+	if err := w.commit(); err != nil {
+		return nil, err
+	}
+	return &SpotHistory{Prices: len(prices), Series: len(series)}, nil
+}
+
+// Sets how long each price held, from its time, or since if that is
+// later, to the next price change or until. If no time passed at all,
+// each price counts the same. Sorts the samples by price.
+func weighSpotSamples(samples []spotSample, since time.Time, until time.Time) {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	var total int64
+	for i := range samples {
+		start := samples[i].Time
+		if start.Before(since) {
+			start = since
+		}
+		end := until
+		if i+1 < len(samples) {
+			end = samples[i+1].Time
+		}
+		if end.After(start) {
+			samples[i].Seconds = int64(end.Sub(start) / time.Second)
+			total += samples[i].Seconds
+		}
+	}
+	if total == 0 {
+		for i := range samples {
+			samples[i].Seconds = 1
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Price < samples[j].Price
+	})
+}
+
+// Returns the lowest price that held for at least p percent of the
+// time, from samples sorted by price.
+func spotPercentile(samples []spotSample, p uint32) float64 {
+	var total int64
+	for _, s := range samples {
+		total += s.Seconds
+	}
+	var cumulative int64
+	var price float64
+	for _, s := range samples {
+		if s.Seconds == 0 {
+			continue
+		}
+		cumulative += s.Seconds
+		price = s.Price
+		if cumulative*100 >= total*int64(p) {
+			break
+		}
+	}
+	return price
+}
+
+// Returns the product description of spot prices for the instance's
+// operating system. Spot instances are shared and licensed with the
+// instance.
+func spotProductDescription(avm *resources.Ec2VM) (string, error) {
+	if tenancy(avm) != "Shared" || avm.LicenseModel == "Bring your own license" {
+		return "", fmt.Errorf("no spot prices for tenancy %s with license model %s",
+			tenancy(avm), avm.LicenseModel)
+	}
+	switch os := osName(avm); os {
+	case "Linux":
+		return "Linux/UNIX", nil
+	case "RHEL":
+		return "Red Hat Enterprise Linux", nil
+	case "SUSE":
+		return "SUSE Linux", nil
+	case "Windows":
+		return "Windows", nil
+	default:
+		return "", fmt.Errorf("no spot prices for os %s", os)
+	}
+}
+
+// Prices spot instances at a percentile of the price history, in the
+// availability zone of the region where that is lowest. Spot instances
+// can be reclaimed at two minutes' notice whenever EC2 needs the
+// capacity back, which the spec says.
+func spotCost(db *sql.DB, vmset *common.InstanceSet, spec string, avm *resources.Ec2VM) (
+	[][]string, error) {
+	percentile := avm.SpotPercentile
+	if percentile == 0 {
+		percentile = defaultSpotPercentile
+	}
+	valid := false
+	for _, p := range spotPercentiles {
+		valid = valid || p == percentile
+	}
+	if !valid {
+		return nil, fmt.Errorf("spot percentile %d is not one of %v", percentile, spotPercentiles)
+	}
+	product, err := spotProductDescription(avm)
+	if err != nil {
+		return nil, err
+	}
+	var zone, since, until string
+	var price float64
+	err = db.QueryRow(`SELECT AvailabilityZone, Price, Since, Until FROM SpotPrices
+	WHERE InstanceType=? AND Region=? AND ProductDescription=? AND Percentile=?
+	ORDER BY Price, AvailabilityZone LIMIT 1;`,
+		avm.InstanceType, avm.Region, product, percentile).Scan(&zone, &price, &since, &until)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no spot price history for %s %s in %s, is it in the cache?",
+			avm.InstanceType, product, avm.Region)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sspec := fmt.Sprintf("%s, spot in %s at the %dth percentile of %s to %s, can be interrupted",
+		spec, zone, percentile, since[:10], until[:10])
+	maxUsage := uint64(hoursPerMonth * vmset.Count)
+	projectedUsage := uint64(vmset.UsageHoursPerMonth * vmset.Count)
+	// Spot prices are in USD.
+	// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
+	return [][]string{{
+		"VM",
+		fmt.Sprintf("%d", vmset.Count),
+		sspec,
+		fmt.Sprintf("%d h per month", maxUsage),
+		fmt.Sprintf("%.2f USD", price*float64(maxUsage)),
+		fmt.Sprintf("%d h per month", projectedUsage),
+		fmt.Sprintf("%.2f USD", price*float64(projectedUsage)),
+	}}, nil
+}
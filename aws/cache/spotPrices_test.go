@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-test/deep"
+	"nephomancy/aws/fake"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"testing"
+)
+
+const spotPriceHistoryFile = "testdata/describe-spot-price-history.json"
+
+func spotPrices(t *testing.T, db *sql.DB, instanceType string, zone string) map[uint32]float64 {
+	res, err := db.Query(`SELECT Percentile, Price FROM SpotPrices
+	WHERE InstanceType=? AND AvailabilityZone=?;`, instanceType, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	prices := make(map[uint32]float64)
+	for res.Next() {
+		var p uint32
+		var price float64
+		if err = res.Scan(&p, &price); err != nil {
+			t.Fatal(err)
+		}
+		prices[p] = price
+	}
+	return prices
+}
+
+func TestReadSpotPriceHistory(t *testing.T) {
+	db := emptyDb(t)
+	defer db.Close()
+	sh, err := ReadSpotPriceHistory(context.Background(), db, spotPriceHistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sh.Prices != 9 || sh.Series != 4 {
+		t.Errorf("expected 9 prices in 4 series, got %+v", sh)
+	}
+	// 0.0031 held for 2 of the 10 days, 0.004 for 4 and 0.005 for 4.
+	want := map[uint32]float64{10: 0.0031, 25: 0.004, 50: 0.004, 75: 0.005, 90: 0.005,
+		95: 0.005, 99: 0.005, 100: 0.005}
+	if diff := deep.Equal(want, spotPrices(t, db, "t3.micro", "us-east-1a")); diff != nil {
+		t.Errorf("unexpected percentiles: %v", diff)
+	}
+	// The last price marks the end of the history and doesn't count.
+	if p := spotPrices(t, db, "t3.micro", "us-east-1b"); p[90] != 0.0045 || p[100] != 0.006 {
+		t.Errorf("expected 0.0045 at the 90th and 0.006 at the 100th percentile, got %v", p)
+	}
+	var product, region string
+	if err = db.QueryRow(`SELECT ProductDescription, Region FROM SpotPrices
+	WHERE InstanceType='m5.large'`).Scan(&product, &region); err != nil ||
+		product != "Windows" || region != "us-east-1" {
+		t.Errorf("expected Windows in us-east-1, got %s in %s (%v)", product, region, err)
+	}
+	if _, err = ReadSpotPriceHistory(context.Background(), db, "testdata/missing.json"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestFetchSpotPriceHistoryFromFake(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	resources.Endpoint = server.URL
+	defer func() { resources.Endpoint = "" }()
+	db := emptyDb(t)
+	defer db.Close()
+
+	server.FailNext(1)
+	sh, err := FetchSpotPriceHistory(context.Background(), db, []string{"us-east-1"}, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sh.Prices != 7 || sh.Series != 2 {
+		t.Errorf("expected 7 prices in 2 series over two pages, got %+v", sh)
+	}
+	if p := spotPrices(t, db, "t3.micro", "us-east-1a"); len(p) != len(spotPercentiles) {
+		t.Errorf("expected %d percentiles, got %v", len(spotPercentiles), p)
+	}
+}
+
+func TestGetCostSpot(t *testing.T) {
+	db := pricedDb(t)
+	defer db.Close()
+	if _, err := ReadSpotPriceHistory(context.Background(), db, spotPriceHistoryFile); err != nil {
+		t.Fatal(err)
+	}
+	p := &common.Project{Name: "shop", InstanceSets: []*common.InstanceSet{
+		vmSet(t, "batch", 2, 730, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Spot"}),
+		vmSet(t, "risky", 1, 730, &resources.Ec2VM{InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Spot", SpotPercentile: 99}),
+	}}
+	costs, err := GetCost(db, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"shop", "aws", "batch", "VM", "2",
+			"t3.micro Linux in us-east-1, spot in us-east-1b at the 90th percentile of 2026-10-01 to 2026-10-11, can be interrupted",
+			"1460 h per month", "6.57 USD", "1460 h per month", "6.57 USD"},
+		{"shop", "aws", "risky", "VM", "1",
+			"t3.micro Linux in us-east-1, spot in us-east-1a at the 99th percentile of 2026-10-01 to 2026-10-11, can be interrupted",
+			"730 h per month", "3.65 USD", "730 h per month", "3.65 USD"},
+	}
+	if diff := deep.Equal(want, costs); diff != nil {
+		t.Errorf("unexpected costs: %v", diff)
+	}
+
+	for name, avm := range map[string]*resources.Ec2VM{
+		"unknown percentile": {InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Spot", SpotPercentile: 42},
+		"no history in region": {InstanceType: "t3.micro", Region: "us-west-2",
+			TermType: "Spot"},
+		"dedicated": {InstanceType: "t3.micro", Region: "us-east-1",
+			TermType: "Spot", Tenancy: "Dedicated"},
+	} {
+		p := &common.Project{Name: "shop",
+			InstanceSets: []*common.InstanceSet{vmSet(t, name, 1, 730, avm)}}
+		if costs, err := GetCost(db, p); err == nil {
+			t.Errorf("%s: expected an error but got %v", name, costs)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"database/sql"
+	"nephomancy/aws/resources"
+	common "nephomancy/common/resources"
+	"strings"
+)
+
+// An EBS volume type from the VolumeTypes table.
+type volumeType struct {
+	ApiType       string
+	Media         string
+	MinSize       uint32
+	MaxSize       uint32
+	MaxIops       uint32
+	MaxThroughput uint32
+}
+
+// Returns the volume types that the price list has in the region, in
+// API name order.
+func listVolumeTypes(db *sql.DB, region string) ([]volumeType, error) {
+	res, err := db.Query(`SELECT v.VolumeApiType, IFNULL(v.StorageMedia, ''),
+	v.MinVolumeSize, IFNULL(v.MaxVolumeSize, 0), IFNULL(v.MaxIOPS, 0),
+	IFNULL(v.MaxThroughput, 0) FROM VolumeTypes v JOIN VolumeTypeByRegion r
+	ON v.VolumeApiType=r.VolumeApiType WHERE r.Region=? ORDER BY v.VolumeApiType;`,
+		region)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	vts := make([]volumeType, 0)
+	for res.Next() {
+		var vt volumeType
+		if err = res.Scan(&vt.ApiType, &vt.Media, &vt.MinSize, &vt.MaxSize,
+			&vt.MaxIops, &vt.MaxThroughput); err != nil {
+			return nil, err
+		}
+		vts = append(vts, vt)
+	}
+	return vts, res.Err()
+}
+
+// Returns true for SSD-backed volume types if tech is SSD and for the
+// others if it is Standard.
+func (vt volumeType) hasTech(tech string) bool {
+	ssd := strings.HasPrefix(vt.Media, "SSD")
+	switch tech {
+	case "SSD":
+		return ssd
+	case "Standard":
+		return !ssd
+	}
+	return true
+}
+
+// Returns the IOPS and throughput in MiB/s a volume of the size gets
+// without paying for them. gp2 IOPS and HDD throughput grow with the
+// size; for HDDs this is the baseline, not the burst rate.
+func (vt volumeType) baseline(sizeGb uint64) (uint32, uint32) {
+	switch vt.ApiType {
+	case "gp2":
+		iops := 3 * sizeGb
+		if iops < 100 {
+			iops = 100
+		}
+		if iops > uint64(vt.MaxIops) {
+			iops = uint64(vt.MaxIops)
+		}
+		return uint32(iops), vt.MaxThroughput
+	case "gp3":
+		return 3000, 125
+	case "io1", "io2":
+		// Depends on the provisioned IOPS.
+		return 0, 0
+	case "st1":
+		return vt.MaxIops, minUint32(vt.MaxThroughput, uint32(40*sizeGb/1024))
+	case "sc1":
+		return vt.MaxIops, minUint32(vt.MaxThroughput, uint32(12*sizeGb/1024))
+	}
+	return vt.MaxIops, vt.MaxThroughput
+}
+
+// Returns whether IOPS and throughput beyond the baseline can be
+// provisioned, at a price.
+func (vt volumeType) provisioned() (bool, bool) {
+	switch vt.ApiType {
+	case "gp3":
+		return true, true
+	case "io1", "io2":
+		return true, false
+	}
+	return false, false
+}
+
+// Returns how many IOPS can be provisioned per GiB of the volume, or 0
+// if the IOPS don't depend on the size.
+func (vt volumeType) iopsPerGb() uint32 {
+	switch vt.ApiType {
+	case "gp3", "io2":
+		return 500
+	case "io1":
+		return 50
+	}
+	return 0
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Returns the IOPS and throughput of the volume that are paid for on
+// top of the storage. Provisioned IOPS volumes pay for all their IOPS,
+// gp3 volumes only for what is above the baseline.
+func billablePerformance(dsk *resources.Ec2Disk) (uint32, uint32) {
+	switch dsk.VolumeType {
+	case "gp3":
+		var iops, throughput uint32
+		if dsk.Iops > 3000 {
+			iops = dsk.Iops - 3000
+		}
+		if dsk.Throughput > 125 {
+			throughput = dsk.Throughput - 125
+		}
+		return iops, throughput
+	case "io1", "io2":
+		return dsk.Iops, 0
+	}
+	return 0, 0
+}
+
+// Returns the volume in the region that meets the disk type's size, IOPS
+// and throughput at the lowest monthly price, or nil if there is none.
+// Volumes smaller than the volume type's minimum size, or too small for
+// their IOPS, are made bigger.
+func volumeForDiskType(db *sql.DB, dt *common.DiskType, region string) (*resources.Ec2Disk, error) {
+	vts, err := listVolumeTypes(db, region)
+	if err != nil {
+		return nil, err
+	}
+	var best *resources.Ec2Disk
+	var lowest float64
+	for _, vt := range vts {
+		if !vt.hasTech(dt.DiskTech) || dt.Iops > vt.MaxIops ||
+			dt.ThroughputMibps > vt.MaxThroughput {
+			continue
+		}
+		size := dt.SizeGb
+		if size < vt.MinSize {
+			size = vt.MinSize
+		}
+		if size > vt.MaxSize {
+			continue
+		}
+		dsk := &resources.Ec2Disk{
+			VolumeType:   vt.ApiType,
+			ActualSizeGb: uint64(size),
+			Region:       region,
+		}
+		baseIops, baseThroughput := vt.baseline(dsk.ActualSizeGb)
+		provIops, provThroughput := vt.provisioned()
+		if baseIops == 0 {
+			// Provisioned IOPS volumes need at least 100 IOPS, and their
+			// throughput is 256 KiB per I/O.
+			dsk.Iops = dt.Iops
+			if dsk.Iops < 4*dt.ThroughputMibps {
+				dsk.Iops = 4 * dt.ThroughputMibps
+			}
+			if dsk.Iops < 100 {
+				dsk.Iops = 100
+			}
+			if dsk.Iops > vt.MaxIops {
+				continue
+			}
+			baseThroughput = minUint32(vt.MaxThroughput, dsk.Iops/4)
+		} else if dt.Iops > baseIops {
+			if !provIops {
+				continue
+			}
+			dsk.Iops = dt.Iops
+		}
+		if dt.ThroughputMibps > baseThroughput {
+			if !provThroughput {
+				continue
+			}
+			dsk.Throughput = dt.ThroughputMibps
+			// gp3 volumes get at most 0.25 MiB/s per IOPS.
+			if iops := 4 * dsk.Throughput; iops > baseIops && iops > dsk.Iops {
+				if iops > vt.MaxIops {
+					continue
+				}
+				dsk.Iops = iops
+			}
+		}
+		if perGb := vt.iopsPerGb(); perGb > 0 {
+			if min := uint64((dsk.Iops + perGb - 1) / perGb); dsk.ActualSizeGb < min {
+				dsk.ActualSizeGb = min
+			}
+			if dsk.ActualSizeGb > uint64(vt.MaxSize) {
+				continue
+			}
+		}
+		vr, err := getVolumeRates(db, dsk)
+		if err != nil {
+			return nil, err
+		}
+		if vr == nil {
+			continue
+		}
+		storage, iops, throughput := vr.monthly(dsk)
+		if total := storage + iops + throughput; best == nil || total < lowest {
+			best = dsk
+			lowest = total
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"fmt"
+	"log"
+	"nephomancy/aws/assets"
+	"nephomancy/aws/cache"
+	"nephomancy/aws/provider"
+	common "nephomancy/common/command"
+	"nephomancy/common/registry"
+	"strings"
+)
+
+type AssetsCommand struct {
+	common.Command
+	name      string
+	regions   string
+	inventory string
+}
+
+func (*AssetsCommand) Help() string {
+	helpText := fmt.Sprintf(`
+	Usage: nephomancy aws assets [options]
+
+	Build a project from the instances, EBS volumes, Elastic IPs, NAT
+	gateways and load balancers in an AWS account.
+
+	Identical instances are grouped into instance sets, and volumes of
+	the same type and size into disk sets. Each VPC becomes a network.
+	The resources are read from the APIs, with the credentials the AWS
+	CLI uses, or from saved output of the AWS CLI's describe-* commands.
+	The instance types need to be in the cache, see "nephomancy aws init".
+
+	Options:
+	  --workingdir=path	Optional: directory under which the data directory is. Defaults to current working directory.
+	  --name=name	Name of the project.
+	  --regions=list	Comma-separated regions to read from the APIs, e.g. us-east-1,eu-central-1.
+	  --inventory=path	Directory with saved describe-* output instead of the APIs, with one subdirectory per region.
+	  --projectin=filename	Optional: a project to fill in the spec of instead.
+	  --projectout=filename	Where to save the project. Defaults to the project name.
+	  --timeout=duration	%s
+	  --call-timeout=duration	%s
+	  --retries=n	%s
+`, common.TimeoutDoc, common.CallTimeoutDoc, common.RetriesDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*AssetsCommand) Synopsis() string {
+	return "Builds a project from the resources in an AWS account."
+}
+
+// Run this with
+// nephomancy aws assets --name=shop --regions=us-east-1
+// or, offline,
+// nephomancy aws assets --name=shop --inventory=inventory
+func (c *AssetsCommand) Run(args []string) int {
+	fs := c.Command.DefaultFlagSet("awsAssets")
+	c.Command.AddFetchFlags(fs)
+	fs.StringVar(&c.name, "name", "", "Name of the project.")
+	fs.StringVar(&c.regions, "regions", "", "Regions to read from the APIs.")
+	fs.StringVar(&c.inventory, "inventory", "", "Directory with saved describe-* output.")
+	fs.Parse(args)
+
+	// A project specified via an infile will be used as it is.
+	project, err := c.Command.LoadProject()
+	if err != nil {
+		log.Fatalf("Failed to load project from file: %v\n", err)
+	}
+	if project == nil {
+		if c.name == "" {
+			log.Fatalf("Need a project name, please pass one via --name.\n")
+		}
+		var invs []*assets.Inventory
+		if c.inventory != "" {
+			if invs, err = assets.ReadInventory(c.inventory); err != nil {
+				log.Fatalf("Failed to read inventory: %v\n", err)
+			}
+		} else {
+			if c.regions == "" {
+				log.Fatalf("Need regions, please pass them via --regions, or use --inventory.\n")
+			}
+			ctx, cancel := c.Command.FetchContext()
+			defer cancel()
+			for _, region := range strings.Split(c.regions, ",") {
+				inv, err := assets.FetchInventory(ctx, strings.TrimSpace(region))
+				if err != nil {
+					log.Fatalf("Failed to get inventory: %v\n", err)
+				}
+				invs = append(invs, inv)
+			}
+		}
+		if project, err = assets.BuildProject(c.name, invs); err != nil {
+			log.Fatalf("Building project failed: %v\n", err)
+		}
+	}
+
+	p, err := registry.GetProvider("aws")
+	if err != nil {
+		log.Fatalf("Failed to get AWS provider: %v\n", err)
+	}
+	dd, err := c.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to get data directory: %v\n", err)
+	}
+	prov, _ := p.(*provider.AwsProvider)
+	if err := prov.Initialize(dd); err != nil {
+		log.Fatalf("Failed to initialize provider: %v\n", err)
+	}
+	if err = cache.FillInSpec(prov.DbHandle, project); err != nil {
+		log.Fatalf("Resolving project failed: %v\n", err)
+	}
+	if err = c.Command.SaveProject(project); err != nil {
+		log.Fatalf("Failed to save project: %v\n", err)
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"nephomancy/aws/billing"
+	"nephomancy/aws/provider"
+	common "nephomancy/common/command"
+	"nephomancy/common/registry"
+	"os"
+	"strings"
+)
+
+type BillingCommand struct {
+	common.Command
+	report string
+}
+
+func (*BillingCommand) Help() string {
+	helpText := `
+	Usage: nephomancy aws billing [options]
+
+	Compare the estimated costs of a project's instance sets with a
+	Cost and Usage Report.
+
+	Matches the instance usage in the report to the instance sets by
+	instance type, region and purchase option, and prints a CSV with the
+	estimated and actual costs per instance set, followed by the rest of
+	the spend per usage type. Estimates are monthly, so they are scaled
+	to the period the report covers. Reserved instances and savings plans
+	are counted at their effective cost.
+
+	Options:
+	  --workingdir=path	Optional: directory under which the data directory is. Defaults to current working directory.
+	  --projectin=filename	The project to compare, as a json-encoded protocol buffer.
+	  --report=filename	The Cost and Usage Report, as .csv, .csv.gz or .parquet.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (*BillingCommand) Synopsis() string {
+	return "Compares estimated costs with an AWS Cost and Usage Report."
+}
+
+// Run this with
+// nephomancy aws billing --projectin=shop.json --report=shop-00001.csv.gz
+func (c *BillingCommand) Run(args []string) int {
+	fs := c.Command.DefaultFlagSet("awsBilling")
+	fs.StringVar(&c.report, "report", "", "The Cost and Usage Report (csv, csv.gz or parquet).")
+	fs.Parse(args)
+
+	project, err := c.Command.LoadProject()
+	if err != nil {
+		log.Fatalf("Failed to load project from file: %v\n", err)
+	}
+	if project == nil {
+		log.Fatalf("Need a project, please pass one via --projectin.\n")
+	}
+	if c.report == "" {
+		log.Fatalf("Need a Cost and Usage Report, please pass one via --report.\n")
+	}
+	items, err := billing.ReadCurFile(c.report)
+	if err != nil {
+		log.Fatalf("Failed to read Cost and Usage Report: %v\n", err)
+	}
+
+	p, err := registry.GetProvider("aws")
+	if err != nil {
+		log.Fatalf("Failed to get AWS provider: %v\n", err)
+	}
+	dd, err := c.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to get data directory: %v\n", err)
+	}
+	prov, _ := p.(*provider.AwsProvider)
+	if err := prov.Initialize(dd); err != nil {
+		log.Fatalf("Failed to initialize provider: %v\n", err)
+	}
+	lines, err := prov.GetCost(project)
+	if err != nil {
+		log.Fatalf("Failed to get costs: %v\n", err)
+	}
+	if len(lines) == 0 {
+		log.Printf("No estimates for project %s, is the AWS price cache populated?\n",
+			project.Name)
+	}
+	report, err := billing.Compare(project, lines, items)
+	if err != nil {
+		log.Fatalf("Failed to compare costs: %v\n", err)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.WriteAll(varianceLines(report))
+	if err = w.Error(); err != nil {
+		log.Fatalf("Failed to write report: %v\n", err)
+	}
+	return 0
+}
+
+// Returns a CSV line per instance set and unmatched usage type, and a total.
+func varianceLines(r *billing.Report) [][]string {
+	money := func(amount float64) string {
+		return fmt.Sprintf("%.2f USD", amount)
+	}
+	line := func(kind string, v billing.Variance) []string {
+		percent := "n/a"
+		if v.Estimated != 0 {
+			percent = fmt.Sprintf("%.1f%%", (v.Actual-v.Estimated)/v.Estimated*100)
+		}
+		return []string{kind, v.Name, v.Description, money(v.Estimated),
+			money(v.Actual), money(v.Actual - v.Estimated), percent}
+	}
+	lines := [][]string{{"kind", "name", "description", "estimated", "actual",
+		"variance", "variance %"}}
+	total := billing.Variance{Name: "total",
+		Description: fmt.Sprintf("%s to %s", r.Start.Format("2006-01-02 15:04"),
+			r.End.Format("2006-01-02 15:04"))}
+	for _, v := range r.Sets {
+		lines = append(lines, line("instance set", v))
+		total.Estimated += v.Estimated
+		total.Actual += v.Actual
+	}
+	for _, v := range r.Unmatched {
+		lines = append(lines, line("unmatched", v))
+		total.Actual += v.Actual
+	}
+	return append(lines, line("total", total))
+}
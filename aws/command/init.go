@@ -4,20 +4,25 @@ import (
 	"fmt"
 	"log"
 	"nephomancy/aws/cache"
-	"nephomancy/aws/ec2"
 	"nephomancy/aws/provider"
-	"nephomancy/aws/resources"
 	common "nephomancy/common/command"
 	"nephomancy/common/registry"
 	"strings"
+	"time"
 )
 
 type InitCommand struct {
 	common.Command
+	priceList        string
+	savingsPlans     string
+	savingsPlanIndex string
+	spotPrices       string
+	spotHistory      string
+	spotDays         int
 }
 
 func (*InitCommand) Help() string {
-	helpText := `
+	helpText := fmt.Sprintf(`
 	"Usage nephomancy aws init [options]
 
 	Initialize a new or existing data directory by building or refreshing
@@ -26,11 +31,22 @@ func (*InitCommand) Help() string {
 	You should run this when you first start working on a project, and
 	whenever you think AWS pricing may have changed.
 
-	This command is safe to run multiple times.
+	This command is safe to run multiple times. Each run also saves a dated
+	snapshot of the price data, which you can use with "nephomancy prices"
+	and "nephomancy cost --as-of".
 
 	Options:
 	  --workingdir=path	Optional: directory under which the data directory should be. Defaults to current working directory.
-`
+	  --pricelist=url	Optional: URL or local file of the EC2 price list. Defaults to %s. Pass an empty value to skip prices.
+	  --savingsplans=regions	Optional: comma-separated regions whose Savings Plans rates to ingest, e.g. us-east-1,eu-central-1. There is a large price list per region, so none are ingested by default.
+	  --savingsplanindex=url	Optional: URL of the Savings Plans price list index, or a local file with the price list of one region. Defaults to %s.
+	  --spotprices=regions	Optional: comma-separated regions whose spot price history to fetch from the EC2 API, e.g. us-east-1,eu-central-1. Spot instances are priced at a percentile of it.
+	  --spothistory=filename	Optional: output of "aws ec2 describe-spot-price-history" saved to a file, instead of or as well as the API.
+	  --spotdays=n	Optional: days of spot price history to fetch from the API, at most 90. Defaults to 30.
+	  --timeout=duration	%s
+	  --call-timeout=duration	%s
+	  --retries=n	%s
+`, cache.PriceListUrl(), cache.SavingsPlansIndexUrl(), common.TimeoutDoc, common.CallTimeoutDoc, common.RetriesDoc)
 	return strings.TrimSpace(helpText)
 }
 
@@ -40,6 +56,14 @@ func (*InitCommand) Synopsis() string {
 
 func (c *InitCommand) Run(args []string) int {
 	fs := c.Command.DefaultFlagSet("awsInit")
+	c.Command.AddFetchFlags(fs)
+	fs.StringVar(&c.priceList, "pricelist", cache.PriceListUrl(), "URL or file of the EC2 price list.")
+	fs.StringVar(&c.savingsPlans, "savingsplans", "", "Regions whose Savings Plans rates to ingest.")
+	fs.StringVar(&c.savingsPlanIndex, "savingsplanindex", cache.SavingsPlansIndexUrl(),
+		"URL of the Savings Plans index or file of a regional Savings Plans price list.")
+	fs.StringVar(&c.spotPrices, "spotprices", "", "Regions whose spot price history to fetch.")
+	fs.StringVar(&c.spotHistory, "spothistory", "", "File with saved spot price history.")
+	fs.IntVar(&c.spotDays, "spotdays", 30, "Days of spot price history to fetch.")
 	fs.Parse(args)
 
 	p, err := registry.GetProvider("aws")
@@ -51,42 +75,77 @@ func (c *InitCommand) Run(args []string) int {
 	if err := prov.Initialize(dd); err != nil {
 		log.Fatalf("Failed to initialize provider: %v\n", err)
 	}
-	if err := cache.CreateOrUpdateDatabase(prov.DbHandle); err != nil {
-		log.Fatalf("Failed to create database: %v\n", err)
+	stage, err := prov.BeginRefresh()
+	if err != nil {
+		log.Fatalf("Failed to copy price cache: %v\n", err)
+	}
+	fail := func(format string, v ...interface{}) {
+		stage.Abort()
+		log.Printf("The price cache was left unchanged.\n")
+		log.Fatalf(format, v...)
 	}
+	ctx, cancel := c.Command.FetchContext()
+	defer cancel()
+
 	// This populates the region table based on the default partitions.
 	if err := cache.PopulateDatabase(prov.DbHandle); err != nil {
-		log.Fatalf("Failed to populate database: %v\n", err)
+		fail("Failed to populate database: %v\n", err)
+	}
+
+	if err := cache.FetchInstanceTypes(ctx, prov.DbHandle); err != nil {
+		fail("Failed to fetch instance types: %v\n", err)
+	}
+
+	// The price list is several GB and takes a while.
+	var pl *cache.PriceList
+	if c.priceList != "" {
+		if pl, err = cache.IngestPriceList(ctx, prov.DbHandle, c.priceList); err != nil {
+			fail("Failed to ingest price list: %v\n", err)
+		}
+		fmt.Printf("Ingested %d products and %d prices from the price list of %s.\n",
+			pl.Products, pl.Prices, pl.PublicationDate.Format("2006-01-02"))
 	}
 
-	sendToDb := make(chan *resources.InstanceType, 1)
-	okFromDb := make(chan error, 1)
-	retval := make(chan error, 1)
-	defer close(sendToDb)
-	defer close(okFromDb)
-
-	// Assume us-east-1 has all instance types that exist.
-	go ec2.DescribeInstanceTypes(nil, "us-east-1", sendToDb, okFromDb, retval)
-	go cache.InsertInstanceTypes(prov.DbHandle, sendToDb, okFromDb)
-	select {
-	case failure := <-retval:
-		if failure != nil {
-			log.Fatalf("Could not get instance type descriptions: %v\n", failure)
+	if c.savingsPlans != "" {
+		regions := strings.Split(c.savingsPlans, ",")
+		spl, err := cache.IngestSavingsPlans(ctx, prov.DbHandle, c.savingsPlanIndex, regions)
+		if err != nil {
+			fail("Failed to ingest Savings Plans prices: %v\n", err)
 		}
+		fmt.Printf("Ingested %d Savings Plans and %d rates for %s.\n",
+			spl.Products, spl.Prices, c.savingsPlans)
 	}
-	close(retval)
 
-	regions := cache.AllRegions(true)
-	for _, r := range regions {
-		itypes, err := ec2.ListInstanceTypesByLocation(r)
+	if c.spotPrices != "" {
+		sh, err := cache.FetchSpotPriceHistory(ctx, prov.DbHandle,
+			strings.Split(c.spotPrices, ","), c.spotDays)
 		if err != nil {
-			log.Fatalf("Failed to list instance types for %s: %+v\n", r, err)
+			fail("Failed to fetch spot price history: %v\n", err)
 		}
-		if err = cache.InsertInstanceTypesForRegion(prov.DbHandle, itypes, r); err != nil {
-			log.Fatalf("Failed to insert instance types for %s: %+v\n", r, err)
+		fmt.Printf("Ingested %d spot prices of %d instance types and zones for %s.\n",
+			sh.Prices, sh.Series, c.spotPrices)
+	}
+	if c.spotHistory != "" {
+		sh, err := cache.ReadSpotPriceHistory(ctx, prov.DbHandle, c.spotHistory)
+		if err != nil {
+			fail("Failed to read spot price history: %v\n", err)
 		}
+		fmt.Printf("Ingested %d spot prices of %d instance types and zones from %s.\n",
+			sh.Prices, sh.Series, c.spotHistory)
+	}
+
+	if err := cache.RecordRefresh(prov.DbHandle, pl); err != nil {
+		fail("Failed to record cache metadata: %v\n", err)
 	}
 
+	fname, err := prov.SaveSnapshot(time.Now())
+	if err != nil {
+		fail("Failed to save price snapshot: %v\n", err)
+	}
+	if err = stage.Commit(); err != nil {
+		log.Fatalf("Failed to replace price cache: %v\n", err)
+	}
 	fmt.Println("Populated database.")
+	fmt.Printf("Saved price snapshot to %s\n", fname)
 	return 0
 }
@@ -24,9 +24,12 @@ func (*ListCommand) Synopsis() string {
 func (r *ListCommand) Run(args []string) int {
 	fs := r.Command.DefaultFlagSet("awsRegions")
 	fs.StringVar(&r.region, "region", "us-east-1", "The region to list things for.")
+	r.Command.AddFetchFlags(fs)
 	fs.Parse(args)
 
-	itypes, err := ec2.ListInstanceTypesByLocation(r.region)
+	ctx, cancel := r.Command.FetchContext()
+	defer cancel()
+	itypes, err := ec2.ListInstanceTypesByLocation(ctx, r.region)
 	if err != nil {
 		log.Fatalf("Failed to list aws instance type offerings: %v\n", err)
 	}
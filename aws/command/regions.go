@@ -20,10 +20,13 @@ func (*RegionsCommand) Synopsis() string {
 
 func (r *RegionsCommand) Run(args []string) int {
 	fs := r.Command.DefaultFlagSet("awsRegions")
+	r.Command.AddFetchFlags(fs)
 	fs.Parse(args)
 
-	// regions, err := resources.ListRegions()
-	_, err := resources.ListServices()
+	ctx, cancel := r.Command.FetchContext()
+	defer cancel()
+	// regions, err := resources.ListRegions(ctx)
+	_, err := resources.ListServices(ctx)
 	if err != nil {
 		log.Fatalf("Failed to list aws regions: %v\n", err)
 	}
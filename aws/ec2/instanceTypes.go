@@ -3,22 +3,21 @@
 package ec2
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"nephomancy/aws/resources"
+	"nephomancy/common/fetch"
 )
 
-func DescribeInstanceTypes(
+func DescribeInstanceTypes(ctx context.Context,
 	instanceTypes []string, region string,
 	toDb chan<- *resources.InstanceType, fromDb <-chan error, retval chan<- error) {
 	if region == "" {
 		region = "us-east-1"
 	}
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
+	sess := resources.NewSession(region)
 	svc := ec2.New(sess)
 	var pageSize int64 = 5
 	request := &ec2.DescribeInstanceTypesInput{
@@ -31,7 +30,12 @@ func DescribeInstanceTypes(
 		}
 	}
 	for {
-		typeInfoList, err := svc.DescribeInstanceTypes(request)
+		var typeInfoList *ec2.DescribeInstanceTypesOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			typeInfoList, err = svc.DescribeInstanceTypesWithContext(ctx, request)
+			return err
+		})
 		if err != nil {
 			retval <- err
 			return
@@ -62,10 +66,8 @@ func DescribeInstanceTypes(
 	retval <- nil
 }
 
-func ListInstanceTypesByLocation(region string) ([]string, error) {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
+func ListInstanceTypesByLocation(ctx context.Context, region string) ([]string, error) {
+	sess := resources.NewSession(region)
 	svc := ec2.New(sess)
 	var pageSize int64 = 100
 	request := &ec2.DescribeInstanceTypeOfferingsInput{
@@ -82,7 +84,12 @@ func ListInstanceTypesByLocation(region string) ([]string, error) {
 	}
 	ret := make([]string, 0)
 	for {
-		offeringsObj, err := svc.DescribeInstanceTypeOfferings(request)
+		var offeringsObj *ec2.DescribeInstanceTypeOfferingsOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			offeringsObj, err = svc.DescribeInstanceTypeOfferingsWithContext(ctx, request)
+			return err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("could not get offerings by location: %v", err)
 		}
@@ -168,6 +175,21 @@ func MakeDto(typeInfo ec2.InstanceTypeInfo) (*resources.InstanceType, error) {
 	if *typeInfo.InstanceStorageSupported && typeInfo.InstanceStorageInfo != nil {
 		instanceStorage = uint64(*typeInfo.InstanceStorageInfo.TotalSizeInGB)
 		instanceStorageType = *typeInfo.InstanceStorageInfo.Disks[0].Type
+		nvme := typeInfo.InstanceStorageInfo.NvmeSupport
+		if instanceStorageType == "ssd" && nvme != nil && *nvme != "unsupported" {
+			instanceStorageType = "nvme ssd"
+		}
+	}
+	// Some instance types support both i386 and x86_64, so anything
+	// that isn't arm64 counts as x86_64.
+	var arch string
+	if typeInfo.ProcessorInfo != nil {
+		arch = "x86_64"
+		for _, a := range typeInfo.ProcessorInfo.SupportedArchitectures {
+			if *a == "arm64" {
+				arch = "arm64"
+			}
+		}
 	}
 
 	return &resources.InstanceType{
@@ -181,5 +203,6 @@ func MakeDto(typeInfo ec2.InstanceTypeInfo) (*resources.InstanceType, error) {
 		InstanceStorageMaxSizeGb: instanceStorage,
 		InstanceStorageType:      instanceStorageType,
 		GpuCount:                 gpuCount,
+		CpuArchitecture:          arch,
 	}, nil
 }
@@ -45,3 +45,42 @@ func TestMakeDto(t *testing.T) {
 			wantedDto, *it, diff)
 	}
 }
+
+func TestMakeDtoArmNvme(t *testing.T) {
+	typeInfo := ec2.InstanceTypeInfo{
+		InstanceType: aws.String("m6gd.large"),
+		MemoryInfo: &ec2.MemoryInfo{
+			SizeInMiB: aws.Int64(8192),
+		},
+		NetworkInfo: &ec2.NetworkInfo{
+			NetworkPerformance: aws.String("Up to 10 Gigabit"),
+		},
+		VCpuInfo: &ec2.VCpuInfo{
+			DefaultVCpus: aws.Int64(2),
+		},
+		ProcessorInfo: &ec2.ProcessorInfo{
+			SupportedArchitectures: []*string{aws.String("arm64")},
+		},
+		InstanceStorageSupported: aws.Bool(true),
+		InstanceStorageInfo: &ec2.InstanceStorageInfo{
+			TotalSizeInGB: aws.Int64(118),
+			NvmeSupport:   aws.String("required"),
+			Disks: []*ec2.DiskInfo{
+				&ec2.DiskInfo{
+					Type: aws.String("ssd"),
+				},
+			},
+		},
+	}
+	it, err := MakeDto(typeInfo)
+	if err != nil {
+		t.Errorf("make dto failed: %v", err)
+	}
+	if it.CpuArchitecture != "arm64" {
+		t.Errorf("expected cpu architecture arm64 but got %s", it.CpuArchitecture)
+	}
+	if it.InstanceStorageType != "nvme ssd" {
+		t.Errorf("expected instance storage type nvme ssd but got %s",
+			it.InstanceStorageType)
+	}
+}
@@ -0,0 +1,41 @@
+package ec2
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"nephomancy/aws/resources"
+	"nephomancy/common/fetch"
+	"time"
+)
+
+// Returns the spot price history of all instance types in the region
+// between since and until. Each price is in effect from its timestamp
+// until the next one for the same instance type, zone and product.
+func DescribeSpotPriceHistory(ctx context.Context, region string, since time.Time,
+	until time.Time) ([]*ec2.SpotPrice, error) {
+	svc := ec2.New(resources.NewSession(region))
+	request := &ec2.DescribeSpotPriceHistoryInput{
+		StartTime:  aws.Time(since),
+		EndTime:    aws.Time(until),
+		MaxResults: aws.Int64(1000),
+	}
+	ret := make([]*ec2.SpotPrice, 0)
+	for {
+		var page *ec2.DescribeSpotPriceHistoryOutput
+		err := fetch.Retry(ctx, resources.Transient, func(ctx context.Context) error {
+			var err error
+			page, err = svc.DescribeSpotPriceHistoryWithContext(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, page.SpotPriceHistory...)
+		// The last page has an empty token rather than none.
+		if page.NextToken == nil || *page.NextToken == "" {
+			return ret, nil
+		}
+		request.NextToken = page.NextToken
+	}
+}
@@ -0,0 +1,83 @@
+// A fake of the AWS APIs that init, list and assets use: EC2, Elastic
+// Load Balancing, Price List and Lightsail. Point the fetchers at it by
+// setting resources.Endpoint to the server's URL.
+//
+// EC2 fixtures are named after the action, e.g.
+// ec2/DescribeInstanceTypes.xml. Elastic Load Balancing fixtures are in
+// elbv2 and, for classic load balancers, elb. Offerings are per location, e.g.
+// ec2/DescribeInstanceTypeOfferings/us-east-1.xml, with _default.xml
+// for all other locations. Later pages have the next token appended
+// after an @. Fixtures for the JSON APIs are named after the target,
+// e.g. AWSPriceListService.DescribeServices.json. Price list files are
+// at their path, e.g. offers/v1.0/aws/AmazonEC2/current/index.json and
+// savingsPlan/v1.0/aws/AWSComputeSavingsPlan/current/region_index.json.
+package fake
+
+import (
+	"fmt"
+	common "nephomancy/common/fake"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Starts a server replaying the fixtures. Close it when done.
+func NewServer() *common.Server {
+	return common.NewServer(fixtureDir(), route)
+}
+
+func route(r *http.Request) ([]string, error) {
+	if r.Method == http.MethodGet && (strings.HasPrefix(r.URL.Path, "/offers/") ||
+		strings.HasPrefix(r.URL.Path, "/savingsPlan/")) {
+		return []string{strings.TrimPrefix(r.URL.Path, "/")}, nil
+	}
+	if target := r.Header.Get("X-Amz-Target"); target != "" {
+		return []string{target + ".json"}, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	action := r.PostForm.Get("Action")
+	if action == "" {
+		return nil, fmt.Errorf("request has neither X-Amz-Target nor Action")
+	}
+	dir := "ec2"
+	switch r.PostForm.Get("Version") {
+	case "2015-12-01":
+		dir = "elbv2"
+	case "2012-06-01":
+		dir = "elb"
+	}
+	name := dir + "/" + action
+	location := locationFilter(r.PostForm)
+	if location != "" {
+		name += "/" + location
+	}
+	if token := r.PostForm.Get("NextToken"); token != "" {
+		name += "@" + token
+	}
+	names := []string{name + ".xml"}
+	if location != "" {
+		names = append(names, dir+"/"+action+"/_default.xml")
+	}
+	return names, nil
+}
+
+// Returns the value of the location filter, e.g. for
+// Filter.1.Name=location&Filter.1.Value.1=us-east-1.
+func locationFilter(form url.Values) string {
+	for key, values := range form {
+		if strings.HasPrefix(key, "Filter.") && strings.HasSuffix(key, ".Name") &&
+			len(values) == 1 && values[0] == "location" {
+			return form.Get(strings.TrimSuffix(key, "Name") + "Value.1")
+		}
+	}
+	return ""
+}
+
+func fixtureDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata")
+}
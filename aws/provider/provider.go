@@ -1,5 +1,3 @@
-// This is currently just a stub.
-
 package provider
 
 import (
@@ -7,22 +5,30 @@ import (
 	"fmt"
 	"log"
 	"nephomancy/aws/cache"
+	"nephomancy/common/metadata"
 	"nephomancy/common/registry"
 	"nephomancy/common/resources"
+	"nephomancy/common/snapshot"
+	"nephomancy/common/staging"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
 // AwsProvider implements registry.provider
 type AwsProvider struct {
 	DbHandle *sql.DB
+	// directory with the price cache and its snapshots
+	dir string
 }
 
 var instance registry.Provider = &AwsProvider{}
 
 const name = "aws"
 
+const dbName = "price-cache.db"
+
 func (a *AwsProvider) FillInProviderDetails(p *resources.Project) error {
 	if a.DbHandle == nil {
 		return fmt.Errorf("Provider has not been initialized.\n")
@@ -30,11 +36,96 @@ func (a *AwsProvider) FillInProviderDetails(p *resources.Project) error {
 	return cache.FillInProviderDetails(a.DbHandle, p)
 }
 
+func (a *AwsProvider) Regions(loc *resources.Location) []string {
+	return cache.PlacementRegions(loc)
+}
+
+func (a *AwsProvider) FillInProviderDetailsIn(p *resources.Project, region string) error {
+	if a.DbHandle == nil {
+		return fmt.Errorf("Provider has not been initialized.\n")
+	}
+	return cache.FillInProviderDetailsIn(a.DbHandle, p, region)
+}
+
+func (a *AwsProvider) ListMachineTypes(spec *resources.MachineType, loc *resources.Location) (
+	[]registry.MachineType, error) {
+	if a.DbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized.\n")
+	}
+	return cache.ListInstanceTypes(a.DbHandle, spec, "", loc)
+}
+
 func (a *AwsProvider) GetCost(p *resources.Project) ([][]string, error) {
 	if a.DbHandle == nil {
 		return nil, fmt.Errorf("Provider has not been initialized.\n")
 	}
-	return nil, nil
+	return cache.GetCost(a.DbHandle, p)
+}
+
+func (a *AwsProvider) UseSnapshot(date string) error {
+	if a.DbHandle == nil {
+		return fmt.Errorf("Provider has not been initialized\n")
+	}
+	db, err := snapshot.Open(a.dir, date, cache.LatestSchemaVersion())
+	if err != nil {
+		return err
+	}
+	if err = a.DbHandle.Close(); err != nil {
+		log.Printf("Failed to close price cache: %v\n", err)
+	}
+	a.DbHandle = db
+	return nil
+}
+
+// Switches the provider to a staging copy of its price cache for a
+// refresh, see common/staging. Committing the copy replaces the cache;
+// aborting it leaves the cache as it was.
+func (a *AwsProvider) BeginRefresh() (*staging.Cache, error) {
+	if a.DbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	stage, err := staging.Begin(filepath.Join(a.dir, dbName))
+	if err != nil {
+		return nil, err
+	}
+	if err = cache.CreateOrUpdateDatabase(stage.DB); err != nil {
+		stage.Abort()
+		return nil, err
+	}
+	if err = a.DbHandle.Close(); err != nil {
+		log.Printf("Failed to close price cache: %v\n", err)
+	}
+	a.DbHandle = stage.DB
+	return stage, nil
+}
+
+// Saves a dated copy of the price cache. Returns the snapshot's filename.
+func (a *AwsProvider) SaveSnapshot(t time.Time) (string, error) {
+	if a.DbHandle == nil {
+		return "", fmt.Errorf("Provider has not been initialized\n")
+	}
+	return snapshot.Save(a.DbHandle, a.dir, t)
+}
+
+func (a *AwsProvider) GetPriceChanges(from string, to string) ([][]string, error) {
+	if a.dir == "" {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return snapshot.Changes(a.dir, from, to, cache.LatestSchemaVersion(), cache.ListRates)
+}
+
+func (a *AwsProvider) GetCacheMetadata() (*metadata.Metadata, error) {
+	if a.DbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return metadata.Read(a.DbHandle)
+}
+
+func (a *AwsProvider) GetSchemaVersion() (int, int, error) {
+	if a.DbHandle == nil {
+		return 0, 0, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return cache.SchemaVersion(a.DbHandle)
 }
 
 func init() {
@@ -47,7 +138,7 @@ func (p *AwsProvider) Initialize(datadir string) error {
 	if err != nil {
 		return err
 	}
-	dbfile := filepath.Join(mydir, "price-cache.db")
+	dbfile := filepath.Join(mydir, dbName)
 	_, err = os.OpenFile(dbfile, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return err
@@ -59,11 +150,35 @@ func (p *AwsProvider) Initialize(datadir string) error {
 	if db == nil {
 		return fmt.Errorf("Failed to open a database file at %s\n", dbfile)
 	}
+	if err = cache.CreateOrUpdateDatabase(db); err != nil {
+		return err
+	}
 	p.DbHandle = db
+	p.dir = mydir
 	runtime.SetFinalizer(p, finalizer)
 	return nil
 }
 
+func (p *AwsProvider) InitializeReadOnly(datadir string) error {
+	mydir := filepath.Join(datadir, name)
+	dbfile := filepath.Join(mydir, dbName)
+	db, err := sql.Open("sqlite3", "file:"+dbfile+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fmt.Errorf("Failed to open a database file at %s\n", dbfile)
+	}
+	p.DbHandle = db
+	p.dir = mydir
+	runtime.SetFinalizer(p, finalizer)
+	return nil
+}
+
+func (p *AwsProvider) CacheFile() string {
+	return dbName
+}
+
 func finalizer(p *AwsProvider) {
 	if p.DbHandle != nil {
 		if err := p.DbHandle.Close(); err != nil {
@@ -37,13 +37,20 @@ type Ec2VM struct {
 	Zone         string `protobuf:"bytes,3,opt,name=zone,proto3" json:"zone,omitempty"`                                     // Wavelength Zone or local Zone, for a zonal instance
 	// TODO: maybe combine term_type, purchase_option, offering_class, and tenancy
 	// into one proto?
-	TermType       string `protobuf:"bytes,4,opt,name=term_type,json=termType,proto3" json:"term_type,omitempty"`                   // OnDemand or Reserved
-	PurchaseOption string `protobuf:"bytes,5,opt,name=purchase_option,json=purchaseOption,proto3" json:"purchase_option,omitempty"` // only for TermType=Reserved: "No Upfront", "Partial Upfront" or "All Upfront"
+	TermType       string `protobuf:"bytes,4,opt,name=term_type,json=termType,proto3" json:"term_type,omitempty"`                   // OnDemand, Reserved, SavingsPlan or Spot
+	PurchaseOption string `protobuf:"bytes,5,opt,name=purchase_option,json=purchaseOption,proto3" json:"purchase_option,omitempty"` // only for TermType=Reserved or SavingsPlan: "No Upfront", "Partial Upfront" or "All Upfront"
 	OfferingClass  string `protobuf:"bytes,6,opt,name=offering_class,json=offeringClass,proto3" json:"offering_class,omitempty"`    // Only for TermType=Reserved: "convertible" or "standard"
 	Tenancy        string `protobuf:"bytes,7,opt,name=tenancy,proto3" json:"tenancy,omitempty"`                                     // Dedicated, Shared, Host, NA, Reserved
 	Os             string `protobuf:"bytes,8,opt,name=os,proto3" json:"os,omitempty"`                                               // Linux, SUSE, Windows, RHEL, NA.
 	// No License required, Bring your own license, NA -- not sure if relevant here.
-	LicenseModel string `protobuf:"bytes,9,opt,name=license_model,json=licenseModel,proto3" json:"license_model,omitempty"`
+	LicenseModel        string `protobuf:"bytes,9,opt,name=license_model,json=licenseModel,proto3" json:"license_model,omitempty"`
+	LeaseContractLength string `protobuf:"bytes,10,opt,name=lease_contract_length,json=leaseContractLength,proto3" json:"lease_contract_length,omitempty"` // Only for TermType=Reserved or SavingsPlan: "1yr" or "3yr"
+	// Only for TermType=SavingsPlan: "Compute" or "EC2 Instance". An EC2
+	// Instance Savings Plan is for the instance family in the region.
+	SavingsPlanType string `protobuf:"bytes,11,opt,name=savings_plan_type,json=savingsPlanType,proto3" json:"savings_plan_type,omitempty"`
+	// Only for TermType=Spot: the percentile of the spot price history to
+	// price at, 90 if unset. See SpotPrices in the cache for the choices.
+	SpotPercentile uint32 `protobuf:"varint,12,opt,name=spot_percentile,json=spotPercentile,proto3" json:"spot_percentile,omitempty"`
 }
 
 func (x *Ec2VM) Reset() {
@@ -141,15 +148,41 @@ func (x *Ec2VM) GetLicenseModel() string {
 	return ""
 }
 
+func (x *Ec2VM) GetLeaseContractLength() string {
+	if x != nil {
+		return x.LeaseContractLength
+	}
+	return ""
+}
+
+func (x *Ec2VM) GetSavingsPlanType() string {
+	if x != nil {
+		return x.SavingsPlanType
+	}
+	return ""
+}
+
+func (x *Ec2VM) GetSpotPercentile() uint32 {
+	if x != nil {
+		return x.SpotPercentile
+	}
+	return 0
+}
+
 type Ec2Disk struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	VolumeType string `protobuf:"bytes,1,opt,name=volume_type,json=volumeType,proto3" json:"volume_type,omitempty"` // General Purpose, Throughput Optimized HDD, Cold HDD.
+	VolumeType string `protobuf:"bytes,1,opt,name=volume_type,json=volumeType,proto3" json:"volume_type,omitempty"` // The API name: gp2, gp3, io1, io2, st1, sc1 or standard.
 	// The volume type has a maximum size, this is the actual size. Note the minimal
 	// size tends to be something like 1GiB.
 	ActualSizeGb uint64 `protobuf:"varint,2,opt,name=actual_size_gb,json=actualSizeGb,proto3" json:"actual_size_gb,omitempty"`
+	Region       string `protobuf:"bytes,3,opt,name=region,proto3" json:"region,omitempty"`
+	// Provisioned IOPS and throughput in MiB/s, for the volume types that
+	// have them. Zero means the volume type's baseline.
+	Iops       uint32 `protobuf:"varint,4,opt,name=iops,proto3" json:"iops,omitempty"`
+	Throughput uint32 `protobuf:"varint,5,opt,name=throughput,proto3" json:"throughput,omitempty"`
 }
 
 func (x *Ec2Disk) Reset() {
@@ -198,10 +231,33 @@ func (x *Ec2Disk) GetActualSizeGb() uint64 {
 	return 0
 }
 
+func (x *Ec2Disk) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *Ec2Disk) GetIops() uint32 {
+	if x != nil {
+		return x.Iops
+	}
+	return 0
+}
+
+func (x *Ec2Disk) GetThroughput() uint32 {
+	if x != nil {
+		return x.Throughput
+	}
+	return 0
+}
+
 type Ec2Network struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	VpcId string `protobuf:"bytes,1,opt,name=vpc_id,json=vpcId,proto3" json:"vpc_id,omitempty"`
 }
 
 func (x *Ec2Network) Reset() {
@@ -236,11 +292,123 @@ func (*Ec2Network) Descriptor() ([]byte, []int) {
 	return file_awsec2_model_proto_rawDescGZIP(), []int{2}
 }
 
+func (x *Ec2Network) GetVpcId() string {
+	if x != nil {
+		return x.VpcId
+	}
+	return ""
+}
+
+type Ec2Subnetwork struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Region string `protobuf:"bytes,1,opt,name=region,proto3" json:"region,omitempty"`
+}
+
+func (x *Ec2Subnetwork) Reset() {
+	*x = Ec2Subnetwork{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_awsec2_model_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ec2Subnetwork) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ec2Subnetwork) ProtoMessage() {}
+
+func (x *Ec2Subnetwork) ProtoReflect() protoreflect.Message {
+	mi := &file_awsec2_model_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ec2Subnetwork.ProtoReflect.Descriptor instead.
+func (*Ec2Subnetwork) Descriptor() ([]byte, []int) {
+	return file_awsec2_model_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Ec2Subnetwork) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+type Ec2Gateway struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The product family: NAT Gateway, Load Balancer (classic),
+	// Load Balancer-Application, Load Balancer-Network or
+	// Load Balancer-Gateway.
+	ProductFamily string `protobuf:"bytes,1,opt,name=product_family,json=productFamily,proto3" json:"product_family,omitempty"`
+	Name          string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (x *Ec2Gateway) Reset() {
+	*x = Ec2Gateway{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_awsec2_model_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ec2Gateway) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ec2Gateway) ProtoMessage() {}
+
+func (x *Ec2Gateway) ProtoReflect() protoreflect.Message {
+	mi := &file_awsec2_model_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ec2Gateway.ProtoReflect.Descriptor instead.
+func (*Ec2Gateway) Descriptor() ([]byte, []int) {
+	return file_awsec2_model_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Ec2Gateway) GetProductFamily() string {
+	if x != nil {
+		return x.ProductFamily
+	}
+	return ""
+}
+
+func (x *Ec2Gateway) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
 var File_awsec2_model_proto protoreflect.FileDescriptor
 
 var file_awsec2_model_proto_rawDesc = []byte{
 	0x0a, 0x12, 0x61, 0x77, 0x73, 0x65, 0x63, 0x32, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x2e, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x22, 0x94, 0x02, 0x0a, 0x05,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x05, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x22, 0x9d, 0x03, 0x0a, 0x05,
 	0x45, 0x63, 0x32, 0x56, 0x4d, 0x12, 0x23, 0x0a, 0x0d, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63,
 	0x65, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x69, 0x6e,
 	0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65,
@@ -258,14 +426,36 @@ var file_awsec2_model_proto_rawDesc = []byte{
 	0x02, 0x6f, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x6f, 0x73, 0x12, 0x23, 0x0a,
 	0x0d, 0x6c, 0x69, 0x63, 0x65, 0x6e, 0x73, 0x65, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x18, 0x09,
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x6c, 0x69, 0x63, 0x65, 0x6e, 0x73, 0x65, 0x4d, 0x6f, 0x64,
-	0x65, 0x6c, 0x22, 0x50, 0x0a, 0x07, 0x45, 0x63, 0x32, 0x44, 0x69, 0x73, 0x6b, 0x12, 0x1f, 0x0a,
-	0x0b, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x0a, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x24,
-	0x0a, 0x0e, 0x61, 0x63, 0x74, 0x75, 0x61, 0x6c, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x67, 0x62,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x61, 0x63, 0x74, 0x75, 0x61, 0x6c, 0x53, 0x69,
-	0x7a, 0x65, 0x47, 0x62, 0x22, 0x0c, 0x0a, 0x0a, 0x45, 0x63, 0x32, 0x4e, 0x65, 0x74, 0x77, 0x6f,
-	0x72, 0x6b, 0x42, 0x0d, 0x5a, 0x0b, 0x2e, 0x3b, 0x72, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65,
-	0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x65, 0x6c, 0x12, 0x32, 0x0a, 0x15, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x63, 0x6f, 0x6e, 0x74,
+	0x72, 0x61, 0x63, 0x74, 0x5f, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x13, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74,
+	0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x12, 0x2a, 0x0a, 0x11, 0x73, 0x61, 0x76, 0x69, 0x6e, 0x67,
+	0x73, 0x5f, 0x70, 0x6c, 0x61, 0x6e, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0f, 0x73, 0x61, 0x76, 0x69, 0x6e, 0x67, 0x73, 0x50, 0x6c, 0x61, 0x6e, 0x54, 0x79,
+	0x70, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x73, 0x70, 0x6f, 0x74, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65,
+	0x6e, 0x74, 0x69, 0x6c, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x73, 0x70, 0x6f,
+	0x74, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x69, 0x6c, 0x65, 0x22, 0x9c, 0x01, 0x0a, 0x07,
+	0x45, 0x63, 0x32, 0x44, 0x69, 0x73, 0x6b, 0x12, 0x1f, 0x0a, 0x0b, 0x76, 0x6f, 0x6c, 0x75, 0x6d,
+	0x65, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x76, 0x6f,
+	0x6c, 0x75, 0x6d, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x24, 0x0a, 0x0e, 0x61, 0x63, 0x74, 0x75,
+	0x61, 0x6c, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x67, 0x62, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x0c, 0x61, 0x63, 0x74, 0x75, 0x61, 0x6c, 0x53, 0x69, 0x7a, 0x65, 0x47, 0x62, 0x12, 0x16,
+	0x0a, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x69, 0x6f, 0x70, 0x73, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x69, 0x6f, 0x70, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x74, 0x68,
+	0x72, 0x6f, 0x75, 0x67, 0x68, 0x70, 0x75, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a,
+	0x74, 0x68, 0x72, 0x6f, 0x75, 0x67, 0x68, 0x70, 0x75, 0x74, 0x22, 0x23, 0x0a, 0x0a, 0x45, 0x63,
+	0x32, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x15, 0x0a, 0x06, 0x76, 0x70, 0x63, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x70, 0x63, 0x49, 0x64, 0x22,
+	0x27, 0x0a, 0x0d, 0x45, 0x63, 0x32, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b,
+	0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x22, 0x47, 0x0a, 0x0a, 0x45, 0x63, 0x32, 0x47,
+	0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x72, 0x6f, 0x64, 0x75, 0x63,
+	0x74, 0x5f, 0x66, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d,
+	0x70, 0x72, 0x6f, 0x64, 0x75, 0x63, 0x74, 0x46, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x12, 0x12, 0x0a,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d,
+	0x65, 0x42, 0x0d, 0x5a, 0x0b, 0x2e, 0x3b, 0x72, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x73,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -280,11 +470,13 @@ func file_awsec2_model_proto_rawDescGZIP() []byte {
 	return file_awsec2_model_proto_rawDescData
 }
 
-var file_awsec2_model_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_awsec2_model_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_awsec2_model_proto_goTypes = []interface{}{
-	(*Ec2VM)(nil),      // 0: model.Ec2VM
-	(*Ec2Disk)(nil),    // 1: model.Ec2Disk
-	(*Ec2Network)(nil), // 2: model.Ec2Network
+	(*Ec2VM)(nil),         // 0: model.Ec2VM
+	(*Ec2Disk)(nil),       // 1: model.Ec2Disk
+	(*Ec2Network)(nil),    // 2: model.Ec2Network
+	(*Ec2Subnetwork)(nil), // 3: model.Ec2Subnetwork
+	(*Ec2Gateway)(nil),    // 4: model.Ec2Gateway
 }
 var file_awsec2_model_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for method output_type
@@ -336,6 +528,30 @@ func file_awsec2_model_proto_init() {
 				return nil
 			}
 		}
+		file_awsec2_model_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ec2Subnetwork); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_awsec2_model_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ec2Gateway); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -343,7 +559,7 @@ func file_awsec2_model_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_awsec2_model_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
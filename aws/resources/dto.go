@@ -18,7 +18,10 @@ type InstanceType struct {
 	// This is only set when InstanceStorageSupported is true.
 	InstanceStorageMaxSizeGb uint64
 	// This tells you whether the instance storage is ssd or hdd.
+	// NVMe ssds are "nvme ssd".
 	InstanceStorageType string
+	// "arm64" or "x86_64".
+	CpuArchitecture string
 
 	ValidCores []uint32
 	GpuCount   uint32
@@ -30,10 +33,13 @@ type InstanceType struct {
 // family for the sku is something like "Provisioned Throughput"
 // or "System Operation" and the "Group" is something like "EBS
 // Throughput".
+// Several volume types have the same Name, they are told apart by
+// VolumeApiType.
 type VolumeType struct {
 	Name                       string
 	VolumeApiType              string
 	Media                      string
+	MinVolumeSizeGiB           uint32
 	MaxVolumeSizeGiB           uint32
 	MaxIOPSPerVolumeKiB        uint32
 	MaxThroughputPerVolumeMiBs uint32
@@ -43,36 +49,50 @@ type VolumeType struct {
 func StandardVolumeTypes() []VolumeType {
 	return []VolumeType{
 		VolumeType{
-			Name:                       "Provisioned IOPS",
-			VolumeApiType:              "io1",
+			Name:                       "General Purpose",
+			VolumeApiType:              "gp2",
 			Media:                      "SSD-backed",
+			MinVolumeSizeGiB:           uint32(1),
 			MaxVolumeSizeGiB:           uint32(16384),
-			MaxIOPSPerVolumeKiB:        uint32(64000),
+			MaxIOPSPerVolumeKiB:        uint32(16000),
+			MaxThroughputPerVolumeMiBs: uint32(250),
+			MultiAttach:                false,
+		},
+		VolumeType{
+			Name:                       "General Purpose",
+			VolumeApiType:              "gp3",
+			Media:                      "SSD-backed",
+			MinVolumeSizeGiB:           uint32(1),
+			MaxVolumeSizeGiB:           uint32(16384),
+			MaxIOPSPerVolumeKiB:        uint32(16000),
 			MaxThroughputPerVolumeMiBs: uint32(1000),
-			MultiAttach:                true,
+			MultiAttach:                false,
 		},
 		VolumeType{
 			Name:                       "Provisioned IOPS",
-			VolumeApiType:              "io2",
+			VolumeApiType:              "io1",
 			Media:                      "SSD-backed",
+			MinVolumeSizeGiB:           uint32(4),
 			MaxVolumeSizeGiB:           uint32(16384),
 			MaxIOPSPerVolumeKiB:        uint32(64000),
 			MaxThroughputPerVolumeMiBs: uint32(1000),
 			MultiAttach:                true,
 		},
 		VolumeType{
-			Name:                       "General Purpose",
+			Name:                       "Provisioned IOPS",
 			VolumeApiType:              "io2",
 			Media:                      "SSD-backed",
+			MinVolumeSizeGiB:           uint32(4),
 			MaxVolumeSizeGiB:           uint32(16384),
-			MaxIOPSPerVolumeKiB:        uint32(16000),
-			MaxThroughputPerVolumeMiBs: uint32(250),
-			MultiAttach:                false,
+			MaxIOPSPerVolumeKiB:        uint32(64000),
+			MaxThroughputPerVolumeMiBs: uint32(1000),
+			MultiAttach:                true,
 		},
 		VolumeType{
 			Name:                       "Throughput Optimized HDD",
 			VolumeApiType:              "st1",
 			Media:                      "HDD-backed",
+			MinVolumeSizeGiB:           uint32(125),
 			MaxVolumeSizeGiB:           uint32(16384),
 			MaxIOPSPerVolumeKiB:        uint32(500),
 			MaxThroughputPerVolumeMiBs: uint32(500),
@@ -82,10 +102,21 @@ func StandardVolumeTypes() []VolumeType {
 			Name:                       "Cold HDD",
 			VolumeApiType:              "sc1",
 			Media:                      "HDD-backed",
+			MinVolumeSizeGiB:           uint32(125),
 			MaxVolumeSizeGiB:           uint32(16384),
 			MaxIOPSPerVolumeKiB:        uint32(250),
 			MaxThroughputPerVolumeMiBs: uint32(250),
 			MultiAttach:                false,
 		},
+		VolumeType{
+			Name:                       "Magnetic",
+			VolumeApiType:              "standard",
+			Media:                      "Magnetic",
+			MinVolumeSizeGiB:           uint32(1),
+			MaxVolumeSizeGiB:           uint32(1024),
+			MaxIOPSPerVolumeKiB:        uint32(200),
+			MaxThroughputPerVolumeMiBs: uint32(90),
+			MultiAttach:                false,
+		},
 	}
 }
@@ -1,21 +1,24 @@
 package resources
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/pricing"
+	"nephomancy/common/fetch"
 )
 
-func ListServices() ([]string, error) {
+func ListServices(ctx context.Context) ([]string, error) {
 	// pricing endpoints only exist in us-east-1 and ap-south-1
-	sess := session.Must(session.NewSession(
-		&aws.Config{
-			Region: aws.String("us-east-1"),
-		}))
+	sess := NewSession("us-east-1")
 	svc := pricing.New(sess)
-	services, err := svc.DescribeServices(&pricing.DescribeServicesInput{
-		ServiceCode: aws.String("AmazonEC2"),
+	var services *pricing.DescribeServicesOutput
+	err := fetch.Retry(ctx, Transient, func(ctx context.Context) error {
+		var err error
+		services, err = svc.DescribeServicesWithContext(ctx, &pricing.DescribeServicesInput{
+			ServiceCode: aws.String("AmazonEC2"),
+		})
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -1,22 +1,25 @@
 package resources
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/lightsail"
+	"nephomancy/common/fetch"
 )
 
-func ListRegions() ([]string, error) {
-	sess := session.Must(session.NewSession(
-		&aws.Config{
-			Region: aws.String("us-east-1"),
-		}))
+func ListRegions(ctx context.Context) ([]string, error) {
+	sess := NewSession("us-east-1")
 	svc := ec2.New(sess)
 	// This actually just gives you ids and endpoints.
-	regions, err := svc.DescribeRegions(nil)
+	var regions *ec2.DescribeRegionsOutput
+	err := fetch.Retry(ctx, Transient, func(ctx context.Context) error {
+		var err error
+		regions, err = svc.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -24,8 +27,13 @@ func ListRegions() ([]string, error) {
 	// Lightsail has pieces of the display names, but not for all regions
 	// (e.g. govcloud is missing).
 	ls := lightsail.New(sess)
-	lr, err := ls.GetRegions(&lightsail.GetRegionsInput{
-		IncludeAvailabilityZones: aws.Bool(true),
+	var lr *lightsail.GetRegionsOutput
+	err = fetch.Retry(ctx, Transient, func(ctx context.Context) error {
+		var err error
+		lr, err = ls.GetRegionsWithContext(ctx, &lightsail.GetRegionsInput{
+			IncludeAvailabilityZones: aws.Bool(true),
+		})
+		return err
 	})
 	if err != nil {
 		return nil, err
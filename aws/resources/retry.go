@@ -0,0 +1,18 @@
+package resources
+
+import (
+	"nephomancy/common/fetch"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Returns whether an error from an AWS API is worth retrying. Sessions
+// from NewSession don't retry, so that fetch.Retry does all the retrying.
+func Transient(err error) bool {
+	// The SDK reports a call that timed out as cancelled.
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
+		return true
+	}
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err) || fetch.Transient(err)
+}
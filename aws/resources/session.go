@@ -0,0 +1,27 @@
+package resources
+
+import (
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// If set, the AWS clients talk to this URL instead of the real APIs,
+// with fake credentials. For testing against aws/fake; can also be set
+// with NEPHOMANCY_AWS_ENDPOINT.
+var Endpoint = os.Getenv("NEPHOMANCY_AWS_ENDPOINT")
+
+// Returns a session for the region. Retries are left to fetch.Retry.
+func NewSession(region string) *session.Session {
+	config := &aws.Config{
+		Region:     aws.String(region),
+		MaxRetries: aws.Int(0),
+	}
+	if Endpoint != "" {
+		config.Endpoint = aws.String(Endpoint)
+		config.Credentials = credentials.NewStaticCredentials("fake", "fake", "")
+	}
+	return session.Must(session.NewSession(config))
+}
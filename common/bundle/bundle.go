@@ -0,0 +1,210 @@
+// Portable bundles of provider price caches.
+//
+// A bundle is a gzipped tar archive. Its first entry is manifest.json,
+// which describes the bundle; all other entries are the files of a
+// provider's data directory, stored as <provider>/<path>.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The bundle format version written by Export. Import refuses bundles
+// with a newer version.
+const FormatVersion = 1
+
+const manifestName = "manifest.json"
+
+type Manifest struct {
+	Version int `json:"version"`
+	// When the bundle was created, in RFC 3339 format.
+	Created   string          `json:"created"`
+	Providers []ProviderCache `json:"providers"`
+}
+
+type ProviderCache struct {
+	Name string `json:"name"`
+	// Publication date (YYYY-MM-DD) of the prices in the cache, if known.
+	SourceDate string `json:"sourceDate,omitempty"`
+	// Files relative to the provider's data directory.
+	Files []string `json:"files"`
+}
+
+// Writes a bundle with the given files of each provider, read from
+// datadir/<name>. Returns the manifest.
+func Export(w io.Writer, datadir string, providers []ProviderCache, created time.Time) (*Manifest, error) {
+	m := &Manifest{
+		Version:   FormatVersion,
+		Created:   created.UTC().Format(time.RFC3339),
+		Providers: providers,
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	hdr := &tar.Header{
+		Name:    manifestName,
+		Mode:    0666,
+		Size:    int64(len(manifest)),
+		ModTime: created,
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err = tw.Write(manifest); err != nil {
+		return nil, err
+	}
+	for _, p := range m.Providers {
+		for _, f := range p.Files {
+			if err = addFile(tw, filepath.Join(datadir, p.Name, filepath.FromSlash(f)),
+				path.Join(p.Name, f)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return m, gz.Close()
+}
+
+func addFile(tw *tar.Writer, fname string, name string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0666,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Reads a bundle and unpacks the provider caches in it into datadir,
+// replacing existing files of the same name. Only caches of the known
+// providers are accepted. The files are unpacked into a temporary
+// directory first and only moved into place once the whole bundle has
+// been read, so a broken bundle leaves datadir as it was. Returns the
+// manifest.
+func Import(r io.Reader, datadir string, known []string) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %v", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("not a nephomancy cache bundle: first entry is %s", hdr.Name)
+	}
+	var m Manifest
+	if err = json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, fmt.Errorf("bad manifest: %v", err)
+	}
+	if m.Version > FormatVersion || m.Version < 1 {
+		return nil, fmt.Errorf("unsupported bundle version %d, this version of nephomancy supports up to %d",
+			m.Version, FormatVersion)
+	}
+	expected := make(map[string]bool)
+	for _, p := range m.Providers {
+		if !isKnown(p.Name, known) {
+			return nil, fmt.Errorf("bundle has a cache for unknown provider %q", p.Name)
+		}
+		for _, f := range p.Files {
+			expected[path.Join(p.Name, f)] = true
+		}
+	}
+	if err = os.MkdirAll(datadir, 0777); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir(datadir, ".import")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %v", err)
+		}
+		// Only unpack what the manifest lists, which also keeps
+		// entries like ../../etc/passwd out.
+		if !expected[hdr.Name] || path.Clean(hdr.Name) != hdr.Name ||
+			strings.HasPrefix(hdr.Name, "../") || path.IsAbs(hdr.Name) {
+			return nil, fmt.Errorf("unexpected entry %s in bundle", hdr.Name)
+		}
+		if err = extractFile(tr, filepath.Join(tmp, filepath.FromSlash(hdr.Name))); err != nil {
+			return nil, err
+		}
+		delete(expected, hdr.Name)
+	}
+	if len(expected) > 0 {
+		return nil, fmt.Errorf("bundle is missing %d files listed in its manifest", len(expected))
+	}
+	for _, p := range m.Providers {
+		for _, f := range p.Files {
+			name := filepath.FromSlash(path.Join(p.Name, f))
+			dst := filepath.Join(datadir, name)
+			if err = os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+				return nil, err
+			}
+			if err = os.Rename(filepath.Join(tmp, name), dst); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &m, nil
+}
+
+func isKnown(name string, known []string) bool {
+	for _, k := range known {
+		if name == k {
+			return true
+		}
+	}
+	return false
+}
+
+func extractFile(r io.Reader, fname string) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,153 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func writeFile(t *testing.T, fname string, content string) {
+	if err := os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	writeFile(t, filepath.Join(src, "dcs", "price-cache.db"), "dcs prices")
+	writeFile(t, filepath.Join(src, "dcs", "snapshots", "2021-01-15.db"), "old dcs prices")
+	writeFile(t, filepath.Join(src, "aws", "price-cache.db"), "aws prices")
+
+	var buf bytes.Buffer
+	created := time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)
+	_, err = Export(&buf, src, []ProviderCache{
+		ProviderCache{Name: "dcs", SourceDate: "2020-11-27", Files: []string{"price-cache.db"}},
+	}, created)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	dst, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	m, err := Import(&buf, dst, []string{"aws", "dcs"})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	expected := &Manifest{
+		Version: FormatVersion,
+		Created: "2021-02-01T12:00:00Z",
+		Providers: []ProviderCache{
+			ProviderCache{
+				Name:       "dcs",
+				SourceDate: "2020-11-27",
+				Files:      []string{"price-cache.db"},
+			},
+		},
+	}
+	if diff := deep.Equal(m, expected); diff != nil {
+		t.Errorf("unexpected manifest: %v", diff)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "dcs", "price-cache.db"))
+	if err != nil || string(content) != "dcs prices" {
+		t.Errorf("cache not imported correctly: %q %v", content, err)
+	}
+	if _, err = os.Stat(filepath.Join(dst, "dcs", "snapshots")); !os.IsNotExist(err) {
+		t.Errorf("snapshots should not have been exported")
+	}
+	if _, err = os.Stat(filepath.Join(dst, "aws")); !os.IsNotExist(err) {
+		t.Errorf("aws cache should not have been exported")
+	}
+}
+
+// Writes a bundle with the entries, name and content, in order.
+func writeBundle(entries [][2]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		tw.WriteHeader(&tar.Header{Name: e[0], Mode: 0666, Size: int64(len(e[1]))})
+		tw.Write([]byte(e[1]))
+	}
+	tw.Close()
+	gz.Close()
+	return &buf
+}
+
+func TestImportRejectsUnlistedFiles(t *testing.T) {
+	buf := writeBundle([][2]string{
+		{manifestName, `{"version": 1, "providers": [{"name": "dcs", "files": ["price-cache.db"]}]}`},
+		{"../evil", "evil"},
+	})
+	dst, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if _, err = Import(buf, dst, []string{"dcs"}); err == nil {
+		t.Errorf("expected import of unlisted file to fail")
+	}
+}
+
+func TestImportRejectsUnknownProviders(t *testing.T) {
+	buf := writeBundle([][2]string{
+		{manifestName, `{"version": 1, "providers": [{"name": "azure", "files": ["price-cache.db"]}]}`},
+		{"azure/price-cache.db", "azure prices"},
+	})
+	dst, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if _, err = Import(buf, dst, []string{"dcs"}); err == nil {
+		t.Errorf("expected import of an unknown provider's cache to fail")
+	}
+	if _, err = os.Stat(filepath.Join(dst, "azure")); !os.IsNotExist(err) {
+		t.Errorf("nothing should have been unpacked for an unknown provider")
+	}
+}
+
+func TestFailedImportKeepsCaches(t *testing.T) {
+	dst, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	writeFile(t, filepath.Join(dst, "dcs", "price-cache.db"), "dcs prices")
+	writeFile(t, filepath.Join(dst, "aws", "price-cache.db"), "aws prices")
+	// The aws cache is listed but missing.
+	buf := writeBundle([][2]string{
+		{manifestName, `{"version": 1, "providers": [
+			{"name": "dcs", "files": ["price-cache.db"]},
+			{"name": "aws", "files": ["price-cache.db"]}]}`},
+		{"dcs/price-cache.db", "new dcs prices"},
+	})
+	if _, err = Import(buf, dst, []string{"aws", "dcs"}); err == nil {
+		t.Errorf("expected import of an incomplete bundle to fail")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "dcs", "price-cache.db"))
+	if err != nil || string(content) != "dcs prices" {
+		t.Errorf("failed import changed the dcs cache: %q %v", content, err)
+	}
+	files, err := ioutil.ReadDir(dst)
+	if err != nil || len(files) != 2 {
+		t.Errorf("expected only the aws and dcs directories after a failed import, got %v (%v)",
+			files, err)
+	}
+}
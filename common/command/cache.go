@@ -0,0 +1,272 @@
+package command
+
+import (
+	"fmt"
+	"log"
+	"nephomancy/common/bundle"
+	"nephomancy/common/registry"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	// The modules implementing providers have to be loaded
+	_ "nephomancy/aws/provider"
+	_ "nephomancy/dcs/provider"
+	_ "nephomancy/gcloud/provider"
+)
+
+const bundleDoc = `Filename of the cache bundle (a gzipped tar archive). Defaults to nephomancy-cache.tar.gz.`
+
+const exportProviderDoc = `Name of a cloud provider. If set, only this provider's cache is exported. By default, the caches of all providers that have one are exported.`
+
+const defaultBundle = "nephomancy-cache.tar.gz"
+
+type CacheExportCommand struct {
+	Command
+	bundleFile string
+}
+
+func (r *CacheExportCommand) Help() string {
+	helpText := fmt.Sprintf(`
+        Usage: nephomancy cache export [options]
+
+	Pack the price caches in $workingdir/.nephomancy/data into a bundle.
+
+	Building a price cache with "nephomancy <provider> init" requires
+	credentials and, for some providers, large downloads. Someone who
+	has them can export the caches, and others can use
+	"nephomancy cache import" to estimate costs offline.
+
+	Only each provider's price cache is exported, not its snapshots.
+	The bundle contains a manifest with the bundle format version, the
+	creation time, and the publication date of each provider's prices.
+
+        Options:
+          --workingdir=path  %s
+	  --provider=name %s
+	  --bundle=filename %s
+`, workingDirDoc, exportProviderDoc, bundleDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*CacheExportCommand) Synopsis() string {
+	return "Exports price caches to a bundle."
+}
+
+func (r *CacheExportCommand) Run(args []string) int {
+	fs := r.Command.DefaultFlagSet("cacheExport")
+	fs.StringVar(&r.bundleFile, "bundle", defaultBundle, "Cache bundle file.")
+	fs.Parse(args)
+
+	dd, err := r.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to set up data directory: %v\n", err)
+	}
+	names := make([]string, 0)
+	if r.provider != "" {
+		names = append(names, r.provider)
+	} else {
+		for name := range registry.Registry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	caches := make([]bundle.ProviderCache, 0, len(names))
+	for _, name := range names {
+		prov, err := registry.GetProvider(name)
+		if err != nil {
+			log.Fatalf("Failed to get provider %s: %v\n", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dd, name, prov.CacheFile())); os.IsNotExist(err) {
+			if r.provider != "" {
+				log.Fatalf("There is no cache for provider %s.\n", name)
+			}
+			continue
+		}
+		// Exporting must not change the cache, so it isn't migrated.
+		if err = prov.InitializeReadOnly(dd); err != nil {
+			log.Fatalf("Failed to open the cache of provider %s: %v\n", name, err)
+		}
+		sourceDate := ""
+		md, err := prov.GetCacheMetadata()
+		if err != nil {
+			log.Printf("Could not read cache metadata for %s: %v\n", name, err)
+		} else if md != nil {
+			sourceDate = md.PricingDate()
+		}
+		caches = append(caches, bundle.ProviderCache{
+			Name:       name,
+			SourceDate: sourceDate,
+			Files:      []string{prov.CacheFile()},
+		})
+	}
+	if len(caches) == 0 {
+		log.Fatalf("No price caches found in %s.\n", dd)
+	}
+	wd, err := r.WorkingDir()
+	if err != nil {
+		log.Fatalf("Bad working directory: %v\n", err)
+	}
+	fname := r.bundleFile
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(wd, fname)
+	}
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Fatalf("Failed to create bundle file: %v\n", err)
+	}
+	defer f.Close()
+	m, err := bundle.Export(f, dd, caches, time.Now())
+	if err != nil {
+		log.Fatalf("Failed to export caches: %v\n", err)
+	}
+	for _, p := range m.Providers {
+		fmt.Printf("Exported %s cache (%d files, prices from %s).\n",
+			p.Name, len(p.Files), sourceDateOrUnknown(p.SourceDate))
+	}
+	fmt.Printf("Wrote bundle to %s\n", fname)
+	return 0
+}
+
+type CacheImportCommand struct {
+	Command
+	bundleFile string
+}
+
+func (r *CacheImportCommand) Help() string {
+	helpText := fmt.Sprintf(`
+        Usage: nephomancy cache import [options]
+
+	Unpack a bundle created by "nephomancy cache export" into
+	$workingdir/.nephomancy/data.
+
+	Existing cache files of the providers in the bundle are replaced.
+
+        Options:
+          --workingdir=path  %s
+	  --bundle=filename %s
+`, workingDirDoc, bundleDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*CacheImportCommand) Synopsis() string {
+	return "Imports price caches from a bundle."
+}
+
+func (r *CacheImportCommand) Run(args []string) int {
+	fs := r.Command.DefaultFlagSet("cacheImport")
+	fs.StringVar(&r.bundleFile, "bundle", defaultBundle, "Cache bundle file.")
+	fs.Parse(args)
+
+	dd, err := r.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to set up data directory: %v\n", err)
+	}
+	wd, err := r.WorkingDir()
+	if err != nil {
+		log.Fatalf("Bad working directory: %v\n", err)
+	}
+	fname := r.bundleFile
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(wd, fname)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatalf("Failed to open bundle file: %v\n", err)
+	}
+	defer f.Close()
+	known := make([]string, 0, len(registry.Registry))
+	for name := range registry.Registry {
+		known = append(known, name)
+	}
+	m, err := bundle.Import(f, dd, known)
+	if err != nil {
+		log.Fatalf("Failed to import bundle %s: %v\n", fname, err)
+	}
+	for _, p := range m.Providers {
+		fmt.Printf("Imported %s cache (%d files, prices from %s).\n",
+			p.Name, len(p.Files), sourceDateOrUnknown(p.SourceDate))
+	}
+	fmt.Printf("Bundle was created at %s.\n", m.Created)
+	return 0
+}
+
+func sourceDateOrUnknown(d string) string {
+	if d == "" {
+		return "an unknown date"
+	}
+	return d
+}
+
+type CacheStatusCommand struct {
+	Command
+}
+
+func (r *CacheStatusCommand) Help() string {
+	helpText := fmt.Sprintf(`
+        Usage: nephomancy cache status [options]
+
+	Show the state of the price caches in $workingdir/.nephomancy/data:
+	schema version, when each cache was refreshed and which date its
+	prices are from.
+
+	Opening a cache upgrades its schema to the latest version, so after
+	running this command all caches have the latest schema.
+
+        Options:
+          --workingdir=path  %s
+`, workingDirDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*CacheStatusCommand) Synopsis() string {
+	return "Shows schema version and age of price caches."
+}
+
+func (r *CacheStatusCommand) Run(args []string) int {
+	fs := r.Command.DefaultFlagSet("cacheStatus")
+	fs.Parse(args)
+
+	dd, err := r.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to set up data directory: %v\n", err)
+	}
+	names := make([]string, 0, len(registry.Registry))
+	for name := range registry.Registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dd, name)); os.IsNotExist(err) {
+			fmt.Printf("%s: no cache\n", name)
+			continue
+		}
+		prov, err := registry.GetProvider(name)
+		if err != nil {
+			log.Fatalf("Failed to get provider %s: %v\n", name, err)
+		}
+		if err = prov.Initialize(dd); err != nil {
+			log.Fatalf("Failed to initialize provider %s: %v\n", name, err)
+		}
+		version, latest, err := prov.GetSchemaVersion()
+		if err != nil {
+			log.Fatalf("Failed to get schema version for %s: %v\n", name, err)
+		}
+		fmt.Printf("%s: schema version %d (latest %d)\n", name, version, latest)
+		md, err := prov.GetCacheMetadata()
+		if err != nil {
+			log.Fatalf("Failed to read cache metadata for %s: %v\n", name, err)
+		}
+		if md == nil {
+			fmt.Printf("  never refreshed, run 'nephomancy %s init'\n", name)
+			continue
+		}
+		fmt.Printf("  refreshed %s from %s\n", md.Refreshed.UTC().Format(time.RFC3339), md.Source)
+		if md.SourceVersion != "" {
+			fmt.Printf("  source version %s\n", md.SourceVersion)
+		}
+		fmt.Printf("  prices from %s\n", md.PricingDate())
+	}
+	return 0
+}
@@ -1,16 +1,21 @@
 package command
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/kennygrant/sanitize"
 	"google.golang.org/protobuf/encoding/protojson"
 	"io/ioutil"
 	"log"
+	"nephomancy/common/fetch"
+	"nephomancy/common/metadata"
+	"nephomancy/common/registry"
 	"nephomancy/common/resources"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const workingDirDoc = `Path to working directory. Defaults to current working directory. A data directory called .nephomancy/data will be created underneath this working directory if it does not exist yet.`
@@ -21,6 +26,16 @@ const projectOutDoc = `Filename to save project information to. Project informat
 
 const costReportDoc = `Filename to save cost report (csv) to.`
 
+const maxAgeDoc = `Maximum age in days of a provider's price cache. Using an older cache prints a warning, or fails with --fail-if-stale. Defaults to 30.`
+
+const failIfStaleDoc = `Fail instead of printing a warning when a price cache is older than --max-age.`
+
+const TimeoutDoc = `Maximum time for fetching data from the cloud provider's APIs, e.g. 30m. Defaults to no limit. Ctrl-C also stops fetching; the price cache is left as it was.`
+
+const CallTimeoutDoc = `Maximum time for a single API call, e.g. 90s. Calls that time out are retried. Defaults to 2m.`
+
+const RetriesDoc = `How often to retry an API call that failed with a transient error, waiting longer before each retry. Defaults to 3.`
+
 const providerDoc = `Name of a cloud provider. A registry entry must exist for this provider. Supported providers are: gcloud, green.ch, custom.`
 
 type Command struct {
@@ -47,6 +62,15 @@ type Command struct {
 
 	// The provider to use.
 	provider string
+
+	// Price caches older than this many days are stale.
+	maxAgeDays int
+
+	// Whether to fail rather than warn about stale price caches.
+	failIfStale bool
+
+	// Maximum time for fetching data, 0 for no limit.
+	timeout time.Duration
 }
 
 // Create a flag set with flags common to most commands.
@@ -60,6 +84,44 @@ func (c *Command) DefaultFlagSet(cn string) *flag.FlagSet {
 	return f
 }
 
+// Add flags for commands that check the age of price caches.
+func (c *Command) addFreshnessFlags(f *flag.FlagSet) {
+	f.IntVar(&c.maxAgeDays, "max-age", 30, "Maximum age of price caches in days.")
+	f.BoolVar(&c.failIfStale, "fail-if-stale", false, "Fail if a price cache is older than max-age.")
+}
+
+// Checks whether an initialized provider's price cache is older than
+// the maximum age, and warns or fails if it is. Returns the cache metadata,
+// which may be nil.
+func (c *Command) checkFreshness(name string, prov registry.Provider) *metadata.Metadata {
+	m, err := prov.GetCacheMetadata()
+	if err != nil {
+		log.Fatalf("Failed to read cache metadata for provider %s: %v\n", name, err)
+	}
+	maxAge := time.Duration(c.maxAgeDays) * 24 * time.Hour
+	if err = metadata.CheckAge(m, maxAge, time.Now()); err != nil {
+		if c.failIfStale {
+			log.Fatalf("Price cache for provider %s is stale: %v\nPlease run 'nephomancy %s init'.\n",
+				name, err, name)
+		}
+		log.Printf("Warning: price cache for provider %s may be stale: %v\n", name, err)
+	}
+	return m
+}
+
+// Add flags for commands that fetch data from cloud provider APIs.
+func (c *Command) AddFetchFlags(f *flag.FlagSet) {
+	f.DurationVar(&c.timeout, "timeout", 0, "Maximum time for fetching data.")
+	f.DurationVar(&fetch.CallTimeout, "call-timeout", fetch.CallTimeout, "Maximum time for a single API call.")
+	f.IntVar(&fetch.Retries, "retries", fetch.Retries, "How often to retry transient API errors.")
+}
+
+// Returns a context for fetching data, which is cancelled on Ctrl-C and
+// after the timeout.
+func (c *Command) FetchContext() (context.Context, context.CancelFunc) {
+	return fetch.Context(c.timeout)
+}
+
 func (c *Command) WorkingDir() (string, error) {
 	if c.workingDir != "" {
 		return c.workingDir, nil
@@ -135,7 +197,8 @@ func (c *Command) CostReportFile(fallback string) (string, error) {
 	return filepath.Join(wd, outfile), nil
 }
 
-func (c *Command) loadProject() (*resources.Project, error) {
+// Reads the project from the --projectin file, or returns nil if there is none.
+func (c *Command) LoadProject() (*resources.Project, error) {
 	infile, err := c.ProjectInFile()
 	if err != nil {
 		return nil, err
@@ -154,9 +217,11 @@ func (c *Command) loadProject() (*resources.Project, error) {
 	return p, nil
 }
 
-func (c *Command) saveProject(p *resources.Project) error {
+// Saves the project to the --projectout file, or to a file named after
+// the project if there is none. Existing files are not overwritten.
+func (c *Command) SaveProject(p *resources.Project) error {
 	if p.Name == "" {
-		return fmt.Errorf("project %v has no name", *p)
+		return fmt.Errorf("project %v has no name", p)
 	}
 	fallback := sanitize.Name(fmt.Sprintf("%s.json", p.Name))
 	outfile, err := c.ProjectOutFile(fallback)
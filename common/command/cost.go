@@ -3,9 +3,11 @@ package command
 import (
 	"fmt"
 	"log"
+	"nephomancy/common/metadata"
 	"nephomancy/common/registry"
 	"nephomancy/common/resources"
 	"nephomancy/common/utils"
+	"runtime"
 	"strings"
 	// The modules implementing providers have to be loaded
 	_ "nephomancy/aws/provider"
@@ -13,8 +15,17 @@ import (
 	_ "nephomancy/gcloud/provider"
 )
 
+const groupByDoc = `Label key to group costs by, e.g. "team". Each group gets a subtotal. Costs for resources without the label end up in a group called "(unlabelled)".`
+
+const asOfDoc = `Date (YYYY-MM-DD). If set, costs are estimated using the newest price snapshot taken on or before this date instead of the current prices.`
+
+const workersDoc = `Maximum number of providers and resource sets to price concurrently. Defaults to the number of CPUs. Use 1 to price one after another.`
+
 type CostCommand struct {
 	Command
+	groupBy string
+	asOf    string
+	workers int
 }
 
 func (r *CostCommand) Help() string {
@@ -43,7 +54,12 @@ func (r *CostCommand) Help() string {
           --workingdir=path  %s
           --projectin=filename %s
           --costreport=filename %s
-`, workingDirDoc, projectInDoc, costReportDoc)
+          --group-by=key %s
+          --as-of=date %s
+          --max-age=days %s
+          --fail-if-stale %s
+          --workers=n %s
+`, workingDirDoc, projectInDoc, costReportDoc, groupByDoc, asOfDoc, maxAgeDoc, failIfStaleDoc, workersDoc)
 	return strings.TrimSpace(helpText)
 }
 
@@ -53,6 +69,10 @@ func (*CostCommand) Synopsis() string {
 
 func (r *CostCommand) Run(args []string) int {
 	fs := r.Command.DefaultFlagSet("cost")
+	fs.StringVar(&r.groupBy, "group-by", "", "Label key to group costs by.")
+	fs.StringVar(&r.asOf, "as-of", "", "Use prices as of this date (YYYY-MM-DD).")
+	fs.IntVar(&r.workers, "workers", runtime.NumCPU(), "Maximum number of concurrent price lookups.")
+	r.addFreshnessFlags(fs)
 	fs.Parse(args)
 
 	infile, err := r.ProjectInFile()
@@ -62,7 +82,7 @@ func (r *CostCommand) Run(args []string) int {
 	if infile == "" {
 		log.Fatalf("Please specify a project via the --projectin parameter.\n")
 	}
-	project, err := r.loadProject()
+	project, err := r.LoadProject()
 	if err != nil {
 		log.Fatalf("Failed to load project from file %s: %v\n",
 			infile, err)
@@ -77,14 +97,19 @@ func (r *CostCommand) Run(args []string) int {
 		log.Fatalf("Failed to create cost report file: %v\n", err)
 	}
 	reporter := utils.CostReporter{}
-	reporter.Init(f)
+	if r.groupBy == "" {
+		reporter.Init(f)
+	} else {
+		reporter.InitGrouped(f, r.groupBy)
+	}
 
-	providers := resources.GetProviderNames(*project)
+	providers := resources.GetProviderNames(project)
 	if len(providers) == 0 {
 		log.Fatalf("Project spec is missing provider details, please run 'nephomancy resources' first.\n")
 	}
-
-	for _, provName := range providers {
+	provs := make([]registry.Provider, len(providers))
+	metadatas := make([]*metadata.Metadata, len(providers))
+	for idx, provName := range providers {
 		prov, err := registry.GetProvider(provName)
 		if err != nil {
 			log.Fatalf("Failed to get provider %s: %v\n", provName, err)
@@ -93,22 +118,96 @@ func (r *CostCommand) Run(args []string) int {
 		if err != nil {
 			log.Fatalf("Failed to initialize provider %s: %v\n", provName, err)
 		}
-		// Maybe call a consistency checker here?
-		costs, err := prov.GetCost(project)
-		if err != nil {
-			log.Fatalf("Failed to get costs for provider %s: %v\n",
-				provName, err)
+		if r.asOf != "" {
+			if err = prov.UseSnapshot(r.asOf); err != nil {
+				log.Fatalf("Failed to use prices as of %s for provider %s: %v\n",
+					r.asOf, provName, err)
+			}
+			// Old prices were asked for, so don't complain about them.
+			if metadatas[idx], err = prov.GetCacheMetadata(); err != nil {
+				log.Fatalf("Failed to read cache metadata for provider %s: %v\n",
+					provName, err)
+			}
+		} else {
+			metadatas[idx] = r.checkFreshness(provName, prov)
 		}
+		provs[idx] = prov
+	}
 
-		for _, c := range costs {
+	pool := utils.NewPool(r.workers)
+	if r.groupBy == "" {
+		costs, err := getCosts(pool, provs, providers, []*resources.Project{project})
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		for _, c := range costs[0] {
 			if err = reporter.AddLine(c); err != nil {
 				log.Fatalf("Failed to report cost line %+v: %v\n",
 					c, err)
 			}
 		}
+	} else {
+		groups := resources.GroupByLabel(project, r.groupBy)
+		groupProjects := make([]*resources.Project, len(groups))
+		for i, group := range groups {
+			groupProjects[i] = group.Project
+		}
+		costs, err := getCosts(pool, provs, providers, groupProjects)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		for i, group := range groups {
+			for _, c := range costs[i] {
+				if err = reporter.AddGroupLine(group.Value, c); err != nil {
+					log.Fatalf("Failed to report cost line %+v: %v\n",
+						c, err)
+				}
+			}
+			if err = reporter.AddSubtotals(group.Value, project.Name, costs[i]); err != nil {
+				log.Fatalf("Failed to report subtotals for %s=%s: %v\n",
+					r.groupBy, group.Value, err)
+			}
+		}
+	}
+	for idx, provName := range providers {
+		if err = reporter.AddPricingDate(project.Name, provName, metadatas[idx]); err != nil {
+			log.Fatalf("Failed to report pricing date for provider %s: %v\n",
+				provName, err)
+		}
 	}
 	reporter.Flush()
 	log.Printf("Wrote costs to %s\n", f.Name())
 
 	return 0
 }
+
+// Gets the costs of each project from each provider, concurrently. The
+// providers share the pool for their own concurrency. The result has the
+// costs for each project, in provider order.
+func getCosts(pool *utils.Pool, provs []registry.Provider, names []string,
+	projects []*resources.Project) ([][][]string, error) {
+	byTask := make([][][]string, len(projects)*len(provs))
+	err := pool.ForEach(len(byTask), func(i int) error {
+		p := projects[i/len(provs)]
+		idx := i % len(provs)
+		// Maybe call a consistency checker here?
+		costs, err := registry.GetCost(provs[idx], p, pool)
+		if err != nil {
+			return fmt.Errorf("Failed to get costs for provider %s and project %s: %v",
+				names[idx], p.Name, err)
+		}
+		byTask[i] = costs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	costs := make([][][]string, len(projects))
+	for i := range projects {
+		costs[i] = make([][]string, 0)
+		for idx := range provs {
+			costs[i] = append(costs[i], byTask[i*len(provs)+idx]...)
+		}
+	}
+	return costs, nil
+}
@@ -0,0 +1,249 @@
+package command
+
+// Regression tests for cost reports. Each sample project is filled in and
+// priced against a cache built from checked-in fixtures, and the report is
+// compared with a golden file in testdata/golden. After an intended change
+// to fill-in or pricing, rewrite the golden files with
+//
+//	go test nephomancy/common/command -run TestGoldenCostReports -update
+//
+// and review the diff.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io/ioutil"
+	awscache "nephomancy/aws/cache"
+	awsfake "nephomancy/aws/fake"
+	awsprovider "nephomancy/aws/provider"
+	awsresources "nephomancy/aws/resources"
+	"nephomancy/common/registry"
+	"nephomancy/common/resources"
+	"nephomancy/common/utils"
+	dcscache "nephomancy/dcs/cache"
+	dcsprovider "nephomancy/dcs/provider"
+	"nephomancy/gcloud/assets"
+	gcloudcache "nephomancy/gcloud/cache"
+	gcloudfake "nephomancy/gcloud/fake"
+	gcloudprovider "nephomancy/gcloud/provider"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// Sample projects, relative to the repository root, by provider.
+var goldenSamples = map[string][]string{
+	"aws": {
+		"common/resources/testdata/nephomancy-sample-project.json",
+	},
+	"dcs": {
+		"dcs/provider/testdata/nephomancy-sample-project.json",
+		"dcs/provider/testdata/dcs-sample-project.json",
+	},
+	"gcloud": {
+		"gcloud/provider/testdata/nephomancy-sample-project.json",
+		"gcloud/provider/testdata/gcloud-sample-project.json",
+	},
+}
+
+// Builds each provider's price cache in datadir the way init does, but
+// from fixtures, and returns providers using them.
+var goldenCaches = map[string]func(t *testing.T, datadir string) registry.Provider{
+	"aws":    awsGoldenCache,
+	"dcs":    dcsGoldenCache,
+	"gcloud": gcloudGoldenCache,
+}
+
+func awsGoldenCache(t *testing.T, datadir string) registry.Provider {
+	server := awsfake.NewServer()
+	defer server.Close()
+	awsresources.Endpoint = server.URL
+	defer func() { awsresources.Endpoint = "" }()
+
+	prov := &awsprovider.AwsProvider{}
+	if err := prov.Initialize(datadir); err != nil {
+		t.Fatal(err)
+	}
+	if err := awscache.PopulateDatabase(prov.DbHandle); err != nil {
+		t.Fatal(err)
+	}
+	if err := awscache.FetchInstanceTypes(context.Background(), prov.DbHandle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awscache.IngestPriceList(context.Background(), prov.DbHandle,
+		awscache.PriceListUrl()); err != nil {
+		t.Fatal(err)
+	}
+	return prov
+}
+
+func dcsGoldenCache(t *testing.T, datadir string) registry.Provider {
+	prov := &dcsprovider.DcsProvider{}
+	if err := prov.Initialize(datadir); err != nil {
+		t.Fatal(err)
+	}
+	if err := dcscache.PopulateDatabase(prov.DbHandle); err != nil {
+		t.Fatal(err)
+	}
+	return prov
+}
+
+func gcloudGoldenCache(t *testing.T, datadir string) registry.Provider {
+	server := gcloudfake.NewServer()
+	defer server.Close()
+	assets.Endpoint = server.URL
+	defer func() { assets.Endpoint = "" }()
+
+	dir := filepath.Join(datadir, "gcloud")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sku-cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = gcloudcache.MigrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = gcloudcache.PopulateDatabase(context.Background(), db, gcloudfake.Project); err != nil {
+		t.Fatal(err)
+	}
+	prov := &gcloudprovider.GcloudProvider{}
+	if err = prov.Initialize(datadir); err != nil {
+		t.Fatal(err)
+	}
+	return prov
+}
+
+// Fills in the project for the provider and writes its cost report, the
+// way the resources and cost commands do. The pricing date line is left
+// out because it changes with every refresh.
+func costReport(prov registry.Provider, name string, project *resources.Project) ([]byte, error) {
+	if err := prov.FillInProviderDetails(project); err != nil {
+		return nil, fmt.Errorf("failed to fill in details: %v", err)
+	}
+	costs, err := getCosts(utils.NewPool(4), []registry.Provider{prov}, []string{name},
+		[]*resources.Project{project})
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "costreport")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	reporter := utils.CostReporter{}
+	reporter.Init(f)
+	for _, c := range costs[0] {
+		if err = reporter.AddLine(c); err != nil {
+			return nil, err
+		}
+	}
+	reporter.Flush()
+	return ioutil.ReadFile(f.Name())
+}
+
+func TestGoldenCostReports(t *testing.T) {
+	root := filepath.Join("..", "..")
+	for name, samples := range goldenSamples {
+		name, samples := name, samples
+		t.Run(name, func(t *testing.T) {
+			datadir, err := ioutil.TempDir("", "golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(datadir)
+			prov := goldenCaches[name](t, datadir)
+
+			for _, sample := range samples {
+				data, err := ioutil.ReadFile(filepath.Join(root, sample))
+				if err != nil {
+					t.Fatal(err)
+				}
+				project := &resources.Project{}
+				if err = protojson.Unmarshal(data, project); err != nil {
+					t.Fatalf("failed to parse %s: %v", sample, err)
+				}
+				got, err := costReport(prov, name, project)
+				if err != nil {
+					t.Errorf("%s: %v", sample, err)
+					continue
+				}
+				checkRows(t, sample, got)
+				golden := filepath.Join("testdata", "golden", name,
+					strings.TrimSuffix(filepath.Base(sample), ".json")+".csv")
+				checkGolden(t, golden, got)
+			}
+		})
+	}
+}
+
+// Checks that every row of the report has as many columns as the header,
+// and that projected usage is no more than max usage, so that -update
+// can't lock in broken rows.
+func checkRows(t *testing.T, sample string, report []byte) {
+	rows, err := csv.NewReader(bytes.NewReader(report)).ReadAll()
+	if err != nil {
+		t.Errorf("%s: malformed report: %v", sample, err)
+		return
+	}
+	for i, row := range rows[1:] {
+		var max, projected float64
+		_, errMax := fmt.Sscanf(row[6], "%f", &max)
+		_, errProjected := fmt.Sscanf(row[8], "%f", &projected)
+		if errMax == nil && errProjected == nil && projected > max {
+			t.Errorf("%s: row %d projects more usage than the max: %v", sample, i+2, row)
+		}
+	}
+}
+
+// Compares got with the golden file, or rewrites the golden file with -update.
+func checkGolden(t *testing.T, golden string, got []byte) {
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(golden, got, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("cost report differs from %s, run with -update if this is intended\n%s",
+			golden, diffLines(string(want), string(got)))
+	}
+}
+
+// A crude line diff, good enough for cost reports of a few lines.
+func diffLines(want string, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	var b strings.Builder
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			fmt.Fprintf(&b, "line %d:\n- %s\n+ %s\n", i+1, w, g)
+		}
+	}
+	return b.String()
+}
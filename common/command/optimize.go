@@ -0,0 +1,196 @@
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"nephomancy/common/optimize"
+	"nephomancy/common/registry"
+	"nephomancy/common/utils"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	// The modules implementing providers have to be loaded
+	_ "nephomancy/aws/provider"
+	_ "nephomancy/dcs/provider"
+	_ "nephomancy/gcloud/provider"
+)
+
+const sameRegionDoc = `Keep resource sets with the same location in the same region and with the same provider, e.g. disks with the VMs they belong to.`
+
+const singleProviderDoc = `Place all resource sets with one provider.`
+
+const currencyDoc = `Currency to compare costs in. Defaults to USD.`
+
+const ratesDoc = `Exchange rates into --currency for providers that price in other currencies, e.g. CHF=1.08,EUR=1.17. Providers whose currency has no rate are not considered.`
+
+type OptimizeCommand struct {
+	Command
+	sameRegion     bool
+	singleProvider bool
+	currency       string
+	rates          string
+	workers        int
+}
+
+func (r *OptimizeCommand) Help() string {
+	helpText := fmt.Sprintf(`
+        Usage: nephomancy optimize [options]
+
+	Place a project's resource sets where they are cheapest.
+
+	For every resource set, every provider with a price cache and every
+	region allowed by the set's location is priced, and the cheapest
+	one is chosen. Provider details already in the project are replaced.
+	The optimised project is saved to projectout, and a CSV summary with
+	the cost of each placement and the savings over the provider's
+	default placement is printed.
+
+        Options:
+          --workingdir=path  %s
+          --projectin=filename %s
+          --projectout=filename %s
+	  --provider=name[,name] Only consider these providers. Defaults to all providers.
+	  --same-region %s
+	  --single-provider %s
+	  --currency=code %s
+	  --rates=list %s
+	  --max-age=days %s
+	  --fail-if-stale %s
+	  --workers=n %s
+`, workingDirDoc, projectInDoc, projectOutDoc, sameRegionDoc, singleProviderDoc,
+		currencyDoc, ratesDoc, maxAgeDoc, failIfStaleDoc, workersDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*OptimizeCommand) Synopsis() string {
+	return "Places a project's resource sets where they are cheapest."
+}
+
+func (r *OptimizeCommand) Run(args []string) int {
+	fs := r.Command.DefaultFlagSet("optimize")
+	fs.BoolVar(&r.sameRegion, "same-region", false, "Keep resource sets with the same location together.")
+	fs.BoolVar(&r.singleProvider, "single-provider", false, "Place all resource sets with one provider.")
+	fs.StringVar(&r.currency, "currency", "USD", "Currency to compare costs in.")
+	fs.StringVar(&r.rates, "rates", "", "Exchange rates into the currency, e.g. CHF=1.08.")
+	fs.IntVar(&r.workers, "workers", runtime.NumCPU(), "Maximum number of concurrent price lookups.")
+	r.addFreshnessFlags(fs)
+	fs.Parse(args)
+
+	infile, err := r.ProjectInFile()
+	if err != nil {
+		log.Fatalf("Bad project infile: %v\n", err)
+	}
+	if infile == "" {
+		log.Fatalf("Please specify a project via the --projectin parameter.\n")
+	}
+	project, err := r.LoadProject()
+	if err != nil {
+		log.Fatalf("Failed to load project from file %s: %v\n", infile, err)
+	}
+	rates, err := parseRates(r.rates)
+	if err != nil {
+		log.Fatalf("Bad exchange rates: %v\n", err)
+	}
+	dd, err := r.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to set up data directory: %v\n", err)
+	}
+
+	var names []string
+	if r.provider != "" {
+		names = strings.Split(r.provider, ",")
+	} else {
+		for name := range registry.Registry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	provs := make([]optimize.Provider, len(names))
+	for idx, name := range names {
+		prov, err := registry.GetProvider(name)
+		if err != nil {
+			log.Fatalf("Failed to get provider %s: %v\n", name, err)
+		}
+		if err = prov.Initialize(dd); err != nil {
+			log.Fatalf("Failed to initialize provider %s: %v\n", name, err)
+		}
+		provs[idx] = optimize.Provider{Name: name, Provider: prov}
+	}
+
+	res, err := optimize.Optimize(project, provs, optimize.Options{
+		SameRegion:     r.sameRegion,
+		SingleProvider: r.singleProvider,
+		Currency:       r.currency,
+		Rates:          rates,
+		Pool:           utils.NewPool(r.workers),
+	})
+	if err != nil {
+		log.Fatalf("Failed to optimize project: %v\n", err)
+	}
+	for _, s := range res.Skipped {
+		log.Printf("%s\n", s)
+	}
+	// Only the caches that prices were taken from need to be fresh.
+	checked := make(map[string]bool)
+	for idx, name := range names {
+		for _, pl := range res.Placements {
+			if pl.Provider == name && !checked[name] {
+				r.checkFreshness(name, provs[idx].Provider)
+				checked[name] = true
+			}
+		}
+	}
+
+	if err = r.SaveProject(res.Project); err != nil {
+		log.Fatalf("Failed to save project: %v\n", err)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.WriteAll(savingsSummary(res.Placements, r.currency))
+	if err = w.Error(); err != nil {
+		log.Fatalf("Failed to write savings summary: %v\n", err)
+	}
+	return 0
+}
+
+// Parses exchange rates like "CHF=1.08,EUR=1.17".
+func parseRates(s string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	if s == "" {
+		return rates, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not of the form CUR=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%q is not a positive exchange rate", kv[1])
+		}
+		rates[strings.ToUpper(kv[0])] = rate
+	}
+	return rates, nil
+}
+
+// Returns one CSV line per placement and a total line.
+func savingsSummary(placements []optimize.Placement, currency string) [][]string {
+	money := func(amount float64) string {
+		return fmt.Sprintf("%.2f %s", amount, currency)
+	}
+	lines := [][]string{{"resource sets", "provider", "region",
+		"projected cost", "default placement cost", "savings"}}
+	var cost, dflt float64
+	for _, pl := range placements {
+		lines = append(lines, []string{strings.Join(pl.Sets, " "), pl.Provider,
+			pl.Region, money(pl.Cost), money(pl.DefaultCost),
+			money(pl.DefaultCost - pl.Cost)})
+		cost += pl.Cost
+		dflt += pl.DefaultCost
+	}
+	lines = append(lines, []string{"total", "", "", money(cost), money(dflt),
+		money(dflt - cost)})
+	return lines
+}
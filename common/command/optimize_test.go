@@ -0,0 +1,90 @@
+package command
+
+import (
+	"github.com/go-test/deep"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io/ioutil"
+	"nephomancy/common/optimize"
+	"nephomancy/common/registry"
+	"nephomancy/common/resources"
+	"nephomancy/common/utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRates(t *testing.T) {
+	rates, err := parseRates("chf=1.08,EUR=1.17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(rates, map[string]float64{"CHF": 1.08, "EUR": 1.17}); diff != nil {
+		t.Error(diff)
+	}
+	for _, bad := range []string{"CHF", "=1", "CHF=x", "CHF=-1"} {
+		if _, err := parseRates(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// Optimizes a project in Switzerland across the fixture caches and checks
+// that the mixed result can still be priced the way the cost command does.
+func TestOptimizeWithFixtureCaches(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "optimize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	names := []string{"dcs", "gcloud"}
+	var provs []optimize.Provider
+	for _, name := range names {
+		provs = append(provs, optimize.Provider{Name: name, Provider: goldenCaches[name](t, datadir)})
+	}
+	data, err := ioutil.ReadFile(filepath.Join("..", "..",
+		"dcs", "provider", "testdata", "nephomancy-sample-project.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := &resources.Project{}
+	if err = protojson.Unmarshal(data, project); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := optimize.Optimize(project, provs, optimize.Options{
+		Rates: map[string]float64{"CHF": 1.1},
+		Pool:  utils.NewPool(4),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Placements) != 3 {
+		t.Fatalf("expected a placement per resource set, got %v", res.Placements)
+	}
+	for _, pl := range res.Placements {
+		if pl.Cost <= 0 || pl.Cost > pl.DefaultCost {
+			t.Errorf("unexpected placement %+v", pl)
+		}
+		if pl.Provider == "gcloud" && pl.Region != "europe-west6" {
+			t.Errorf("expected gcloud to stay in Switzerland, got %+v", pl)
+		}
+	}
+
+	used := resources.GetProviderNames(res.Project)
+	var usedProvs []registry.Provider
+	for _, name := range used {
+		for _, p := range provs {
+			if p.Name == name {
+				usedProvs = append(usedProvs, p.Provider)
+			}
+		}
+	}
+	if _, err = getCosts(utils.NewPool(4), usedProvs, used, []*resources.Project{res.Project}); err != nil {
+		t.Errorf("failed to price the optimized project: %v", err)
+	}
+
+	summary := savingsSummary(res.Placements, "USD")
+	if len(summary) != len(res.Placements)+2 || summary[len(summary)-1][0] != "total" {
+		t.Errorf("unexpected summary %v", summary)
+	}
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"nephomancy/common/registry"
+	"nephomancy/common/snapshot"
+	"os"
+	"strings"
+	"time"
+	// The modules implementing providers have to be loaded
+	_ "nephomancy/aws/provider"
+	_ "nephomancy/dcs/provider"
+	_ "nephomancy/gcloud/provider"
+)
+
+const fromDoc = `Date (YYYY-MM-DD) of the older price snapshot. The newest snapshot taken on or before this date is used.`
+
+const toDoc = `Date (YYYY-MM-DD) of the newer price snapshot. Defaults to today, i.e. the newest snapshot.`
+
+type PricesCommand struct {
+	Command
+	from string
+	to   string
+}
+
+func (r *PricesCommand) Help() string {
+	helpText := fmt.Sprintf(`
+        Usage: nephomancy prices [options]
+
+	List price changes between two price snapshots.
+
+	Every run of "nephomancy <provider> init" saves a dated snapshot of the
+	provider's price data. This command compares two of them and prints
+	a CSV line for each sku whose rates changed, with the rates before and
+	after. Skus that were added or removed have an empty before or after
+	column.
+
+        Options:
+          --workingdir=path  %s
+	  --provider=name %s
+	  --from=date %s
+	  --to=date %s
+`, workingDirDoc, providerDoc, fromDoc, toDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*PricesCommand) Synopsis() string {
+	return "Lists price changes between two price snapshots."
+}
+
+func (r *PricesCommand) Run(args []string) int {
+	fs := r.Command.DefaultFlagSet("prices")
+	fs.StringVar(&r.from, "from", "", "Date of the older price snapshot.")
+	fs.StringVar(&r.to, "to", "", "Date of the newer price snapshot.")
+	fs.Parse(args)
+
+	if r.provider == "" {
+		log.Fatalf("Please specify a provider via the --provider parameter.\n")
+	}
+	if r.from == "" {
+		log.Fatalf("Please specify a snapshot date via the --from parameter.\n")
+	}
+	if r.to == "" {
+		r.to = time.Now().Format(snapshot.DateFormat)
+	}
+	prov, err := registry.GetProvider(r.provider)
+	if err != nil {
+		log.Fatalf("Failed to get provider %s: %v\n", r.provider, err)
+	}
+	dd, err := r.DataDir()
+	if err != nil {
+		log.Fatalf("Failed to set up data directory: %v\n", err)
+	}
+	if err = prov.Initialize(dd); err != nil {
+		log.Fatalf("Failed to initialize provider %s: %v\n", r.provider, err)
+	}
+	changes, err := prov.GetPriceChanges(r.from, r.to)
+	if err != nil {
+		log.Fatalf("Failed to get price changes for provider %s: %v\n",
+			r.provider, err)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"sku", fmt.Sprintf("rates as of %s", r.from),
+		fmt.Sprintf("rates as of %s", r.to)})
+	w.WriteAll(changes)
+	if err = w.Error(); err != nil {
+		log.Fatalf("Failed to write price changes: %v\n", err)
+	}
+	return 0
+}
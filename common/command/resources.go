@@ -5,14 +5,18 @@ import (
 	"log"
 	"nephomancy/common/registry"
 	"nephomancy/common/resources"
+	"os"
 	"strings"
 	// The modules implementing providers have to be loaded
 	_ "nephomancy/aws/provider"
 	_ "nephomancy/gcloud/provider"
 )
 
+const interactiveDoc = `Build the project by answering questions instead of starting from a generic template. With --provider, answers are checked against the provider's price cache and matching machine types are offered. Can't be combined with --projectin.`
+
 type ResourcesCommand struct {
 	Command
+	interactive bool
 }
 
 func (r *ResourcesCommand) Help() string {
@@ -22,7 +26,8 @@ func (r *ResourcesCommand) Help() string {
 	Create or complete a project resource file.
 
 	Call this with no projectin parameter set, and you will get
-	a generic template file.
+	a generic template file. Add --interactive to be asked about the
+	instance sets, disk sets and networks you need instead.
 
 	Call it with a template file containing a spec and say which provider
 	you want to get provider details filled in.
@@ -33,7 +38,10 @@ func (r *ResourcesCommand) Help() string {
           --projectout=filename %s
 	  --provider=name %s
 	  --location=place Region, Continent or 2-letter country code. This will be used as the default location when creating a template.
-`, workingDirDoc, projectInDoc, projectOutDoc, providerDoc)
+	  --interactive %s
+	  --max-age=days %s
+	  --fail-if-stale %s
+`, workingDirDoc, projectInDoc, projectOutDoc, providerDoc, interactiveDoc, maxAgeDoc, failIfStaleDoc)
 	return strings.TrimSpace(helpText)
 }
 
@@ -45,26 +53,21 @@ func (r *ResourcesCommand) Run(args []string) int {
 	fs := r.Command.DefaultFlagSet("resources")
 	var location string
 	fs.StringVar(&location, "location", "", "Location. Can be a global region (EMEA, APAC, NAM, LATAM), a continent (Africa, Asia, Europe, NorthAmerica, SouthAmerica) or a two-letter ISO country code.")
+	fs.BoolVar(&r.interactive, "interactive", false, "Build the project by answering questions.")
+	r.addFreshnessFlags(fs)
 	fs.Parse(args)
 
 	infile, err := r.ProjectInFile()
 	if err != nil {
 		log.Fatalf("Bad project infile: %v\n", err)
 	}
-	var project resources.Project
-	if infile != "" {
-		p, err := r.loadProject()
-		if err != nil {
-			log.Fatalf("Failed to load project from file %s: %v\n",
-				infile, err)
-		}
-		project = *p
-	} else {
-		project = resources.MakeSampleProject(location)
+	if infile != "" && r.interactive {
+		log.Fatalf("--interactive builds a new project, it can't be combined with --projectin.\n")
 	}
 
+	var prov registry.Provider
 	if r.provider != "" {
-		prov, err := registry.GetProvider(r.provider)
+		prov, err = registry.GetProvider(r.provider)
 		if err != nil {
 			log.Fatalf("Failed to get provider %s: %v\n", r.provider, err)
 		}
@@ -76,15 +79,36 @@ func (r *ResourcesCommand) Run(args []string) int {
 		if err != nil {
 			log.Fatalf("Failed to initialize provider %s: %v\n", r.provider, err)
 		}
+		r.checkFreshness(r.provider, prov)
+	}
+
+	var project *resources.Project
+	if infile != "" {
+		p, err := r.LoadProject()
+		if err != nil {
+			log.Fatalf("Failed to load project from file %s: %v\n",
+				infile, err)
+		}
+		project = p
+	} else if r.interactive {
+		p, err := newWizard(os.Stdin, os.Stdout, prov, r.provider).project(location)
+		if err != nil {
+			log.Fatalf("Failed to build project: %v\n", err)
+		}
+		project = p
+	} else {
+		project = resources.MakeSampleProject(location)
+	}
 
-		err = prov.FillInProviderDetails(&project)
+	if prov != nil {
+		err = prov.FillInProviderDetails(project)
 		if err != nil {
 			log.Fatalf("Failed to fill in details for provider %s: %v\n",
 				r.provider, err)
 		}
 	}
 
-	if err = r.saveProject(&project); err != nil {
+	if err = r.SaveProject(project); err != nil {
 		log.Fatalf("Failed to save project: %v\n", err)
 	}
 	return 0
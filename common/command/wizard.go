@@ -0,0 +1,431 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"nephomancy/common/registry"
+	"nephomancy/common/resources"
+	"strconv"
+	"strings"
+)
+
+const locationHelp = `a global region (APAC, EMEA, LATAM, NAM), a continent (Africa, Asia, Australia, Europe, LatinAmerica, NorthAmerica) or a two-letter country code`
+
+// How many matching machine types to show at most.
+const maxMachineTypes = 10
+
+// Builds a project by asking questions on a terminal. When there is a
+// provider, each resource set is checked by filling in provider details
+// for it, and instance sets are offered the provider's matching machine
+// types.
+type wizard struct {
+	in  *bufio.Scanner
+	out io.Writer
+	// Optional.
+	prov     registry.Provider
+	provName string
+}
+
+func newWizard(in io.Reader, out io.Writer, prov registry.Provider, provName string) *wizard {
+	return &wizard{
+		in:       bufio.NewScanner(in),
+		out:      out,
+		prov:     prov,
+		provName: provName,
+	}
+}
+
+// Asks a question. Returns def if the answer is empty.
+func (w *wizard) ask(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	if !w.in.Scan() {
+		if err := w.in.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("input ended before the project was complete")
+	}
+	answer := strings.TrimSpace(w.in.Text())
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// Asks for a number between min and max until it gets one.
+func (w *wizard) askNumber(question string, def uint64, min uint64, max uint64) (uint64, error) {
+	for {
+		answer, err := w.ask(question, strconv.FormatUint(def, 10))
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseUint(answer, 10, 64)
+		if err == nil && n >= min && n <= max {
+			return n, nil
+		}
+		fmt.Fprintf(w.out, "Please enter a whole number between %d and %d.\n", min, max)
+	}
+}
+
+func (w *wizard) askYesNo(question string, def bool) (bool, error) {
+	d := "n"
+	if def {
+		d = "y"
+	}
+	for {
+		answer, err := w.ask(question+" (y/n)", d)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintf(w.out, "Please answer y or n.\n")
+	}
+}
+
+// Asks for one of the choices, ignoring case.
+func (w *wizard) askChoice(question string, choices []string, def string) (string, error) {
+	for {
+		answer, err := w.ask(fmt.Sprintf("%s (%s)", question, strings.Join(choices, ", ")), def)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range choices {
+			if strings.EqualFold(answer, c) {
+				return c, nil
+			}
+		}
+		fmt.Fprintf(w.out, "Please enter one of %s.\n", strings.Join(choices, ", "))
+	}
+}
+
+// Asks for a location until it gets one the geo package knows. Returns
+// the location and the answer, to use as the default for the next one.
+func (w *wizard) askLocation(question string, def string) (*resources.Location, string, error) {
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil {
+			return nil, "", err
+		}
+		if loc := resources.ResolveLocation(answer); loc != nil {
+			return loc, answer, nil
+		}
+		if loc := resources.ResolveLocation(strings.ToUpper(answer)); loc != nil {
+			return loc, strings.ToUpper(answer), nil
+		}
+		fmt.Fprintf(w.out, "Unknown location %s. Please enter %s.\n", answer, locationHelp)
+	}
+}
+
+// Fills in provider details for a scratch project holding a copy of one
+// resource set, to find out whether the provider can offer it. The
+// project's resource sets are left as they are, so that they can be
+// filled in together later.
+func (w *wizard) check(scratch *resources.Project) bool {
+	if w.prov == nil {
+		return true
+	}
+	scratch.Name = "wizard"
+	if err := w.prov.FillInProviderDetails(scratch); err != nil {
+		fmt.Fprintf(w.out, "Provider %s can't offer this: %v\nPlease try again.\n",
+			w.provName, err)
+		return false
+	}
+	return true
+}
+
+// Asks questions until the project is complete. Resources are in the
+// location where by default, unless the user says otherwise.
+func (w *wizard) project(where string) (*resources.Project, error) {
+	if where == "" {
+		where = "US"
+	}
+	fmt.Fprintf(w.out, "Press enter to accept the default in brackets.\n")
+	name, err := w.ask("Project name", "Nephomancy project")
+	if err != nil {
+		return nil, err
+	}
+	p := &resources.Project{Name: name}
+	_, where, err = w.askLocation(
+		fmt.Sprintf("Where should resources be by default? Enter %s", locationHelp), where)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		add, err := w.askYesNo("Add an instance set?", len(p.InstanceSets) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if !add {
+			break
+		}
+		var is *resources.InstanceSet
+		for is == nil {
+			if is, err = w.instanceSet(len(p.InstanceSets)+1, where); err != nil {
+				return nil, err
+			}
+			if is != nil && !w.check(&resources.Project{
+				InstanceSets: []*resources.InstanceSet{proto.Clone(is).(*resources.InstanceSet)},
+			}) {
+				is = nil
+			}
+		}
+		p.InstanceSets = append(p.InstanceSets, is)
+	}
+	for {
+		add, err := w.askYesNo("Add a disk set?", len(p.DiskSets) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if !add {
+			break
+		}
+		var ds *resources.DiskSet
+		for ds == nil {
+			if ds, err = w.diskSet(len(p.DiskSets)+1, where); err != nil {
+				return nil, err
+			}
+			if !w.check(&resources.Project{
+				DiskSets: []*resources.DiskSet{proto.Clone(ds).(*resources.DiskSet)},
+			}) {
+				ds = nil
+			}
+		}
+		p.DiskSets = append(p.DiskSets, ds)
+	}
+	for {
+		add, err := w.askYesNo("Add a network?", len(p.Networks) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if !add {
+			break
+		}
+		var nw *resources.Network
+		for nw == nil {
+			if nw, err = w.network(len(p.Networks)+1, where); err != nil {
+				return nil, err
+			}
+			if !w.check(&resources.Project{
+				Networks: []*resources.Network{proto.Clone(nw).(*resources.Network)},
+			}) {
+				nw = nil
+			}
+		}
+		p.Networks = append(p.Networks, nw)
+	}
+	return p, nil
+}
+
+func (w *wizard) instanceSet(n int, where string) (*resources.InstanceSet, error) {
+	fmt.Fprintf(w.out, "Instance set %d\n", n)
+	name, err := w.ask("Name", fmt.Sprintf("Instance set %d", n))
+	if err != nil {
+		return nil, err
+	}
+	loc, _, err := w.askLocation("Location", where)
+	if err != nil {
+		return nil, err
+	}
+	mt, err := w.machineType(loc)
+	if err != nil || mt == nil {
+		return nil, err
+	}
+	os, err := w.ask("Operating system, e.g. linux, windows or a provider's OS name", "linux")
+	if err != nil {
+		return nil, err
+	}
+	count, err := w.askNumber("How many instances", 1, 1, 100000)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := w.askNumber("Hours per month each instance runs", 730, 0, 730)
+	if err != nil {
+		return nil, err
+	}
+	return &resources.InstanceSet{
+		Name: name,
+		Template: &resources.Instance{
+			Location: loc,
+			Type:     mt,
+			Os:       os,
+		},
+		Count:              uint32(count),
+		UsageHoursPerMonth: uint32(hours),
+	}, nil
+}
+
+// Asks for cpus and memory. If the provider has machine types, shows
+// the ones matching the answer, and lets the user pick one to use its
+// size. Returns nil if the provider has no matching machine types in
+// the location.
+func (w *wizard) machineType(loc *resources.Location) (*resources.MachineType, error) {
+	lister, _ := w.prov.(registry.MachineTypeLister)
+	cpus, err := w.askNumber("Number of cpus", 2, 1, 1024)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := w.askNumber("Memory in GB", 16, 1, 12288)
+	if err != nil {
+		return nil, err
+	}
+	mt := &resources.MachineType{CpuCount: uint32(cpus), MemoryGb: uint32(memory)}
+	if lister == nil {
+		return mt, nil
+	}
+	mts, err := lister.ListMachineTypes(mt, loc)
+	if err != nil {
+		return nil, err
+	}
+	if len(mts) == 0 {
+		fmt.Fprintf(w.out, "Provider %s has no machine types with %s in %s.\nPlease try again.\n",
+			w.provName, resources.PrintMachineType(mt), resources.PrintLocation(loc))
+		return nil, nil
+	}
+	if len(mts) > maxMachineTypes {
+		mts = mts[:maxMachineTypes]
+	}
+	fmt.Fprintf(w.out, "Matching %s machine types:\n", w.provName)
+	for _, m := range mts {
+		fmt.Fprintf(w.out, "  %s: %d cpus, %.1f GB memory in %s\n", m.Name, m.CpuCount,
+			float64(m.MemoryMb)/1024, strings.Join(m.Regions, ", "))
+	}
+	for {
+		pick, err := w.ask("Enter one of these to use its size, or press enter to keep your answer", "")
+		if err != nil {
+			return nil, err
+		}
+		if pick == "" {
+			return mt, nil
+		}
+		for _, m := range mts {
+			if m.Name == pick {
+				mt.CpuCount = m.CpuCount
+				mt.MemoryGb = uint32(m.MemoryMb / 1024)
+				return mt, nil
+			}
+		}
+		fmt.Fprintf(w.out, "%s is not one of the machine types above.\n", pick)
+	}
+}
+
+func (w *wizard) diskSet(n int, where string) (*resources.DiskSet, error) {
+	fmt.Fprintf(w.out, "Disk set %d\n", n)
+	name, err := w.ask("Name", fmt.Sprintf("Disk set %d", n))
+	if err != nil {
+		return nil, err
+	}
+	loc, _, err := w.askLocation("Location", where)
+	if err != nil {
+		return nil, err
+	}
+	size, err := w.askNumber("Size in GB", 100, 1, 65536)
+	if err != nil {
+		return nil, err
+	}
+	tech, err := w.askChoice("Disk technology", []string{"SSD", "Standard"}, "SSD")
+	if err != nil {
+		return nil, err
+	}
+	count, err := w.askNumber("How many disks", 1, 1, 100000)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := w.askNumber("Hours per month each disk exists", 730, 0, 730)
+	if err != nil {
+		return nil, err
+	}
+	return &resources.DiskSet{
+		Name: name,
+		Template: &resources.Disk{
+			Location: loc,
+			Type: &resources.DiskType{
+				SizeGb:   uint32(size),
+				DiskTech: tech,
+			},
+		},
+		Count:              uint32(count),
+		UsageHoursPerMonth: uint32(hours),
+	}, nil
+}
+
+func (w *wizard) network(n int, where string) (*resources.Network, error) {
+	fmt.Fprintf(w.out, "Network %d\n", n)
+	name, err := w.ask("Name", fmt.Sprintf("Network %d", n))
+	if err != nil {
+		return nil, err
+	}
+	ips, err := w.askNumber("Number of external IP addresses", 1, 0, 1024)
+	if err != nil {
+		return nil, err
+	}
+	nw := &resources.Network{Name: name, IpAddresses: int32(ips)}
+	for {
+		snw, err := w.subnetwork(len(nw.Subnetworks)+1, where)
+		if err != nil {
+			return nil, err
+		}
+		nw.Subnetworks = append(nw.Subnetworks, snw)
+		more, err := w.askYesNo("Add another subnetwork?", false)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return nw, nil
+		}
+	}
+}
+
+func (w *wizard) subnetwork(n int, where string) (*resources.Subnetwork, error) {
+	fmt.Fprintf(w.out, "Subnetwork %d\n", n)
+	name, err := w.ask("Name", fmt.Sprintf("Subnetwork %d", n))
+	if err != nil {
+		return nil, err
+	}
+	loc, _, err := w.askLocation("Location", where)
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := w.askNumber("Number of gateways", 1, 0, 64)
+	if err != nil {
+		return nil, err
+	}
+	bandwidth, err := w.askNumber("Bandwidth in Mbit/s", 150, 0, 100000)
+	if err != nil {
+		return nil, err
+	}
+	ingress, err := w.askNumber("Ingress in Gbit per month", 1, 0, 1<<40)
+	if err != nil {
+		return nil, err
+	}
+	external, err := w.askNumber("Egress to the internet in Gbit per month", 1, 0, 1<<40)
+	if err != nil {
+		return nil, err
+	}
+	internal, err := w.askNumber("Egress to other regions in Gbit per month", 3, 0, 1<<40)
+	if err != nil {
+		return nil, err
+	}
+	snw := &resources.Subnetwork{
+		Name:                        name,
+		Location:                    loc,
+		BandwidthMbits:              uint32(bandwidth),
+		IngressGbitsPerMonth:        ingress,
+		ExternalEgressGbitsPerMonth: external,
+		InternalEgressGbitsPerMonth: internal,
+	}
+	for i := uint64(0); i < gateways; i++ {
+		snw.Gateways = append(snw.Gateways, &resources.Gateway{})
+	}
+	return snw, nil
+}
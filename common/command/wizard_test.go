@@ -0,0 +1,136 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"nephomancy/common/resources"
+)
+
+func TestWizardWithoutProvider(t *testing.T) {
+	answers := []string{
+		"Shop",   // project name
+		"Mars",   // unknown location
+		"ch",     // default location
+		"",       // add an instance set: yes
+		"web",    // name
+		"",       // location: CH
+		"two",    // not a number
+		"4",      // cpus
+		"8",      // memory
+		"",       // os: linux
+		"3",      // count
+		"800",    // more hours than a month has
+		"200",    // hours
+		"n",      // no more instance sets
+		"",       // add a disk set: yes
+		"",       // name
+		"Europe", // location
+		"50",     // size
+		"hdd",    // unknown disk tech
+		"standard",
+		"",  // count
+		"",  // hours
+		"n", // no more disk sets
+		"n", // no network
+	}
+	var out bytes.Buffer
+	w := newWizard(strings.NewReader(strings.Join(answers, "\n")+"\n"), &out, nil, "")
+	p, err := w.project("")
+	if err != nil {
+		t.Fatalf("wizard failed: %v\noutput: %s", err, out.String())
+	}
+	ch := &resources.Location{GlobalRegion: "EMEA", Continent: "Europe", CountryCode: "CH"}
+	want := &resources.Project{
+		Name: "Shop",
+		InstanceSets: []*resources.InstanceSet{{
+			Name: "web",
+			Template: &resources.Instance{
+				Location: ch,
+				Type:     &resources.MachineType{CpuCount: 4, MemoryGb: 8},
+				Os:       "linux",
+			},
+			Count:              3,
+			UsageHoursPerMonth: 200,
+		}},
+		DiskSets: []*resources.DiskSet{{
+			Name: "Disk set 1",
+			Template: &resources.Disk{
+				Location: &resources.Location{Continent: "Europe"},
+				Type:     &resources.DiskType{SizeGb: 50, DiskTech: "Standard"},
+			},
+			Count:              1,
+			UsageHoursPerMonth: 730,
+		}},
+	}
+	if diff := deep.Equal(p.String(), want.String()); diff != nil {
+		t.Errorf("unexpected project: %v", diff)
+	}
+	for _, msg := range []string{"Unknown location Mars", "Please enter a whole number", "Please enter one of SSD, Standard"} {
+		if !strings.Contains(out.String(), msg) {
+			t.Errorf("expected output to contain %q", msg)
+		}
+	}
+}
+
+func TestWizardOffersMachineTypes(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "wizard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	prov := awsGoldenCache(t, datadir)
+
+	answers := []string{
+		"",   // project name
+		"DE", // default location
+		"",   // add an instance set: yes
+		"",   // name
+		"BR", // location without instance types in the fixtures
+		"",   // cpus
+		"",   // memory
+		"",   // name
+		"",   // location: DE
+		"1",  // cpus
+		"9",  // memory
+		"t3.nano",
+		"m5.xlarge",
+		"",  // os
+		"",  // count
+		"",  // hours
+		"n", // no more instance sets
+		"n", // no disk set
+		"n", // no network
+	}
+	var out bytes.Buffer
+	w := newWizard(strings.NewReader(strings.Join(answers, "\n")+"\n"), &out, prov, "aws")
+	p, err := w.project("")
+	if err != nil {
+		t.Fatalf("wizard failed: %v\noutput: %s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Provider aws has no machine types with 2 cpus, 16 gb memory in BR") {
+		t.Errorf("expected the instance set in BR to be refused, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), "m5.xlarge: 1 cpus, 16.0 GB memory in eu-central-1") {
+		t.Errorf("expected m5.xlarge to be offered, got %s", out.String())
+	}
+	if len(p.InstanceSets) != 1 {
+		t.Fatalf("expected one instance set, got %v", p.InstanceSets)
+	}
+	is := p.InstanceSets[0]
+	if is.Template.Location.CountryCode != "DE" ||
+		is.Template.Type.CpuCount != 1 || is.Template.Type.MemoryGb != 16 {
+		t.Errorf("expected the size of m5.xlarge in DE, got %v", is.Template)
+	}
+	if len(is.Template.ProviderDetails) != 0 {
+		t.Errorf("expected the wizard to leave filling in to the caller, got %v",
+			is.Template.ProviderDetails)
+	}
+	if err = prov.FillInProviderDetails(p); err != nil {
+		t.Errorf("failed to fill in the project: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Fake cloud APIs that replay recorded responses, for testing fetchers
+// without a cloud account or network access.
+//
+// A Server answers each request with the contents of a fixture file.
+// A provider-specific Router maps the request to fixture names, relative
+// to the fixture directory, and the first one that exists is served.
+// Requests without a fixture get a 404.
+package fake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Returns the names of the fixtures that could answer the request, in
+// order of preference.
+type Router func(r *http.Request) ([]string, error)
+
+type Server struct {
+	*httptest.Server
+
+	dir   string
+	route Router
+
+	mu       sync.Mutex
+	failures int
+	requests []string
+}
+
+// Starts a server replaying the fixtures in dir. Close it when done.
+func NewServer(dir string, route Router) *Server {
+	s := &Server{dir: dir, route: route}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Makes the next n requests fail with 503 Service Unavailable, to test
+// retries.
+func (s *Server) FailNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+}
+
+// Returns the fixtures served so far, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	s.mu.Unlock()
+	if fail {
+		http.Error(w, "fake failure", http.StatusServiceUnavailable)
+		return
+	}
+	names, err := s.route(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, name := range names {
+		body, err := ioutil.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, name)
+		s.mu.Unlock()
+		if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Write(body)
+		return
+	}
+	http.Error(w, fmt.Sprintf("no fixture for %s %s, tried %v", r.Method, r.URL, names),
+		http.StatusNotFound)
+}
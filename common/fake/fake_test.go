@@ -0,0 +1,47 @@
+package fake
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"b":1}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dir, func(r *http.Request) ([]string, error) {
+		return []string{r.URL.Path[1:] + ".json", "b.json"}, nil
+	})
+	defer s.Close()
+
+	s.FailNext(1)
+	resp, err := http.Get(s.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected injected failure, got %s", resp.Status)
+	}
+
+	resp, err = http.Get(s.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `{"b":1}` {
+		t.Errorf("expected fallback fixture, got %s %s", resp.Status, body)
+	}
+	if got := s.Requests(); len(got) != 1 || got[0] != "b.json" {
+		t.Errorf("unexpected requests %v", got)
+	}
+}
@@ -0,0 +1,145 @@
+// Timeouts, retries and cancellation for calls to cloud provider APIs.
+//
+// Every fetcher takes a context. Commands get theirs from Context, which
+// is cancelled on Ctrl-C and after an optional overall timeout, and each
+// API call goes through Retry, which gives it its own timeout and retries
+// it with exponential backoff if it fails with a transient error.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Maximum time for a single API call. 0 means no limit.
+var CallTimeout = 2 * time.Minute
+
+// How often a call that failed with a transient error is retried.
+var Retries = 3
+
+// Wait before the first retry. It doubles for every following retry,
+// up to maxBackoff.
+var Backoff = time.Second
+
+const maxBackoff = 30 * time.Second
+
+// Returns a context that is cancelled on Ctrl-C, and after the timeout
+// unless it is 0. A second Ctrl-C quits immediately.
+func Context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancelAll := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelAll()
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			log.Printf("Interrupted, cleaning up. Press Ctrl-C again to quit immediately.\n")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// An HTTP response with an unexpected status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %s", e.Status)
+}
+
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
+// Returns a StatusError if the response doesn't have status 200.
+func CheckStatus(r *http.Response) error {
+	if r.StatusCode != http.StatusOK {
+		return &StatusError{Code: r.StatusCode, Status: r.Status}
+	}
+	return nil
+}
+
+// Returns whether an error is worth retrying: server errors, rate
+// limiting, network timeouts and dropped connections.
+func Transient(err error) bool {
+	var se interface{ StatusCode() int }
+	if errors.As(err, &se) {
+		code := se.StatusCode()
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+	var te interface{ Timeout() bool }
+	if errors.As(err, &te) && te.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// Calls call with a context that times out after CallTimeout. Retries
+// it if it times out or fails with an error for which transient returns
+// true, at most Retries times. Gives up as soon as ctx is done.
+func Retry(ctx context.Context, transient func(error) bool,
+	call func(context.Context) error) error {
+	return RetryWithin(ctx, CallTimeout, transient, call)
+}
+
+// Like Retry, but with a different timeout for each call, e.g. for
+// large downloads.
+func RetryWithin(ctx context.Context, timeout time.Duration, transient func(error) bool,
+	call func(context.Context) error) error {
+	wait := Backoff
+	for attempt := 0; ; attempt++ {
+		err := callWithin(ctx, timeout, call)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// ctx is still fine, so a deadline means the call timed out.
+		timedOut := errors.Is(err, context.DeadlineExceeded)
+		if attempt >= Retries || !(timedOut || transient(err)) {
+			return err
+		}
+		log.Printf("Retrying in %v after transient error: %v\n", wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+func callWithin(ctx context.Context, timeout time.Duration,
+	call func(context.Context) error) error {
+	if timeout <= 0 {
+		return call(ctx)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return call(callCtx)
+}
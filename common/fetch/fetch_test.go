@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func init() {
+	Backoff = time.Millisecond
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{Code: 503, Status: "503 Service Unavailable"}, true},
+		{&StatusError{Code: 429, Status: "429 Too Many Requests"}, true},
+		{&StatusError{Code: 404, Status: "404 Not Found"}, false},
+		{fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), true},
+		{errors.New("permission denied"), false},
+	}
+	for _, tt := range tests {
+		if got := Transient(tt.err); got != tt.want {
+			t.Errorf("Transient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	if err := CheckStatus(&http.Response{StatusCode: 200, Status: "200 OK"}); err != nil {
+		t.Errorf("unexpected error for 200: %v", err)
+	}
+	err := CheckStatus(&http.Response{StatusCode: 502, Status: "502 Bad Gateway"})
+	if !Transient(err) {
+		t.Errorf("expected 502 to be transient, got %v", err)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	calls := 0
+	err := Retry(context.Background(), Transient, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &StatusError{Code: 500, Status: "500 Internal Server Error"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	calls := 0
+	err := Retry(context.Background(), Transient, func(ctx context.Context) error {
+		calls++
+		return &StatusError{Code: 500, Status: "500 Internal Server Error"}
+	})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if calls != Retries+1 {
+		t.Errorf("expected %d calls, got %d", Retries+1, calls)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	calls := 0
+	err := Retry(context.Background(), Transient, func(ctx context.Context) error {
+		calls++
+		return errors.New("permission denied")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected one failed call, got %d calls and error %v", calls, err)
+	}
+}
+
+func TestRetryCallTimeout(t *testing.T) {
+	calls := 0
+	err := RetryWithin(context.Background(), 10*time.Millisecond, Transient,
+		func(ctx context.Context) error {
+			calls++
+			if calls == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the timed out call to be retried, got %d calls", calls)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Retry(ctx, Transient, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return &StatusError{Code: 500, Status: "500 Internal Server Error"}
+	})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected no retries after cancellation, got %d calls", calls)
+	}
+}
@@ -0,0 +1,99 @@
+// Metadata about a provider's price cache: when it was refreshed, and
+// where the prices came from.
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Metadata struct {
+	// When the cache was last refreshed.
+	Refreshed time.Time
+	// Where the prices come from, e.g. an API or a price list URL.
+	Source string
+	// Version of the source, if it has one, e.g. the AWS offer file version.
+	SourceVersion string
+	// Publication date (YYYY-MM-DD) of the prices, if the source has one.
+	SourceDate string
+}
+
+// Returns the date the prices are from: the publication date if it is
+// known, the refresh date otherwise.
+func (m Metadata) PricingDate() string {
+	if m.SourceDate != "" {
+		return m.SourceDate
+	}
+	return m.Refreshed.UTC().Format("2006-01-02")
+}
+
+// Creates the table holding the metadata. There is only ever one row in it.
+func CreateTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS CacheMetadata (
+		"Id" INTEGER NOT NULL PRIMARY KEY CHECK (Id = 0),
+		"RefreshedTS" INTEGER NOT NULL,
+		"Source" TEXT,
+		"SourceVersion" TEXT,
+		"SourceDate" TEXT
+	);`)
+	return err
+}
+
+// Records the metadata, replacing what was there before.
+func Record(db *sql.DB, m Metadata) error {
+	_, err := db.Exec(`REPLACE INTO CacheMetadata(Id, RefreshedTS, Source,
+	SourceVersion, SourceDate) VALUES (0, ?, ?, ?, ?)`,
+		m.Refreshed.Unix(), m.Source, m.SourceVersion, m.SourceDate)
+	return err
+}
+
+// Reads the metadata. Returns nil if none was recorded, e.g. because
+// the cache was built by an older version of nephomancy.
+func Read(db *sql.DB) (*Metadata, error) {
+	var ts int64
+	var m Metadata
+	err := db.QueryRow(`SELECT RefreshedTS, IFNULL(Source, ''),
+	IFNULL(SourceVersion, ''), IFNULL(SourceDate, '')
+	FROM CacheMetadata WHERE Id=0`).Scan(&ts, &m.Source, &m.SourceVersion, &m.SourceDate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m.Refreshed = time.Unix(ts, 0)
+	return &m, nil
+}
+
+// Returns an error if the prices are more than maxAge old, or if it is
+// not known how old they are. Prices with a publication date are as old
+// as that date, refreshing the cache from the same source doesn't make
+// them newer; the others are as old as the last refresh.
+func CheckAge(m *Metadata, maxAge time.Duration, now time.Time) error {
+	if m == nil {
+		return fmt.Errorf("cache has no refresh time recorded")
+	}
+	if m.SourceDate != "" {
+		published, err := time.Parse("2006-01-02", m.SourceDate)
+		if err != nil {
+			return fmt.Errorf("cache has an invalid price date %q: %v", m.SourceDate, err)
+		}
+		if age := now.Sub(published); age > maxAge {
+			return fmt.Errorf("prices were published %d days ago, on %s",
+				int(age.Hours()/24), m.SourceDate)
+		}
+		return nil
+	}
+	if age := now.Sub(m.Refreshed); age > maxAge {
+		return fmt.Errorf("cache was last refreshed %d days ago, on %s",
+			int(age.Hours()/24), m.Refreshed.UTC().Format("2006-01-02"))
+	}
+	return nil
+}
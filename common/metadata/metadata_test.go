@@ -0,0 +1,83 @@
+package metadata
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRecordAndRead(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Every connection to :memory: gets its own database.
+	db.SetMaxOpenConns(1)
+	m, err := Read(db)
+	if m != nil || err != nil {
+		t.Errorf("expected no metadata before the table exists but got %v %v", m, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CreateTable(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	refreshed := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	for _, v := range []string{"v1", "v2"} {
+		err = Record(db, Metadata{
+			Refreshed:     refreshed,
+			Source:        "price list",
+			SourceVersion: v,
+		})
+		if err != nil {
+			t.Fatalf("failed to record metadata: %v", err)
+		}
+	}
+	m, err = Read(db)
+	if err != nil || m == nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if !m.Refreshed.Equal(refreshed) || m.SourceVersion != "v2" {
+		t.Errorf("unexpected metadata %+v", *m)
+	}
+	if m.PricingDate() != "2021-03-01" {
+		t.Errorf("expected refresh date as pricing date but got %s", m.PricingDate())
+	}
+	m.SourceDate = "2020-11-27"
+	if m.PricingDate() != "2020-11-27" {
+		t.Errorf("expected source date as pricing date but got %s", m.PricingDate())
+	}
+}
+
+func TestCheckAge(t *testing.T) {
+	now := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	m := &Metadata{Refreshed: now.AddDate(0, 0, -10)}
+	if err := CheckAge(m, 30*24*time.Hour, now); err != nil {
+		t.Errorf("10 day old cache should not be stale: %v", err)
+	}
+	if err := CheckAge(m, 7*24*time.Hour, now); err == nil {
+		t.Errorf("10 day old cache should be stale")
+	}
+	if err := CheckAge(nil, 7*24*time.Hour, now); err == nil {
+		t.Errorf("cache without metadata should be stale")
+	}
+	// A freshly refreshed cache of old prices is stale.
+	m = &Metadata{Refreshed: now, SourceDate: "2020-11-27"}
+	if err := CheckAge(m, 30*24*time.Hour, now); err == nil {
+		t.Errorf("prices published on 2020-11-27 should be stale")
+	}
+	m.SourceDate = "2021-02-20"
+	if err := CheckAge(m, 30*24*time.Hour, now); err != nil {
+		t.Errorf("prices published 9 days ago should not be stale: %v", err)
+	}
+	m.SourceDate = "last week"
+	if err := CheckAge(m, 30*24*time.Hour, now); err == nil {
+		t.Errorf("prices with an invalid date should be stale")
+	}
+}
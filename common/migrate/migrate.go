@@ -0,0 +1,154 @@
+// Versioned schema migrations for the provider caches.
+//
+// Each provider has an ordered list of steps. The steps that have been
+// applied to a cache are recorded in its SchemaVersion table, and Run
+// applies the ones that haven't. Once a step has been released it must
+// not be changed; add a new step instead.
+//
+// Each step runs in a transaction with the record of it, so a step that
+// fails leaves nothing behind. Caches created before migrations existed
+// have no SchemaVersion table, so steps have to be idempotent: CREATE
+// TABLE IF NOT EXISTS, and check with HasColumn before adding a column.
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Step struct {
+	// Steps are numbered 1, 2, 3, ...
+	Version     int
+	Description string
+	Apply       func(*sql.Tx) error
+}
+
+// What *sql.DB and *sql.Tx have in common, for helpers that are used in
+// steps and outside of them.
+type DB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// An applied step, as recorded in the SchemaVersion table.
+type Applied struct {
+	Version     int
+	Description string
+	Applied     time.Time
+}
+
+func createVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS SchemaVersion (
+		"Version" INTEGER NOT NULL PRIMARY KEY,
+		"Description" TEXT,
+		"AppliedTS" INTEGER NOT NULL
+	);`)
+	return err
+}
+
+// Returns the steps applied to the database, oldest first.
+func History(db *sql.DB) ([]Applied, error) {
+	if err := createVersionTable(db); err != nil {
+		return nil, err
+	}
+	res, err := db.Query(`SELECT Version, IFNULL(Description, ''), AppliedTS
+	FROM SchemaVersion ORDER BY Version`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	history := make([]Applied, 0)
+	for res.Next() {
+		var a Applied
+		var ts int64
+		if err = res.Scan(&a.Version, &a.Description, &ts); err != nil {
+			return nil, err
+		}
+		a.Applied = time.Unix(ts, 0)
+		history = append(history, a)
+	}
+	return history, res.Err()
+}
+
+// Returns the schema version of the database, 0 if no step was applied.
+// Doesn't write to the database, so it works on read-only ones.
+func Version(db *sql.DB) (int, error) {
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+	WHERE type='table' AND name='SchemaVersion'`).Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	var v int
+	err := db.QueryRow(`SELECT IFNULL(MAX(Version), 0) FROM SchemaVersion`).Scan(&v)
+	return v, err
+}
+
+// Returns the version the steps migrate to.
+func Latest(steps []Step) int {
+	if len(steps) == 0 {
+		return 0
+	}
+	return steps[len(steps)-1].Version
+}
+
+// Applies the steps that haven't been applied to the database yet, in order.
+func Run(db *sql.DB, steps []Step) error {
+	for i, s := range steps {
+		if s.Version != i+1 {
+			return fmt.Errorf("migration step %d (%s) is out of order", s.Version, s.Description)
+		}
+	}
+	if err := createVersionTable(db); err != nil {
+		return err
+	}
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if current > Latest(steps) {
+		return fmt.Errorf("database schema version %d is newer than %d, please upgrade nephomancy",
+			current, Latest(steps))
+	}
+	for _, s := range steps[current:] {
+		if err = apply(db, s); err != nil {
+			return fmt.Errorf("migration to schema version %d (%s) failed: %v",
+				s.Version, s.Description, err)
+		}
+	}
+	return nil
+}
+
+// Applies a step and records it in one transaction.
+func apply(db *sql.DB, s Step) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = s.Apply(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO SchemaVersion(Version, Description, AppliedTS)
+	VALUES (?, ?, ?)`, s.Version, s.Description, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns whether a table has a column.
+func HasColumn(db DB, table string, column string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`,
+		table, column).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func openDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets its own database.
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func exec(stmt string) func(*sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmt)
+		return err
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := openDb(t)
+	defer db.Close()
+	steps := []Step{
+		{Version: 1, Description: "create", Apply: exec(`CREATE TABLE IF NOT EXISTS T ("A" TEXT)`)},
+	}
+	if err := Run(db, steps); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	addB := func(tx *sql.Tx) error {
+		has, err := HasColumn(tx, "T", "B")
+		if err != nil || has {
+			return err
+		}
+		return exec(`ALTER TABLE T ADD COLUMN "B" TEXT`)(tx)
+	}
+	steps = append(steps, Step{Version: 2, Description: "add B", Apply: addB})
+	// Running twice must not apply step 2 twice.
+	for i := 0; i < 2; i++ {
+		if err := Run(db, steps); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+	}
+	if has, err := HasColumn(db, "T", "B"); !has || err != nil {
+		t.Errorf("column B was not added: %v", err)
+	}
+	history, err := History(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].Description != "add B" {
+		t.Errorf("unexpected history %+v", history)
+	}
+	if v, err := Version(db); v != 2 || err != nil {
+		t.Errorf("expected version 2 but got %d (%v)", v, err)
+	}
+	// An older nephomancy doesn't know about step 2.
+	if err = Run(db, steps[:1]); err == nil {
+		t.Errorf("expected error when the schema is newer than the steps")
+	}
+}
+
+func TestRunStopsAtFailure(t *testing.T) {
+	db := openDb(t)
+	defer db.Close()
+	steps := []Step{
+		{Version: 1, Description: "create", Apply: exec(`CREATE TABLE T ("A" TEXT)`)},
+		{Version: 2, Description: "fail", Apply: func(tx *sql.Tx) error {
+			if err := exec(`CREATE TABLE V ("A" TEXT)`)(tx); err != nil {
+				return err
+			}
+			return fmt.Errorf("oops")
+		}},
+		{Version: 3, Description: "never", Apply: exec(`CREATE TABLE U ("A" TEXT)`)},
+	}
+	if err := Run(db, steps); err == nil {
+		t.Fatalf("expected migration to fail")
+	}
+	if v, err := Version(db); v != 1 || err != nil {
+		t.Errorf("expected version 1 but got %d (%v)", v, err)
+	}
+	// What the failed step did is rolled back.
+	if has, err := HasColumn(db, "V", "A"); has || err != nil {
+		t.Errorf("expected table V to be rolled back (%v)", err)
+	}
+}
+
+func TestRunRejectsBadOrder(t *testing.T) {
+	db := openDb(t)
+	defer db.Close()
+	steps := []Step{
+		{Version: 2, Description: "create", Apply: exec(`CREATE TABLE T ("A" TEXT)`)},
+	}
+	if err := Run(db, steps); err == nil {
+		t.Errorf("expected error for misnumbered step")
+	}
+}
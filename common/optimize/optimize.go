@@ -0,0 +1,331 @@
+// Package optimize places the resource sets of a project with the
+// providers and in the regions where they are cheapest.
+package optimize
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"math"
+	"nephomancy/common/registry"
+	"nephomancy/common/resources"
+	"nephomancy/common/utils"
+)
+
+type Options struct {
+	// Keep resource sets with the same location spec in the same
+	// region, e.g. disks with the VMs they are attached to.
+	SameRegion bool
+	// Place everything with one provider.
+	SingleProvider bool
+	// Currency that costs are compared in. Defaults to USD.
+	Currency string
+	// Exchange rates into Currency, e.g. "CHF": 1.08. Candidates priced
+	// in a currency without a rate are not considered.
+	Rates map[string]float64
+	// Goroutines to price candidates with, shared with the providers.
+	// Nil prices them one after another.
+	Pool *utils.Pool
+}
+
+// An initialized provider and its registry name.
+type Provider struct {
+	Name string
+	registry.Provider
+}
+
+// Where a group of resource sets ended up.
+type Placement struct {
+	// Names of the resource sets.
+	Sets     []string
+	Provider string
+	// Empty if the provider can't be told which region to use.
+	Region string
+	// Projected monthly cost in the options' currency.
+	Cost float64
+	// Projected monthly cost with the same provider's default placement.
+	DefaultCost float64
+}
+
+type Result struct {
+	// Copy of the input project with the chosen provider details.
+	Project    *resources.Project
+	Placements []Placement
+	// Why some providers could not place some resource sets.
+	Skipped []string
+}
+
+// Resource sets that are placed together, by index into the project.
+type group struct {
+	instanceSets []int
+	diskSets     []int
+	networks     []int
+	location     *resources.Location
+}
+
+// A provider filled in a group in a region, and what that costs.
+type candidate struct {
+	group    int
+	provider int
+	region   string
+	project  *resources.Project
+	cost     float64
+	err      error
+}
+
+// Finds the cheapest provider and region for each group of resource sets
+// in p. Provider details already in p are replaced.
+func Optimize(p *resources.Project, provs []Provider, opts Options) (*Result, error) {
+	if opts.Currency == "" {
+		opts.Currency = "USD"
+	}
+	groups := makeGroups(p, opts.SameRegion)
+	var cands []*candidate
+	for gi, g := range groups {
+		for pi, prov := range provs {
+			// Explicit regions come first, so they win ties with
+			// the default placement and show up in the summary.
+			if placer, ok := prov.Provider.(registry.RegionPlacer); ok && g.location != nil {
+				for _, r := range placer.Regions(g.location) {
+					cands = append(cands, &candidate{group: gi, provider: pi, region: r})
+				}
+			}
+			cands = append(cands, &candidate{group: gi, provider: pi})
+		}
+	}
+	// A candidate that can't be priced is skipped rather than failing the
+	// whole run, so its error stays with the candidate.
+	if err := opts.Pool.ForEach(len(cands), func(i int) error {
+		c := cands[i]
+		c.project, c.cost, c.err = price(p, groups[c.group], provs[c.provider], c.region, opts)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// best[g][p] is the cheapest candidate of provider p for group g,
+	// dflt[g][p] is its default placement.
+	best := make([][]*candidate, len(groups))
+	dflt := make([][]*candidate, len(groups))
+	lastErr := make([][]error, len(groups))
+	for gi := range groups {
+		best[gi] = make([]*candidate, len(provs))
+		dflt[gi] = make([]*candidate, len(provs))
+		lastErr[gi] = make([]error, len(provs))
+	}
+	for _, c := range cands {
+		if c.err != nil {
+			lastErr[c.group][c.provider] = c.err
+			continue
+		}
+		if c.region == "" {
+			dflt[c.group][c.provider] = c
+		}
+		if b := best[c.group][c.provider]; b == nil || c.cost < b.cost {
+			best[c.group][c.provider] = c
+		}
+	}
+
+	ret := &Result{Project: proto.Clone(p).(*resources.Project)}
+	for gi, g := range groups {
+		for pi, prov := range provs {
+			if best[gi][pi] == nil {
+				ret.Skipped = append(ret.Skipped, fmt.Sprintf(
+					"provider %s can't place %v: %v", prov.Name,
+					g.names(p), lastErr[gi][pi]))
+			}
+		}
+	}
+
+	chosen := make([]*candidate, len(groups))
+	if opts.SingleProvider {
+		total := math.Inf(1)
+		for pi := range provs {
+			sum := 0.0
+			for gi := range groups {
+				if best[gi][pi] == nil {
+					sum = math.Inf(1)
+					break
+				}
+				sum += best[gi][pi].cost
+			}
+			if sum < total {
+				total = sum
+				for gi := range groups {
+					chosen[gi] = best[gi][pi]
+				}
+			}
+		}
+		if len(groups) > 0 && math.IsInf(total, 1) {
+			return nil, fmt.Errorf("no single provider can place all resource sets")
+		}
+	} else {
+		for gi, g := range groups {
+			for pi := range provs {
+				if b := best[gi][pi]; b != nil && (chosen[gi] == nil || b.cost < chosen[gi].cost) {
+					chosen[gi] = b
+				}
+			}
+			if chosen[gi] == nil {
+				return nil, fmt.Errorf("no provider can place %v", g.names(p))
+			}
+		}
+	}
+
+	for gi, g := range groups {
+		c := chosen[gi]
+		for j, idx := range g.instanceSets {
+			ret.Project.InstanceSets[idx] = c.project.InstanceSets[j]
+		}
+		for j, idx := range g.diskSets {
+			ret.Project.DiskSets[idx] = c.project.DiskSets[j]
+		}
+		for j, idx := range g.networks {
+			ret.Project.Networks[idx] = c.project.Networks[j]
+		}
+		for name, details := range c.project.ProviderDetails {
+			if ret.Project.ProviderDetails == nil {
+				ret.Project.ProviderDetails = make(map[string]*anypb.Any)
+			}
+			if ret.Project.ProviderDetails[name] == nil {
+				ret.Project.ProviderDetails[name] = details
+			}
+		}
+		pl := Placement{
+			Sets:     g.names(p),
+			Provider: provs[c.provider].Name,
+			Region:   c.region,
+			Cost:     c.cost,
+		}
+		if d := dflt[gi][c.provider]; d != nil {
+			pl.DefaultCost = d.cost
+		} else {
+			pl.DefaultCost = c.cost
+		}
+		ret.Placements = append(ret.Placements, pl)
+	}
+	return ret, nil
+}
+
+// Fills in a copy of the group's resource sets with the provider, in
+// region if it isn't empty, and returns the copy and its projected cost.
+func price(p *resources.Project, g group, prov Provider, region string, opts Options) (
+	*resources.Project, float64, error) {
+	scratch := &resources.Project{
+		Name:            p.Name,
+		ProviderDetails: cloneDetails(p.ProviderDetails),
+	}
+	for _, idx := range g.instanceSets {
+		is := proto.Clone(p.InstanceSets[idx]).(*resources.InstanceSet)
+		is.Template.ProviderDetails = nil
+		scratch.InstanceSets = append(scratch.InstanceSets, is)
+	}
+	for _, idx := range g.diskSets {
+		ds := proto.Clone(p.DiskSets[idx]).(*resources.DiskSet)
+		ds.Template.ProviderDetails = nil
+		scratch.DiskSets = append(scratch.DiskSets, ds)
+	}
+	for _, idx := range g.networks {
+		nw := proto.Clone(p.Networks[idx]).(*resources.Network)
+		nw.ProviderDetails = nil
+		for _, snw := range nw.Subnetworks {
+			snw.ProviderDetails = nil
+			for _, gw := range snw.Gateways {
+				gw.ProviderDetails = nil
+			}
+		}
+		scratch.Networks = append(scratch.Networks, nw)
+	}
+	var err error
+	if region == "" {
+		err = prov.FillInProviderDetails(scratch)
+	} else {
+		err = prov.Provider.(registry.RegionPlacer).FillInProviderDetailsIn(scratch, region)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	lines, err := registry.GetCost(prov.Provider, scratch, opts.Pool)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, projected := utils.SumCosts(lines)
+	if len(projected) == 0 {
+		return nil, 0, fmt.Errorf("no prices")
+	}
+	cost := 0.0
+	for cur, amount := range projected {
+		rate := 1.0
+		if cur != opts.Currency {
+			var ok bool
+			if rate, ok = opts.Rates[cur]; !ok {
+				return nil, 0, fmt.Errorf("no exchange rate from %s to %s", cur, opts.Currency)
+			}
+		}
+		cost += amount * rate
+	}
+	return scratch, cost, nil
+}
+
+func cloneDetails(details map[string]*anypb.Any) map[string]*anypb.Any {
+	if details == nil {
+		return nil
+	}
+	ret := make(map[string]*anypb.Any)
+	for name, d := range details {
+		ret[name] = proto.Clone(d).(*anypb.Any)
+	}
+	return ret
+}
+
+// Puts every resource set in its own group, or, with sameRegion, the
+// resource sets with the same location spec in one group. Networks go
+// by the location of their first subnetwork.
+func makeGroups(p *resources.Project, sameRegion bool) []group {
+	var groups []group
+	byLocation := make(map[string]int)
+	add := func(loc *resources.Location) *group {
+		if !sameRegion || loc == nil {
+			groups = append(groups, group{location: loc})
+			return &groups[len(groups)-1]
+		}
+		key := resources.PrintLocation(loc)
+		if idx, ok := byLocation[key]; ok {
+			return &groups[idx]
+		}
+		byLocation[key] = len(groups)
+		groups = append(groups, group{location: loc})
+		return &groups[len(groups)-1]
+	}
+	for idx, is := range p.InstanceSets {
+		g := add(is.Template.Location)
+		g.instanceSets = append(g.instanceSets, idx)
+	}
+	for idx, ds := range p.DiskSets {
+		g := add(ds.Template.Location)
+		g.diskSets = append(g.diskSets, idx)
+	}
+	for idx, nw := range p.Networks {
+		var loc *resources.Location
+		if len(nw.Subnetworks) > 0 {
+			loc = nw.Subnetworks[0].Location
+		}
+		g := add(loc)
+		g.networks = append(g.networks, idx)
+	}
+	return groups
+}
+
+func (g group) names(p *resources.Project) []string {
+	var ret []string
+	for _, idx := range g.instanceSets {
+		ret = append(ret, p.InstanceSets[idx].Name)
+	}
+	for _, idx := range g.diskSets {
+		ret = append(ret, p.DiskSets[idx].Name)
+	}
+	for _, idx := range g.networks {
+		ret = append(ret, p.Networks[idx].Name)
+	}
+	return ret
+}
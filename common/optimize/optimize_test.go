@@ -0,0 +1,235 @@
+package optimize
+
+import (
+	"fmt"
+	"github.com/go-test/deep"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"nephomancy/common/metadata"
+	"nephomancy/common/resources"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// A provider with a price per resource set kind and region.
+type stubProvider struct {
+	name     string
+	currency string
+	// The region that plain fill-in uses.
+	dflt string
+	// Prices by "region/vm" and "region/disk".
+	prices map[string]float64
+	// On-demand prices of the vms, reported like the break-even line of
+	// a commitment, by region.
+	onDemand map[string]float64
+}
+
+func (s *stubProvider) Regions(*resources.Location) []string {
+	seen := make(map[string]bool)
+	var ret []string
+	for key := range s.prices {
+		r := strings.Split(key, "/")[0]
+		if !seen[r] {
+			seen[r] = true
+			ret = append(ret, r)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *stubProvider) FillInProviderDetails(p *resources.Project) error {
+	return s.FillInProviderDetailsIn(p, s.dflt)
+}
+
+func (s *stubProvider) FillInProviderDetailsIn(p *resources.Project, region string) error {
+	place := func(kind string, name string) (map[string]*anypb.Any, error) {
+		if _, ok := s.prices[region+"/"+kind]; !ok {
+			return nil, fmt.Errorf("no %s for %s in %s", kind, name, region)
+		}
+		details, err := anypb.New(wrapperspb.String(region))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*anypb.Any{s.name: details}, nil
+	}
+	var err error
+	for _, is := range p.InstanceSets {
+		if is.Template.ProviderDetails, err = place("vm", is.Name); err != nil {
+			return err
+		}
+	}
+	for _, ds := range p.DiskSets {
+		if ds.Template.ProviderDetails, err = place("disk", ds.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *stubProvider) GetCost(p *resources.Project) ([][]string, error) {
+	var lines [][]string
+	cost := func(kind string, name string, count uint32, details map[string]*anypb.Any) error {
+		var region wrapperspb.StringValue
+		if err := details[s.name].UnmarshalTo(&region); err != nil {
+			return err
+		}
+		price := s.prices[region.Value+"/"+kind] * float64(count)
+		lines = append(lines, []string{p.Name, s.name, name, "",
+			fmt.Sprintf("%.2f %s", price, s.currency), "",
+			fmt.Sprintf("%.2f %s", price, s.currency)})
+		if od, ok := s.onDemand[region.Value]; ok && kind == "vm" {
+			lines = append(lines, []string{p.Name, s.name, name,
+				fmt.Sprintf("on demand would cost %.2f %s", od*float64(count), s.currency),
+				"", "", ""})
+		}
+		return nil
+	}
+	for _, is := range p.InstanceSets {
+		if err := cost("vm", is.Name, is.Count, is.Template.ProviderDetails); err != nil {
+			return nil, err
+		}
+	}
+	for _, ds := range p.DiskSets {
+		if err := cost("disk", ds.Name, ds.Count, ds.Template.ProviderDetails); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+func (s *stubProvider) Initialize(string) error                            { return nil }
+func (s *stubProvider) InitializeReadOnly(string) error                    { return nil }
+func (s *stubProvider) CacheFile() string                                  { return "" }
+func (s *stubProvider) UseSnapshot(string) error                           { return nil }
+func (s *stubProvider) GetPriceChanges(string, string) ([][]string, error) { return nil, nil }
+func (s *stubProvider) GetCacheMetadata() (*metadata.Metadata, error)      { return nil, nil }
+func (s *stubProvider) GetSchemaVersion() (int, int, error)                { return 0, 0, nil }
+
+func testProject() *resources.Project {
+	loc := &resources.Location{GlobalRegion: "EMEA", Continent: "Europe"}
+	return &resources.Project{
+		Name: "test",
+		InstanceSets: []*resources.InstanceSet{{
+			Name:     "web",
+			Template: &resources.Instance{Location: loc},
+			Count:    2,
+		}},
+		DiskSets: []*resources.DiskSet{{
+			Name:     "data",
+			Template: &resources.Disk{Location: loc},
+			Count:    1,
+		}},
+	}
+}
+
+func testProviders() []Provider {
+	return []Provider{
+		{"alpha", &stubProvider{name: "alpha", currency: "USD", dflt: "a1",
+			prices: map[string]float64{"a1/vm": 10, "a1/disk": 5, "a2/vm": 8, "a2/disk": 6}}},
+		{"beta", &stubProvider{name: "beta", currency: "USD", dflt: "b1",
+			prices: map[string]float64{"b1/vm": 12, "b1/disk": 1}}},
+	}
+}
+
+func TestOptimizePerSet(t *testing.T) {
+	res, err := Optimize(testProject(), testProviders(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Placement{
+		{Sets: []string{"web"}, Provider: "alpha", Region: "a2", Cost: 16, DefaultCost: 20},
+		{Sets: []string{"data"}, Provider: "beta", Region: "b1", Cost: 1, DefaultCost: 1},
+	}
+	if diff := deep.Equal(res.Placements, want); diff != nil {
+		t.Error(diff)
+	}
+	names := resources.GetProviderNames(res.Project)
+	if len(names) != 2 {
+		t.Errorf("expected a project with both providers, got %v", names)
+	}
+	if res.Project.DiskSets[0].Template.ProviderDetails["beta"] == nil {
+		t.Errorf("expected the disk set to be placed with beta, got %v", res.Project.DiskSets[0])
+	}
+}
+
+// Commitments come with the on-demand cost for comparison, which must
+// not count towards the cost of the candidate.
+func TestOptimizeCommitment(t *testing.T) {
+	provs := testProviders()
+	provs[0].Provider.(*stubProvider).onDemand = map[string]float64{"a1": 30, "a2": 30}
+	res, err := Optimize(testProject(), provs, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl := res.Placements[0]; pl.Provider != "alpha" || pl.Region != "a2" || pl.Cost != 16 {
+		t.Errorf("expected web with alpha in a2 for 16 USD, got %v", pl)
+	}
+}
+
+func TestOptimizeSameRegion(t *testing.T) {
+	res, err := Optimize(testProject(), testProviders(), Options{SameRegion: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alpha: a1 costs 25, a2 costs 22; beta: b1 costs 25.
+	want := []Placement{
+		{Sets: []string{"web", "data"}, Provider: "alpha", Region: "a2", Cost: 22, DefaultCost: 25},
+	}
+	if diff := deep.Equal(res.Placements, want); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestOptimizeSingleProvider(t *testing.T) {
+	provs := testProviders()
+	provs[1].Provider.(*stubProvider).prices["b1/vm"] = 4
+	// Per set, web would go to beta and data to alpha. Together they
+	// cost 9 with beta and 16.5 with alpha.
+	provs[0].Provider.(*stubProvider).prices["a1/disk"] = 0.5
+	res, err := Optimize(testProject(), provs, Options{SingleProvider: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pl := range res.Placements {
+		if pl.Provider != "beta" {
+			t.Errorf("expected everything with beta, got %v", res.Placements)
+		}
+	}
+}
+
+func TestOptimizeCurrencies(t *testing.T) {
+	provs := testProviders()
+	provs[1].Provider.(*stubProvider).currency = "CHF"
+	res, err := Optimize(testProject(), provs, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Placements[1].Provider != "alpha" {
+		t.Errorf("expected beta to be skipped without an exchange rate, got %v", res.Placements)
+	}
+	if len(res.Skipped) != 2 || !strings.Contains(res.Skipped[0], "no exchange rate from CHF to USD") {
+		t.Errorf("unexpected skipped providers: %v", res.Skipped)
+	}
+
+	res, err = Optimize(testProject(), provs, Options{Rates: map[string]float64{"CHF": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl := res.Placements[1]; pl.Provider != "beta" || pl.Cost != 2 {
+		t.Errorf("expected the disks with beta for 2 USD, got %v", pl)
+	}
+}
+
+func TestOptimizeNowhere(t *testing.T) {
+	provs := testProviders()
+	for _, p := range provs {
+		p.Provider.(*stubProvider).prices = map[string]float64{"x/disk": 1}
+		p.Provider.(*stubProvider).dflt = "x"
+	}
+	if _, err := Optimize(testProject(), provs, Options{}); err == nil ||
+		!strings.Contains(err.Error(), "no provider can place [web]") {
+		t.Errorf("expected web to be impossible to place, got %v", err)
+	}
+}
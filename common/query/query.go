@@ -0,0 +1,88 @@
+// Parameterised SQL for the provider caches.
+//
+// Values from project files must never be pasted into SQL text. A Builder
+// keeps the SQL and the values apart: fragments are added with ?
+// placeholders, and the values are passed to the database as arguments.
+package query
+
+import (
+	"database/sql"
+	"strings"
+)
+
+type Builder struct {
+	sql  strings.Builder
+	args []interface{}
+}
+
+// Starts a query. The SQL may contain ? placeholders for the args.
+func New(sql string, args ...interface{}) *Builder {
+	b := &Builder{}
+	return b.Add(sql, args...)
+}
+
+// Appends a fragment with ? placeholders for the args.
+func (b *Builder) Add(sql string, args ...interface{}) *Builder {
+	b.sql.WriteString(sql)
+	b.args = append(b.args, args...)
+	return b
+}
+
+// Appends "<prefix> column IN (?, ?, ...)" with one placeholder per value.
+// An empty list of values matches nothing.
+func (b *Builder) In(prefix string, column string, values []string) *Builder {
+	if len(values) == 0 {
+		return b.Add(prefix + " 0")
+	}
+	b.sql.WriteString(prefix)
+	b.sql.WriteString(" ")
+	b.sql.WriteString(column)
+	b.sql.WriteString(" IN (")
+	b.sql.WriteString(Placeholders(len(values)))
+	b.sql.WriteString(")")
+	for _, v := range values {
+		b.args = append(b.args, v)
+	}
+	return b
+}
+
+// Appends "<prefix> (column LIKE ? OR column LIKE ? ...)" with one
+// placeholder per pattern. An empty list of patterns matches nothing.
+func (b *Builder) AnyLike(prefix string, column string, patterns []string) *Builder {
+	if len(patterns) == 0 {
+		return b.Add(prefix + " 0")
+	}
+	b.sql.WriteString(prefix)
+	b.sql.WriteString(" (")
+	for i, p := range patterns {
+		if i > 0 {
+			b.sql.WriteString(" OR ")
+		}
+		b.sql.WriteString(column)
+		b.sql.WriteString(" LIKE ?")
+		b.args = append(b.args, p)
+	}
+	b.sql.WriteString(")")
+	return b
+}
+
+func (b *Builder) String() string {
+	return b.sql.String()
+}
+
+func (b *Builder) Args() []interface{} {
+	return b.args
+}
+
+// Runs the query.
+func (b *Builder) Query(db *sql.DB) (*sql.Rows, error) {
+	return db.Query(b.String(), b.args...)
+}
+
+// Returns "?, ?, ?" with n placeholders.
+func Placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
@@ -0,0 +1,21 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestBuilder(t *testing.T) {
+	q := New("SELECT a FROM T WHERE b=?", 1)
+	q.In(" AND", "c", []string{"x", "y"})
+	q.AnyLike(" AND", "d", []string{"%x", "y%"})
+	q.In(" AND", "e", nil)
+	expected := "SELECT a FROM T WHERE b=? AND c IN (?, ?) AND (d LIKE ? OR d LIKE ?) AND 0"
+	if q.String() != expected {
+		t.Errorf("expected %q but got %q", expected, q.String())
+	}
+	if diff := deep.Equal(q.Args(), []interface{}{1, "x", "y", "%x", "y%"}); diff != nil {
+		t.Errorf("unexpected args: %v", diff)
+	}
+}
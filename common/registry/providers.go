@@ -2,13 +2,76 @@ package registry
 
 import (
 	"fmt"
+	"nephomancy/common/metadata"
 	"nephomancy/common/resources"
+	"nephomancy/common/utils"
 )
 
 type Provider interface {
 	FillInProviderDetails(*resources.Project) error
 	GetCost(*resources.Project) ([][]string, error)
 	Initialize(datadir string) error
+	// Opens the price cache read-only, without creating or migrating
+	// it, for commands that only read the file. Call this instead of
+	// Initialize.
+	InitializeReadOnly(datadir string) error
+	// Returns the filename of the price cache in the provider's
+	// directory under the data directory.
+	CacheFile() string
+	// Switches to the price snapshot taken on the given date (YYYY-MM-DD),
+	// or the newest one before it. Call this after Initialize.
+	UseSnapshot(date string) error
+	// Lists skus whose rates changed between two snapshot dates.
+	GetPriceChanges(from string, to string) ([][]string, error)
+	// Returns when the cache was refreshed and where its prices are from,
+	// or nil if that is not known.
+	GetCacheMetadata() (*metadata.Metadata, error)
+	// Returns the schema version of the cache and the latest schema version.
+	GetSchemaVersion() (int, int, error)
+}
+
+// A machine type offered by a provider.
+type MachineType struct {
+	Name string
+	// The cpu count a spec has to ask for to get this machine type.
+	CpuCount uint32
+	MemoryMb uint64
+	// Regions where the machine type is available.
+	Regions []string
+}
+
+// Implemented by providers that have a fixed list of machine types, so
+// users can be shown the ones that match what they ask for.
+type MachineTypeLister interface {
+	// Lists the machine types that fill-in would consider for the spec
+	// in the location, smallest first.
+	ListMachineTypes(spec *resources.MachineType, loc *resources.Location) ([]MachineType, error)
+}
+
+// Implemented by providers that can be told which region to use, so
+// placements in different regions can be compared.
+type RegionPlacer interface {
+	// Lists the regions consistent with the location.
+	Regions(loc *resources.Location) []string
+	// Like FillInProviderDetails, but places everything in the region.
+	FillInProviderDetailsIn(p *resources.Project, region string) error
+}
+
+// Implemented by providers that price the resource sets of a project
+// concurrently. They take their goroutines from the caller's pool, so
+// that pricing several projects or providers at once stays within its
+// limit.
+type ConcurrentPricer interface {
+	GetCostIn(p *resources.Project, pool *utils.Pool) ([][]string, error)
+}
+
+// Gets the costs of the project from the provider, with goroutines from
+// the pool if the provider can use them.
+func GetCost(prov Provider, p *resources.Project, pool *utils.Pool) ([][]string, error) {
+	if cp, ok := prov.(ConcurrentPricer); ok {
+		return cp.GetCostIn(p, pool)
+	}
+	return prov.GetCost(p)
 }
 
 var Registry map[string]Provider
@@ -46,4 +109,28 @@ func (emptyProvider) Initialize(string) error {
 	return nil
 }
 
+func (emptyProvider) InitializeReadOnly(string) error {
+	return nil
+}
+
+func (emptyProvider) CacheFile() string {
+	return ""
+}
+
+func (emptyProvider) UseSnapshot(string) error {
+	return nil
+}
+
+func (emptyProvider) GetPriceChanges(string, string) ([][]string, error) {
+	return nil, nil
+}
+
+func (emptyProvider) GetCacheMetadata() (*metadata.Metadata, error) {
+	return nil, nil
+}
+
+func (emptyProvider) GetSchemaVersion() (int, int, error) {
+	return 0, 0, nil
+}
+
 var dummyProvider emptyProvider
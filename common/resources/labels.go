@@ -0,0 +1,74 @@
+package resources
+
+import (
+	"sort"
+)
+
+// Costs for resource sets that don't have the label (and whose project
+// doesn't have it either) are reported under this value.
+const UnlabelledGroup = "(unlabelled)"
+
+// A LabelGroup is the part of a project whose resource sets have the
+// same value for a label key.
+type LabelGroup struct {
+	Value   string
+	Project *Project
+}
+
+func labelValue(labels map[string]string, defaults map[string]string, key string) string {
+	if v, ok := labels[key]; ok {
+		return v
+	}
+	if v, ok := defaults[key]; ok {
+		return v
+	}
+	return UnlabelledGroup
+}
+
+// Splits a project into one project per value of the label key. Resource
+// sets without the label inherit the project's label. The returned projects
+// share their resource sets and provider details with p, so they should not be
+// modified. Groups are sorted by label value, with the unlabelled group last.
+func GroupByLabel(p *Project, key string) []LabelGroup {
+	groups := make(map[string]*Project)
+	group := func(value string) *Project {
+		if groups[value] == nil {
+			groups[value] = &Project{
+				Name:            p.Name,
+				ProviderDetails: p.ProviderDetails,
+				Labels:          p.Labels,
+			}
+		}
+		return groups[value]
+	}
+	for _, is := range p.InstanceSets {
+		g := group(labelValue(is.Labels, p.Labels, key))
+		g.InstanceSets = append(g.InstanceSets, is)
+	}
+	for _, ds := range p.DiskSets {
+		g := group(labelValue(ds.Labels, p.Labels, key))
+		g.DiskSets = append(g.DiskSets, ds)
+	}
+	for _, nw := range p.Networks {
+		g := group(labelValue(nw.Labels, p.Labels, key))
+		g.Networks = append(g.Networks, nw)
+	}
+	values := make([]string, 0, len(groups))
+	for v := range groups {
+		if v != UnlabelledGroup {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	if groups[UnlabelledGroup] != nil {
+		values = append(values, UnlabelledGroup)
+	}
+	ret := make([]LabelGroup, len(values))
+	for idx, v := range values {
+		ret[idx] = LabelGroup{
+			Value:   v,
+			Project: groups[v],
+		}
+	}
+	return ret
+}
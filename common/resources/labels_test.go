@@ -0,0 +1,43 @@
+package resources
+
+import (
+	"testing"
+)
+
+func TestGroupByLabel(t *testing.T) {
+	p := &Project{
+		Name:   "labelled",
+		Labels: map[string]string{"team": "platform"},
+		InstanceSets: []*InstanceSet{
+			&InstanceSet{Name: "a", Labels: map[string]string{"team": "web"}},
+			&InstanceSet{Name: "b"},
+			&InstanceSet{Name: "c", Labels: map[string]string{"team": "web"}},
+		},
+		DiskSets: []*DiskSet{
+			&DiskSet{Name: "d", Labels: map[string]string{"team": "data"}},
+		},
+		Networks: []*Network{
+			&Network{Name: "n"},
+		},
+	}
+	groups := GroupByLabel(p, "team")
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups but got %d: %+v\n", len(groups), groups)
+	}
+	if groups[0].Value != "data" || len(groups[0].Project.DiskSets) != 1 {
+		t.Errorf("expected group data with one disk set but got %+v\n", groups[0])
+	}
+	if groups[1].Value != "platform" || len(groups[1].Project.InstanceSets) != 1 ||
+		len(groups[1].Project.Networks) != 1 {
+		t.Errorf("expected group platform with one instance set and one network but got %+v\n",
+			groups[1])
+	}
+	if groups[2].Value != "web" || len(groups[2].Project.InstanceSets) != 2 {
+		t.Errorf("expected group web with two instance sets but got %+v\n", groups[2])
+	}
+
+	groups = GroupByLabel(p, "cost-center")
+	if len(groups) != 1 || groups[0].Value != UnlabelledGroup {
+		t.Errorf("expected only the unlabelled group but got %+v\n", groups)
+	}
+}
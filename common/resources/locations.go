@@ -23,7 +23,7 @@ func CountryCodeToLocation(cc string) (Location, error) {
 }
 
 // Returns nil if loc is consistent with spec, an error otherwise.
-func CheckLocation(loc Location, spec Location) error {
+func CheckLocation(loc *Location, spec *Location) error {
 	if spec.GlobalRegion != "" && spec.GlobalRegion != loc.GlobalRegion {
 		return fmt.Errorf("spec global region %s does not match details: %s",
 			spec.GlobalRegion, loc.GlobalRegion)
@@ -39,7 +39,7 @@ func CheckLocation(loc Location, spec Location) error {
 	return nil
 }
 
-func LocationEqual(loc1 Location, loc2 Location) bool {
+func LocationEqual(loc1 *Location, loc2 *Location) bool {
 	return loc1.GlobalRegion == loc2.GlobalRegion &&
 		loc1.Continent == loc2.Continent &&
 		loc1.CountryCode == loc2.CountryCode
@@ -41,19 +41,19 @@ func TestCountryCodeToLocaton(t *testing.T) {
 		if err != nil {
 			t.Errorf("failed to get location for country code %s\n", cc)
 		}
-		if !LocationEqual(l, locs[idx]) {
-			t.Errorf("wrong location %v for country code %s\n", l, cc)
+		if !LocationEqual(&l, &locs[idx]) {
+			t.Errorf("wrong location %v for country code %s\n", &l, cc)
 		}
 	}
 }
 
 func TestCheckLocation(t *testing.T) {
-	loc := Location{
+	loc := &Location{
 		GlobalRegion: geo.EMEA.String(),
 		Continent:    geo.Europe.String(),
 		CountryCode:  "CH",
 	}
-	spec := Location{
+	spec := &Location{
 		GlobalRegion: geo.EMEA.String(),
 	}
 	if err := CheckLocation(loc, spec); err != nil {
@@ -71,13 +71,13 @@ func TestCheckLocation(t *testing.T) {
 	if err := CheckLocation(loc, spec); err == nil {
 		t.Errorf("%v should not match %v\n", loc, spec)
 	}
-	spec = Location{
+	spec = &Location{
 		GlobalRegion: geo.APAC.String(),
 	}
 	if err := CheckLocation(loc, spec); err == nil {
 		t.Errorf("%v should not match %v\n", loc, spec)
 	}
-	spec = Location{
+	spec = &Location{
 		GlobalRegion: geo.EMEA.String(),
 		Continent:    geo.Africa.String(),
 	}
@@ -1,19 +1,32 @@
 package resources
 
 import (
+	"google.golang.org/protobuf/types/known/anypb"
 	"log"
 	"nephomancy/common/geo"
 )
 
-func GetProviderNames(p Project) []string {
+func GetProviderNames(p *Project) []string {
 	providers := make(map[string]bool)
+	add := func(details map[string](*anypb.Any)) {
+		for pname, _ := range details {
+			providers[pname] = true
+		}
+	}
 	for _, is := range p.InstanceSets {
 		if is.Template != nil {
-			if is.Template.ProviderDetails != nil {
-				for pname, _ := range is.Template.ProviderDetails {
-					providers[pname] = true
-				}
-			}
+			add(is.Template.ProviderDetails)
+		}
+	}
+	for _, ds := range p.DiskSets {
+		if ds.Template != nil {
+			add(ds.Template.ProviderDetails)
+		}
+	}
+	for _, nw := range p.Networks {
+		add(nw.ProviderDetails)
+		for _, snw := range nw.Subnetworks {
+			add(snw.ProviderDetails)
 		}
 	}
 	ret := make([]string, len(providers))
@@ -25,6 +38,13 @@ func GetProviderNames(p Project) []string {
 	return ret
 }
 
+// Returns true if the details are for other providers only, which means
+// the resource has been placed with one of them and the named provider
+// should leave it alone.
+func OtherProviders(name string, details map[string](*anypb.Any)) bool {
+	return len(details) > 0 && details[name] == nil
+}
+
 func ResolveLocation(where string) *Location {
 	region := geo.RegionFromString(where)
 	if region != geo.UnknownG {
@@ -49,7 +69,7 @@ func ResolveLocation(where string) *Location {
 	return nil
 }
 
-func MakeSampleProject(where string) Project {
+func MakeSampleProject(where string) *Project {
 	var loc *Location
 	if where == "" {
 		loc = sampleLocation()
@@ -60,13 +80,12 @@ func MakeSampleProject(where string) Project {
 			loc = sampleLocation()
 		}
 	}
-	ret := Project{
+	return &Project{
 		Name:         "Nephomancy sample project",
 		InstanceSets: []*InstanceSet{makeSampleInstanceSet(loc)},
 		DiskSets:     []*DiskSet{makeSampleDiskSet(loc)},
 		Networks:     []*Network{makeSampleNetwork(loc)},
 	}
-	return ret
 }
 
 func sampleLocation() *Location {
@@ -20,8 +20,8 @@ func TestMakeSampleProject(t *testing.T) {
 		Multiline: true,
 		Indent:    " ",
 	}
-	if options.Format(wantedProject) != options.Format(&actual) {
+	if options.Format(wantedProject) != options.Format(actual) {
 		t.Errorf("wanted %s but got %s", options.Format(wantedProject),
-			options.Format(&actual))
+			options.Format(actual))
 	}
 }
@@ -2,9 +2,10 @@ package resources
 
 import (
 	"fmt"
+	"strings"
 )
 
-func PrintLocation(l Location) string {
+func PrintLocation(l *Location) string {
 	if l.CountryCode != "" {
 		if l.Continent != "" {
 			return fmt.Sprintf("%s, %s", l.CountryCode, l.Continent)
@@ -23,20 +24,83 @@ func PrintLocation(l Location) string {
 	return "Probably somewhere on Earth"
 }
 
-func PrintMachineType(m MachineType) string {
+func PrintMachineType(m *MachineType) string {
+	var ret string
 	if m.GpuCount > 0 {
-		return fmt.Sprintf("%d gpus, %d cpus, %d gb memory",
+		ret = fmt.Sprintf("%d gpus, %d cpus, %d gb memory",
 			m.GpuCount, m.CpuCount, m.MemoryGb)
 	} else {
-		return fmt.Sprintf("%d cpus, %d gb memory",
+		ret = fmt.Sprintf("%d cpus, %d gb memory",
 			m.CpuCount, m.MemoryGb)
 	}
+	if m.CpuArchitecture != "" {
+		ret = fmt.Sprintf("%s, %s", ret, m.CpuArchitecture)
+	}
+	if m.LocalSsd {
+		if m.LocalSsdInterface != "" {
+			ret = fmt.Sprintf("%s, local %s ssd", ret, m.LocalSsdInterface)
+		} else {
+			ret = fmt.Sprintf("%s, local ssd", ret)
+		}
+	}
+	if m.NetworkGbps > 0 {
+		ret = fmt.Sprintf("%s, %d gbps network", ret, m.NetworkGbps)
+	}
+	return ret
+}
+
+// Returns true if the machine type spec asks for an ARM cpu, e.g.
+// "ARM", "arm64", "aarch64".
+func IsArmArchitecture(arch string) bool {
+	a := strings.ToLower(arch)
+	return a == "arm" || a == "arm64" || a == "aarch64"
+}
+
+// Returns true if the machine type spec asks for an x86 cpu, e.g.
+// "x86", "x86_64", "amd64".
+func IsX86Architecture(arch string) bool {
+	a := strings.ToLower(arch)
+	return a == "x86" || a == "x86_64" || a == "amd64"
+}
+
+// Checks the parts of the machine type spec that are plain strings.
+func CheckMachineType(m *MachineType) error {
+	if m.CpuArchitecture != "" && !IsArmArchitecture(m.CpuArchitecture) &&
+		!IsX86Architecture(m.CpuArchitecture) {
+		return fmt.Errorf("unknown cpu architecture %s, should be x86 or ARM",
+			m.CpuArchitecture)
+	}
+	if m.LocalSsdInterface != "" {
+		i := strings.ToLower(m.LocalSsdInterface)
+		if i != "nvme" && i != "scsi" {
+			return fmt.Errorf("unknown local ssd interface %s, should be NVMe or SCSI",
+				m.LocalSsdInterface)
+		}
+	}
+	return nil
+}
+
+// Returns an error unless po is one of the purchase options
+// OnDemand, Spot, Commit1Yr, Commit3Yr, or empty.
+func CheckPurchaseOption(po string) error {
+	switch po {
+	case "", "OnDemand", "Spot", "Commit1Yr", "Commit3Yr":
+		return nil
+	}
+	return fmt.Errorf("unknown purchase option %s, should be one of OnDemand, Spot, Commit1Yr, Commit3Yr", po)
 }
 
-func PrintDiskType(d DiskType) string {
+func PrintDiskType(d *DiskType) string {
+	tech := "non-SSD"
 	if d.DiskTech == "SSD" {
-		return fmt.Sprintf("%d GB of SSD", d.SizeGb)
-	} else {
-		return fmt.Sprintf("%d GB of non-SSD", d.SizeGb)
+		tech = "SSD"
+	}
+	ret := fmt.Sprintf("%d GB of %s", d.SizeGb, tech)
+	if d.Iops > 0 {
+		ret += fmt.Sprintf(", %d IOPS", d.Iops)
+	}
+	if d.ThroughputMibps > 0 {
+		ret += fmt.Sprintf(", %d MiB/s", d.ThroughputMibps)
 	}
+	return ret
 }
@@ -0,0 +1,18 @@
+package resources
+
+import (
+	"testing"
+)
+
+func TestCheckPurchaseOption(t *testing.T) {
+	for _, po := range []string{"", "OnDemand", "Spot", "Commit1Yr", "Commit3Yr"} {
+		if err := CheckPurchaseOption(po); err != nil {
+			t.Errorf("purchase option %s should be valid: %v\n", po, err)
+		}
+	}
+	for _, po := range []string{"Preemptible", "Reserved", "spot"} {
+		if err := CheckPurchaseOption(po); err == nil {
+			t.Errorf("purchase option %s should be invalid\n", po)
+		}
+	}
+}
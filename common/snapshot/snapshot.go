@@ -0,0 +1,161 @@
+// Dated snapshots of a provider's price cache.
+//
+// Every provider keeps its sqlite cache in a directory under the data
+// directory. Each time the cache is (re-)built, a copy of it is saved as
+// snapshots/<YYYY-MM-DD>.db in that directory, so that price changes can be
+// tracked and old estimates reproduced. There is at most one snapshot
+// per day; running init twice on the same day replaces that day's snapshot.
+package snapshot
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nephomancy/common/migrate"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Snapshot dates look like 2021-01-31.
+const DateFormat = "2006-01-02"
+
+const subdir = "snapshots"
+
+const suffix = ".db"
+
+// Saves a copy of the database as the snapshot for the day of t in dir.
+// Returns the filename of the snapshot.
+func Save(db *sql.DB, dir string, t time.Time) (string, error) {
+	sdir := filepath.Join(dir, subdir)
+	if err := os.MkdirAll(sdir, 0777); err != nil {
+		return "", err
+	}
+	fname := filepath.Join(sdir, t.Format(DateFormat)+suffix)
+	// VACUUM INTO refuses to overwrite an existing file.
+	if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if _, err := db.Exec(`VACUUM INTO ?`, fname); err != nil {
+		return "", fmt.Errorf("failed to save snapshot %s: %v", fname, err)
+	}
+	return fname, nil
+}
+
+// Lists the dates of all snapshots in dir, oldest first.
+func List(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(dir, subdir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dates := make([]string, 0, len(files))
+	for _, f := range files {
+		date := strings.TrimSuffix(f.Name(), suffix)
+		if f.IsDir() || date == f.Name() {
+			continue
+		}
+		if _, err := time.Parse(DateFormat, date); err != nil {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates, nil
+}
+
+// Finds the newest snapshot in dir that was taken on or before the
+// given date. Returns the snapshot's date and filename.
+func Find(dir string, asOf string) (string, string, error) {
+	if _, err := time.Parse(DateFormat, asOf); err != nil {
+		return "", "", fmt.Errorf("bad date %q, expected YYYY-MM-DD", asOf)
+	}
+	dates, err := List(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for i := len(dates) - 1; i >= 0; i-- {
+		if dates[i] <= asOf {
+			return dates[i], filepath.Join(dir, subdir, dates[i]+suffix), nil
+		}
+	}
+	return "", "", fmt.Errorf("no price snapshot from %s or earlier in %s", asOf, dir)
+}
+
+// Opens the newest snapshot taken on or before the given date, read-only.
+// Snapshots aren't migrated, so one with a schema version other than
+// latest, the version of the cache, can't be read.
+func Open(dir string, asOf string, latest int) (*sql.DB, error) {
+	_, fname, err := Find(dir, asOf)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+fname+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		return nil, fmt.Errorf("Failed to open snapshot at %s\n", fname)
+	}
+	v, err := migrate.Version(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read the schema version of snapshot %s: %v", fname, err)
+	}
+	if v != latest {
+		db.Close()
+		return nil, fmt.Errorf("snapshot %s has schema version %d but this version of nephomancy reads %d, it can't be used",
+			fname, v, latest)
+	}
+	return db, nil
+}
+
+// Compares two sets of rates, keyed by sku. Returns one line per sku
+// whose rates differ, sorted by sku: the sku, the rates before and the
+// rates after. Rates are empty for skus that were added or removed.
+func Diff(before map[string]string, after map[string]string) [][]string {
+	keys := make([]string, 0, len(after))
+	for k, a := range after {
+		if b, ok := before[k]; !ok || a != b {
+			keys = append(keys, k)
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	lines := make([][]string, len(keys))
+	for i, k := range keys {
+		lines[i] = []string{k, before[k], after[k]}
+	}
+	return lines
+}
+
+// Lists the skus whose rates changed between the snapshots from and to
+// in dir. listRates reads the rates, keyed by sku, from one snapshot;
+// latest is the schema version it expects, see Open.
+func Changes(dir string, from string, to string, latest int,
+	listRates func(*sql.DB) (map[string]string, error)) ([][]string, error) {
+	rates := make([]map[string]string, 2)
+	for i, date := range []string{from, to} {
+		db, err := Open(dir, date, latest)
+		if err != nil {
+			return nil, err
+		}
+		rates[i], err = listRates(db)
+		db.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rates as of %s: %v", date, err)
+		}
+	}
+	return Diff(rates[0], rates[1]), nil
+}
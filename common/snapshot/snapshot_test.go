@@ -0,0 +1,120 @@
+package snapshot
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"nephomancy/common/migrate"
+)
+
+func listRates(db *sql.DB) (map[string]string, error) {
+	res, err := db.Query(`SELECT Sku, Rate FROM Rates`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	rates := make(map[string]string)
+	var sku, rate string
+	for res.Next() {
+		if err = res.Scan(&sku, &rate); err != nil {
+			return nil, err
+		}
+		rates[sku] = rate
+	}
+	return rates, nil
+}
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "price-cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE Rates ("Sku" TEXT NOT NULL PRIMARY KEY, "Rate" TEXT)`,
+		`INSERT INTO Rates(Sku, Rate) VALUES ("a", "1 USD"), ("b", "2 USD")`,
+	}
+	for _, s := range stmts {
+		if _, err = db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jan := time.Date(2021, 1, 15, 10, 0, 0, 0, time.UTC)
+	if _, err = Save(db, dir, jan); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	// Saving twice on the same day replaces the snapshot.
+	if _, err = Save(db, dir, jan); err != nil {
+		t.Fatalf("failed to replace snapshot: %v", err)
+	}
+	stmts = []string{
+		`UPDATE Rates SET Rate="3 USD" WHERE Sku="b"`,
+		`INSERT INTO Rates(Sku, Rate) VALUES ("c", "4 USD")`,
+		`DELETE FROM Rates WHERE Sku="a"`,
+	}
+	for _, s := range stmts {
+		if _, err = db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = Save(db, dir, jan.AddDate(0, 1, 0)); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+
+	dates, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(dates, []string{"2021-01-15", "2021-02-15"}); diff != nil {
+		t.Errorf("unexpected snapshot dates: %v", diff)
+	}
+	date, _, err := Find(dir, "2021-02-01")
+	if err != nil || date != "2021-01-15" {
+		t.Errorf("expected snapshot from 2021-01-15 but got %s (%v)", date, err)
+	}
+	if _, _, err = Find(dir, "2020-12-31"); err == nil {
+		t.Errorf("expected no snapshot before 2021-01-15")
+	}
+
+	changes, err := Changes(dir, "2021-01-31", "2021-02-28", 0, listRates)
+	if err != nil {
+		t.Fatalf("failed to get changes: %v", err)
+	}
+	expected := [][]string{
+		[]string{"a", "1 USD", ""},
+		[]string{"b", "2 USD", "3 USD"},
+		[]string{"c", "", "4 USD"},
+	}
+	if diff := deep.Equal(changes, expected); diff != nil {
+		t.Errorf("unexpected changes: %v", diff)
+	}
+
+	// Snapshots of an older schema can't be read.
+	if sdb, err := Open(dir, "2021-02-28", 1); err == nil {
+		sdb.Close()
+		t.Errorf("expected an error for a snapshot of schema version 0")
+	}
+	err = migrate.Run(db, []migrate.Step{{Version: 1, Description: "nothing",
+		Apply: func(*sql.Tx) error { return nil }}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Save(db, dir, jan.AddDate(0, 2, 0)); err != nil {
+		t.Fatalf("failed to save snapshot: %v", err)
+	}
+	sdb, err := Open(dir, "2021-03-31", 1)
+	if err != nil {
+		t.Fatalf("failed to open snapshot of schema version 1: %v", err)
+	}
+	sdb.Close()
+}
@@ -0,0 +1,70 @@
+// Refreshing a price cache without leaving it half-written.
+//
+// A refresh works on a staging copy of the cache, next to it. Commit
+// replaces the cache with the copy; Abort throws the copy away. If the
+// program dies in between, the cache is left as it was, and the next
+// refresh starts from a fresh copy.
+package staging
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const suffix = ".staging"
+
+type Cache struct {
+	// Handle for the staging copy.
+	DB *sql.DB
+
+	fname string
+	tmp   string
+}
+
+// Copies the database at fname, if there is one, to a staging file and
+// opens it.
+func Begin(fname string) (*Cache, error) {
+	tmp := fname + suffix
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if fi, err := os.Stat(fname); err == nil && fi.Size() > 0 {
+		if err = copyDatabase(fname, tmp); err != nil {
+			return nil, fmt.Errorf("failed to copy %s to %s: %v", fname, tmp, err)
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{DB: db, fname: fname, tmp: tmp}, nil
+}
+
+func copyDatabase(from string, to string) error {
+	db, err := sql.Open("sqlite3", "file:"+from+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(`VACUUM INTO ?`, to)
+	return err
+}
+
+// Replaces the cache with the staging copy. Closes DB.
+func (c *Cache) Commit() error {
+	if err := c.DB.Close(); err != nil {
+		return err
+	}
+	return os.Rename(c.tmp, c.fname)
+}
+
+// Throws the staging copy away. Closes DB.
+func (c *Cache) Abort() {
+	c.DB.Close()
+	os.Remove(c.tmp)
+}
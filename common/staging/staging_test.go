@@ -0,0 +1,95 @@
+package staging
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeCache(t *testing.T, fname string) {
+	db, err := sql.Open("sqlite3", fname)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", fname, err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(`CREATE TABLE Prices (Sku TEXT, Nanos INTEGER);
+	INSERT INTO Prices VALUES ('a', 1);`); err != nil {
+		t.Fatalf("failed to fill %s: %v", fname, err)
+	}
+}
+
+func count(t *testing.T, fname string) int {
+	db, err := sql.Open("sqlite3", fname)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", fname, err)
+	}
+	defer db.Close()
+	var n int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM Prices`).Scan(&n); err != nil {
+		t.Fatalf("failed to count prices in %s: %v", fname, err)
+	}
+	return n
+}
+
+func TestCommitAndAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "cache.db")
+	makeCache(t, fname)
+
+	stage, err := Begin(fname)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err = stage.DB.Exec(`INSERT INTO Prices VALUES ('b', 2)`); err != nil {
+		t.Fatalf("insert into staging copy failed: %v", err)
+	}
+	stage.Abort()
+	if n := count(t, fname); n != 1 {
+		t.Errorf("expected aborted refresh to leave 1 price, got %d", n)
+	}
+	if _, err = os.Stat(fname + suffix); !os.IsNotExist(err) {
+		t.Errorf("expected staging copy to be removed, got %v", err)
+	}
+
+	stage, err = Begin(fname)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err = stage.DB.Exec(`INSERT INTO Prices VALUES ('b', 2)`); err != nil {
+		t.Fatalf("insert into staging copy failed: %v", err)
+	}
+	if err = stage.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if n := count(t, fname); n != 2 {
+		t.Errorf("expected committed refresh to have 2 prices, got %d", n)
+	}
+}
+
+func TestBeginWithoutCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "cache.db")
+	stage, err := Begin(fname)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err = stage.DB.Exec(`CREATE TABLE Prices (Sku TEXT, Nanos INTEGER)`); err != nil {
+		t.Fatalf("create table failed: %v", err)
+	}
+	if err = stage.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if n := count(t, fname); n != 0 {
+		t.Errorf("expected empty cache, got %d prices", n)
+	}
+}
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"encoding/csv"
+	"fmt"
+	"nephomancy/common/metadata"
 	"os"
+	"sort"
 )
 
 var header = []string{"project name", "cloud provider", "resource name", "resource type", "count", "spec",
@@ -11,6 +14,9 @@ var header = []string{"project name", "cloud provider", "resource name", "resour
 type CostReporter struct {
 	file   *os.File
 	writer *csv.Writer
+	// When this is set, there is an extra first column with the
+	// value of this label key.
+	groupBy string
 }
 
 func (c *CostReporter) Init(f *os.File) {
@@ -21,6 +27,16 @@ func (c *CostReporter) Init(f *os.File) {
 	c.writer.Write(header)
 }
 
+// Like Init, but for a report that groups costs by the label key.
+func (c *CostReporter) InitGrouped(f *os.File, key string) {
+	if c.writer == nil {
+		c.writer = csv.NewWriter(f)
+	}
+	c.file = f
+	c.groupBy = key
+	c.writer.Write(append([]string{key}, header...))
+}
+
 func (c *CostReporter) AddLine(line []string) error {
 	if c.writer == nil {
 		c.writer = csv.NewWriter(c.file)
@@ -28,6 +44,81 @@ func (c *CostReporter) AddLine(line []string) error {
 	return c.writer.Write(line)
 }
 
+// Adds a cost line for a label group.
+func (c *CostReporter) AddGroupLine(group string, line []string) error {
+	return c.AddLine(append([]string{group}, line...))
+}
+
+// Adds subtotal lines for a label group, one per currency.
+func (c *CostReporter) AddSubtotals(group string, projectName string, lines [][]string) error {
+	maxCosts, projectedCosts := SumCosts(lines)
+	currencies := make([]string, 0, len(maxCosts))
+	for cur := range maxCosts {
+		currencies = append(currencies, cur)
+	}
+	sort.Strings(currencies)
+	for _, cur := range currencies {
+		subtotal := []string{group, projectName, "", "",
+			fmt.Sprintf("subtotal for %s=%s", c.groupBy, group), "", "", "",
+			fmt.Sprintf("%.2f %s", maxCosts[cur], cur), "",
+			fmt.Sprintf("%.2f %s", projectedCosts[cur], cur),
+		}
+		if err := c.AddLine(subtotal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds up the max cost and projected cost columns of cost lines, by
+// currency. The costs are the third-last and last columns and look like
+// "12.34 USD". Costs that aren't numbers (e.g. "unknown") are skipped.
+func SumCosts(lines [][]string) (maxCosts map[string]float64, projectedCosts map[string]float64) {
+	maxCosts = make(map[string]float64)
+	projectedCosts = make(map[string]float64)
+	for _, line := range lines {
+		if len(line) < 4 {
+			continue
+		}
+		if amount, cur, ok := parseCost(line[len(line)-3]); ok {
+			maxCosts[cur] += amount
+			if _, ok := projectedCosts[cur]; !ok {
+				projectedCosts[cur] = 0
+			}
+		}
+		if amount, cur, ok := parseCost(line[len(line)-1]); ok {
+			projectedCosts[cur] += amount
+			if _, ok := maxCosts[cur]; !ok {
+				maxCosts[cur] = 0
+			}
+		}
+	}
+	return maxCosts, projectedCosts
+}
+
+func parseCost(cost string) (float64, string, bool) {
+	var amount float64
+	var cur string
+	if n, err := fmt.Sscanf(cost, "%f %s", &amount, &cur); n != 2 || err != nil {
+		return 0, "", false
+	}
+	return amount, cur, true
+}
+
+// Adds a line saying which date a provider's prices are from.
+func (c *CostReporter) AddPricingDate(projectName string, provider string, m *metadata.Metadata) error {
+	date := "unknown"
+	if m != nil {
+		date = fmt.Sprintf("%s (refreshed %s from %s)", m.PricingDate(),
+			m.Refreshed.UTC().Format("2006-01-02"), m.Source)
+	}
+	line := []string{projectName, provider, "", "pricing date", "", date, "", "", "", ""}
+	if c.groupBy != "" {
+		line = append([]string{""}, line...)
+	}
+	return c.AddLine(line)
+}
+
 func (c *CostReporter) Flush() {
 	if c.writer != nil {
 		c.writer.Flush()
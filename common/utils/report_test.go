@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestSumCosts(t *testing.T) {
+	lines := [][]string{
+		[]string{"p", "gcloud", "vms", "VM cpu", "1", "2 cpus",
+			"1460 h per month", "44.56 USD", "1460 h per month", "40.00 USD"},
+		[]string{"p", "gcloud", "default", "Network", "1", "egress",
+			"unknown", "unknown", "10 Gb", "1.20 USD"},
+		// dcs lines don't have the first three columns.
+		[]string{"VM CPU", "1", "2 cpus", "1440 h per month", "39.99 CHF",
+			"1460 h per month", "40.54 CHF"},
+	}
+	maxCosts, projectedCosts := SumCosts(lines)
+	if len(maxCosts) != 2 || len(projectedCosts) != 2 {
+		t.Fatalf("expected two currencies but got %v and %v\n", maxCosts, projectedCosts)
+	}
+	if maxCosts["USD"] != 44.56 || maxCosts["CHF"] != 39.99 {
+		t.Errorf("wrong max costs %v\n", maxCosts)
+	}
+	if projectedCosts["USD"] != 41.20 || projectedCosts["CHF"] != 40.54 {
+		t.Errorf("wrong projected costs %v\n", projectedCosts)
+	}
+}
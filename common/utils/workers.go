@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A limit on how many goroutines work at once. Pass the same pool down
+// to nested ForEach calls, so that they share the limit rather than
+// multiply it. A nil pool works one call at a time.
+type Pool struct {
+	// A slot for each goroutine besides the callers of ForEach.
+	slots chan struct{}
+}
+
+// Returns a pool of at most workers goroutines, including the one that
+// calls ForEach. Fewer than 1 counts as 1.
+func NewPool(workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{slots: make(chan struct{}, workers-1)}
+}
+
+// Calls fn(0), ..., fn(n-1) and waits for all calls to finish. The calls
+// run on the calling goroutine and on as many more as the pool has free
+// slots for. Nested calls from fn run on fn's goroutine if no slots are
+// free, so they can't deadlock. To keep results in a stable order, fn
+// should store its result at index i of a slice rather than append to a
+// shared one. If any calls fail, returns the error of the one with the
+// lowest index.
+func (p *Pool) ForEach(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+	next := int64(-1)
+	work := func() {
+		for {
+			i := int(atomic.AddInt64(&next, 1))
+			if i >= n {
+				return
+			}
+			errs[i] = fn(i)
+		}
+	}
+	var wg sync.WaitGroup
+helpers:
+	for h := 0; p != nil && h < n-1; h++ {
+		select {
+		case p.slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-p.slots }()
+				work()
+			}()
+		default:
+			break helpers
+		}
+	}
+	work()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
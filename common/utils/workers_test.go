@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// Counts calls that run at the same time.
+type concurrency struct {
+	running, max int32
+}
+
+func (c *concurrency) enter() {
+	r := atomic.AddInt32(&c.running, 1)
+	for {
+		m := atomic.LoadInt32(&c.max)
+		if r <= m || atomic.CompareAndSwapInt32(&c.max, m, r) {
+			break
+		}
+	}
+}
+
+func (c *concurrency) leave() {
+	atomic.AddInt32(&c.running, -1)
+}
+
+func TestForEach(t *testing.T) {
+	var c concurrency
+	results := make([]int, 20)
+	err := NewPool(3).ForEach(len(results), func(i int) error {
+		c.enter()
+		defer c.leave()
+		results[i] = i * i
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.max > 3 {
+		t.Errorf("expected at most 3 concurrent calls but got %d", c.max)
+	}
+	for i, r := range results {
+		if r != i*i {
+			t.Errorf("wrong result %d at index %d", r, i)
+		}
+	}
+}
+
+func TestForEachNested(t *testing.T) {
+	for _, p := range []*Pool{nil, NewPool(1), NewPool(4)} {
+		var c concurrency
+		var calls int32
+		pool := p
+		err := pool.ForEach(8, func(int) error {
+			return pool.ForEach(8, func(int) error {
+				c.enter()
+				defer c.leave()
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		limit := int32(1)
+		if p != nil {
+			limit = int32(cap(p.slots) + 1)
+		}
+		if calls != 64 || c.max > limit {
+			t.Errorf("expected 64 calls, at most %d at once, but got %d, %d at once",
+				limit, calls, c.max)
+		}
+	}
+}
+
+func TestForEachReturnsFirstError(t *testing.T) {
+	err := NewPool(4).ForEach(10, func(i int) error {
+		if i == 4 || i == 7 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "failed 4" {
+		t.Errorf("expected error from index 4 but got %v", err)
+	}
+	if err = NewPool(4).ForEach(0, func(int) error { return fmt.Errorf("never") }); err != nil {
+		t.Errorf("expected no error without work but got %v", err)
+	}
+}
@@ -2,22 +2,37 @@ package cache
 
 import (
 	"database/sql"
+	"nephomancy/common/metadata"
+	"nephomancy/common/migrate"
 )
 
+// Schema migrations for the DCS cache, see common/migrate.
+var migrations = []migrate.Step{
+	{Version: 1, Description: "create tables", Apply: createTables},
+	{Version: 2, Description: "create CacheMetadata", Apply: metadata.CreateTable},
+}
+
 func CreateOrUpdateDatabase(db *sql.DB) error {
-	return createTables(db)
+	return migrate.Run(db, migrations)
 }
 
-func createTable(db *sql.DB, ct string) error {
-	stmt, err := db.Prepare(ct)
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec()
+// Returns the schema version caches are migrated to.
+func LatestSchemaVersion() int {
+	return migrate.Latest(migrations)
+}
+
+// Returns the cache's schema version and the latest schema version.
+func SchemaVersion(db *sql.DB) (int, int, error) {
+	v, err := migrate.Version(db)
+	return v, migrate.Latest(migrations), err
+}
+
+func createTable(tx *sql.Tx, ct string) error {
+	_, err := tx.Exec(ct)
 	return err
 }
 
-func createTables(db *sql.DB) error {
+func createTables(tx *sql.Tx) error {
 	// DCS have multiple Locations. You can choose a location manually when
 	// creating a DDC (dynamic data center), but since all their locations
 	// are in Switzerland and all have the same prices, this is not modelled
@@ -36,7 +51,7 @@ func createTables(db *sql.DB) error {
 		"CurrencyCode" TEXT,
 		"Nanos" INTEGER
 	);`
-	if err := createTable(db, createCPUCostsTableSQL); err != nil {
+	if err := createTable(tx, createCPUCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -47,7 +62,7 @@ func createTables(db *sql.DB) error {
 		"CurrencyCode" TEXT,
 		"Nanos" INTEGER
 	);`
-	if err := createTable(db, createMemoryCostsTableSQL); err != nil {
+	if err := createTable(tx, createMemoryCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -63,7 +78,7 @@ func createTables(db *sql.DB) error {
 		"Nanos" INTEGER,
 		PRIMARY KEY (SLA, DiskType)
 	);`
-	if err := createTable(db, createDiskCostsTableSQL); err != nil {
+	if err := createTable(tx, createDiskCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -76,7 +91,7 @@ func createTables(db *sql.DB) error {
 		"Nanos" INTEGER,
 		PRIMARY KEY (SLA, Cidr)
 	);`
-	if err := createTable(db, createIPAddrCostsTableSQL); err != nil {
+	if err := createTable(tx, createIPAddrCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -92,7 +107,7 @@ func createTables(db *sql.DB) error {
 		"MaxMbits" INTEGER,
 		PRIMARY KEY (SLA, MaxMbits)
 	);`
-	if err := createTable(db, createBandwidthCostsTableSQL); err != nil {
+	if err := createTable(tx, createBandwidthCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -106,7 +121,7 @@ func createTables(db *sql.DB) error {
 		"Type" TEXT NOT NULL,
                 PRIMARY KEY (SLA, Type)
 	);`
-	if err := createTable(db, createGatewayCostsTableSQL); err != nil {
+	if err := createTable(tx, createGatewayCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -121,7 +136,7 @@ func createTables(db *sql.DB) error {
 		"Vendor" TEXT NOT NULL,
                 PRIMARY KEY (SLA, Vendor)
 	);`
-	if err := createTable(db, createOSCostsTableSQL); err != nil {
+	if err := createTable(tx, createOSCostsTableSQL); err != nil {
 		return err
 	}
 
@@ -134,7 +149,7 @@ func createTables(db *sql.DB) error {
 		"CurrencyCode" TEXT,
 		"Nanos" INTEGER
 	);`
-	if err := createTable(db, createObjectStorageCostsTableSQL); err != nil {
+	if err := createTable(tx, createObjectStorageCostsTableSQL); err != nil {
 		return err
 	}
 	return nil
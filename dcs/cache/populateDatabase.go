@@ -8,10 +8,17 @@ import (
 	"database/sql"
 	_ "github.com/mattn/go-sqlite3"
 	"math"
+	"nephomancy/common/metadata"
+	"time"
 )
 
 var nanoFactor = math.Pow(10, 9)
 
+// Publication date of the price list the prices are based on.
+const PriceListDate = "2020-11-27"
+
+const PriceListSource = "https://documents.swisscom.com/product/filestore/lib/a9e6ce54-97c6-4e5c-a670-9d751bc1fe44/dcsplus%20leistungen%20und%20preise-en.pdf"
+
 func populateCPUCosts(db *sql.DB) error {
 	insert := `REPLACE INTO CpuCosts(SLA, UsageUnit, Summary, CurrencyCode, Nanos)
 	VALUES(?, ?, ?, ?, ?)`
@@ -301,5 +308,10 @@ func PopulateDatabase(db *sql.DB) error {
 	if err := populateObjectStorageCosts(db); err != nil {
 		return err
 	}
-	return nil
+	return metadata.Record(db, metadata.Metadata{
+		Refreshed:     time.Now(),
+		Source:        PriceListSource,
+		SourceVersion: PriceListDate,
+		SourceDate:    PriceListDate,
+	})
 }
@@ -4,12 +4,21 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/golang/protobuf/ptypes"
+	"log"
 	"math"
 	common "nephomancy/common/resources"
 	"nephomancy/dcs/resources"
 )
 
+// Hours in an average month, the most a resource can be used.
+const hoursPerMonth = 730
+
 func GetCost(db *sql.DB, p *common.Project) ([][]string, error) {
+	r, err := prepareRates(db)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
 	costs := make([][]string, 0)
 	sla := "Basic"
 	if p.ProviderDetails != nil && p.ProviderDetails[resources.DcsProvider] != nil {
@@ -21,6 +30,10 @@ func GetCost(db *sql.DB, p *common.Project) ([][]string, error) {
 		sla = dcsProject.Sla
 	}
 	for _, vmset := range p.InstanceSets {
+		// Resources placed with other providers are left to them.
+		if common.OtherProviders(resources.DcsProvider, vmset.Template.ProviderDetails) {
+			continue
+		}
 		if vmset.Template.ProviderDetails == nil || vmset.Template.ProviderDetails[resources.DcsProvider] == nil {
 			return nil, fmt.Errorf("missing %s provider details for instance set %s",
 				resources.DcsProvider, vmset.Name)
@@ -30,16 +43,19 @@ func GetCost(db *sql.DB, p *common.Project) ([][]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		vmcosts, err := vmCostRange(db, sla, *vmset, dcsVm)
+		vmcosts, err := vmCostRange(r, sla, vmset, &dcsVm)
 		if err != nil {
 			return nil, err
 		}
-		for _, vc := range vmcosts {
-			vc = append([]string{p.Name, resources.DcsProvider, vmset.Name}, vc...)
+		for i, vc := range vmcosts {
+			vmcosts[i] = append([]string{p.Name, resources.DcsProvider, vmset.Name}, vc...)
 		}
 		costs = append(costs, vmcosts...)
 	}
 	for _, dset := range p.DiskSets {
+		if common.OtherProviders(resources.DcsProvider, dset.Template.ProviderDetails) {
+			continue
+		}
 		if dset.Template.ProviderDetails == nil || dset.Template.ProviderDetails[resources.DcsProvider] == nil {
 			return nil, fmt.Errorf("Missing %s provider details for disk set %s\n",
 				resources.DcsProvider, dset.Name)
@@ -50,26 +66,32 @@ func GetCost(db *sql.DB, p *common.Project) ([][]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		dcosts, err := diskCostRange(db, sla, *dset, dcsDisk)
+		dcosts, err := diskCostRange(r, sla, dset, &dcsDisk)
 		if err != nil {
 			return nil, err
 		}
-		for _, dc := range dcosts {
-			dc = append([]string{p.Name, resources.DcsProvider, dset.Name}, dc...)
+		for i, dc := range dcosts {
+			dcosts[i] = append([]string{p.Name, resources.DcsProvider, dset.Name}, dc...)
 		}
 		costs = append(costs, dcosts...)
 	}
 	for _, nw := range p.Networks {
-		nwcosts, err := networkCostRange(db, sla, *nw)
+		if common.OtherProviders(resources.DcsProvider, nw.ProviderDetails) {
+			continue
+		}
+		nwcosts, err := networkCostRange(r, sla, nw)
 		if err != nil {
 			return nil, err
 		}
+		for i, nc := range nwcosts {
+			nwcosts[i] = append([]string{p.Name, resources.DcsProvider, nw.Name}, nc...)
+		}
 		costs = append(costs, nwcosts...)
 	}
 	return costs, nil
 }
 
-func networkCostRange(db *sql.DB, sla string, network common.Network) (
+func networkCostRange(r *rates, sla string, network *common.Network) (
 	[][]string, error) {
 	var bandwidthMBits uint32
 	// With dcs, assume there is only one subnetwork.
@@ -85,27 +107,25 @@ func networkCostRange(db *sql.DB, sla string, network common.Network) (
 	costs := make([][]string, 0)
 	// the max number of IP Addresses is: 2^(32 - Cidr) - 5
 	cidr := uint32(32 - math.Log2(float64(ipAddrCount+5)))
-	fmt.Printf("cidr for %d ip addresses is %d\n", ipAddrCount, cidr)
-	priceIPAddr, err := executePriceQuery(db, "IpAddrCosts", sla,
-		fmt.Sprintf(` AND Cidr >= %d `, cidr))
+	log.Printf("cidr for %d ip addresses is %d\n", ipAddrCount, cidr)
+	priceIPAddr, err := r.ipAddrRate(sla, cidr)
 	if err != nil {
 		return nil, err
 	}
 	// This is the price for 10 MBit/s
-	pricePer10MBits, err := executePriceQuery(db, "BandwidthCosts", sla, "")
+	pricePer10MBits, err := r.bandwidthRate(sla)
 	if err != nil {
 		return nil, err
 	}
-	priceBandwidth := float64(pricePer10MBits) * math.Ceil(float64(bandwidthMBits)/10.0) / math.Pow(10, 9)
-	hoursPerMonth := uint32(24 * 30)
+	priceBandwidth := float64(pricePer10MBits.Nanos) * math.Ceil(float64(bandwidthMBits)/10.0) / math.Pow(10, 9)
 	costs = append(costs, []string{
 		"IP Addresses",
 		fmt.Sprintf("%d", ipAddrCount),
 		fmt.Sprintf("ip addresses: /%d cidr", cidr),
 		fmt.Sprintf("%d addresses for %d h per month", ipAddrCount, hoursPerMonth),
-		fmt.Sprintf("%.2f CHF", float64(priceIPAddr*hoursPerMonth)/math.Pow(10, 9)),
+		fmt.Sprintf("%.2f CHF", float64(priceIPAddr.Nanos*hoursPerMonth)/math.Pow(10, 9)),
 		fmt.Sprintf("%d addresses for %d h per month", ipAddrCount, hoursPerMonth),
-		fmt.Sprintf("%.2f CHF", float64(priceIPAddr*hoursPerMonth)/math.Pow(10, 9)),
+		fmt.Sprintf("%.2f CHF", float64(priceIPAddr.Nanos*hoursPerMonth)/math.Pow(10, 9)),
 	})
 	costs = append(costs, []string{
 		"Bandwidth",
@@ -128,8 +148,7 @@ func networkCostRange(db *sql.DB, sla string, network common.Network) (
 			if gwType == "" {
 				gwType = "Eco" // Default, free.
 			}
-			priceGateway, err := executePriceQuery(db, "GatewayCosts", sla,
-				fmt.Sprintf(` AND Type="%s" `, gwType))
+			priceGateway, err := r.gatewayRate(sla, gwType)
 			if err != nil {
 				return nil, err
 			}
@@ -138,39 +157,33 @@ func networkCostRange(db *sql.DB, sla string, network common.Network) (
 				"1",
 				fmt.Sprintf("Gateway of type %s", gwType),
 				fmt.Sprintf("for %d h per month", hoursPerMonth),
-				fmt.Sprintf("%.2f CHF", float64(priceGateway*hoursPerMonth)/math.Pow(10, 9)),
+				fmt.Sprintf("%.2f CHF", float64(priceGateway.Nanos*hoursPerMonth)/math.Pow(10, 9)),
 				fmt.Sprintf("for %d h per month", hoursPerMonth),
-				fmt.Sprintf("%.2f CHF", float64(priceGateway*hoursPerMonth)/math.Pow(10, 9)),
+				fmt.Sprintf("%.2f CHF", float64(priceGateway.Nanos*hoursPerMonth)/math.Pow(10, 9)),
 			})
 		}
 	}
 	return costs, nil
 }
 
-func diskCostRange(db *sql.DB, sla string, disk common.DiskSet, dcsDisk resources.DcsDisk) (
+func diskCostRange(r *rates, sla string, disk *common.DiskSet, dcsDisk *resources.DcsDisk) (
 	[][]string, error) {
 	dtype := dcsDisk.DiskType
 	backup := dcsDisk.WithBackup
-	backupInt := 0
-	if backup {
-		backupInt = 1
-	}
 	if dtype == "" {
 		return nil, fmt.Errorf("missing disk type information for disk set %s",
 			disk.Name)
 	}
 	diskCount := disk.Count
 	sizeGb := uint64(disk.Template.Type.SizeGb)
-	priceDisk, err := executePriceQuery(db, "DiskCosts", sla,
-		fmt.Sprintf(` AND DiskType="%s" AND Backup=%d `, dtype, backupInt))
+	priceDisk, err := r.diskRate(sla, dtype, backup)
 	if err != nil {
 		return nil, err
 	}
-	price := float64(priceDisk) / math.Pow(10, 9)
+	price := float64(priceDisk.Nanos) / math.Pow(10, 9)
 	spec := fmt.Sprintf("%s in %s",
-		common.PrintDiskType(*disk.Template.Type),
-		common.PrintLocation(*disk.Template.Location))
-	const hoursPerMonth = 24 * 30
+		common.PrintDiskType(disk.Template.Type),
+		common.PrintLocation(disk.Template.Location))
 	expectedHours := disk.UsageHoursPerMonth
 	costs := make([][]string, 1)
 	costs[0] = []string{
@@ -185,7 +198,7 @@ func diskCostRange(db *sql.DB, sla string, disk common.DiskSet, dcsDisk resource
 	return costs, nil
 }
 
-func vmCostRange(db *sql.DB, sla string, vm common.InstanceSet, dcsvm resources.DcsVM) (
+func vmCostRange(r *rates, sla string, vm *common.InstanceSet, dcsvm *resources.DcsVM) (
 	[][]string, error) {
 	lic := dcsvm.OsChoice
 	if lic == "" {
@@ -196,37 +209,37 @@ func vmCostRange(db *sql.DB, sla string, vm common.InstanceSet, dcsvm resources.
 	memoryGb := vm.Template.Type.MemoryGb
 	usage := vm.UsageHoursPerMonth
 	vmCount := vm.Count
-	maxCpuUsage := uint32(30 * 24 * cpuCount * vmCount)
+	maxCpuUsage := uint32(hoursPerMonth * cpuCount * vmCount)
 	projectedCpuUsage := uint32(usage * cpuCount * vmCount)
-	maxMemoryUsage := uint32(30 * 24 * vmCount * memoryGb)
+	maxMemoryUsage := uint32(hoursPerMonth * vmCount * memoryGb)
 	projectedMemoryUsage := uint32(usage * vmCount * memoryGb)
 
 	spec := fmt.Sprintf("%s in %s",
-		common.PrintMachineType(*vm.Template.Type),
-		common.PrintLocation(*vm.Template.Location))
+		common.PrintMachineType(vm.Template.Type),
+		common.PrintLocation(vm.Template.Location))
 
-	priceCpu, err := executePriceQuery(db, "CpuCosts", sla, "")
+	priceCpu, err := r.cpuRate(sla)
 	if err != nil {
 		return nil, err
 	}
-	priceMem, err := executePriceQuery(db, "MemoryCosts", sla, "")
+	priceMem, err := r.memoryRate(sla)
 	if err != nil {
 		return nil, err
 	}
 	// The sample bill in the dcs guide seems to say that License costs in DCS
 	// are per VM, not per CPU or CU.
-	priceOs, err := executePriceQuery(db, "OSCosts", sla, fmt.Sprintf(` AND Vendor="%s"`, lic))
+	priceOs, err := r.osRate(sla, lic)
 	if err != nil {
 		return nil, err
 	}
-	cpu := float64(priceCpu) / math.Pow(10, 9)
+	cpu := float64(priceCpu.Nanos) / math.Pow(10, 9)
 	maxCpu := cpu * float64(maxCpuUsage)
 	expCpu := cpu * float64(projectedCpuUsage)
-	mem := float64(priceMem) / math.Pow(10, 9)
+	mem := float64(priceMem.Nanos) / math.Pow(10, 9)
 	maxMem := mem * float64(maxMemoryUsage)
 	expMem := mem * float64(projectedMemoryUsage)
-	os := float64(priceOs) / math.Pow(10, 9)
-	maxOs := os * float64(30*24*vmCount)
+	os := float64(priceOs.Nanos) / math.Pow(10, 9)
+	maxOs := os * float64(hoursPerMonth*vmCount)
 	expOs := os * float64(usage*vmCount)
 	costs := make([][]string, 3)
 
@@ -252,7 +265,7 @@ func vmCostRange(db *sql.DB, sla string, vm common.InstanceSet, dcsvm resources.
 		"VM OS",
 		fmt.Sprintf("%s", lic),
 		spec,
-		fmt.Sprintf("%d h per month", 30*24*vmCount),
+		fmt.Sprintf("%d h per month", hoursPerMonth*vmCount),
 		fmt.Sprintf("%.2f CHF", maxOs),
 		fmt.Sprintf("%d h per month", usage*vmCount),
 		fmt.Sprintf("%.2f CHF", expOs),
@@ -261,21 +274,39 @@ func vmCostRange(db *sql.DB, sla string, vm common.InstanceSet, dcsvm resources.
 	return costs, nil
 }
 
-func executePriceQuery(db *sql.DB, table string, sla string, q string) (uint32, error) {
-	query := fmt.Sprintf(`SELECT Nanos from %s WHERE SLA="%s" %s;`, table, sla, q)
-	fmt.Printf("query: %s\n", query)
-	res, err := db.Query(query)
-	if err != nil {
-		return 0, err
+// Returns the rates from all cost tables, keyed by table and the
+// columns that identify a price, e.g. "DiskCosts Basic Fast".
+func ListRates(db *sql.DB) (map[string]string, error) {
+	queries := map[string]string{
+		"CpuCosts":           `SELECT SLA, UsageUnit, CurrencyCode, Nanos FROM CpuCosts`,
+		"MemoryCosts":        `SELECT SLA, UsageUnit, CurrencyCode, Nanos FROM MemoryCosts`,
+		"DiskCosts":          `SELECT SLA || ' ' || DiskType, UsageUnit, CurrencyCode, Nanos FROM DiskCosts`,
+		"IpAddrCosts":        `SELECT SLA || ' /' || Cidr, UsageUnit, CurrencyCode, Nanos FROM IpAddrCosts`,
+		"BandwidthCosts":     `SELECT SLA || ' ' || MaxMbits || ' Mbit/s', UsageUnit, CurrencyCode, Nanos FROM BandwidthCosts`,
+		"GatewayCosts":       `SELECT SLA || ' ' || Type, UsageUnit, CurrencyCode, Nanos FROM GatewayCosts`,
+		"OSCosts":            `SELECT SLA || ' ' || Vendor, UsageUnit, CurrencyCode, Nanos FROM OSCosts`,
+		"ObjectStorageCosts": `SELECT SLA, UsageUnit, CurrencyCode, Nanos FROM ObjectStorageCosts`,
 	}
-	defer res.Close()
-	var nanos uint32
-	for res.Next() {
-		err = res.Scan(&nanos)
+	rates := make(map[string]string)
+	for table, query := range queries {
+		res, err := db.Query(query)
 		if err != nil {
-			return 0, fmt.Errorf("error scanning row: %v", err)
+			return nil, err
+		}
+		var key, usageUnit, currencyCode string
+		var nanos float64
+		for res.Next() {
+			if err = res.Scan(&key, &usageUnit, &currencyCode, &nanos); err != nil {
+				res.Close()
+				return nil, err
+			}
+			rates[table+" "+key] = fmt.Sprintf("%.5f %s per %s",
+				nanos/nanoFactor, currencyCode, usageUnit)
+		}
+		res.Close()
+		if err = res.Err(); err != nil {
+			return nil, err
 		}
-		return nanos, nil
 	}
-	return 0, fmt.Errorf("no nanos retrieved for query %s", query)
+	return rates, nil
 }
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func memoryDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = CreateOrUpdateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = PopulateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testRates(t *testing.T, db *sql.DB) *rates {
+	r, err := prepareRates(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRates(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	r := testRates(t, db)
+	defer r.close()
+	rate, err := r.cpuRate("Standard")
+	if err != nil || rate.Nanos != 36100000 || rate.CurrencyCode != "CHF" {
+		t.Errorf("expected 36100000 CHF nanos per cpu hour but got %+v (%v)", rate, err)
+	}
+	rate, err = r.diskRate("Basic", "Fast with Backup", true)
+	if err != nil || rate.Nanos != 460000 {
+		t.Errorf("expected 460000 nanos per GB hour but got %+v (%v)", rate, err)
+	}
+	if _, err = r.gatewayRate("Basic", "Deluxe"); err == nil {
+		t.Errorf("expected error for a gateway type without a price")
+	}
+}
+
+func TestRatesHostileValues(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	r := testRates(t, db)
+	defer r.close()
+	// Pasted into the SQL, these would match a row.
+	if _, err := r.cpuRate(`x" OR "1"="1`); err == nil {
+		t.Errorf("expected no price for a hostile SLA")
+	}
+	if _, err := r.osRate("Basic", `x" OR Vendor<>"`); err == nil {
+		t.Errorf("expected no price for a hostile os vendor")
+	}
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A price from one of the cost tables, in nanos of the currency per
+// usage unit.
+type Rate struct {
+	Nanos        uint32
+	CurrencyCode string
+	UsageUnit    string
+}
+
+// The price lookups in the cost tables, prepared once per cost report.
+// Each takes the SLA first, then the values that identify the price
+// within the SLA.
+type rates struct {
+	cpu, memory, disk, ipAddr, bandwidth, gateway, os *sql.Stmt
+}
+
+func prepareRates(db *sql.DB) (*rates, error) {
+	r := &rates{}
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&r.cpu, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM CpuCosts WHERE SLA=?;`},
+		{&r.memory, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM MemoryCosts WHERE SLA=?;`},
+		{&r.disk, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM DiskCosts WHERE SLA=? AND DiskType=? AND Backup=?;`},
+		{&r.ipAddr, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM IpAddrCosts WHERE SLA=? AND Cidr >= ?;`},
+		{&r.bandwidth, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM BandwidthCosts WHERE SLA=?;`},
+		{&r.gateway, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM GatewayCosts WHERE SLA=? AND Type=?;`},
+		{&r.os, `SELECT Nanos, IFNULL(CurrencyCode, ''), IFNULL(UsageUnit, '')
+		FROM OSCosts WHERE SLA=? AND Vendor=?;`},
+	} {
+		stmt, err := db.Prepare(s.query)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return r, nil
+}
+
+func (r *rates) close() {
+	for _, stmt := range []*sql.Stmt{r.cpu, r.memory, r.disk, r.ipAddr, r.bandwidth,
+		r.gateway, r.os} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func lookupRate(stmt *sql.Stmt, what string, args ...interface{}) (Rate, error) {
+	var r Rate
+	err := stmt.QueryRow(args...).Scan(&r.Nanos, &r.CurrencyCode, &r.UsageUnit)
+	if err == sql.ErrNoRows {
+		return r, fmt.Errorf("no price for %s %v", what, args)
+	}
+	if err != nil {
+		return r, fmt.Errorf("error scanning row: %v", err)
+	}
+	return r, nil
+}
+
+func (r *rates) cpuRate(sla string) (Rate, error) {
+	return lookupRate(r.cpu, "cpu", sla)
+}
+
+func (r *rates) memoryRate(sla string) (Rate, error) {
+	return lookupRate(r.memory, "memory", sla)
+}
+
+func (r *rates) diskRate(sla string, diskType string, backup bool) (Rate, error) {
+	return lookupRate(r.disk, "disk", sla, diskType, backup)
+}
+
+func (r *rates) ipAddrRate(sla string, cidr uint32) (Rate, error) {
+	return lookupRate(r.ipAddr, "ip addresses", sla, cidr)
+}
+
+// The price of 10 MBit/s.
+func (r *rates) bandwidthRate(sla string) (Rate, error) {
+	return lookupRate(r.bandwidth, "bandwidth", sla)
+}
+
+func (r *rates) gatewayRate(sla string, gwType string) (Rate, error) {
+	return lookupRate(r.gateway, "gateway", sla, gwType)
+}
+
+func (r *rates) osRate(sla string, vendor string) (Rate, error) {
+	return lookupRate(r.os, "os", sla, vendor)
+}
@@ -14,7 +14,7 @@ import (
 
 // Returns nil if spec location is compatible with Switzerland,
 // an error otherwise.
-func checkLocation(spec common.Location) error {
+func checkLocation(spec *common.Location) error {
 	if spec.GlobalRegion != "" && spec.GlobalRegion != "EMEA" {
 		return fmt.Errorf("spec global region %s does not allow a provider in EMEA",
 			spec.GlobalRegion)
@@ -39,7 +39,20 @@ func chooseOs(templateOs string) string {
 	}
 }
 
-func isVmConsistent(dcsVm resources.DcsVM, template common.Instance) error {
+// DCS only rents out vms by the month, there is no spot market and
+// there are no commitments.
+func checkPurchaseOption(po string) error {
+	if err := common.CheckPurchaseOption(po); err != nil {
+		return err
+	}
+	if po != "" && po != "OnDemand" {
+		return fmt.Errorf("provider %s does not offer purchase option %s",
+			resources.DcsProvider, po)
+	}
+	return nil
+}
+
+func isVmConsistent(dcsVm *resources.DcsVM, template *common.Instance) error {
 	if template.Os == "" {
 		return nil
 	}
@@ -73,12 +86,15 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 		if vmset.Template.Location == nil {
 			return fmt.Errorf("missing vmset location information")
 		}
-		if err := checkLocation(*vmset.Template.Location); err != nil {
+		if err := checkLocation(vmset.Template.Location); err != nil {
 			return err
 		}
 		if vmset.Template.Type == nil {
 			return fmt.Errorf("missing vmset type information")
 		}
+		if err := checkPurchaseOption(vmset.Template.PurchaseOption); err != nil {
+			return err
+		}
 		if vmset.Template.ProviderDetails == nil {
 			vmset.Template.ProviderDetails = make(map[string](*anypb.Any))
 		}
@@ -89,7 +105,7 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 			if err != nil {
 				return err
 			}
-			if err = isVmConsistent(dcsvm, *vmset.Template); err != nil {
+			if err = isVmConsistent(&dcsvm, vmset.Template); err != nil {
 				return err
 			}
 			log.Printf("Instance Set %s already has details for provider %s, leaving them as they are.\n", vmset.Name, resources.DcsProvider)
@@ -105,7 +121,7 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 		if dset.Template.Location == nil {
 			return fmt.Errorf("missing disk set location information")
 		}
-		if err := checkLocation(*dset.Template.Location); err != nil {
+		if err := checkLocation(dset.Template.Location); err != nil {
 			return err
 		}
 		if dset.Template.Type == nil {
@@ -8,6 +8,7 @@ import (
 	"nephomancy/dcs/cache"
 	"nephomancy/dcs/provider"
 	"strings"
+	"time"
 )
 
 type InitCommand struct {
@@ -24,7 +25,9 @@ func (*InitCommand) Help() string {
 	You should run this when you first start working on a project, and after
 	upgrading to a version that contains newer DCS cost data.
 
-	This command is safe to run multiple times.
+	This command is safe to run multiple times. Each run also saves a dated
+	snapshot of the price data, which you can use with "nephomancy prices"
+	and "nephomancy cost --as-of".
 
 	Options:
 	  --workingdir=path	Optional: directory under which the data directory should be. Defaults to current working directory.
@@ -50,15 +53,25 @@ func (c *InitCommand) Run(args []string) int {
 	if err := prov.Initialize(dd); err != nil {
 		log.Fatalf("Failed to initialize provider: %v\n", err)
 	}
-
-	if err := cache.CreateOrUpdateDatabase(prov.DbHandle); err != nil {
-		log.Fatalf("Failed to create database: %v\n", err)
+	stage, err := prov.BeginRefresh()
+	if err != nil {
+		log.Fatalf("Failed to copy price cache: %v\n", err)
 	}
 
 	if err := cache.PopulateDatabase(prov.DbHandle); err != nil {
+		stage.Abort()
 		log.Fatalf("Failed to populate database: %v\n", err)
 	}
 
+	fname, err := prov.SaveSnapshot(time.Now())
+	if err != nil {
+		stage.Abort()
+		log.Fatalf("Failed to save price snapshot: %v\n", err)
+	}
+	if err = stage.Commit(); err != nil {
+		log.Fatalf("Failed to replace price cache: %v\n", err)
+	}
 	fmt.Println("Populated database.")
+	fmt.Printf("Saved price snapshot to %s\n", fname)
 	return 0
 }
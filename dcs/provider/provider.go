@@ -6,12 +6,16 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"nephomancy/common/metadata"
 	"nephomancy/common/registry"
 	"nephomancy/common/resources"
+	"nephomancy/common/snapshot"
+	"nephomancy/common/staging"
 	"nephomancy/dcs/cache"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -19,12 +23,16 @@ import (
 // DcsProvider implements registry.provider
 type DcsProvider struct {
 	DbHandle *sql.DB
+	// directory with the price cache and its snapshots
+	dir string
 }
 
 var instance registry.Provider = &DcsProvider{}
 
 const name = "dcs"
 
+const dbName = "price-cache.db"
+
 func (d *DcsProvider) FillInProviderDetails(p *resources.Project) error {
 	if d.DbHandle == nil {
 		return fmt.Errorf("Provider has not been initialized\n")
@@ -39,6 +47,72 @@ func (d *DcsProvider) GetCost(p *resources.Project) ([][]string, error) {
 	return cache.GetCost(d.DbHandle, p)
 }
 
+func (d *DcsProvider) UseSnapshot(date string) error {
+	if d.DbHandle == nil {
+		return fmt.Errorf("Provider has not been initialized\n")
+	}
+	db, err := snapshot.Open(d.dir, date, cache.LatestSchemaVersion())
+	if err != nil {
+		return err
+	}
+	if err = d.DbHandle.Close(); err != nil {
+		log.Printf("Failed to close price cache: %v\n", err)
+	}
+	d.DbHandle = db
+	return nil
+}
+
+// Switches the provider to a staging copy of its price cache for a
+// refresh, see common/staging. Committing the copy replaces the cache;
+// aborting it leaves the cache as it was.
+func (d *DcsProvider) BeginRefresh() (*staging.Cache, error) {
+	if d.DbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	stage, err := staging.Begin(filepath.Join(d.dir, dbName))
+	if err != nil {
+		return nil, err
+	}
+	if err = cache.CreateOrUpdateDatabase(stage.DB); err != nil {
+		stage.Abort()
+		return nil, err
+	}
+	if err = d.DbHandle.Close(); err != nil {
+		log.Printf("Failed to close price cache: %v\n", err)
+	}
+	d.DbHandle = stage.DB
+	return stage, nil
+}
+
+// Saves a dated copy of the price cache. Returns the snapshot's filename.
+func (d *DcsProvider) SaveSnapshot(t time.Time) (string, error) {
+	if d.DbHandle == nil {
+		return "", fmt.Errorf("Provider has not been initialized\n")
+	}
+	return snapshot.Save(d.DbHandle, d.dir, t)
+}
+
+func (d *DcsProvider) GetPriceChanges(from string, to string) ([][]string, error) {
+	if d.dir == "" {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return snapshot.Changes(d.dir, from, to, cache.LatestSchemaVersion(), cache.ListRates)
+}
+
+func (d *DcsProvider) GetCacheMetadata() (*metadata.Metadata, error) {
+	if d.DbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return metadata.Read(d.DbHandle)
+}
+
+func (d *DcsProvider) GetSchemaVersion() (int, int, error) {
+	if d.DbHandle == nil {
+		return 0, 0, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return cache.SchemaVersion(d.DbHandle)
+}
+
 func init() {
 	registry.Register(name, instance)
 }
@@ -49,7 +123,7 @@ func (p *DcsProvider) Initialize(datadir string) error {
 	if err != nil {
 		return err
 	}
-	dbfile := filepath.Join(mydir, "price-cache.db")
+	dbfile := filepath.Join(mydir, dbName)
 	_, err = os.OpenFile(dbfile, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return err
@@ -61,11 +135,35 @@ func (p *DcsProvider) Initialize(datadir string) error {
 	if db == nil {
 		return fmt.Errorf("Failed to open a database file at %s\n", dbfile)
 	}
+	if err = cache.CreateOrUpdateDatabase(db); err != nil {
+		return err
+	}
+	p.DbHandle = db
+	p.dir = mydir
+	runtime.SetFinalizer(p, finalizer)
+	return nil
+}
+
+func (p *DcsProvider) InitializeReadOnly(datadir string) error {
+	mydir := filepath.Join(datadir, name)
+	dbfile := filepath.Join(mydir, dbName)
+	db, err := sql.Open("sqlite3", "file:"+dbfile+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fmt.Errorf("Failed to open a database file at %s\n", dbfile)
+	}
 	p.DbHandle = db
+	p.dir = mydir
 	runtime.SetFinalizer(p, finalizer)
 	return nil
 }
 
+func (p *DcsProvider) CacheFile() string {
+	return dbName
+}
+
 func finalizer(p *DcsProvider) {
 	if p.DbHandle != nil {
 		if err := p.DbHandle.Close(); err != nil {
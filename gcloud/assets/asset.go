@@ -79,6 +79,24 @@ func (a *SmallAsset) scheduling() (string, error) {
 	return "", nil
 }
 
+// GCE labels, for the resource types that have them. Returns nil if there
+// are none.
+func (a *SmallAsset) labels() (map[string]string, error) {
+	if err := a.ensureResourceMap(); err != nil {
+		return nil, err
+	}
+	lm, ok := a.resourceMap["labels"].(map[string]interface{})
+	if !ok || len(lm) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for k, v := range lm {
+		value, _ := v.(string)
+		labels[k] = value
+	}
+	return labels, nil
+}
+
 // a is assumed to be an instance that has at least a boot disk.
 func (a *SmallAsset) licenses() ([]string, error) {
 	if err := a.ensureResourceMap(); err != nil {
@@ -10,6 +10,7 @@ import (
 	// The new one is documented here
 	// https://pkg.go.dev/cloud.google.com/go@v0.73.0/billing/apiv1
 	"google.golang.org/api/cloudbilling/v1"
+	"nephomancy/common/fetch"
 )
 
 type BillingService struct {
@@ -47,16 +48,20 @@ type BillingServiceSku struct {
 	SkuId               string
 }
 
-func ListBillingServices() (map[string]BillingService, error) {
-	ctx := context.Background()
-	client, err := cloudbilling.NewService(ctx)
+func ListBillingServices(ctx context.Context) (map[string]BillingService, error) {
+	client, err := cloudbilling.NewService(ctx, clientOptions("")...)
 	if err != nil {
 		return nil, err
 	}
 	ret := make(map[string]BillingService)
+	nextPageToken := ""
 	for {
-		nextPageToken := ""
-		resp, err := client.Services.List().PageToken(nextPageToken).Do()
+		var resp *cloudbilling.ListServicesResponse
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Services.List().PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -86,9 +91,8 @@ func ListBillingServices() (map[string]BillingService, error) {
 // Some services (e.g. compute engine) have a lot of skus, might be worth
 // looking first which regions the current project is even present in and
 // then skip skus that are regional and don't overlap those regions/zones.
-func ListSkus(billingServiceName *string) (map[string]BillingServiceSku, error) {
-	ctx := context.Background()
-	client, err := cloudbilling.NewService(ctx)
+func ListSkus(ctx context.Context, billingServiceName *string) (map[string]BillingServiceSku, error) {
+	client, err := cloudbilling.NewService(ctx, clientOptions("")...)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +101,12 @@ func ListSkus(billingServiceName *string) (map[string]BillingServiceSku, error)
 	const tsLayout = "2006-01-02T15:04:05.000Z"
 	nextPageToken := ""
 	for {
-		resp, err := client.Services.Skus.List(*billingServiceName).PageSize(100).PageToken(nextPageToken).Do()
+		var resp *cloudbilling.ListSkusResponse
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Services.Skus.List(*billingServiceName).PageSize(100).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -4,19 +4,24 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/api/compute/v1"
+	"nephomancy/common/fetch"
 	"strings"
 )
 
-func ListRegions(project string) ([]string, error) {
-	ctx := context.Background()
+func ListRegions(ctx context.Context, project string) ([]string, error) {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return nil, err
 	}
 
 	ret := make([]string, 0)
-	resp, err := client.Regions.List(project).MaxResults(pageSize).Do()
+	var resp *compute.RegionList
+	err = fetch.Retry(ctx, transient, func(ctx context.Context) error {
+		var err error
+		resp, err = client.Regions.List(project).MaxResults(pageSize).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -39,10 +44,9 @@ func ListRegions(project string) ([]string, error) {
 	return ret, nil
 }
 
-func ListZones(project string) ([]RegionZone, error) {
-	ctx := context.Background()
+func ListZones(ctx context.Context, project string) ([]RegionZone, error) {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +54,12 @@ func ListZones(project string) ([]RegionZone, error) {
 	ret := make([]RegionZone, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.Zones.List(project).MaxResults(pageSize).PageToken(nextPageToken).Do()
+		var resp *compute.ZoneList
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Zones.List(project).MaxResults(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -74,10 +83,9 @@ func ListZones(project string) ([]RegionZone, error) {
 	return ret, nil
 }
 
-func ListMachineTypes(project string, zone string) ([]MachineType, error) {
-	ctx := context.Background()
+func ListMachineTypes(ctx context.Context, project string, zone string) ([]MachineType, error) {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +93,12 @@ func ListMachineTypes(project string, zone string) ([]MachineType, error) {
 	ret := make([]MachineType, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.MachineTypes.List(project, zone).MaxResults(pageSize).PageToken(nextPageToken).Do()
+		var resp *compute.MachineTypeList
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.MachineTypes.List(project, zone).MaxResults(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -131,10 +144,9 @@ func ListMachineTypes(project string, zone string) ([]MachineType, error) {
 	return ret, nil
 }
 
-func ListDiskTypes(project string, zone string) ([]DiskType, error) {
-	ctx := context.Background()
+func ListDiskTypes(ctx context.Context, project string, zone string) ([]DiskType, error) {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +154,12 @@ func ListDiskTypes(project string, zone string) ([]DiskType, error) {
 	ret := make([]DiskType, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.DiskTypes.List(project, zone).MaxResults(pageSize).PageToken(nextPageToken).Do()
+		var resp *compute.DiskTypeList
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.DiskTypes.List(project, zone).MaxResults(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -162,10 +179,9 @@ func ListDiskTypes(project string, zone string) ([]DiskType, error) {
 	return ret, nil
 }
 
-func ListRegionDiskTypes(project string, region string) ([]DiskType, error) {
-	ctx := context.Background()
+func ListRegionDiskTypes(ctx context.Context, project string, region string) ([]DiskType, error) {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +189,12 @@ func ListRegionDiskTypes(project string, region string) ([]DiskType, error) {
 	ret := make([]DiskType, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.RegionDiskTypes.List(project, region).MaxResults(pageSize).PageToken(nextPageToken).Do()
+		var resp *compute.RegionDiskTypeList
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.RegionDiskTypes.List(project, region).MaxResults(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -194,14 +215,18 @@ func ListRegionDiskTypes(project string, region string) ([]DiskType, error) {
 	return ret, nil
 }
 
-func GetProject(project string) error {
-	ctx := context.Background()
-	client, err := compute.NewService(ctx)
+func GetProject(ctx context.Context, project string) error {
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return err
 	}
 
-	resp, err := client.Projects.Get(project).Do()
+	var resp *compute.Project
+	err = fetch.Retry(ctx, transient, func(ctx context.Context) error {
+		var err error
+		resp, err = client.Projects.Get(project).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -221,10 +246,9 @@ func GetProject(project string) error {
 // DisksService: Get(project, zone, disk)  -- calls projects/{project}/zones/{zone}/disks/{disk}
 // Disks.List takes project and zone  (these are always zonal disks, use RegionDisks for the others)
 
-func ListDisks(project string) error {
-	ctx := context.Background()
+func ListDisks(ctx context.Context, project string) error {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return err
 	}
@@ -232,7 +256,12 @@ func ListDisks(project string) error {
 	// ret := make([]Instance, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.Disks.AggregatedList(project).MaxResults(pageSize).PageToken(nextPageToken).Do()
+		var resp *compute.DiskAggregatedList
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Disks.AggregatedList(project).MaxResults(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -252,10 +281,9 @@ func ListDisks(project string) error {
 	return nil
 }
 
-func ListInstances(project string) error {
-	ctx := context.Background()
+func ListInstances(ctx context.Context, project string) error {
 	const pageSize int64 = 100
-	client, err := compute.NewService(ctx)
+	client, err := compute.NewService(ctx, clientOptions("compute/v1/")...)
 	if err != nil {
 		return err
 	}
@@ -263,7 +291,12 @@ func ListInstances(project string) error {
 	// ret := make([]Instance, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.Instances.AggregatedList(project).MaxResults(pageSize).PageToken(nextPageToken).Do()
+		var resp *compute.InstanceAggregatedList
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Instances.AggregatedList(project).MaxResults(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return err
 		}
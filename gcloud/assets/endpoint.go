@@ -0,0 +1,25 @@
+package assets
+
+import (
+	"os"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// If set, the Google API clients talk to this URL instead of the real
+// APIs, and don't authenticate. For testing against gcloud/fake; can
+// also be set with NEPHOMANCY_GCLOUD_ENDPOINT.
+var Endpoint = os.Getenv("NEPHOMANCY_GCLOUD_ENDPOINT")
+
+// Returns the client options for an API whose paths start with basePath,
+// e.g. "compute/v1/" for Compute.
+func clientOptions(basePath string) []option.ClientOption {
+	if Endpoint == "" {
+		return nil
+	}
+	return []option.ClientOption{
+		option.WithEndpoint(strings.TrimSuffix(Endpoint, "/") + "/" + basePath),
+		option.WithoutAuthentication(),
+	}
+}
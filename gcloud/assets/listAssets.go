@@ -11,6 +11,7 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/api/cloudasset/v1p5beta1"
+	"nephomancy/common/fetch"
 )
 
 // Remember to export GOOGLE_APPLICATION_CREDENTIALS=<wherever.json>
@@ -18,10 +19,9 @@ import (
 // make sure the project you pass in the parent is the one that the application credentials
 // grant access to
 // you need the cloud-platform auth scope or an equivalent role.
-func ListAssetsForProject(project string) ([]SmallAsset, error) {
-	ctx := context.Background()
+func ListAssetsForProject(ctx context.Context, project string) ([]SmallAsset, error) {
 	var pageSize int64 = 100
-	client, err := cloudasset.NewService(ctx)
+	client, err := cloudasset.NewService(ctx, clientOptions("")...)
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +29,12 @@ func ListAssetsForProject(project string) ([]SmallAsset, error) {
 	ret := make([]SmallAsset, 0)
 	nextPageToken := ""
 	for {
-		resp, err := client.Assets.List(project).ContentType("RESOURCE").PageSize(pageSize).PageToken(nextPageToken).Do()
+		var resp *cloudasset.ListAssetsResponse
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Assets.List(project).ContentType("RESOURCE").PageSize(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,49 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-test/deep"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io/ioutil"
+	common "nephomancy/common/resources"
+	"nephomancy/gcloud/fake"
+	"sort"
+	"testing"
+)
+
+func TestListAssetsForProjectFromFake(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	Endpoint = server.URL
+	defer func() { Endpoint = "" }()
+
+	ax, err := ListAssetsForProject(context.Background(),
+		fmt.Sprintf("projects/%s", fake.Project))
+	if err != nil {
+		t.Fatalf("ListAssetsForProject failed: %v", err)
+	}
+	if got := len(server.Requests()); got != 2 {
+		t.Errorf("expected two pages of assets, got %d", got)
+	}
+	p, err := BuildProject(ax)
+	if err != nil {
+		t.Fatalf("BuildProject failed: %v", err)
+	}
+	wanted, err := ioutil.ReadFile("testdata/project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantedProject := &common.Project{}
+	if err = protojson.Unmarshal(wanted, wantedProject); err != nil {
+		t.Fatal(err)
+	}
+	if wantedProject.Networks[0].Subnetworks[0].Gateways == nil {
+		wantedProject.Networks[0].Subnetworks[0].Gateways = make([]*common.Gateway, 0)
+	}
+	sort.Sort(SortableDiskSet(wantedProject.DiskSets))
+	sort.Sort(SortableDiskSet(p.DiskSets))
+	if diff := deep.Equal(wantedProject, p); diff != nil {
+		t.Errorf("project built from fake assets differs: %v", diff)
+	}
+}
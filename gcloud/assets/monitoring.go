@@ -3,113 +3,305 @@ package assets
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"nephomancy/common/fetch"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/monitoring/apiv3"
-	"google.golang.org/protobuf/types/known/durationpb"
-	//	metricpb "google.golang.org/genproto/googleapis/api/metric"
 	timestamppb "github.com/golang/protobuf/ptypes/timestamp"
 	"google.golang.org/api/iterator"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 // code is here: https://godoc.org/cloud.google.com/go/monitoring/apiv3#pkg-files
 
-// e.g. metricType=networking.googleapis.com/vm_flow/egress_bytes_count
-// resource.type=gce_instance
-// Probably no need for this, can just get the timeseries directly.
-func getMetricDescriptors(project string, metricType string, resourceType string) error {
-	ctx := context.Background()
+// If set, time series are read from recordings in this directory instead
+// of the Monitoring API, see RecordedMonitoring. Can also be set with
+// NEPHOMANCY_GCLOUD_MONITORING.
+var MonitoringFixture = os.Getenv("NEPHOMANCY_GCLOUD_MONITORING")
+
+// Metrics used for rightsizing. The memory metric is only there for
+// instances running the Ops agent.
+const (
+	CpuUtilizationMetric = "compute.googleapis.com/instance/cpu/utilization"
+	MemoryUsedMetric     = "agent.googleapis.com/memory/percent_used"
+)
+
+// A source of time series, usually the Monitoring API.
+type TimeSeriesSource interface {
+	ListTimeSeries(ctx context.Context, req *monitoringpb.ListTimeSeriesRequest) (
+		[]*monitoringpb.TimeSeries, error)
+	Close() error
+}
+
+// Returns the Monitoring API, or the recordings in MonitoringFixture
+// if it is set.
+func NewMonitoring(ctx context.Context) (TimeSeriesSource, error) {
+	if MonitoringFixture != "" {
+		return &RecordedMonitoring{Dir: MonitoringFixture}, nil
+	}
 	client, err := monitoring.NewMetricClient(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &apiMonitoring{client}, nil
+}
+
+type apiMonitoring struct {
+	client *monitoring.MetricClient
+}
+
+// Gets the time series a page at a time, so that a failed page can be
+// retried on its own.
+func (m *apiMonitoring) ListTimeSeries(ctx context.Context, req *monitoringpb.ListTimeSeriesRequest) (
+	[]*monitoringpb.TimeSeries, error) {
+	const pageSize = 1000
+	var ret []*monitoringpb.TimeSeries
 	nextPageToken := ""
-	// filter := fmt.Sprintf(`metric.type=starts_with("%s")`, "networking.googleapis.com")
-	filter := fmt.Sprintf(`metric.type="%s" AND resource.type="%s"`,
-		metricType, resourceType)
 	for {
-		req := &monitoringpb.ListMetricDescriptorsRequest{
-			Name:      project,
-			Filter:    filter,
-			PageSize:  100,
-			PageToken: nextPageToken,
-		}
-		it := client.ListMetricDescriptors(ctx, req)
-		for {
-			resp, err := it.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return err
-			}
-			fmt.Printf("resp: %+v\n", resp)
+		var page []*monitoringpb.TimeSeries
+		var next string
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			page = nil
+			var err error
+			next, err = iterator.NewPager(m.client.ListTimeSeries(ctx, req),
+				pageSize, nextPageToken).NextPage(&page)
+			return err
+		})
+		if err != nil {
+			return nil, err
 		}
-		if it.PageInfo().Token == "" {
-			break
+		ret = append(ret, page...)
+		if next == "" {
+			return ret, nil
 		}
-		nextPageToken = it.PageInfo().Token
+		nextPageToken = next
+	}
+}
+
+func (m *apiMonitoring) Close() error {
+	return m.client.Close()
+}
+
+// Time series recorded with RecordingMonitoring. There is one file per
+// project and metric type, holding a ListTimeSeriesResponse as json,
+// e.g. my-project/compute.googleapis.com_instance_cpu_utilization.json.
+// The request's interval is ignored.
+type RecordedMonitoring struct {
+	Dir string
+}
+
+func recordingFile(dir string, req *monitoringpb.ListTimeSeriesRequest, metricType string) string {
+	project := strings.TrimPrefix(req.Name, "projects/")
+	return filepath.Join(dir, project, strings.ReplaceAll(metricType, "/", "_")+".json")
+}
+
+func (m *RecordedMonitoring) ListTimeSeries(ctx context.Context, req *monitoringpb.ListTimeSeriesRequest) (
+	[]*monitoringpb.TimeSeries, error) {
+	data, err := ioutil.ReadFile(recordingFile(m.Dir, req, filterMetricType(req.Filter)))
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	var resp monitoringpb.ListTimeSeriesResponse
+	if err = protojson.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return resp.TimeSeries, nil
+}
+
+func (m *RecordedMonitoring) Close() error {
 	return nil
 }
 
-func getTimeSeries(project string, metricType string, resourceType string) error {
-	ctx := context.Background()
-	client, err := monitoring.NewMetricClient(ctx)
+// Passes requests on to a source and saves the responses in Dir, in the
+// format RecordedMonitoring reads.
+type RecordingMonitoring struct {
+	TimeSeriesSource
+	Dir string
+}
+
+func (m *RecordingMonitoring) ListTimeSeries(ctx context.Context, req *monitoringpb.ListTimeSeriesRequest) (
+	[]*monitoringpb.TimeSeries, error) {
+	ts, err := m.TimeSeriesSource.ListTimeSeries(ctx, req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	file := recordingFile(m.Dir, req, filterMetricType(req.Filter))
+	if err = os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return nil, err
+	}
+	data, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(
+		&monitoringpb.ListTimeSeriesResponse{TimeSeries: ts})
+	if err != nil {
+		return nil, err
 	}
-	now := time.Now().Unix()
-	interval := &monitoringpb.TimeInterval{
-		EndTime: &timestamppb.Timestamp{
-			Seconds: now,
+	return ts, ioutil.WriteFile(file, data, 0644)
+}
+
+// Returns the metric type a filter made by timeSeriesRequest asks for.
+func filterMetricType(filter string) string {
+	parts := strings.SplitN(filter, `"`, 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Asks for the means of a gce_instance metric over 10 minute periods,
+// per instance. Extra restricts the filter further.
+func timeSeriesRequest(project string, metricType string, extra string,
+	start time.Time, end time.Time) *monitoringpb.ListTimeSeriesRequest {
+	filter := fmt.Sprintf(`metric.type="%s" AND resource.type="gce_instance"`, metricType)
+	if extra != "" {
+		filter += " AND " + extra
+	}
+	return &monitoringpb.ListTimeSeriesRequest{
+		Name:   fmt.Sprintf("projects/%s", project),
+		Filter: filter,
+		Interval: &monitoringpb.TimeInterval{
+			StartTime: &timestamppb.Timestamp{Seconds: start.Unix()},
+			EndTime:   &timestamppb.Timestamp{Seconds: end.Unix()},
 		},
-		StartTime: &timestamppb.Timestamp{
-			Seconds: now - 86400*7,
+		// FULL includes the resource metadata with the machine type.
+		View: monitoringpb.ListTimeSeriesRequest_FULL,
+		Aggregation: &monitoringpb.Aggregation{
+			PerSeriesAligner: monitoringpb.Aggregation_ALIGN_MEAN,
+			AlignmentPeriod:  &durationpb.Duration{Seconds: 600},
 		},
 	}
+}
+
+// Usage of one instance.
+type InstanceMetrics struct {
+	MachineType string
+	Zone        string
+	// Id of the instance.
+	Instance string
+	// Samples as fractions of capacity, one per 10 minutes. Memory is
+	// empty for instances without the Ops agent.
+	Cpu    []float64
+	Memory []float64
+}
+
+// Gets cpu and memory usage between start and end for each instance in a
+// project.
+func GetInstanceMetrics(ctx context.Context, src TimeSeriesSource, project string,
+	start time.Time, end time.Time) ([]*InstanceMetrics, error) {
+	var ret []*InstanceMetrics
+	byId := make(map[string]*InstanceMetrics)
+	metrics := []struct {
+		metricType string
+		extra      string
+		// Divides values into fractions.
+		scale   float64
+		samples func(*InstanceMetrics) *[]float64
+	}{
+		{CpuUtilizationMetric, "", 1, func(m *InstanceMetrics) *[]float64 { return &m.Cpu }},
+		{MemoryUsedMetric, `metric.labels.state="used"`, 100, func(m *InstanceMetrics) *[]float64 { return &m.Memory }},
+	}
+	for _, metric := range metrics {
+		series, err := src.ListTimeSeries(ctx, timeSeriesRequest(
+			project, metric.metricType, metric.extra, start, end))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %v", metric.metricType, err)
+		}
+		for _, ts := range series {
+			mt := ts.GetMetadata().GetSystemLabels().GetFields()["machine_type"].GetStringValue()
+			zone := ts.GetResource().GetLabels()["zone"]
+			id := ts.GetResource().GetLabels()["instance_id"]
+			if mt == "" || zone == "" || id == "" {
+				continue
+			}
+			m := byId[id]
+			if m == nil {
+				m = &InstanceMetrics{
+					MachineType: mt,
+					Zone:        zone,
+					Instance:    id,
+				}
+				byId[id] = m
+				ret = append(ret, m)
+			}
+			samples := metric.samples(m)
+			for _, pt := range ts.Points {
+				*samples = append(*samples, pt.GetValue().GetDoubleValue()/metric.scale)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// e.g. metricType=networking.googleapis.com/vm_flow/egress_bytes_count
+// resource.type=gce_instance
+// Probably no need for this, can just get the timeseries directly.
+func getMetricDescriptors(ctx context.Context, project string, metricType string, resourceType string) error {
+	client, err := monitoring.NewMetricClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	// filter := fmt.Sprintf(`metric.type=starts_with("%s")`, "networking.googleapis.com")
 	filter := fmt.Sprintf(`metric.type="%s" AND resource.type="%s"`,
 		metricType, resourceType)
-	groupBy := "metric.labels.instance_name"
-	_ = groupBy
-	req := &monitoringpb.ListTimeSeriesRequest{
-		Name:     project,
-		Filter:   filter,
-		Interval: interval,
-		View:     monitoringpb.ListTimeSeriesRequest_FULL,
-		Aggregation: &monitoringpb.Aggregation{
-			CrossSeriesReducer: monitoringpb.Aggregation_REDUCE_SUM,
-			PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_MEAN,
-			AlignmentPeriod:    &durationpb.Duration{Seconds: 600},
-			GroupByFields:      []string{groupBy},
-		},
+	req := &monitoringpb.ListMetricDescriptorsRequest{
+		Name:   project,
+		Filter: filter,
 	}
-	fmt.Printf("sending ts request: %+v\n", req)
-	it := client.ListTimeSeries(ctx, req)
+	nextPageToken := ""
 	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			fmt.Printf("done going over time series\n")
-			break
-		}
+		var page []*metricpb.MetricDescriptor
+		var next string
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			page = nil
+			var err error
+			next, err = iterator.NewPager(client.ListMetricDescriptors(ctx, req),
+				100, nextPageToken).NextPage(&page)
+			return err
+		})
 		if err != nil {
-			fmt.Printf("error: %v\n", err)
 			return err
 		}
-		fmt.Printf("ts: %+v\n", resp)
+		for _, resp := range page {
+			fmt.Printf("resp: %+v\n", resp)
+		}
+		if next == "" {
+			return nil
+		}
+		nextPageToken = next
 	}
-	return nil
 }
 
-func ListMetrics(project string) error {
-	err := getMetricDescriptors(project,
+// Prints the uptime metric descriptor and a week of uptime per instance.
+func ListMetrics(ctx context.Context, project string) error {
+	err := getMetricDescriptors(ctx, project,
 		// "networking.googleapis.com/vm_flow/egress_bytes_count", "gce_instance")
 		"compute.googleapis.com/instance/uptime", "gce_instance")
 	if err != nil {
 		return err
 	}
-	err = getTimeSeries(project,
-		"compute.googleapis.com/instance/uptime_total", "gce_instance")
+	src, err := NewMonitoring(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	end := time.Now()
+	series, err := src.ListTimeSeries(ctx, timeSeriesRequest(
+		strings.TrimPrefix(project, "projects/"),
+		"compute.googleapis.com/instance/uptime_total", "", end.Add(-7*24*time.Hour), end))
+	if err != nil {
+		return err
+	}
+	for _, ts := range series {
+		fmt.Printf("ts: %+v\n", ts)
+	}
 	return nil
 }
@@ -0,0 +1,55 @@
+package assets
+
+import (
+	"context"
+	"io/ioutil"
+	"nephomancy/gcloud/fake"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestGetInstanceMetricsFromRecording(t *testing.T) {
+	end := time.Now()
+	start := end.Add(-24 * time.Hour)
+	metrics, err := GetInstanceMetrics(context.Background(),
+		&RecordedMonitoring{Dir: fake.MonitoringDir()}, fake.Project, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 5 {
+		t.Fatalf("expected metrics for 5 instances, got %d", len(metrics))
+	}
+	web := metrics[0]
+	if web.MachineType != "n1-standard-2" || web.Zone != "europe-west1-b" ||
+		web.Instance != "1001" || len(web.Cpu) != 4 || len(web.Memory) != 4 {
+		t.Errorf("unexpected metrics for the first instance: %+v", web)
+	}
+	if web.Memory[0] != 0.25 {
+		t.Errorf("expected memory as a fraction, got %v", web.Memory[0])
+	}
+
+	// Recording what the recording returns gives the same metrics.
+	dir, err := ioutil.TempDir("", "monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rec := &RecordingMonitoring{
+		TimeSeriesSource: &RecordedMonitoring{Dir: fake.MonitoringDir()},
+		Dir:              dir,
+	}
+	if _, err = GetInstanceMetrics(context.Background(), rec, fake.Project, start, end); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := GetInstanceMetrics(context.Background(),
+		&RecordedMonitoring{Dir: dir}, fake.Project, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(replayed, metrics); diff != nil {
+		t.Error(diff)
+	}
+}
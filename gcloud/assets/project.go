@@ -6,6 +6,7 @@ import (
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/anypb"
 	common "nephomancy/common/resources"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -27,9 +28,10 @@ type ProjectInProgress struct {
 	danglingImages map[string]*common.Image
 	// Map long name of disk to disk
 	danglingDisks map[string]*common.Disk
-	// Map network name to list of regions where
-	// subnetworks exist
-	danglingSubnetworks map[string][]string
+	// Map long name of disk to its labels
+	danglingDiskLabels map[string]map[string]string
+	// Map network name to its subnetworks
+	danglingSubnetworks map[string][]danglingSubnetwork
 	// Map ip addresses to their features.
 	danglingIPs map[string]*GCloudIpAddress
 }
@@ -45,7 +47,8 @@ func BuildProject(ax []SmallAsset) (*common.Project, error) {
 		project:             p,
 		danglingImages:      make(map[string](*common.Image)),
 		danglingDisks:       make(map[string](*common.Disk)),
-		danglingSubnetworks: make(map[string]([]string)),
+		danglingDiskLabels:  make(map[string](map[string]string)),
+		danglingSubnetworks: make(map[string]([]danglingSubnetwork)),
 		danglingIPs:         make(map[string](*GCloudIpAddress)),
 	}
 	for _, as := range ax {
@@ -63,7 +66,8 @@ func BuildProject(ax []SmallAsset) (*common.Project, error) {
 			if err != nil {
 				return nil, err
 			}
-			if err = addVMToProject(p, vm); err != nil {
+			labels, _ := as.labels()
+			if err = addVMToProject(p, vm, labels); err != nil {
 				return nil, err
 			}
 			if err = addDanglingAddressesToProject(pip, as); err != nil {
@@ -76,6 +80,7 @@ func BuildProject(ax []SmallAsset) (*common.Project, error) {
 					return nil, err
 				}
 				pip.danglingDisks[name] = d
+				pip.danglingDiskLabels[name], _ = as.labels()
 			}
 		case "RegionDisk":
 			{
@@ -84,6 +89,7 @@ func BuildProject(ax []SmallAsset) (*common.Project, error) {
 					return nil, err
 				}
 				pip.danglingDisks[name] = d
+				pip.danglingDiskLabels[name], _ = as.labels()
 			}
 		case "Image":
 			{
@@ -108,6 +114,7 @@ func BuildProject(ax []SmallAsset) (*common.Project, error) {
 				name, _ := as.resourceMap["projectId"].(string)
 				if name != "" {
 					p.Name = name
+					p.Labels, _ = as.labels()
 				}
 			}
 		case "Firewall":
@@ -164,7 +171,7 @@ func BuildProject(ax []SmallAsset) (*common.Project, error) {
 	return p, nil
 }
 
-func VmRegionZone(instance common.Instance) (region string, zone string, err error) {
+func VmRegionZone(instance *common.Instance) (region string, zone string, err error) {
 	var gvm GCloudVM
 	if err := ptypes.UnmarshalAny(
 		instance.ProviderDetails[GcloudProvider], &gvm); err != nil {
@@ -173,7 +180,7 @@ func VmRegionZone(instance common.Instance) (region string, zone string, err err
 	return gvm.Region, gvm.Zone, nil
 }
 
-func DiskRegionZone(disk common.Disk) (region string, zone string, err error) {
+func DiskRegionZone(disk *common.Disk) (region string, zone string, err error) {
 	var gdsk GCloudDisk
 	if err := ptypes.UnmarshalAny(
 		disk.ProviderDetails[GcloudProvider], &gdsk); err != nil {
@@ -182,7 +189,7 @@ func DiskRegionZone(disk common.Disk) (region string, zone string, err error) {
 	return gdsk.Region, gdsk.Zone, nil
 }
 
-func SubnetworkRegion(subnetwork common.Subnetwork) (region string, err error) {
+func SubnetworkRegion(subnetwork *common.Subnetwork) (region string, err error) {
 	var gsnw GCloudSubnetwork
 	if err := ptypes.UnmarshalAny(
 		subnetwork.ProviderDetails[GcloudProvider], &gsnw); err != nil {
@@ -191,7 +198,7 @@ func SubnetworkRegion(subnetwork common.Subnetwork) (region string, err error) {
 	return gsnw.Region, nil
 }
 
-func NetworkTier(network common.Network) (tier string, err error) {
+func NetworkTier(network *common.Network) (tier string, err error) {
 	var gnw GCloudNetwork
 	if err := ptypes.UnmarshalAny(
 		network.ProviderDetails[GcloudProvider], &gnw); err != nil {
@@ -200,7 +207,7 @@ func NetworkTier(network common.Network) (tier string, err error) {
 	return gnw.Tier, nil
 }
 
-func vmNetworkTier(instance common.Instance) (string, error) {
+func vmNetworkTier(instance *common.Instance) (string, error) {
 	var gvm GCloudVM
 	if err := ptypes.UnmarshalAny(
 		instance.ProviderDetails[GcloudProvider], &gvm); err != nil {
@@ -219,14 +226,16 @@ func resolveDisks(pip *ProjectInProgress) error {
 		}
 		pip.danglingDisks[diskName].Image = img
 	}
-	for _, dsk := range pip.danglingDisks {
-		fp, err := fingerprintDisk(*dsk)
+	for diskName, dsk := range pip.danglingDisks {
+		labels := pip.danglingDiskLabels[diskName]
+		fp, err := fingerprintDisk(dsk)
 		if err != nil {
 			return err
 		}
+		fp += fingerprintLabels(labels)
 		for _, dskSet := range pip.project.DiskSets {
-			f, _ := fingerprintDisk(*dskSet.Template)
-			if f == fp {
+			f, _ := fingerprintDisk(dskSet.Template)
+			if f+fingerprintLabels(dskSet.Labels) == fp {
 				dskSet.Count++
 				return nil
 			}
@@ -236,6 +245,7 @@ func resolveDisks(pip *ProjectInProgress) error {
 			Name:     fp,
 			Template: dsk,
 			Count:    1,
+			Labels:   labels,
 		}
 		pip.project.DiskSets = append(pip.project.DiskSets, &dset)
 	}
@@ -245,28 +255,28 @@ func resolveDisks(pip *ProjectInProgress) error {
 func pruneSubnetworks(p *ProjectInProgress) error {
 	regions := make(map[string]string)
 	for _, vms := range p.project.InstanceSets {
-		region, _, _ := VmRegionZone(*vms.Template)
-		tier, _ := vmNetworkTier(*vms.Template)
+		region, _, _ := VmRegionZone(vms.Template)
+		tier, _ := vmNetworkTier(vms.Template)
 		// This assumes there is only one network. FIXME
 		regions[region] = tier
 	}
-	for network, snwRegions := range p.danglingSubnetworks {
-		prunedRegions := make([]string, 0)
-		for _, rg := range snwRegions {
+	for network, snws := range p.danglingSubnetworks {
+		prunedSnws := make([]danglingSubnetwork, 0)
+		for _, s := range snws {
 			// Do we even have an instance in this region?
-			if regions[rg] != "" {
-				prunedRegions = append(prunedRegions, rg)
+			if regions[s.Region] != "" {
+				prunedSnws = append(prunedSnws, s)
 			}
 		}
 		pruned := make([]*common.Subnetwork, 0)
 		var nwTier string
-		for _, reg := range prunedRegions {
-			nwTier = regions[reg]
+		for _, s := range prunedSnws {
+			nwTier = regions[s.Region]
 			details, _ := ptypes.MarshalAny(&GCloudSubnetwork{
-				Region: reg,
+				Region: s.Region,
 			})
 			snw := &common.Subnetwork{
-				Name:     network,
+				Name:     s.Name,
 				Gateways: make([]*common.Gateway, 0),
 				// Traffic estimate.
 				// Gcloud has a limit of 20Gbits/s per external IP address.
@@ -299,6 +309,13 @@ func pruneSubnetworks(p *ProjectInProgress) error {
 	return nil
 }
 
+// A subnetwork from the assets, kept until it is known whether there
+// are instances in its region.
+type danglingSubnetwork struct {
+	Name   string
+	Region string
+}
+
 // This just records that a subnetwork in a given region should
 // exist, the contents will be created during "pruning".
 func addSubnetworkToProject(p *ProjectInProgress, a SmallAsset) error {
@@ -312,12 +329,11 @@ func addSubnetworkToProject(p *ProjectInProgress, a SmallAsset) error {
 	fullName, _ := a.resourceMap["network"].(string)
 	nameParts := strings.Split(fullName, "/")
 	networkName := nameParts[len(nameParts)-1]
-	snwList, ok := p.danglingSubnetworks[networkName]
-	if ok {
-		p.danglingSubnetworks[networkName] = append(snwList, region)
-	} else {
-		p.danglingSubnetworks[networkName] = []string{region}
-	}
+	// Subnetworks are named like the network in auto mode networks,
+	// but not necessarily in custom mode ones.
+	snwParts := strings.Split(a.Name, "/")
+	snw := danglingSubnetwork{Name: snwParts[len(snwParts)-1], Region: region}
+	p.danglingSubnetworks[networkName] = append(p.danglingSubnetworks[networkName], snw)
 	return nil
 }
 
@@ -350,7 +366,7 @@ func addNetworkToProject(p *common.Project, n *common.Network) error {
 // The fingerprints are internal only. This just creates a basic
 // grouping of Instances into sets that is probably useful. There is
 // no need in general for InstanceSets to be uniquely distinguishable.
-func fingerprintVM(instance common.Instance) (string, error) {
+func fingerprintVM(instance *common.Instance) (string, error) {
 	region, _, err := VmRegionZone(instance)
 	if err != nil {
 		return "", err
@@ -374,27 +390,65 @@ func fingerprintVM(instance common.Instance) (string, error) {
 	return fp.String(), nil
 }
 
-func addVMToProject(p *common.Project, instance *common.Instance) error {
-	fp, err := fingerprintVM(*instance)
+// Instances only end up in the same set if they have the same labels.
+func fingerprintLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var fp strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&fp, ":%s=%s", k, labels[k])
+	}
+	return fp.String()
+}
+
+func addVMToProject(p *common.Project, instance *common.Instance,
+	labels map[string]string) error {
+	fp, err := fingerprintVM(instance)
 	if err != nil {
 		return err
 	}
+	fp += fingerprintLabels(labels)
 	for _, instanceSet := range p.InstanceSets {
-		f, _ := fingerprintVM(*instanceSet.Template)
-		if f == fp {
+		f, _ := fingerprintVM(instanceSet.Template)
+		if f+fingerprintLabels(instanceSet.Labels) == fp {
 			instanceSet.Count++
-			return nil
+			return addInstanceIds(instanceSet.Template, instance)
 		}
 	}
 	// No instance set with the given fingerprint yet
 	vset := common.InstanceSet{
 		Template: instance,
 		Count:    1,
+		Labels:   labels,
 	}
 	p.InstanceSets = append(p.InstanceSets, &vset)
 	return nil
 }
 
+// Adds the ids of the instance to the template of its set.
+func addInstanceIds(template *common.Instance, instance *common.Instance) error {
+	var gvm, ivm GCloudVM
+	if err := ptypes.UnmarshalAny(template.ProviderDetails[GcloudProvider], &gvm); err != nil {
+		return err
+	}
+	if err := ptypes.UnmarshalAny(instance.ProviderDetails[GcloudProvider], &ivm); err != nil {
+		return err
+	}
+	gvm.InstanceIds = append(gvm.InstanceIds, ivm.InstanceIds...)
+	details, err := ptypes.MarshalAny(&gvm)
+	if err != nil {
+		return err
+	}
+	template.ProviderDetails[GcloudProvider] = details
+	return nil
+}
+
 func createVM(a SmallAsset) (*common.Instance, error) {
 	networkTier := ""
 	if a.resourceMap["networkInterfaces"] != nil {
@@ -441,6 +495,10 @@ func createVM(a SmallAsset) (*common.Instance, error) {
 	region := regions[0]
 	machineType, _ := a.machineType()
 	scheduling, _ := a.scheduling()
+	var ids []string
+	if id, _ := a.resourceMap["id"].(string); id != "" {
+		ids = []string{id}
+	}
 
 	details, err := ptypes.MarshalAny(&GCloudVM{
 		MachineType: machineType,
@@ -449,6 +507,7 @@ func createVM(a SmallAsset) (*common.Instance, error) {
 		Region:      region,
 		Zone:        zone,
 		OsChoice:    os,
+		InstanceIds: ids,
 	})
 	if err != nil {
 		return nil, err
@@ -464,7 +523,7 @@ func createVM(a SmallAsset) (*common.Instance, error) {
 	return &ret, nil
 }
 
-func fingerprintDisk(disk common.Disk) (string, error) {
+func fingerprintDisk(disk *common.Disk) (string, error) {
 	region, _, _ := DiskRegionZone(disk)
 	if region == "" {
 		return "", fmt.Errorf("missing region for disk %+v", disk)
@@ -86,6 +86,13 @@ func MaxBandwidthGbps(m string, ingress bool, external bool) int32 {
 	// this information in the machine type list you can get from the compute
 	// API though, so this is from the website. Should maybe put it into the cache db.
 	parts := strings.Split(m, "-")
+	if len(parts) < 3 {
+		// shared-core machine types (e2-medium, f1-micro, ...)
+		if m == "e2-medium" {
+			return 2
+		}
+		return 1
+	}
 	cpuCount, _ := strconv.Atoi(parts[2])
 	if parts[0] == "n1" {
 		if cpuCount == 1 {
@@ -114,3 +121,33 @@ func MaxBandwidthGbps(m string, ingress bool, external bool) int32 {
 	}
 	return int32(max)
 }
+
+// CPU architecture for a given machine type, "ARM" or "x86".
+func CpuArchitecture(m string) string {
+	if strings.HasPrefix(m, "t2a-") {
+		return "ARM"
+	}
+	return "x86"
+}
+
+// Local SSD interfaces supported by a given machine type. This is
+// empty for machine types that don't support local SSDs.
+// E2, T2D, T2A, memory-optimized ultramem and m2, and the shared-core
+// machine types do not support local ssd.
+// n2, n2d, n1, compute-optimized, memory-optimized megamem, accelerator-optimized
+// a2 support local ssd.
+func LocalSsdInterfaces(m string) []string {
+	parts := strings.Split(m, "-")
+	if len(parts) < 3 {
+		return nil
+	}
+	switch parts[0] {
+	case "n1", "n2", "n2d", "c2", "c2d", "a2":
+		return []string{"NVMe", "SCSI"}
+	case "m1":
+		if parts[1] == "megamem" {
+			return []string{"NVMe", "SCSI"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package assets
+
+import (
+	"testing"
+)
+
+func TestCpuArchitecture(t *testing.T) {
+	if a := CpuArchitecture("t2a-standard-4"); a != "ARM" {
+		t.Errorf("Expected ARM for t2a-standard-4 but got %s\n", a)
+	}
+	if a := CpuArchitecture("n2-standard-4"); a != "x86" {
+		t.Errorf("Expected x86 for n2-standard-4 but got %s\n", a)
+	}
+}
+
+func TestLocalSsdInterfaces(t *testing.T) {
+	for _, mt := range []string{"n1-standard-1", "n2-highmem-8", "m1-megamem-96"} {
+		if len(LocalSsdInterfaces(mt)) == 0 {
+			t.Errorf("Expected %s to support local ssd\n", mt)
+		}
+	}
+	for _, mt := range []string{"e2-standard-2", "e2-medium", "m1-ultramem-40", "t2a-standard-1"} {
+		if i := LocalSsdInterfaces(mt); len(i) != 0 {
+			t.Errorf("Expected %s not to support local ssd but got %v\n", mt, i)
+		}
+	}
+}
+
+func TestMaxBandwidthGbps(t *testing.T) {
+	cases := map[string]int32{
+		"n1-standard-1":  2,
+		"n2-standard-8":  16,
+		"e2-standard-32": 16,
+		"e2-medium":      2,
+		"f1-micro":       1,
+	}
+	for mt, want := range cases {
+		if got := MaxBandwidthGbps(mt, false, false); got != want {
+			t.Errorf("Expected %d Gbps for %s but got %d\n", want, mt, got)
+		}
+	}
+}
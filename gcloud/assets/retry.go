@@ -0,0 +1,30 @@
+package assets
+
+import (
+	"errors"
+	"nephomancy/common/fetch"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Returns whether an error from a Google API is worth retrying. Clients
+// like the Monitoring one use gRPC and return gRPC status errors.
+func transient(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500
+	}
+	var serr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &serr) {
+		switch serr.GRPCStatus().Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded,
+			codes.Internal, codes.Aborted:
+			return true
+		}
+		return false
+	}
+	return fetch.Transient(err)
+}
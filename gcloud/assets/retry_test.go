@@ -0,0 +1,27 @@
+package assets
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTransient(t *testing.T) {
+	for err, want := range map[error]bool{
+		&googleapi.Error{Code: http.StatusServiceUnavailable}:           true,
+		&googleapi.Error{Code: http.StatusForbidden}:                    false,
+		status.Error(codes.Unavailable, "try again"):                    true,
+		status.Error(codes.ResourceExhausted, "quota"):                  true,
+		status.Error(codes.PermissionDenied, "no"):                      false,
+		status.Error(codes.InvalidArgument, "bad filter"):               false,
+		fmt.Errorf("listing: %w", status.Error(codes.Internal, "oops")): true,
+	} {
+		if got := transient(err); got != want {
+			t.Errorf("transient(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
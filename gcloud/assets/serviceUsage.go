@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/api/serviceusage/v1"
+	"nephomancy/common/fetch"
 )
 
 // code is here: https://code.googlesource.com/google-api-go-client/+/master/serviceusage/v1/serviceusage-gen.go
@@ -19,16 +20,20 @@ import (
 // Services []*GoogleApiServiceusageV1Service
 // Get method takes a name, returns one *GoogleApiServiceusageV1Service
 
-func ListServices(project string) error {
-	ctx := context.Background()
+func ListServices(ctx context.Context, project string) error {
 	const pageSize int64 = 100
-	client, err := serviceusage.NewService(ctx)
+	client, err := serviceusage.NewService(ctx, clientOptions("")...)
 	if err != nil {
 		return err
 	}
 	nextPageToken := ""
 	for {
-		resp, err := client.Services.List(project).PageSize(pageSize).PageToken(nextPageToken).Do()
+		var resp *serviceusage.ListServicesResponse
+		err := fetch.Retry(ctx, transient, func(ctx context.Context) error {
+			var err error
+			resp, err = client.Services.List(project).PageSize(pageSize).PageToken(nextPageToken).Context(ctx).Do()
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,166 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	common "nephomancy/common/resources"
+	"sort"
+	"strings"
+	"time"
+
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// VPC flow log metrics. They are only there for subnetworks with flow
+// logs turned on, and are estimates based on sampled flows.
+const (
+	EgressBytesMetric  = "networking.googleapis.com/vm_flow/egress_bytes_count"
+	IngressBytesMetric = "networking.googleapis.com/vm_flow/ingress_bytes_count"
+)
+
+// Observed traffic of the instances in one subnetwork, in gbits per
+// month.
+type SubnetworkTraffic struct {
+	Network    string
+	Subnetwork string
+	Region     string
+	Ingress    float64
+	// Egress by destination. Same region egress is to other zones in
+	// the region; traffic within a zone is free and left out.
+	InternetEgress    float64
+	SameRegionEgress  float64
+	OtherRegionEgress float64
+}
+
+// Asks for the bytes sent per instance zone, network, subnetwork, remote
+// region and remote zone, summed up per hour.
+func trafficRequest(project string, metricType string, start time.Time, end time.Time) *monitoringpb.ListTimeSeriesRequest {
+	req := timeSeriesRequest(project, metricType, "", start, end)
+	req.Aggregation = &monitoringpb.Aggregation{
+		PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_SUM,
+		AlignmentPeriod:    &durationpb.Duration{Seconds: 3600},
+		CrossSeriesReducer: monitoringpb.Aggregation_REDUCE_SUM,
+		GroupByFields: []string{
+			"resource.labels.zone",
+			"metric.labels.local_network",
+			"metric.labels.local_subnetwork",
+			"metric.labels.remote_region",
+			"metric.labels.remote_zone",
+		},
+	}
+	return req
+}
+
+// Returns the region of a zone, e.g. europe-west1 for europe-west1-b.
+func zoneRegion(zone string) string {
+	if idx := strings.LastIndex(zone, "-"); idx > 0 {
+		return zone[:idx]
+	}
+	return zone
+}
+
+// Gets the traffic of the instances in a project between start and end,
+// scaled up or down to a month, per subnetwork. Egress to flows
+// without a remote region counts as internet egress, and egress within
+// the zone is dropped.
+func GetSubnetworkTraffic(ctx context.Context, src TimeSeriesSource, project string,
+	start time.Time, end time.Time) ([]*SubnetworkTraffic, error) {
+	if !end.After(start) {
+		return nil, fmt.Errorf("the end %v is not after the start %v", end, start)
+	}
+	// bytes in the window -> gbits per month
+	scale := 8 / 1e9 * 730 / end.Sub(start).Hours()
+	byKey := make(map[string]*SubnetworkTraffic)
+	for _, metricType := range []string{EgressBytesMetric, IngressBytesMetric} {
+		series, err := src.ListTimeSeries(ctx, trafficRequest(project, metricType, start, end))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %v", metricType, err)
+		}
+		for _, ts := range series {
+			network := ts.GetMetric().GetLabels()["local_network"]
+			subnetwork := ts.GetMetric().GetLabels()["local_subnetwork"]
+			zone := ts.GetResource().GetLabels()["zone"]
+			if network == "" || subnetwork == "" || zone == "" {
+				continue
+			}
+			region := zoneRegion(zone)
+			key := trafficKey(network, subnetwork, region)
+			t := byKey[key]
+			if t == nil {
+				t = &SubnetworkTraffic{Network: network, Subnetwork: subnetwork, Region: region}
+				byKey[key] = t
+			}
+			var gbits float64
+			for _, pt := range ts.Points {
+				gbits += float64(pt.GetValue().GetInt64Value()) * scale
+			}
+			if metricType == IngressBytesMetric {
+				t.Ingress += gbits
+				continue
+			}
+			labels := ts.GetMetric().GetLabels()
+			switch labels["remote_region"] {
+			case "":
+				t.InternetEgress += gbits
+			case region:
+				if labels["remote_zone"] != zone {
+					t.SameRegionEgress += gbits
+				}
+			default:
+				t.OtherRegionEgress += gbits
+			}
+		}
+	}
+	ret := make([]*SubnetworkTraffic, 0, len(byKey))
+	for _, t := range byKey {
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Network != ret[j].Network {
+			return ret[i].Network < ret[j].Network
+		}
+		if ret[i].Region != ret[j].Region {
+			return ret[i].Region < ret[j].Region
+		}
+		return ret[i].Subnetwork < ret[j].Subnetwork
+	})
+	return ret, nil
+}
+
+// Subnetwork names are only unique within a network and region.
+func trafficKey(network string, subnetwork string, region string) string {
+	return network + "/" + subnetwork + "/" + region
+}
+
+// Sets the traffic figures of the subnetworks in p that there is observed
+// traffic for. Returns the names and regions of the ones that were set.
+func FillInTraffic(p *common.Project, traffic []*SubnetworkTraffic) ([]string, error) {
+	byKey := make(map[string]*SubnetworkTraffic)
+	for _, t := range traffic {
+		byKey[trafficKey(t.Network, t.Subnetwork, t.Region)] = t
+	}
+	var filled []string
+	for _, nw := range p.Networks {
+		for _, snw := range nw.Subnetworks {
+			if snw.ProviderDetails[GcloudProvider] == nil {
+				continue
+			}
+			region, err := SubnetworkRegion(snw)
+			if err != nil {
+				return nil, fmt.Errorf("subnetwork %s: %v", snw.Name, err)
+			}
+			t := byKey[trafficKey(nw.Name, snw.Name, region)]
+			if t == nil {
+				continue
+			}
+			snw.IngressGbitsPerMonth = uint64(math.Round(t.Ingress))
+			snw.ExternalEgressGbitsPerMonth = uint64(math.Round(t.InternetEgress))
+			snw.InternalEgressGbitsPerMonth = uint64(math.Round(t.OtherRegionEgress))
+			snw.SameRegionEgressGbitsPerMonth = uint64(math.Round(t.SameRegionEgress))
+			filled = append(filled, fmt.Sprintf("%s/%s", snw.Name, region))
+		}
+	}
+	return filled, nil
+}
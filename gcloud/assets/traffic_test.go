@@ -0,0 +1,80 @@
+package assets
+
+import (
+	"context"
+	common "nephomancy/common/resources"
+	"nephomancy/gcloud/fake"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestGetSubnetworkTrafficFromRecording(t *testing.T) {
+	// With a window of a month, gbits per month are just bytes * 8 / 1e9.
+	end := time.Now()
+	traffic, err := GetSubnetworkTraffic(context.Background(),
+		&RecordedMonitoring{Dir: fake.MonitoringDir()}, fake.Project,
+		end.Add(-730*time.Hour), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 32 gbits between instances in europe-west1-b are free and left out.
+	want := []*SubnetworkTraffic{
+		{Network: "default", Subnetwork: "default", Region: "europe-west1", Ingress: 100,
+			InternetEgress: 40, SameRegionEgress: 20, OtherRegionEgress: 10},
+		{Network: "default", Subnetwork: "more", Region: "europe-west1",
+			InternetEgress: 40},
+		{Network: "default", Subnetwork: "default", Region: "us-central1", Ingress: 8,
+			OtherRegionEgress: 8},
+	}
+	defer func(precision int) { deep.FloatPrecision = precision }(deep.FloatPrecision)
+	deep.FloatPrecision = 6
+	if diff := deep.Equal(traffic, want); diff != nil {
+		t.Error(diff)
+	}
+
+	details, err := ptypes.MarshalAny(&GCloudSubnetwork{Region: "europe-west1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snw := &common.Subnetwork{
+		Name:                        "default",
+		InternalEgressGbitsPerMonth: 500,
+		ProviderDetails:             map[string]*anypb.Any{GcloudProvider: details},
+	}
+	p := &common.Project{Networks: []*common.Network{{
+		Name:        "default",
+		Subnetworks: []*common.Subnetwork{snw},
+	}}}
+	filled, err := FillInTraffic(p, traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filled) != 1 || filled[0] != "default/europe-west1" {
+		t.Errorf("unexpected subnetworks filled in: %v", filled)
+	}
+	if snw.IngressGbitsPerMonth != 100 || snw.ExternalEgressGbitsPerMonth != 40 ||
+		snw.InternalEgressGbitsPerMonth != 10 || snw.SameRegionEgressGbitsPerMonth != 20 {
+		t.Errorf("unexpected traffic: %v", snw)
+	}
+
+	// Each subnetwork in the region gets its own traffic.
+	snw2 := &common.Subnetwork{Name: "more", ProviderDetails: snw.ProviderDetails}
+	p.Networks[0].Subnetworks = append(p.Networks[0].Subnetworks, snw2)
+	if filled, err = FillInTraffic(p, traffic); err != nil {
+		t.Fatal(err)
+	}
+	if len(filled) != 2 {
+		t.Errorf("unexpected subnetworks filled in: %v", filled)
+	}
+	if snw.IngressGbitsPerMonth != 100 || snw.ExternalEgressGbitsPerMonth != 40 {
+		t.Errorf("unexpected traffic in default: %v", snw)
+	}
+	if snw2.IngressGbitsPerMonth != 0 || snw2.ExternalEgressGbitsPerMonth != 40 ||
+		snw2.InternalEgressGbitsPerMonth != 0 || snw2.SameRegionEgressGbitsPerMonth != 0 {
+		t.Errorf("unexpected traffic in more: %v", snw2)
+	}
+}
@@ -0,0 +1,131 @@
+package billing
+
+import (
+	"fmt"
+	"nephomancy/gcloud/pricing"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Estimated and actual cost of a resource set, a sku, or unmatched spend,
+// in USD over the report's period.
+type Variance struct {
+	// The resource set name or the sku id.
+	Name        string
+	Description string
+	Estimated   float64
+	Actual      float64
+}
+
+type Report struct {
+	// The usage period the line items cover. Estimates are scaled from
+	// monthly costs to it.
+	Start time.Time
+	End   time.Time
+	Sets  []Variance
+	Skus  []Variance
+	// Spend on skus that nothing in the project was priced with.
+	Unmatched []Variance
+}
+
+// Matches line items to the estimated costs by sku id. When several
+// resource sets are priced with the same sku, its actual cost is split
+// between them in proportion to their estimates.
+func Compare(estimates []pricing.SkuCost, items []LineItem) (*Report, error) {
+	r := &Report{}
+	for _, li := range items {
+		if !li.UsageStart.IsZero() && (r.Start.IsZero() || li.UsageStart.Before(r.Start)) {
+			r.Start = li.UsageStart
+		}
+		if li.UsageEnd.After(r.End) {
+			r.End = li.UsageEnd
+		}
+	}
+	if r.Start.IsZero() || !r.End.After(r.Start) {
+		return nil, fmt.Errorf("the line items need usage start and end times")
+	}
+	scale := r.End.Sub(r.Start).Hours() / 730
+
+	skus := make(map[string]*Variance)
+	var skuOrder []string
+	sets := make(map[string]*Variance)
+	var setOrder []string
+	// Estimated cost per sku and set, for splitting actual costs.
+	bySku := make(map[string]map[string]float64)
+	for _, e := range estimates {
+		if e.Currency != "USD" {
+			return nil, fmt.Errorf("estimate for %s in %s, expected USD", e.Set, e.Currency)
+		}
+		cost := e.Cost * scale
+		if skus[e.SkuId] == nil {
+			skus[e.SkuId] = &Variance{Name: e.SkuId, Description: e.Resource}
+			skuOrder = append(skuOrder, e.SkuId)
+			bySku[e.SkuId] = make(map[string]float64)
+		}
+		skus[e.SkuId].Estimated += cost
+		if sets[e.Set] == nil {
+			sets[e.Set] = &Variance{Name: e.Set}
+			setOrder = append(setOrder, e.Set)
+		}
+		set := sets[e.Set]
+		set.Estimated += cost
+		if !strings.Contains(", "+set.Description+",", ", "+e.Resource+",") {
+			if set.Description != "" {
+				set.Description += ", "
+			}
+			set.Description += e.Resource
+		}
+		bySku[e.SkuId][e.Set] += cost
+	}
+
+	unmatched := make(map[string]*Variance)
+	for _, li := range items {
+		cost, err := li.CostUsd()
+		if err != nil {
+			return nil, err
+		}
+		sku := skus[li.SkuId]
+		if sku == nil {
+			u := unmatched[li.SkuId]
+			if u == nil {
+				u = &Variance{Name: li.SkuId, Description: li.SkuDescription}
+				unmatched[li.SkuId] = u
+			}
+			u.Actual += cost
+			continue
+		}
+		// The billing description is more telling than the resource type.
+		if li.SkuDescription != "" {
+			sku.Description = li.SkuDescription
+		}
+		sku.Actual += cost
+	}
+	for skuId, perSet := range bySku {
+		actual := skus[skuId].Actual
+		for set, estimated := range perSet {
+			if skus[skuId].Estimated > 0 {
+				sets[set].Actual += actual * estimated / skus[skuId].Estimated
+			} else {
+				sets[set].Actual += actual / float64(len(perSet))
+			}
+		}
+	}
+
+	for _, name := range setOrder {
+		r.Sets = append(r.Sets, *sets[name])
+	}
+	for _, id := range skuOrder {
+		r.Skus = append(r.Skus, *skus[id])
+	}
+	for _, u := range unmatched {
+		r.Unmatched = append(r.Unmatched, *u)
+	}
+	sort.Slice(r.Unmatched, func(i, j int) bool {
+		if r.Unmatched[i].Actual != r.Unmatched[j].Actual {
+			return r.Unmatched[i].Actual > r.Unmatched[j].Actual
+		}
+		return r.Unmatched[i].Name < r.Unmatched[j].Name
+	})
+	return r, nil
+}
@@ -0,0 +1,58 @@
+package billing
+
+import (
+	"nephomancy/gcloud/pricing"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestCompare(t *testing.T) {
+	items, err := ReadExportFile("testdata/export.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The export covers 730 hours, so the estimates are not scaled.
+	estimates := []pricing.SkuCost{
+		{Set: "web", Resource: "VM cpu", SkuId: "0009-6F35-3126", Cost: 150, Currency: "USD"},
+		{Set: "web", Resource: "VM memory", SkuId: "3FB0-6E59-F436", Cost: 80, Currency: "USD"},
+		{Set: "db", Resource: "VM cpu", SkuId: "0009-6F35-3126", Cost: 50, Currency: "USD"},
+		{Set: "db", Resource: "VM memory", SkuId: "3FB0-6E59-F436", Cost: 20, Currency: "USD"},
+		{Set: "batch", Resource: "VM cpu", SkuId: "9FE0-8F60-A9F0", Cost: 30, Currency: "USD"},
+		{Set: "batch", Resource: "VM memory", SkuId: "1F9A-A9AC-FFC3", Cost: 20, Currency: "USD"},
+	}
+	r, err := Compare(estimates, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hours := r.End.Sub(r.Start).Hours(); hours != 730 {
+		t.Errorf("expected a period of 730 hours, got %v", hours)
+	}
+	defer func(precision int) { deep.FloatPrecision = precision }(deep.FloatPrecision)
+	deep.FloatPrecision = 6
+	// Shared skus are split 3:1 between web and db, like their estimates.
+	wantSets := []Variance{
+		{"web", "VM cpu, VM memory", 230, 261.5},
+		{"db", "VM cpu, VM memory", 70, 78.5},
+		{"batch", "VM cpu, VM memory", 50, 50},
+	}
+	if diff := deep.Equal(r.Sets, wantSets); diff != nil {
+		t.Errorf("sets: %v", diff)
+	}
+	wantSkus := []Variance{
+		{"0009-6F35-3126", "N1 Predefined Instance Core running in Belgium", 200, 210},
+		{"3FB0-6E59-F436", "N1 Predefined Instance Ram running in Belgium", 100, 130},
+		{"9FE0-8F60-A9F0", "E2 Instance Core running in Belgium", 30, 30},
+		{"1F9A-A9AC-FFC3", "E2 Instance Ram running in Belgium", 20, 20},
+	}
+	if diff := deep.Equal(r.Skus, wantSkus); diff != nil {
+		t.Errorf("skus: %v", diff)
+	}
+	wantUnmatched := []Variance{
+		{"5AC5-C0C8-3A18", "SSD backed PD Capacity in Belgium", 0, 12.5},
+		{"6B8F-E63D-832B", "Active Storage", 0, 3},
+	}
+	if diff := deep.Equal(r.Unmatched, wantUnmatched); diff != nil {
+		t.Errorf("unmatched: %v", diff)
+	}
+}
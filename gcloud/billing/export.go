@@ -0,0 +1,215 @@
+// Package billing reads Cloud Billing exports and compares what was
+// billed with what nephomancy estimated.
+package billing
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One line item of a Cloud Billing export to BigQuery.
+type LineItem struct {
+	ProjectId          string
+	ServiceDescription string
+	SkuId              string
+	SkuDescription     string
+	UsageStart         time.Time
+	UsageEnd           time.Time
+	// Including credits, in Currency.
+	Cost     float64
+	Currency string
+	// Units of Currency per USD. 0 if unknown.
+	ConversionRate float64
+}
+
+// Returns the cost in USD, or an error if it is in another currency
+// without a conversion rate.
+func (li LineItem) CostUsd() (float64, error) {
+	if li.Currency == "" || li.Currency == "USD" {
+		return li.Cost, nil
+	}
+	if li.ConversionRate <= 0 {
+		return 0, fmt.Errorf("no conversion rate from %s to USD for sku %s",
+			li.Currency, li.SkuId)
+	}
+	return li.Cost / li.ConversionRate, nil
+}
+
+// Reads an export saved as .csv or .jsonl (also .json, for newline
+// delimited json as written by bq extract).
+func ReadExportFile(path string) ([]LineItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		return ReadCsv(f)
+	case ".jsonl", ".json", ".ndjson":
+		return ReadJsonl(f)
+	default:
+		return nil, fmt.Errorf("unknown export format %q, expected .csv or .jsonl", ext)
+	}
+}
+
+// A row of the export as written by bq extract --destination_format
+// NEWLINE_DELIMITED_JSON.
+type exportRow struct {
+	Service struct {
+		Description string `json:"description"`
+	} `json:"service"`
+	Sku struct {
+		Id          string `json:"id"`
+		Description string `json:"description"`
+	} `json:"sku"`
+	Project struct {
+		Id string `json:"id"`
+	} `json:"project"`
+	UsageStartTime         string  `json:"usage_start_time"`
+	UsageEndTime           string  `json:"usage_end_time"`
+	Cost                   float64 `json:"cost"`
+	Currency               string  `json:"currency"`
+	CurrencyConversionRate float64 `json:"currency_conversion_rate"`
+	Credits                []struct {
+		Amount float64 `json:"amount"`
+	} `json:"credits"`
+}
+
+// Reads an export as newline delimited json, one row per line.
+func ReadJsonl(r io.Reader) ([]LineItem, error) {
+	var items []LineItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var row exportRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		li := LineItem{
+			ProjectId:          row.Project.Id,
+			ServiceDescription: row.Service.Description,
+			SkuId:              row.Sku.Id,
+			SkuDescription:     row.Sku.Description,
+			Cost:               row.Cost,
+			Currency:           row.Currency,
+			ConversionRate:     row.CurrencyConversionRate,
+		}
+		for _, c := range row.Credits {
+			li.Cost += c.Amount
+		}
+		var err error
+		if li.UsageStart, err = parseTime(row.UsageStartTime); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if li.UsageEnd, err = parseTime(row.UsageEndTime); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		items = append(items, li)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Reads an export as csv, e.g. saved from a query in the BigQuery console.
+// Nested columns can be named sku.id or sku_id. Credits are expected to be
+// summed up into a credits column already.
+func ReadCsv(r io.Reader) ([]LineItem, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header: %v", err)
+	}
+	columns := make(map[string]int)
+	for idx, name := range header {
+		columns[strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), ".", "_")] = idx
+	}
+	for _, required := range []string{"sku_id", "cost"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %s", required)
+		}
+	}
+	var items []LineItem
+	for lineNo := 2; ; lineNo++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		number := func(name string) (float64, error) {
+			v := value(name)
+			if v == "" {
+				return 0, nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %s: %v", lineNo, name, err)
+			}
+			return f, nil
+		}
+		li := LineItem{
+			ProjectId:          value("project_id"),
+			ServiceDescription: value("service_description"),
+			SkuId:              value("sku_id"),
+			SkuDescription:     value("sku_description"),
+			Currency:           value("currency"),
+		}
+		if li.Cost, err = number("cost"); err != nil {
+			return nil, err
+		}
+		credits, err := number("credits")
+		if err != nil {
+			return nil, err
+		}
+		li.Cost += credits
+		if li.ConversionRate, err = number("currency_conversion_rate"); err != nil {
+			return nil, err
+		}
+		if li.UsageStart, err = parseTime(value("usage_start_time")); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if li.UsageEnd, err = parseTime(value("usage_end_time")); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		items = append(items, li)
+	}
+}
+
+// BigQuery writes timestamps like 2020-11-01 08:00:00 UTC, other tools
+// use RFC 3339.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05 MST",
+		"2006-01-02 15:04:05.999999 MST", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", value)
+}
@@ -0,0 +1,38 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestReadExportFile(t *testing.T) {
+	jsonl, err := ReadExportFile("testdata/export.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jsonl) != 8 {
+		t.Fatalf("expected 8 line items, got %d", len(jsonl))
+	}
+	first := jsonl[0]
+	if first.SkuId != "0009-6F35-3126" || first.Cost != 150 ||
+		first.ProjectId != "binderhub-test-275512" ||
+		!first.UsageStart.Equal(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first line item: %+v", first)
+	}
+	if usd, err := jsonl[5].CostUsd(); err != nil || usd != 20 {
+		t.Errorf("expected 16 EUR to be 20 USD, got %v, %v", usd, err)
+	}
+	// The csv has the same line items, with credits summed up.
+	csv, err := ReadExportFile("testdata/export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(csv, jsonl); diff != nil {
+		t.Error(diff)
+	}
+	if _, err = ReadExportFile("testdata/export.txt"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
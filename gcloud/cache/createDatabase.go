@@ -2,6 +2,8 @@ package cache
 
 import (
 	"database/sql"
+	"nephomancy/common/metadata"
+	"nephomancy/common/migrate"
 	"os"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -15,19 +17,39 @@ func CreateOrUpdateDatabase(filename *string) error {
 	handle.Close()
 	db, _ := sql.Open("sqlite3", *filename)
 	defer db.Close()
-	if err = createBillingTables(db); err != nil {
-		return err
-	}
-	return createResourceMetadataTables(db)
+	return MigrateDatabase(db)
+}
+
+// Schema migrations for the gcloud cache, see common/migrate.
+var migrations = []migrate.Step{
+	{Version: 1, Description: "create billing tables", Apply: createBillingTables},
+	{Version: 2, Description: "create resource metadata tables", Apply: createResourceMetadataTables},
+	{Version: 3, Description: "create CacheMetadata", Apply: metadata.CreateTable},
 }
 
-func createBillingTables(db *sql.DB) error {
+// Brings the schema of an open database up to date.
+func MigrateDatabase(db *sql.DB) error {
+	return migrate.Run(db, migrations)
+}
+
+// Returns the schema version caches are migrated to.
+func LatestSchemaVersion() int {
+	return migrate.Latest(migrations)
+}
+
+// Returns the cache's schema version and the latest schema version.
+func SchemaVersion(db *sql.DB) (int, int, error) {
+	v, err := migrate.Version(db)
+	return v, migrate.Latest(migrations), err
+}
+
+func createBillingTables(tx *sql.Tx) error {
 	createBillingServicesTableSQL := `CREATE TABLE IF NOT EXISTS BillingServices (
 		"ServiceId" TEXT NOT NULL PRIMARY KEY,
 		"DisplayName" TEXT NOT NULL,
 		"LastUpdatedTS" INTEGER
 	);`
-	if err := createTable(db, &createBillingServicesTableSQL); err != nil {
+	if err := createTable(tx, &createBillingServicesTableSQL); err != nil {
 		return err
 	}
 
@@ -47,7 +69,7 @@ func createBillingTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createSkuTableSQL); err != nil {
+	if err := createTable(tx, &createSkuTableSQL); err != nil {
 		return err
 	}
 	createServiceRegionsTableSQL := `CREATE TABLE IF NOT EXISTS ServiceRegions (
@@ -59,7 +81,7 @@ func createBillingTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createServiceRegionsTableSQL); err != nil {
+	if err := createTable(tx, &createServiceRegionsTableSQL); err != nil {
 		return err
 	}
 	createPricingInfoTableSQL := `CREATE TABLE IF NOT EXISTS PricingInfo (
@@ -75,7 +97,7 @@ func createBillingTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createPricingInfoTableSQL); err != nil {
+	if err := createTable(tx, &createPricingInfoTableSQL); err != nil {
 		return err
 	}
 
@@ -92,18 +114,18 @@ func createBillingTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createTieredRatesTableSQL); err != nil {
+	if err := createTable(tx, &createTieredRatesTableSQL); err != nil {
 		return err
 	}
 	return nil
 }
 
-func createResourceMetadataTables(db *sql.DB) error {
+func createResourceMetadataTables(tx *sql.Tx) error {
 	createRegionZoneTableSQL := `CREATE TABLE IF NOT EXISTS RegionZone (
 		"Region" STRING NOT NULL,
 		"Zone" STRING NOT NULL PRIMARY KEY
 	);`
-	if err := createTable(db, &createRegionZoneTableSQL); err != nil {
+	if err := createTable(tx, &createRegionZoneTableSQL); err != nil {
 		return err
 	}
 
@@ -117,7 +139,7 @@ func createResourceMetadataTables(db *sql.DB) error {
 		"MemoryMb" INTEGER NOT NULL,
 		"IsSharedCpu" INTEGER  
 	);`
-	if err := createTable(db, &createMachineTypeTableSQL); err != nil {
+	if err := createTable(tx, &createMachineTypeTableSQL); err != nil {
 		return err
 	}
 
@@ -133,7 +155,7 @@ func createResourceMetadataTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createAcceleratorTypeTableSQL); err != nil {
+	if err := createTable(tx, &createAcceleratorTypeTableSQL); err != nil {
 		return err
 	}
 
@@ -151,7 +173,7 @@ func createResourceMetadataTables(db *sql.DB) error {
 		ON DELETE NO ACTION
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createMachineTypesByZoneTableSQL); err != nil {
+	if err := createTable(tx, &createMachineTypesByZoneTableSQL); err != nil {
 		return err
 	}
 
@@ -162,7 +184,7 @@ func createResourceMetadataTables(db *sql.DB) error {
 		"Region" STRING,
 		UNIQUE (DiskType, DefaultSizeGb, Region)
 	);`
-	if err := createTable(db, &createDiskTypesTableSQL); err != nil {
+	if err := createTable(tx, &createDiskTypesTableSQL); err != nil {
 		return err
 	}
 
@@ -175,17 +197,13 @@ func createResourceMetadataTables(db *sql.DB) error {
 		ON DELETE CASCADE
 		ON UPDATE NO ACTION
 	);`
-	if err := createTable(db, &createDiskTypesByZoneTableSQL); err != nil {
+	if err := createTable(tx, &createDiskTypesByZoneTableSQL); err != nil {
 		return err
 	}
 	return nil
 }
 
-func createTable(db *sql.DB, ct *string) error {
-	stmt, err := db.Prepare(*ct)
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec()
+func createTable(tx *sql.Tx, ct *string) error {
+	_, err := tx.Exec(*ct)
 	return err
 }
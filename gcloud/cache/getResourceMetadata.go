@@ -8,15 +8,18 @@ import (
 	"google.golang.org/protobuf/types/known/anypb"
 	"log"
 	"nephomancy/common/geo"
+	"nephomancy/common/query"
+	"nephomancy/common/registry"
 	common "nephomancy/common/resources"
 	"nephomancy/gcloud/assets"
+	"sort"
 	"strings"
 )
 
 // Returns nil if the gcloud vm meets the spec, an error otherwise.
-func checkVmSpec(db *sql.DB, gvm assets.GCloudVM, spec common.Instance) error {
+func checkVmSpec(db *sql.DB, gvm *assets.GCloudVM, spec *common.Instance) error {
 	if l := spec.Location; l != nil {
-		if err := checkLocation(gvm.Region, *l); err != nil {
+		if err := checkLocation(gvm.Region, l); err != nil {
 			return err
 		}
 	}
@@ -30,6 +33,9 @@ func checkVmSpec(db *sql.DB, gvm assets.GCloudVM, spec common.Instance) error {
 			return fmt.Errorf("%s vm provider details (%+v) do not match spec (%+v)",
 				assets.GcloudProvider, mt, t)
 		}
+		if err := checkMachineTypeFeatures(gvm.MachineType, t); err != nil {
+			return err
+		}
 	}
 	if os := spec.Os; os != "" {
 		gOs := gvm.OsChoice
@@ -41,22 +47,82 @@ func checkVmSpec(db *sql.DB, gvm assets.GCloudVM, spec common.Instance) error {
 			return err
 		}
 	}
-	// TODO: also check local disk specs. If spec.LocalStorage is not nil, need to see
-	// whether mt supports local disk.
-	// E2, memory-optimized ultramem, e2 shared-core and n1 shared-core do not support local ssd.
-	// n2, n2d, n1, compute-optimized, memory-optimized megamem, accelerator-optimized high-gpu,
-	// accelerator-optimized mega-gpu support local ssd.
+	if po := spec.PurchaseOption; po != "" && gvm.Scheduling != "" {
+		if scheduling := schedulingByPurchaseOption(po); scheduling != gvm.Scheduling {
+			return fmt.Errorf("%s vm scheduling %s does not match spec purchase option %s",
+				assets.GcloudProvider, gvm.Scheduling, po)
+		}
+	}
+	if len(spec.LocalStorage) > 0 && len(assets.LocalSsdInterfaces(gvm.MachineType)) == 0 {
+		return fmt.Errorf("%s machine type %s does not support local disks",
+			assets.GcloudProvider, gvm.MachineType)
+	}
 	return nil
 }
 
+// Returns nil if the machine type has the cpu architecture, local ssd support
+// and network bandwidth asked for in the spec, an error otherwise.
+func checkMachineTypeFeatures(mt string, spec *common.MachineType) error {
+	if a := spec.CpuArchitecture; a != "" {
+		arch := assets.CpuArchitecture(mt)
+		if (common.IsArmArchitecture(a) && arch != "ARM") ||
+			(common.IsX86Architecture(a) && arch != "x86") {
+			return fmt.Errorf("%s machine type %s has cpu architecture %s, not %s",
+				assets.GcloudProvider, mt, arch, a)
+		}
+	}
+	if spec.LocalSsd {
+		interfaces := assets.LocalSsdInterfaces(mt)
+		if len(interfaces) == 0 {
+			return fmt.Errorf("%s machine type %s does not support local ssd",
+				assets.GcloudProvider, mt)
+		}
+		if spec.LocalSsdInterface != "" {
+			found := false
+			for _, i := range interfaces {
+				if strings.EqualFold(i, spec.LocalSsdInterface) {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s machine type %s does not support %s local ssd",
+					assets.GcloudProvider, mt, spec.LocalSsdInterface)
+			}
+		}
+	}
+	if spec.NetworkGbps > 0 {
+		if bw := assets.MaxBandwidthGbps(mt, false, false); uint32(bw) < spec.NetworkGbps {
+			return fmt.Errorf("%s machine type %s has max bandwidth %d Gbps, less than %d",
+				assets.GcloudProvider, mt, bw, spec.NetworkGbps)
+		}
+	}
+	return nil
+}
+
+// Maps a common purchase option to the gcloud scheduling (which is
+// also the usage type of the matching skus).
+func schedulingByPurchaseOption(po string) string {
+	if po == "Spot" {
+		return "Preemptible"
+	}
+	return po // OnDemand, Commit1Yr, Commit3Yr are the same on gcloud
+}
+
+func purchaseOptionByScheduling(scheduling string) string {
+	if scheduling == "Preemptible" {
+		return "Spot"
+	}
+	return scheduling
+}
+
 // Returns nil if the gcloud disk meets the spec, an error otherwise.
-func checkDiskSpec(db *sql.DB, dsk assets.GCloudDisk, spec common.Disk) error {
+func checkDiskSpec(db *sql.DB, dsk *assets.GCloudDisk, spec *common.Disk) error {
 	region := ""
 	if dsk.IsRegional {
 		region = dsk.Region
 	}
 	if l := spec.Location; l != nil {
-		if err := checkLocation(dsk.Region, *l); err != nil {
+		if err := checkLocation(dsk.Region, l); err != nil {
 			return err
 		}
 	}
@@ -96,6 +162,13 @@ func getOsBySpec(spec string) string {
 // TODO: this method needs to be shorter, and bits of it could be
 // in common.
 func FillInProviderDetails(db *sql.DB, p *common.Project) error {
+	return FillInProviderDetailsIn(db, p, "")
+}
+
+// Like FillInProviderDetails, but places everything in the given region,
+// which has to be consistent with the spec locations. An empty region
+// leaves the choice to the tool.
+func FillInProviderDetailsIn(db *sql.DB, p *common.Project, region string) error {
 	// These are locations that have been resolved into zones or regions.
 	// This is so that if an instance set has the same location spec as
 	// a disk set, they both end up in the same region.
@@ -112,6 +185,9 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 		if vmset.Template.Os == "" {
 			return fmt.Errorf("missing vmset os information")
 		}
+		if err := common.CheckPurchaseOption(vmset.Template.PurchaseOption); err != nil {
+			return err
+		}
 		if vmset.Template.ProviderDetails == nil {
 			vmset.Template.ProviderDetails = make(map[string](*anypb.Any))
 		}
@@ -123,24 +199,27 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 			if err != nil {
 				return err
 			}
-			if err = checkVmSpec(db, gvm, *vmset.Template); err != nil {
+			if err = checkVmSpec(db, &gvm, vmset.Template); err != nil {
 				return err
 			}
 			// If checkVmSpec hasn't errored, gvm.Region exists.
-			locstring := common.PrintLocation(*vmset.Template.Location)
+			locstring := common.PrintLocation(vmset.Template.Location)
 			if locations[locstring] == "" {
 				locations[locstring] = gvm.Region
 			}
 			log.Printf("Instance Set %s already has details for provider %s, leaving them as they are.\n",
 				vmset.Name, assets.GcloudProvider)
 		} else { // There are no provider details
-			regions := resolveSpecLocation(*vmset.Template.Location, "")
+			regions, err := placeRegions(vmset.Template.Location, "", region)
+			if err != nil {
+				return err
+			}
 			if len(regions) == 0 {
 				return fmt.Errorf(
 					"provider %s does not support regions matching location %v",
 					assets.GcloudProvider, vmset.Template.Location)
 			}
-			mt, r, err := getMachineTypeBySpec(db, *vmset.Template.Type, regions)
+			mt, r, err := getMachineTypeBySpec(db, vmset.Template.Type, regions)
 			if err != nil {
 				return err
 			}
@@ -154,11 +233,12 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 				MachineType: mt,
 				Region:      r[0], // only using first region
 				OsChoice:    os,
+				Scheduling:  schedulingByPurchaseOption(vmset.Template.PurchaseOption),
 			})
 			if err != nil {
 				return err
 			}
-			locstring := common.PrintLocation(*vmset.Template.Location)
+			locstring := common.PrintLocation(vmset.Template.Location)
 			if locations[locstring] == "" {
 				locations[locstring] = r[0]
 			}
@@ -214,9 +294,12 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 				log.Printf("subnetwork %s already has details\n",
 					snw.Name)
 			} else {
-				locstring := common.PrintLocation(*snw.Location)
-				regions := resolveSpecLocation(*snw.Location,
-					locations[locstring])
+				locstring := common.PrintLocation(snw.Location)
+				regions, err := placeRegions(snw.Location,
+					locations[locstring], region)
+				if err != nil {
+					return err
+				}
 				if len(regions) == 0 {
 					return fmt.Errorf(
 						"provider %s does not support regions matching location %v",
@@ -250,19 +333,22 @@ func FillInProviderDetails(db *sql.DB, p *common.Project) error {
 				&dsk); err != nil {
 				return err
 			}
-			if err := checkDiskSpec(db, dsk, *dset.Template); err != nil {
+			if err := checkDiskSpec(db, &dsk, dset.Template); err != nil {
 				return err
 			}
 			log.Printf("Disk Set %s already has details for provider %s, leaving them as they are.\n",
 				dset.Name, assets.GcloudProvider)
 		} else { // There are no provider details yet.
 			// Get regions for spec location.
-			locstring := common.PrintLocation(*dset.Template.Location)
-			regions := resolveSpecLocation(*dset.Template.Location, locations[locstring])
+			locstring := common.PrintLocation(dset.Template.Location)
+			regions, err := placeRegions(dset.Template.Location, locations[locstring], region)
+			if err != nil {
+				return err
+			}
 			if len(regions) == 0 {
 				return fmt.Errorf("provider %s does not support regions matching location %v", assets.GcloudProvider, dset.Template.Location)
 			}
-			dt, r, err := getDiskTypeBySpec(db, *dset.Template.Type, regions)
+			dt, r, err := getDiskTypeBySpec(db, dset.Template.Type, regions)
 			if err != nil {
 				return err
 			}
@@ -292,7 +378,7 @@ func FillInSpec(db *sql.DB, p *common.Project) error {
 		if err != nil {
 			return err
 		}
-		if err = checkVmSpec(db, gvm, *vmset.Template); err != nil {
+		if err = checkVmSpec(db, &gvm, vmset.Template); err != nil {
 			return err
 		}
 		mt, err := GetMachineType(db, gvm.MachineType, gvm.Region)
@@ -316,6 +402,9 @@ func FillInSpec(db *sql.DB, p *common.Project) error {
 		if vmset.UsageHoursPerMonth == 0 {
 			vmset.UsageHoursPerMonth = 24 * 30 // full usage
 		}
+		if vmset.Template.PurchaseOption == "" {
+			vmset.Template.PurchaseOption = purchaseOptionByScheduling(gvm.Scheduling)
+		}
 		if vmset.Template.Os == "" {
 			if gvm.OsChoice != "" {
 				choice := assets.OsChoiceByName(gvm.OsChoice)
@@ -392,7 +481,7 @@ func FillInSpec(db *sql.DB, p *common.Project) error {
 // Assume loc is internally consistent.
 // If preferred is not empty, and is contained in the possible regions
 // for the location, return only preferred.
-func resolveSpecLocation(loc common.Location, preferred string) []string {
+func resolveSpecLocation(loc *common.Location, preferred string) []string {
 	var regions []string
 	if loc.CountryCode != "" {
 		regions = RegionsByCountry(loc.CountryCode)
@@ -422,6 +511,31 @@ func resolveSpecLocation(loc common.Location, preferred string) []string {
 	return regions
 }
 
+// Returns the regions to consider for loc, or just region if it is
+// not empty and consistent with loc.
+func placeRegions(loc *common.Location, preferred string, region string) ([]string, error) {
+	if region == "" {
+		return resolveSpecLocation(loc, preferred), nil
+	}
+	if err := checkLocation(region, loc); err != nil {
+		return nil, fmt.Errorf("region %s does not match location %v: %v", region, loc, err)
+	}
+	return []string{region}, nil
+}
+
+// Returns the regions consistent with loc, for placing resources in
+// a region of choice.
+func PlacementRegions(loc *common.Location) []string {
+	var regions []string
+	for _, r := range resolveSpecLocation(loc, "") {
+		if checkLocation(r, loc) == nil {
+			regions = append(regions, r)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
 func resolveLocation(region string) (common.Location, error) {
 	cc := RegionCountry(region)
 	if cc == "Unknown" {
@@ -430,36 +544,27 @@ func resolveLocation(region string) (common.Location, error) {
 	return common.CountryCodeToLocation(cc)
 }
 
-func checkLocation(region string, spec common.Location) error {
+func checkLocation(region string, spec *common.Location) error {
 	loc, err := resolveLocation(region)
 	if err != nil {
 		return err
 	}
-	return common.CheckLocation(loc, spec)
+	return common.CheckLocation(&loc, spec)
 }
 
 // Retrieves a disk type satisfying the spec and available in at least
 // one of the regions provided.
-func getDiskTypeBySpec(db *sql.DB, dt common.DiskType, r []string) (
+func getDiskTypeBySpec(db *sql.DB, dt *common.DiskType, r []string) (
 	string, []string, error) {
-	var regionsClause strings.Builder
-	fmt.Fprintf(&regionsClause, "(")
-	for idx, region := range r {
-		fmt.Fprintf(&regionsClause, "'%s'", region)
-		if idx < len(r)-1 {
-			fmt.Fprintf(&regionsClause, ",")
-		}
-	}
-	fmt.Fprintf(&regionsClause, ")")
-	var typeClause string
+	q := query.New(`SELECT DISTINCT DiskType, Region from DiskTypes WHERE DefaultSizeGb >= ?`,
+		dt.SizeGb)
+	q.In(" AND", "Region", r)
 	if dt.DiskTech == "Standard" {
-		typeClause = "DiskType='pd-standard'"
+		q.Add(" AND DiskType='pd-standard'")
 	} else {
-		typeClause = "DiskType IN ('pd-ssd', 'pd-balanced')"
+		q.Add(" AND DiskType IN ('pd-ssd', 'pd-balanced')")
 	}
-	queryDiskType := fmt.Sprintf(`SELECT DISTINCT DiskType, Region from DiskTypes WHERE DefaultSizeGb >= %d AND Region in %s AND %s`,
-		dt.SizeGb, regionsClause.String(), typeClause)
-	res, err := db.Query(queryDiskType)
+	res, err := q.Query(db)
 	if err != nil {
 		return "", []string{}, err
 	}
@@ -500,24 +605,27 @@ func getDiskTypeBySpec(db *sql.DB, dt common.DiskType, r []string) (
 // where it is available. If several machine types match the spec, the smallest
 // one is returned. If there are several smallest types, the order of preference
 // is E2 > N2 > N2D > N1 (based on a generic "TCO" consideration).
+// Machine types that don't have the cpu architecture, local ssd support or
+// network bandwidth asked for are skipped.
 // TODO: probably want to take preemptible status, sole tenancy, commitments into account here.
 // TODO: also query by gpu count (also, if gpu requested, only look at a2)
-func getMachineTypeBySpec(db *sql.DB, st common.MachineType, r []string) (
+func getMachineTypeBySpec(db *sql.DB, st *common.MachineType, r []string) (
 	string, []string, error) {
-	var regionsClause strings.Builder
-	fmt.Fprintf(&regionsClause, "(")
-	for idx, region := range r {
-		fmt.Fprintf(&regionsClause, "'%s'", region)
-		if idx < len(r)-1 {
-			fmt.Fprintf(&regionsClause, ",")
-		}
-	}
-	fmt.Fprintf(&regionsClause, ")")
-	queryMachineType := fmt.Sprintf(`SELECT DISTINCT mt.MachineType, rz.Region from MachineTypes  mt join MachineTypesByZone mtbz on mt.MachineType=mtbz.MachineType JOIN REGIONZONE rz on mtbz.Zone=rz.Zone WHERE rz.Region in %s AND mt.CpuCount >= %d AND mt.CpuCount <= %d AND mt.MemoryMb >= %d AND mt.MemoryMb <= %d ORDER BY mt.CpuCount ASC, mt.MemoryMb asc LIMIT 10;`,
-		regionsClause.String(), st.CpuCount, st.CpuCount*2,
-		st.MemoryGb*1000, st.MemoryGb*2000)
+	if err := common.CheckMachineType(st); err != nil {
+		return "", nil, err
+	}
+	// The features are checked after the query, so the limit would
+	// throw away results we might need.
+	q := query.New(`SELECT DISTINCT mt.MachineType, rz.Region from MachineTypes  mt join MachineTypesByZone mtbz on mt.MachineType=mtbz.MachineType JOIN REGIONZONE rz on mtbz.Zone=rz.Zone WHERE mt.CpuCount >= ? AND mt.CpuCount <= ? AND mt.MemoryMb >= ? AND mt.MemoryMb <= ?`,
+		st.CpuCount, st.CpuCount*2, st.MemoryGb*1000, st.MemoryGb*2000)
+	q.In(" AND", "rz.Region", r)
+	q.Add(" ORDER BY mt.CpuCount ASC, mt.MemoryMb asc")
+	if st.CpuArchitecture == "" && !st.LocalSsd && st.NetworkGbps == 0 {
+		q.Add(" LIMIT 10")
+	}
+	q.Add(";")
 
-	res, err := db.Query(queryMachineType)
+	res, err := q.Query(db)
 	if err != nil {
 		return "", []string{}, err
 	}
@@ -531,6 +639,9 @@ func getMachineTypeBySpec(db *sql.DB, st common.MachineType, r []string) (
 			log.Printf("error scanning row: %v\n", err)
 			continue
 		}
+		if checkMachineTypeFeatures(mt, st) != nil {
+			continue
+		}
 		fmt.Printf("found one: %s in %s\n", mt, reg)
 		if presence[mt] == nil {
 			presence[mt] = []string{reg}
@@ -565,17 +676,60 @@ func getMachineTypeBySpec(db *sql.DB, st common.MachineType, r []string) (
 	return "", nil, fmt.Errorf("Failed to find a suitable machine type for %v in %v", st, r)
 }
 
+// Returns the machine type fill-in would choose for the spec in the region.
+func MachineTypeBySpec(db *sql.DB, st *common.MachineType, region string) (string, error) {
+	mt, _, err := getMachineTypeBySpec(db, st, []string{region})
+	return mt, err
+}
+
+// Lists the machine types satisfying the spec in the regions for loc,
+// smallest first. These are the machine types getMachineTypeBySpec
+// chooses from.
+func ListMachineTypes(db *sql.DB, st *common.MachineType, loc *common.Location) (
+	[]registry.MachineType, error) {
+	if err := common.CheckMachineType(st); err != nil {
+		return nil, err
+	}
+	q := query.New(`SELECT DISTINCT mt.MachineType, mt.CpuCount, mt.MemoryMb, rz.Region from MachineTypes mt join MachineTypesByZone mtbz on mt.MachineType=mtbz.MachineType JOIN REGIONZONE rz on mtbz.Zone=rz.Zone WHERE mt.CpuCount >= ? AND mt.CpuCount <= ? AND mt.MemoryMb >= ? AND mt.MemoryMb <= ?`,
+		st.CpuCount, st.CpuCount*2, st.MemoryGb*1000, st.MemoryGb*2000)
+	q.In(" AND", "rz.Region", resolveSpecLocation(loc, ""))
+	q.Add(" ORDER BY mt.CpuCount ASC, mt.MemoryMb ASC, mt.MachineType ASC, rz.Region ASC;")
+	res, err := q.Query(db)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	mts := make([]registry.MachineType, 0)
+	for res.Next() {
+		var mt registry.MachineType
+		var reg string
+		if err = res.Scan(&mt.Name, &mt.CpuCount, &mt.MemoryMb, &reg); err != nil {
+			return nil, err
+		}
+		if checkMachineTypeFeatures(mt.Name, st) != nil {
+			continue
+		}
+		if last := len(mts) - 1; last >= 0 && mts[last].Name == mt.Name {
+			mts[last].Regions = append(mts[last].Regions, reg)
+			continue
+		}
+		mt.Regions = []string{reg}
+		mts = append(mts, mt)
+	}
+	return mts, res.Err()
+}
+
 // Retrieves a machine type by type name and region.
 func GetMachineType(db *sql.DB, mt string, region string) (
 	assets.MachineType, error) {
-	queryMachineType := ""
+	var q *query.Builder
 	if region == "" {
-		queryMachineType = fmt.Sprintf(`SELECT CpuCount, MemoryMb, IsSharedCpu
-		FROM MachineTypes where MachineType='%s';`, mt)
+		q = query.New(`SELECT CpuCount, MemoryMb, IsSharedCpu
+		FROM MachineTypes where MachineType=?;`, mt)
 	} else {
-		queryMachineType = fmt.Sprintf(`SELECT mt.CpuCount, mt.MemoryMb, mt.IsSharedCpu FROM MachineTypes mt JOIN MachineTypesByZone mtbz on mt.MachineType=mtbz.MachineType JOIN RegionZone rz on mtbz.Zone=rz.Zone WHERE rz.Region='%s' AND mt.MachineType='%s';`, region, mt)
+		q = query.New(`SELECT mt.CpuCount, mt.MemoryMb, mt.IsSharedCpu FROM MachineTypes mt JOIN MachineTypesByZone mtbz on mt.MachineType=mtbz.MachineType JOIN RegionZone rz on mtbz.Zone=rz.Zone WHERE rz.Region=? AND mt.MachineType=?;`, region, mt)
 	}
-	res, err := db.Query(queryMachineType)
+	res, err := q.Query(db)
 	if err != nil {
 		return assets.MachineType{}, err
 	}
@@ -601,20 +755,17 @@ func GetMachineType(db *sql.DB, mt string, region string) (
 }
 
 func getDiskType(db *sql.DB, dt string, region string) (assets.DiskType, error) {
-	queryDiskType := ""
-	if region == "" {
-		queryDiskType = fmt.Sprintf(`SELECT DefaultSizeGb, Region 
-		FROM DiskTypes where DiskType='%s' and Region='None';`, dt)
-	} else {
-		queryDiskType = fmt.Sprintf(`SELECT DefaultSizeGb, Region 
-		FROM DiskTypes where DiskType='%s' and Region='%s';`, dt, region)
+	// Disk types that aren't regional are stored with region 'None'.
+	r := region
+	if r == "" {
+		r = "None"
 	}
-	res, err := db.Query(queryDiskType)
+	res, err := db.Query(`SELECT DefaultSizeGb, Region 
+		FROM DiskTypes where DiskType=? and Region=?;`, dt, r)
 	if err != nil {
 		return assets.DiskType{}, err
 	}
 	defer res.Close()
-	var r string
 	var defaultSizeGb int64
 	for res.Next() {
 		err = res.Scan(&defaultSizeGb, &r)
@@ -1,15 +1,17 @@
 package cache
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
+	"nephomancy/common/metadata"
 	"nephomancy/gcloud/assets"
 	"strings"
 	"time"
 )
 
-func populateBillingServices(db *sql.DB) error {
+func populateBillingServices(ctx context.Context, db *sql.DB) error {
 	insertBS := `INSERT INTO BillingServices(ServiceId,
 	DisplayName, LastUpdatedTS) VALUES (?,?,?)
 	ON CONFLICT(ServiceId) DO UPDATE SET
@@ -20,7 +22,7 @@ func populateBillingServices(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	bServices, bErr := assets.ListBillingServices()
+	bServices, bErr := assets.ListBillingServices(ctx)
 	if bErr != nil {
 		return bErr
 	}
@@ -34,7 +36,7 @@ func populateBillingServices(db *sql.DB) error {
 	return nil
 }
 
-func populateSkuTable(db *sql.DB, billingServiceName *string) error {
+func populateSkuTable(ctx context.Context, db *sql.DB, billingServiceName *string) error {
 	insertSku := `REPLACE INTO Sku(SkuId, Name, Description,
 	ResourceFamily, ResourceGroup, UsageType,
 	ServiceId, GeoTaxonomyType, Regions)
@@ -63,7 +65,7 @@ func populateSkuTable(db *sql.DB, billingServiceName *string) error {
 	if err != nil {
 		return err
 	}
-	skus, serr := assets.ListSkus(billingServiceName)
+	skus, serr := assets.ListSkus(ctx, billingServiceName)
 	if serr != nil {
 		return serr
 	}
@@ -127,8 +129,8 @@ func populateSkuTable(db *sql.DB, billingServiceName *string) error {
 	return nil
 }
 
-func populateComputeMetadata(db *sql.DB, project string) error {
-	zones, err := assets.ListZones(project)
+func populateComputeMetadata(ctx context.Context, db *sql.DB, project string) error {
+	zones, err := assets.ListZones(ctx, project)
 	if err != nil {
 		return err
 	}
@@ -173,7 +175,7 @@ func populateComputeMetadata(db *sql.DB, project string) error {
 
 	for _, rz := range zones {
 		zone := rz.Zone
-		mtypes, err := assets.ListMachineTypes(project, zone)
+		mtypes, err := assets.ListMachineTypes(ctx, project, zone)
 		if err != nil {
 			return err
 		}
@@ -213,7 +215,7 @@ func populateComputeMetadata(db *sql.DB, project string) error {
 
 	for _, rz := range zones {
 		zone := rz.Zone
-		dtypes, err := assets.ListDiskTypes(project, zone)
+		dtypes, err := assets.ListDiskTypes(ctx, project, zone)
 		if err != nil {
 			return err
 		}
@@ -239,7 +241,7 @@ func populateComputeMetadata(db *sql.DB, project string) error {
 
 	for _, rz := range zones {
 		region := rz.Region
-		dtypes, err := assets.ListRegionDiskTypes(project, region)
+		dtypes, err := assets.ListRegionDiskTypes(ctx, project, region)
 		if err != nil {
 			return err
 		}
@@ -253,9 +255,11 @@ func populateComputeMetadata(db *sql.DB, project string) error {
 	return nil
 }
 
-func PopulateDatabase(db *sql.DB, project string) error {
+// Fetches the billing catalog and compute metadata into the database.
+// Stops when ctx is done.
+func PopulateDatabase(ctx context.Context, db *sql.DB, project string) error {
 	log.Printf("Adding billing services to db\n")
-	err := populateBillingServices(db)
+	err := populateBillingServices(ctx, db)
 	if err != nil {
 		return err
 	}
@@ -279,11 +283,17 @@ func PopulateDatabase(db *sql.DB, project string) error {
 		Functions, AppEngine}
 	for _, s := range baseServices {
 		log.Printf("Adding skus for base service %s to db\n", s)
-		err = populateSkuTable(db, &s)
+		err = populateSkuTable(ctx, db, &s)
 		if err != nil {
 			return err
 		}
 	}
-	err = populateComputeMetadata(db, project)
-	return err
+	if err = populateComputeMetadata(ctx, db, project); err != nil {
+		return err
+	}
+	// The billing catalog doesn't say when prices were published.
+	return metadata.Record(db, metadata.Metadata{
+		Refreshed: time.Now(),
+		Source:    "Cloud Billing Catalog API",
+	})
 }
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"nephomancy/common/fetch"
+	"nephomancy/common/metadata"
+	common "nephomancy/common/resources"
+	"nephomancy/gcloud/assets"
+	"nephomancy/gcloud/fake"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+// Builds a complete cache from the fake APIs in an in-memory database.
+func populateFromFake(t *testing.T, failures int) *sql.DB {
+	server := fake.NewServer()
+	defer server.Close()
+	server.FailNext(failures)
+	assets.Endpoint = server.URL
+	defer func() { assets.Endpoint = "" }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = MigrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = PopulateDatabase(context.Background(), db, fake.Project); err != nil {
+		t.Fatalf("PopulateDatabase failed: %v", err)
+	}
+	return db
+}
+
+func TestPopulateDatabaseFromFake(t *testing.T) {
+	db := populateFromFake(t, 0)
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM BillingServices`).Scan(&n); err != nil || n != 8 {
+		t.Errorf("expected 8 billing services, got %d (%v)", n, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM Sku`).Scan(&n); err != nil || n != 16 {
+		t.Errorf("expected 16 skus, got %d (%v)", n, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM TieredRates
+	WHERE SkuId='9DE9-9092-B3BC'`).Scan(&n); err != nil || n != 4 {
+		t.Errorf("expected 4 tiers for internet egress, got %d (%v)", n, err)
+	}
+
+	skus, err := GetSkusForInstance(db, &assets.GCloudVM{
+		MachineType: "n1-standard-2",
+		Region:      "europe-west1",
+		Scheduling:  "OnDemand",
+	})
+	if err != nil {
+		t.Fatalf("GetSkusForInstance failed: %v", err)
+	}
+	sort.Strings(skus)
+	if diff := deep.Equal(skus, []string{"0009-6F35-3126", "3FB0-6E59-F436"}); diff != nil {
+		t.Errorf("unexpected skus for n1-standard-2: %v", diff)
+	}
+
+	mt, err := GetMachineType(db, "e2-micro", "europe-west1")
+	if err != nil {
+		t.Fatalf("GetMachineType failed: %v", err)
+	}
+	if mt.CpuCount != 2 || mt.MemoryMb != 1024 || !mt.IsSharedCpu {
+		t.Errorf("unexpected machine type %+v", mt)
+	}
+	dt, err := getDiskType(db, "pd-ssd", "europe-west1")
+	if err != nil || dt.DefaultSizeGb != 100 {
+		t.Errorf("unexpected regional disk type %+v (%v)", dt, err)
+	}
+
+	mts, err := ListMachineTypes(db, &common.MachineType{CpuCount: 2, MemoryGb: 8},
+		&common.Location{CountryCode: "BE"})
+	// n1-standard-2 has 7.5 GB, less than asked for.
+	if err != nil || len(mts) != 1 || mts[0].Name != "e2-standard-2" ||
+		len(mts[0].Regions) != 1 || mts[0].Regions[0] != "europe-west1" {
+		t.Errorf("expected e2-standard-2 in europe-west1, got %+v (%v)", mts, err)
+	}
+
+	m, err := metadata.Read(db)
+	if err != nil || m == nil || m.Source != "Cloud Billing Catalog API" {
+		t.Errorf("unexpected metadata %+v (%v)", m, err)
+	}
+}
+
+func TestPopulateDatabaseRetriesFromFake(t *testing.T) {
+	backoff := fetch.Backoff
+	fetch.Backoff = time.Millisecond
+	defer func() { fetch.Backoff = backoff }()
+
+	db := populateFromFake(t, fetch.Retries)
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM BillingServices`).Scan(&n); err != nil || n != 8 {
+		t.Errorf("expected 8 billing services after retries, got %d (%v)", n, err)
+	}
+}
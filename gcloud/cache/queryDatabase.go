@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"nephomancy/common/query"
 	"nephomancy/gcloud/assets"
 	"strings"
 	// concrete db driver even though the code only refers to interface.
@@ -14,101 +15,94 @@ const ComputeService = "6F81-5844-456A"
 const ContainerService = "CCD8-9BF1-090E"
 const MonitoringService = "58CD-E7C3-72CA"
 
-// Returns a map of skuid to pricing info.
+// Returns a map of skuid to pricing info. Skus without pricing info
+// are left out.
 func GetPricingInfo(db *sql.DB, skus []string) (map[string](PricingInfo), error) {
-	var queryPricingInfo string
 	ret := make(map[string](PricingInfo))
+	if len(skus) == 0 {
+		return ret, nil
+	}
+	stmt, err := db.Prepare(`SELECT p.CurrencyConversionRate,
+	IFNULL(p.AggregationInfo, ''), IFNULL(p.UsageUnit, ''), IFNULL(tr.CurrencyCode, ''),
+	IFNULL(tr.Nanos, 0), IFNULL(tr.Units, 0), IFNULL(tr.StartUsageAmount, 0) FROM PricingInfo p JOIN
+	TieredRates tr on p.SkuId=tr.SkuId where p.SkuId=? ORDER BY tr.TierNumber;`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
 	for _, skuId := range skus {
-		queryPricingInfo = fmt.Sprintf(`SELECT p.CurrencyConversionRate,
-		p.AggregationInfo, p.UsageUnit, tr.CurrencyCode,
-		tr.Nanos, tr.Units, tr.StartUsageAmount FROM PricingInfo p JOIN 
-		TieredRates tr on p.SkuId=tr.SkuId where p.SkuId='%s';`, skuId)
-		res, err := db.Query(queryPricingInfo)
+		pi, err := pricingInfo(stmt, skuId)
 		if err != nil {
-			return ret, err
-		}
-		defer res.Close()
-		var currencyConversionRate float32
-		var aggregationInfo string
-		var usageUnit string
-		var currencyCode string
-		var nanos int64
-		var units int64
-		var startUsageAmount int64
-		var pi *PricingInfo
-		pi = nil
-		tieredRates := make([]Rate, 0)
-		for res.Next() {
-			err = res.Scan(&currencyConversionRate, &aggregationInfo,
-				&usageUnit, &currencyCode, &nanos, &units, &startUsageAmount)
-			if err != nil {
-				log.Printf("error scanning row: %v\n", err)
-				continue
-			}
-			if pi == nil {
-				pi = &PricingInfo{
-					CurrencyConversionRate: currencyConversionRate,
-					AggregationInfo:        aggregationInfo,
-					PricingExpression: &Pricing{
-						UsageUnit: usageUnit,
-					},
-				}
-
-			}
-			rate := Rate{
-				CurrencyCode:     currencyCode,
-				Nanos:            nanos,
-				StartUsageAmount: startUsageAmount,
-				Units:            units,
-			}
-			tieredRates = append(tieredRates, rate)
-
+			return nil, fmt.Errorf("failed to read pricing info of sku %s: %v", skuId, err)
 		}
 		if pi != nil {
-			pi.PricingExpression.TieredRates = tieredRates
 			ret[skuId] = *pi
 		}
 	}
 	return ret, nil
 }
 
-func getBeginningOfSkuQuery(querySku *strings.Builder, service string, resource string, regions []string) {
-	fmt.Fprintf(querySku, `SELECT Sku.SkuId
-	FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
-	WHERE Sku.ServiceId='%s' AND Sku.ResourceFamily='%s'`, service, resource)
-	if regions != nil {
-		fmt.Fprintf(querySku, " AND ServiceRegions.Region IN (")
-		rcount := len(regions)
-		for i, r := range regions {
-			if i == rcount-1 {
-				fmt.Fprintf(querySku, "'%s'", r)
-			} else {
-				fmt.Fprintf(querySku, "'%s',", r)
+// Runs the pricing info statement of GetPricingInfo for one sku.
+func pricingInfo(stmt *sql.Stmt, skuId string) (*PricingInfo, error) {
+	res, err := stmt.Query(skuId)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var pi *PricingInfo
+	for res.Next() {
+		var currencyConversionRate float32
+		var aggregationInfo, usageUnit string
+		var rate Rate
+		err = res.Scan(&currencyConversionRate, &aggregationInfo, &usageUnit,
+			&rate.CurrencyCode, &rate.Nanos, &rate.Units, &rate.StartUsageAmount)
+		if err != nil {
+			return nil, err
+		}
+		if pi == nil {
+			pi = &PricingInfo{
+				CurrencyConversionRate: currencyConversionRate,
+				AggregationInfo:        aggregationInfo,
+				PricingExpression: &Pricing{
+					UsageUnit: usageUnit,
+				},
 			}
 		}
-		fmt.Fprintf(querySku, ") ")
+		pi.PricingExpression.TieredRates = append(pi.PricingExpression.TieredRates, rate)
+	}
+	return pi, res.Err()
+}
+
+// Starts a query for the ids of skus of a service and resource family,
+// available in one of the regions if regions is not nil.
+func skuQuery(service string, resource string, regions []string) *query.Builder {
+	q := query.New(`SELECT Sku.SkuId
+	FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
+	WHERE Sku.ServiceId=? AND Sku.ResourceFamily=?`, service, resource)
+	if regions != nil {
+		q.In(" AND", "ServiceRegions.Region", regions)
 	}
+	return q
 }
 
-func GetSkusForLicense(db *sql.DB, gvm assets.GCloudVM) ([]string, error) {
+func GetSkusForLicense(db *sql.DB, gvm *assets.GCloudVM) ([]string, error) {
 	os := assets.OsChoiceByName(gvm.OsChoice)
 	rg := os.ResourceGroup()
 	if rg == "Unspecified" {
 		return nil, fmt.Errorf("missing os choice")
 	}
-	var querySku strings.Builder
-	fmt.Fprintf(&querySku, `SELECT Sku.SkuId FROM Sku WHERE Sku.ResourceFamily='License'
-	AND Sku.ResourceGroup='%s' `, rg)
+	q := query.New(`SELECT Sku.SkuId FROM Sku WHERE Sku.ResourceFamily='License'
+	AND Sku.ResourceGroup=?`, rg)
 
 	// Some license skus have 'Google' as their ResourceGroup.
 	if rg == "Google" {
 		if os == assets.RedHatEnterpriseLinuxForSAP {
-			fmt.Fprintf(&querySku, "AND Sku.Description like '%% RHEL 7 with SAP Applications %%' ")
+			q.Add(" AND Sku.Description like ?", "% RHEL 7 with SAP Applications %")
 		} else if os == assets.SUSELinuxEnterpriseServer {
-			fmt.Fprintf(&querySku, "AND Sku.Description like '%% SUSE Linux Enterprise Server %%' ")
+			q.Add(" AND Sku.Description like ?", "% SUSE Linux Enterprise Server %")
 		}
 	} else if os == assets.FedoraCoreOs {
-		fmt.Fprintf(&querySku, "AND Sku.Description like '%% Stable %%' ")
+		q.Add(" AND Sku.Description like ?", "% Stable %")
 	} else if os == assets.RedHatEnterpriseLinux {
 		fmt.Printf("TODO: add query based on vcpu count")
 	} else if os == assets.SQLServerOnWindowsServer {
@@ -118,67 +112,83 @@ func GetSkusForLicense(db *sql.DB, gvm assets.GCloudVM) ([]string, error) {
 	machineType := gvm.MachineType
 	parts := strings.Split(machineType, "-")
 	if parts[0] == "f1" {
-		querySku.WriteString(" AND Sku.Description like '%% on f1-micro' ")
+		q.Add(" AND Sku.Description like ?", "% on f1-micro")
 	} else if parts[0] == "g1" {
-		querySku.WriteString(" AND Sku.Description like '%% on g1-small' ")
+		q.Add(" AND Sku.Description like ?", "% on g1-small")
 	} else {
-		querySku.WriteString(` AND (Sku.Description like '%% (RAM cost)' OR
-		Sku.Description like '%% (CPU cost)' `)
+		patterns := []string{"% (RAM cost)", "% (CPU cost)"}
 		if parts[0] == "a2" {
-			querySku.WriteString(`OR Sku.Description like '%% (GPU cost)'`)
+			patterns = append(patterns, "% (GPU cost)")
 		}
-		querySku.WriteString(")")
+		q.AnyLike(" AND", "Sku.Description", patterns)
 	}
-	querySku.WriteString(";")
-	fmt.Printf("license query: %s\n", querySku.String())
-	return getSkusForQuery(db, querySku.String())
+	q.Add(";")
+	return getSkusForQuery(db, q)
 }
 
-func GetSkusForInstance(db *sql.DB, gvm assets.GCloudVM) ([]string, error) {
-	var querySku strings.Builder
-	getBeginningOfSkuQuery(&querySku, ComputeService, "Compute", []string{gvm.Region})
+// The scheduling (OnDemand, Preemptible, Commit1Yr, Commit3Yr) is the usage
+// type of the skus. Preemptible and commitment skus have their own descriptions,
+// e.g. "Preemptible E2 Instance Core running in Zurich" or
+// "Commitment v1: E2 Cpu in Zurich for 1 Year". Commitments for N1 machine
+// types don't mention the series: "Commitment v1: Cpu in Americas for 1 Year".
+func GetSkusForInstance(db *sql.DB, gvm *assets.GCloudVM) ([]string, error) {
+	q := skuQuery(ComputeService, "Compute", []string{gvm.Region})
 	if gvm.Scheduling != "" {
-		fmt.Fprintf(&querySku, " AND Sku.UsageType='%s' ", gvm.Scheduling)
+		q.Add(" AND Sku.UsageType=?", gvm.Scheduling)
 	}
+	isCommitment := strings.HasPrefix(gvm.Scheduling, "Commit")
 	if gvm.Sharing == "SoleTenancy" {
-		querySku.WriteString(" AND Sku.Description like '% Sole Tenancy %' ")
+		q.Add(" AND Sku.Description like '% Sole Tenancy %'")
 	} else {
-		querySku.WriteString(" AND Sku.Description not like '% Sole Tenancy %' ")
+		q.Add(" AND Sku.Description not like '% Sole Tenancy %'")
 	}
-	querySku.WriteString(" AND Sku.Description not like '% Custom %' ")
+	q.Add(" AND Sku.Description not like '% Custom %'")
 	machineType := gvm.MachineType
 	resourceGroups, err := assets.ResourceGroupByMachineType(machineType)
 	if err != nil {
 		return nil, err
 	}
-	var groupsClause strings.Builder
-	fmt.Fprintf(&groupsClause, "(")
-	for idx, gr := range resourceGroups {
-		fmt.Fprintf(&groupsClause, "'%s'", gr)
-		if idx < len(resourceGroups)-1 {
-			fmt.Fprintf(&groupsClause, ",")
+	parts := strings.Split(machineType, "-")
+	if isCommitment {
+		if parts[0] == "f1" || parts[0] == "g1" {
+			return nil, fmt.Errorf("machine type %s is not eligible for committed use discounts",
+				machineType)
+		}
+		// Commitments are always by cpu and memory (and gpu).
+		resourceGroups = []string{"CPU", "RAM"}
+		if parts[0] == "a2" {
+			resourceGroups = append(resourceGroups, "GPU")
 		}
 	}
-	fmt.Fprintf(&groupsClause, ") ")
-	fmt.Fprintf(&querySku, " AND Sku.ResourceGroup IN %s ", groupsClause.String())
+	q.In(" AND", "Sku.ResourceGroup", resourceGroups)
 
-	parts := strings.Split(machineType, "-")
-	if parts[0] == "f1" {
-		querySku.WriteString(" AND Sku.Description like 'Micro %%' ")
+	prefix := ""
+	if gvm.Scheduling == "Preemptible" {
+		prefix = "Preemptible "
+	}
+	if isCommitment {
+		if parts[0] == "n1" {
+			q.AnyLike(" AND", "Sku.Description",
+				[]string{"Commitment v1: Cpu %", "Commitment v1: Ram %"})
+		} else {
+			first := strings.ToUpper(parts[0])
+			q.Add(" AND Sku.Description like ?", "Commitment v1: "+first+" %")
+		}
+	} else if parts[0] == "f1" {
+		q.Add(" AND Sku.Description like ?", prefix+"Micro %")
 	} else if parts[0] == "g1" {
-		querySku.WriteString(" AND Sku.Description like 'Small %%' ")
+		q.Add(" AND Sku.Description like ?", prefix+"Small %")
 	} else {
 		first := strings.ToUpper(parts[0])
-		fmt.Fprintf(&querySku, " AND Sku.Description like '%s %%' ", first)
+		q.Add(" AND Sku.Description like ?", prefix+first+" %")
 	}
-	fmt.Fprintf(&querySku, ";")
-	return getSkusForQuery(db, querySku.String())
+	q.Add(";")
+	return getSkusForQuery(db, q)
 
 }
 
-func GetSkusForDisk(db *sql.DB, gd assets.GCloudDisk) ([]string, error) {
-	var querySku strings.Builder
-	getBeginningOfSkuQuery(&querySku, ComputeService, "Storage", []string{gd.Region})
+func GetSkusForDisk(db *sql.DB, gd *assets.GCloudDisk) ([]string, error) {
+	q := skuQuery(ComputeService, "Storage", []string{gd.Region})
 	diskType := gd.DiskType
 	resourceGroup := ""
 	switch diskType {
@@ -188,41 +198,39 @@ func GetSkusForDisk(db *sql.DB, gd assets.GCloudDisk) ([]string, error) {
 		resourceGroup = "SSD"
 	case "pd-balanced":
 		resourceGroup = "SSD"
-		querySku.WriteString(" AND Sku.Description like 'Balanced %' ")
+		q.Add(" AND Sku.Description like 'Balanced %'")
 	default:
 		log.Fatalf("Unknown disk type %s in completeDiskQuery\n", diskType)
 	}
-	fmt.Fprintf(&querySku, " AND Sku.ResourceGroup='%s' ", resourceGroup)
+	q.Add(" AND Sku.ResourceGroup=?", resourceGroup)
 	// TODO the region query isn't quite right for all disk types.
 	// afaict both regional and zonal disks have GeoTaxonomyType 'REGIONAL' but
 	// regional disks have 'Regional' in the description.
 	if !gd.IsRegional {
-		querySku.WriteString(" AND Sku.Description not like 'Regional %' ")
+		q.Add(" AND Sku.Description not like 'Regional %'")
 	}
 	// TODO: what about multi-regional storage? Does multi-regional just mean
 	// the same Sku applies to multiple regions? Normally, multi-regional applies
 	// to object storage (like S3, Firestore, etc), not to disks.
-	// querySku.WriteString(" AND Sku.GeoTaxonomyType<>'MULTI_REGIONAL' ")
-	querySku.WriteString(";")
-	return getSkusForQuery(db, querySku.String())
+	// q.Add(" AND Sku.GeoTaxonomyType<>'MULTI_REGIONAL'")
+	q.Add(";")
+	return getSkusForQuery(db, q)
 }
 
-func GetSkusForImage(db *sql.DB, gd assets.GCloudDisk) ([]string, error) {
-	var querySku strings.Builder
-	getBeginningOfSkuQuery(&querySku, ComputeService, "Storage", []string{gd.Region})
-	querySku.WriteString(" AND Sku.ResourceGroup='StorageImage'; ")
-	return getSkusForQuery(db, querySku.String())
+func GetSkusForImage(db *sql.DB, gd *assets.GCloudDisk) ([]string, error) {
+	q := skuQuery(ComputeService, "Storage", []string{gd.Region})
+	q.Add(" AND Sku.ResourceGroup='StorageImage';")
+	return getSkusForQuery(db, q)
 }
 
-func GetSkusForLocalDisk(db *sql.DB, gvm assets.GCloudVM) ([]string, error) {
-	var querySku strings.Builder
-	getBeginningOfSkuQuery(&querySku, ComputeService, "Storage", []string{gvm.Region})
-	querySku.WriteString(" AND Sku.ResourceGroup='LocalSSD' ")
+func GetSkusForLocalDisk(db *sql.DB, gvm *assets.GCloudVM) ([]string, error) {
+	q := skuQuery(ComputeService, "Storage", []string{gvm.Region})
+	q.Add(" AND Sku.ResourceGroup='LocalSSD'")
 	if gvm.Scheduling != "" {
-		fmt.Fprintf(&querySku, " AND Sku.UsageType='%s' ", gvm.Scheduling)
+		q.Add(" AND Sku.UsageType=?", gvm.Scheduling)
 	}
-	querySku.WriteString(";")
-	return getSkusForQuery(db, querySku.String())
+	q.Add(";")
+	return getSkusForQuery(db, q)
 }
 
 // For most network pricing, the region is not relevant, it is enough to
@@ -231,83 +239,76 @@ func getGlobalRegions() []string {
 	return []string{"APAC", "EMEA", "Americas"}
 }
 
+// Description patterns for traffic to any of the global regions.
+func toGlobalRegionsPatterns() []string {
+	patterns := make([]string, 0)
+	for _, area := range getGlobalRegions() {
+		patterns = append(patterns, "% to "+area)
+	}
+	return patterns
+}
+
 func GetSkusForIpAddress(db *sql.DB, region string, usageType string) ([]string, error) {
 	// Only external IP addresses have a cost. Cost depends on
 	// the usage type of the vm (preemptible or standard), on whether the ip address
 	// is in use, and on the region. The usage type is empty when the ip address is not
 	// in use.
-	var querySku strings.Builder
-	fmt.Fprintf(&querySku, `SELECT Sku.SkuId FROM Sku WHERE Sku.ResourceFamily='Network' AND Sku.ResourceGroup='IpAddress'`)
+	q := query.New(`SELECT Sku.SkuId FROM Sku WHERE Sku.ResourceFamily='Network' AND Sku.ResourceGroup='IpAddress'`)
 	inUse := usageType == "Standard" || usageType == "Preemptible"
 	if inUse {
-		querySku.WriteString(" AND Sku.GeoTaxonomyType='GLOBAL' ")
-		fmt.Fprintf(&querySku, " AND Sku.Description like '%% %s VM' ", usageType)
+		q.Add(" AND Sku.GeoTaxonomyType='GLOBAL'")
+		q.Add(" AND Sku.Description like ?", "% "+usageType+" VM")
 	} else {
 		if region != "" {
-			fmt.Fprintf(&querySku, " AND Sku.Regions='%s' ", region)
+			q.Add(" AND Sku.Regions=?", region)
 		} else {
-			querySku.WriteString(" AND Sku.GeoTaxonomyType='MULTI_REGIONAL'")
+			q.Add(" AND Sku.GeoTaxonomyType='MULTI_REGIONAL'")
 		}
 	}
-	fmt.Fprintf(&querySku, ";")
-	fmt.Printf("ip addr sku query: %s\n", querySku.String())
-	return getSkusForQuery(db, querySku.String())
+	q.Add(";")
+	return getSkusForQuery(db, q)
 }
 
 func GetSkusForExternalEgress(db *sql.DB, region string, networkTier string) ([]string, error) {
-	var querySku strings.Builder
-	fmt.Fprintf(&querySku, `SELECT Sku.SkuId FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
+	q := query.New(`SELECT Sku.SkuId FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
 	WHERE Sku.ResourceFamily='Network'`)
 	if region != "" {
-		fmt.Fprintf(&querySku, " AND ServiceRegions.Region='%s'", region)
+		q.Add(" AND ServiceRegions.Region=?", region)
 	}
-	for idx, area := range getGlobalRegions() {
-		if idx == 0 {
-			querySku.WriteString(" AND (Sku.Description like '% to ")
-			querySku.WriteString(area)
-			querySku.WriteString("'")
-		} else {
-			querySku.WriteString(" OR Sku.Description like '% to ")
-			querySku.WriteString(area)
-			querySku.WriteString("'")
-		}
-	}
-	querySku.WriteString(")")
+	q.AnyLike(" AND", "Sku.Description", toGlobalRegionsPatterns())
 	// No Skus for StandardInternetEgress?
-	fmt.Fprintf(&querySku, " AND Sku.ResourceGroup='PremiumInternetEgress' ")
-	fmt.Fprintf(&querySku, ";")
-	return getSkusForQuery(db, querySku.String())
+	q.Add(" AND Sku.ResourceGroup='PremiumInternetEgress';")
+	return getSkusForQuery(db, q)
 }
 
 func GetSkusForInternalEgress(db *sql.DB, region string) ([]string, error) {
-	var querySku strings.Builder
-	fmt.Fprintf(&querySku, `SELECT Sku.SkuId FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
+	q := query.New(`SELECT Sku.SkuId FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
 	WHERE Sku.ResourceFamily='Network'`)
 	if region != "" {
-		fmt.Fprintf(&querySku, " AND ServiceRegions.Region='%s'", region)
+		q.Add(" AND ServiceRegions.Region=?", region)
 	}
 	// There are other types of internal egress. VPN is usually less expensive
 	// than the other types. Not handling Intrazone (it's free atm) or InterzoneEgress
 	// because InterregionEgress should be an upper bound for both.
-	fmt.Fprintf(&querySku, " AND Sku.ResourceGroup='InterregionEgress' ")
-	for idx, area := range getGlobalRegions() {
-		if idx == 0 {
-			querySku.WriteString(" AND (Sku.Description like '% to ")
-			querySku.WriteString(area)
-			querySku.WriteString("'")
-		} else {
-			querySku.WriteString(" OR Sku.Description like '% to ")
-			querySku.WriteString(area)
-			querySku.WriteString("'")
-		}
+	q.Add(" AND Sku.ResourceGroup='InterregionEgress'")
+	q.AnyLike(" AND", "Sku.Description", toGlobalRegionsPatterns())
+	q.Add(";")
+	return getSkusForQuery(db, q)
+}
+
+// Egress between zones of the same region.
+func GetSkusForInterzoneEgress(db *sql.DB, region string) ([]string, error) {
+	q := query.New(`SELECT Sku.SkuId FROM Sku JOIN ServiceRegions ON Sku.SkuId = ServiceRegions.SkuId 
+	WHERE Sku.ResourceFamily='Network'`)
+	if region != "" {
+		q.Add(" AND ServiceRegions.Region=?", region)
 	}
-	fmt.Fprintf(&querySku, ");")
-	return getSkusForQuery(db, querySku.String())
+	q.Add(" AND Sku.ResourceGroup='InterzoneEgress';")
+	return getSkusForQuery(db, q)
 }
 
-func getSkusForQuery(db *sql.DB, query string) ([]string, error) {
-	fmt.Printf("running sku query: %s\n", query)
-	res, err := db.Query(query)
+func getSkusForQuery(db *sql.DB, q *query.Builder) ([]string, error) {
+	res, err := q.Query(db)
 	if err != nil {
 		return nil, err
 	}
@@ -328,6 +329,44 @@ func getSkusForQuery(db *sql.DB, query string) ([]string, error) {
 		keys[i] = k
 		i++
 	}
-	fmt.Printf("returning skus: %v\n", keys)
 	return keys, nil
 }
+
+// Returns the tiered rates of every sku, keyed by sku id and description.
+// Rates are formatted like "0.031611 USD per h from 0", one per tier.
+func ListRates(db *sql.DB) (map[string]string, error) {
+	res, err := db.Query(`SELECT s.SkuId, s.Description, p.UsageUnit,
+	tr.CurrencyCode, tr.Nanos, tr.Units, tr.StartUsageAmount FROM Sku s
+	JOIN PricingInfo p ON s.SkuId=p.SkuId JOIN TieredRates tr ON
+	p.SkuId=tr.SkuId ORDER BY s.SkuId, tr.TierNumber;`)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	rates := make(map[string]string)
+	var skuId, description, usageUnit, currencyCode string
+	var nanos, units, startUsageAmount int64
+	for res.Next() {
+		if err = res.Scan(&skuId, &description, &usageUnit, &currencyCode,
+			&nanos, &units, &startUsageAmount); err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s %s", skuId, description)
+		rate := fmt.Sprintf("%.6f %s per %s from %d",
+			float64(units)+float64(nanos)/1000000000.0, currencyCode,
+			usageUnit, startUsageAmount)
+		if rates[key] != "" {
+			rates[key] += "; "
+		}
+		rates[key] += rate
+	}
+	return rates, res.Err()
+}
+
+// Returns when the billing services were last refreshed, as a unix
+// timestamp, or 0 if they never were.
+func GetLastUpdated(db *sql.DB) (int64, error) {
+	var ts int64
+	err := db.QueryRow(`SELECT IFNULL(MAX(LastUpdatedTS), 0) FROM BillingServices;`).Scan(&ts)
+	return ts, err
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"database/sql"
+	"nephomancy/gcloud/assets"
+	"testing"
+)
+
+// Returns an in-memory cache with one pd-ssd sku in europe-west6.
+func memoryDb(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = MigrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	stmts := []string{
+		`INSERT INTO Sku(SkuId, Name, Description, ResourceFamily, ResourceGroup,
+		UsageType, ServiceId, GeoTaxonomyType, Regions) VALUES ('ssd-zh',
+		'services/6F81-5844-456A/skus/ssd-zh', 'SSD backed PD Capacity in Zurich',
+		'Storage', 'SSD', 'OnDemand', '6F81-5844-456A', 'REGIONAL', 'europe-west6')`,
+		`INSERT INTO ServiceRegions(Region, SkuId) VALUES ('europe-west6', 'ssd-zh')`,
+		`INSERT INTO PricingInfo(Summary, CurrencyConversionRate, AggregationInfo,
+		SkuId, BaseUnit, BaseUnitConversionFactor, UsageUnit) VALUES ('',
+		1.0, '', 'ssd-zh', 'By.s', 2875910101401600, 'GiBy.mo')`,
+		`INSERT INTO TieredRates(SkuId, TierNumber, CurrencyCode, Nanos, Units,
+		StartUsageAmount) VALUES ('ssd-zh', 0, 'USD', 221000000, 0, 0)`,
+	}
+	for _, s := range stmts {
+		if _, err = db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestGetSkusForDisk(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	disk := &assets.GCloudDisk{DiskType: "pd-ssd", Region: "europe-west6"}
+	skus, err := GetSkusForDisk(db, disk)
+	if err != nil || len(skus) != 1 || skus[0] != "ssd-zh" {
+		t.Fatalf("expected sku ssd-zh but got %v (%v)", skus, err)
+	}
+	pi, err := GetPricingInfo(db, skus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates := pi["ssd-zh"].PricingExpression.TieredRates
+	if len(rates) != 1 || rates[0].Nanos != 221000000 {
+		t.Errorf("unexpected rates %+v", rates)
+	}
+}
+
+func TestGetSkusForDiskHostileRegion(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	// Pasted into the SQL, this region would match every region.
+	disk := &assets.GCloudDisk{DiskType: "pd-ssd", Region: "nowhere') OR ('1'='1"}
+	skus, err := GetSkusForDisk(db, disk)
+	if err != nil || len(skus) != 0 {
+		t.Errorf("expected no skus for a hostile region but got %v (%v)", skus, err)
+	}
+}
+
+func TestGetPricingInfoTiers(t *testing.T) {
+	db := memoryDb(t)
+	defer db.Close()
+	// The first 10 GiB are free, and the free tier is inserted last.
+	for _, s := range []string{
+		`UPDATE TieredRates SET TierNumber=1, StartUsageAmount=10 WHERE SkuId='ssd-zh'`,
+		`INSERT INTO TieredRates(SkuId, TierNumber, CurrencyCode, Nanos, Units,
+		StartUsageAmount) VALUES ('ssd-zh', 0, 'USD', 0, 0, 0)`,
+	} {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pi, err := GetPricingInfo(db, []string{"ssd-zh", "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pi["unknown"]; ok || len(pi) != 1 {
+		t.Errorf("expected only ssd-zh to have pricing info, got %v", pi)
+	}
+	rates := pi["ssd-zh"].PricingExpression.TieredRates
+	if len(rates) != 2 || rates[0].StartUsageAmount != 0 || rates[1].StartUsageAmount != 10 {
+		t.Errorf("expected two tiers in order, got %+v", rates)
+	}
+}
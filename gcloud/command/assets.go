@@ -3,14 +3,22 @@ package command
 import (
 	"fmt"
 	"log"
+	common "nephomancy/common/resources"
 	"nephomancy/gcloud/assets"
 	"nephomancy/gcloud/cache"
 	"strings"
+	"time"
 )
 
+const trafficDoc = `Fill in the subnetworks' ingress and egress from the traffic observed in Monitoring over the window. Needs VPC flow logs on the subnetworks. Egress to the internet becomes external egress, egress to other regions internal egress, and egress to other zones in the same region same region egress.`
+
 // TODO: rename to ResourcesCommand (after extracting common parts)
 type AssetsCommand struct {
 	Command
+	traffic bool
+	window  time.Duration
+	record  string
+	replay  string
 }
 
 func (a *AssetsCommand) Help() string {
@@ -24,7 +32,15 @@ func (a *AssetsCommand) Help() string {
 	  --workingdir=path  %s
 	  --projectin=filename %s
 	  --projectout=filename %s
-`, projectDoc, workingDirDoc, projectInDoc, projectOutDoc)
+	  --timeout=duration %s
+	  --call-timeout=duration %s
+	  --retries=n %s
+	  --traffic %s
+	  --window=duration %s
+	  --record=path %s
+	  --replay=path %s
+`, projectDoc, workingDirDoc, projectInDoc, projectOutDoc, timeoutDoc, callTimeoutDoc, retriesDoc,
+		trafficDoc, windowDoc, recordDoc, replayDoc)
 	return strings.TrimSpace(helpText)
 }
 
@@ -36,6 +52,11 @@ func (*AssetsCommand) Synopsis() string {
 // nephomancy gcloud assets --project=binderhub-test-275512
 func (c *AssetsCommand) Run(args []string) int {
 	fs := c.Command.defaultFlagSet("gcloudAssets")
+	c.Command.addFetchFlags(fs)
+	fs.BoolVar(&c.traffic, "traffic", false, "Fill in observed subnetwork traffic.")
+	fs.DurationVar(&c.window, "window", 7*24*time.Hour, "How far back to look at traffic.")
+	fs.StringVar(&c.record, "record", "", "Directory to save the time series to.")
+	fs.StringVar(&c.replay, "replay", "", "Directory with saved time series.")
 	fs.Parse(args)
 
 	// A project specified via an infile will be used even if
@@ -58,7 +79,9 @@ func (c *AssetsCommand) Run(args []string) int {
 			log.Fatalf("Need a project ID. You can see the IDs on the GCloud console.\n")
 		}
 		projectPath := fmt.Sprintf("projects/%s", projectName)
-		ax, err := assets.ListAssetsForProject(projectPath)
+		ctx, cancel := c.fetchContext()
+		defer cancel()
+		ax, err := assets.ListAssetsForProject(ctx, projectPath)
 		if err != nil {
 			log.Fatalf("Listing assets failed: %v", err)
 		}
@@ -67,7 +90,7 @@ func (c *AssetsCommand) Run(args []string) int {
 			log.Fatalf("Building project failed: %v", err)
 		}
 		/*
-			err = assets.GetProject(projectName)
+			err = assets.GetProject(ctx, projectName)
 			if err != nil {
 				log.Fatalf("Failed to get project: %v", err)
 			}
@@ -77,9 +100,49 @@ func (c *AssetsCommand) Run(args []string) int {
 	if err = cache.FillInSpec(db, project); err != nil {
 		log.Fatalf("resolving project failed: %v", err)
 	}
+	if c.traffic {
+		if projectName == "" {
+			projectName = project.Name
+		}
+		if err = c.fillInTraffic(project, projectName); err != nil {
+			log.Fatalf("Failed to fill in traffic: %v\n", err)
+		}
+	}
 
 	if err = c.saveProject(project); err != nil {
 		log.Fatalf("Failed to save project: %v\n", err)
 	}
 	return 0
 }
+
+func (c *AssetsCommand) fillInTraffic(project *common.Project, projectName string) error {
+	if c.replay != "" {
+		assets.MonitoringFixture = c.replay
+	}
+	ctx, cancel := c.fetchContext()
+	defer cancel()
+	src, err := assets.NewMonitoring(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if c.record != "" {
+		src = &assets.RecordingMonitoring{TimeSeriesSource: src, Dir: c.record}
+	}
+	end := time.Now()
+	traffic, err := assets.GetSubnetworkTraffic(ctx, src, projectName, end.Add(-c.window), end)
+	if err != nil {
+		return err
+	}
+	filled, err := assets.FillInTraffic(project, traffic)
+	if err != nil {
+		return err
+	}
+	if len(filled) == 0 {
+		log.Printf("No traffic observed for any subnetwork. Are VPC flow logs turned on?\n")
+	}
+	for _, snw := range filled {
+		log.Printf("Filled in traffic for subnetwork %s\n", snw)
+	}
+	return nil
+}
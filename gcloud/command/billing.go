@@ -0,0 +1,130 @@
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"nephomancy/common/utils"
+	"nephomancy/gcloud/billing"
+	"nephomancy/gcloud/pricing"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const exportDoc = `Cloud Billing export to compare with, as a .csv or .jsonl dump of the BigQuery table. Only line items of the gcloud project given with --project are used, if there is one.`
+
+type BillingCommand struct {
+	Command
+	export string
+}
+
+func (c *BillingCommand) Help() string {
+	helpText := fmt.Sprintf(`
+	Usage: nephomancy gcloud billing [options]
+
+	Compare the estimated costs of a project with a Cloud Billing export.
+
+	Matches the line items of the export to the project's resources by
+	sku, and prints a CSV with the estimated and actual costs per
+	resource set and per sku, followed by spend on skus the project
+	does not use. Estimates are monthly, so they are scaled to the
+	period the export covers. Actual costs include credits.
+
+	Options:
+	  --project=PROJECT  %s
+	  --workingdir=path  %s
+	  --projectin=filename %s
+	  --export=filename %s
+`, projectDoc, workingDirDoc, projectInDoc, exportDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*BillingCommand) Synopsis() string {
+	return "Compares estimated costs with a gcloud billing export."
+}
+
+// Run this with
+// nephomancy gcloud billing --projectin=binderhub-test-275512.json --export=billing.jsonl
+func (c *BillingCommand) Run(args []string) int {
+	fs := c.Command.defaultFlagSet("gcloudBilling")
+	fs.StringVar(&c.export, "export", "", "The billing export (csv or jsonl).")
+	fs.Parse(args)
+
+	project, err := c.loadProject()
+	if err != nil {
+		log.Fatalf("Failed to load project from file: %v\n", err)
+	}
+	if project == nil {
+		log.Fatalf("Need a project, please pass one via --projectin.\n")
+	}
+	if c.export == "" {
+		log.Fatalf("Need a billing export, please pass one via --export.\n")
+	}
+	items, err := billing.ReadExportFile(c.export)
+	if err != nil {
+		log.Fatalf("Failed to read billing export: %v\n", err)
+	}
+	if c.Command.Project != "" {
+		filtered := items[:0]
+		for _, li := range items {
+			if li.ProjectId == c.Command.Project {
+				filtered = append(filtered, li)
+			}
+		}
+		items = filtered
+	}
+
+	db, err := c.DbHandle()
+	if err != nil {
+		log.Fatalf("Could not open database: %v\n", err)
+	}
+	defer c.CloseDb()
+	estimates, err := pricing.GetSkuCosts(db, project, utils.NewPool(runtime.NumCPU()))
+	if err != nil {
+		log.Fatalf("Failed to get costs: %v\n", err)
+	}
+	report, err := billing.Compare(estimates, items)
+	if err != nil {
+		log.Fatalf("Failed to compare costs: %v\n", err)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.WriteAll(varianceLines(report))
+	if err = w.Error(); err != nil {
+		log.Fatalf("Failed to write report: %v\n", err)
+	}
+	return 0
+}
+
+// Returns a CSV line per resource set, sku and unmatched sku, and a total.
+func varianceLines(r *billing.Report) [][]string {
+	money := func(amount float64) string {
+		return fmt.Sprintf("%.2f USD", amount)
+	}
+	line := func(kind string, v billing.Variance) []string {
+		percent := "n/a"
+		if v.Estimated != 0 {
+			percent = fmt.Sprintf("%.1f%%", (v.Actual-v.Estimated)/v.Estimated*100)
+		}
+		return []string{kind, v.Name, v.Description, money(v.Estimated),
+			money(v.Actual), money(v.Actual - v.Estimated), percent}
+	}
+	lines := [][]string{{"kind", "name", "description", "estimated", "actual",
+		"variance", "variance %"}}
+	total := billing.Variance{Name: "total",
+		Description: fmt.Sprintf("%s to %s", r.Start.Format("2006-01-02 15:04"),
+			r.End.Format("2006-01-02 15:04"))}
+	for _, v := range r.Sets {
+		lines = append(lines, line("resource set", v))
+		total.Estimated += v.Estimated
+		total.Actual += v.Actual
+	}
+	for _, v := range r.Skus {
+		lines = append(lines, line("sku", v))
+	}
+	for _, v := range r.Unmatched {
+		lines = append(lines, line("unmatched", v))
+		total.Actual += v.Actual
+	}
+	return append(lines, line("total", total))
+}
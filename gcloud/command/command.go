@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -8,11 +9,13 @@ import (
 	"google.golang.org/protobuf/encoding/protojson"
 	"io/ioutil"
 	"log"
+	"nephomancy/common/fetch"
 	common "nephomancy/common/resources"
 	"nephomancy/gcloud/assets"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const projectDoc = `ID of a gcloud project. The user you are authenticating as must have access to this project. The billing, compute, asset, and monitoring APIs must be enabled for this project, and your user must be authorized to use them.`
@@ -25,6 +28,12 @@ const projectOutDoc = `Filename to save project information to. Project informat
 
 const costReportDoc = `Filename to save cost report (csv) to.`
 
+const timeoutDoc = `Maximum time for fetching data from the cloud provider's APIs, e.g. 30m. Defaults to no limit. Ctrl-C also stops fetching; the price cache is left as it was.`
+
+const callTimeoutDoc = `Maximum time for a single API call, e.g. 90s. Calls that time out are retried. Defaults to 2m.`
+
+const retriesDoc = `How often to retry an API call that failed with a transient error, waiting longer before each retry. Defaults to 3.`
+
 type Command struct {
 	// All relative paths are relative to this directory.
 	// Defaults to current working directory but can be overridden
@@ -55,6 +64,9 @@ type Command struct {
 
 	// File to write cost report to.
 	costReportFile string
+
+	// Maximum time for fetching data, 0 for no limit.
+	timeout time.Duration
 }
 
 // Create a flag set with flags common to most commands.
@@ -68,6 +80,19 @@ func (c *Command) defaultFlagSet(cn string) *flag.FlagSet {
 	return f
 }
 
+// Add flags for commands that fetch data from cloud provider APIs.
+func (c *Command) addFetchFlags(f *flag.FlagSet) {
+	f.DurationVar(&c.timeout, "timeout", 0, "Maximum time for fetching data.")
+	f.DurationVar(&fetch.CallTimeout, "call-timeout", fetch.CallTimeout, "Maximum time for a single API call.")
+	f.IntVar(&fetch.Retries, "retries", fetch.Retries, "How often to retry transient API errors.")
+}
+
+// Returns a context for fetching data, which is cancelled on Ctrl-C and
+// after the timeout.
+func (c *Command) fetchContext() (context.Context, context.CancelFunc) {
+	return fetch.Context(c.timeout)
+}
+
 func (c *Command) WorkingDir() (string, error) {
 	if c.workingDir != "" {
 		return c.workingDir, nil
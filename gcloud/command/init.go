@@ -3,8 +3,12 @@ package command
 import (
 	"fmt"
 	"log"
+	"nephomancy/common/snapshot"
+	"nephomancy/common/staging"
 	"nephomancy/gcloud/cache"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 type InitCommand struct {
@@ -12,7 +16,7 @@ type InitCommand struct {
 }
 
 func (*InitCommand) Help() string {
-	helpText := `
+	helpText := fmt.Sprintf(`
 	"Usage: nephomany gcloud init [options]
 
 	Initialize a new or existing data directory by building or refreshing
@@ -24,6 +28,8 @@ func (*InitCommand) Help() string {
 
 	This command is safe to run multiple times. Subsequent runs will usually be
 	faster than the first because they only need to do incremental updates.
+	Each run also saves a dated snapshot of the billing information, which you
+	can use with "nephomancy prices" and "nephomancy cost --as-of".
 
 	Options:
 
@@ -34,7 +40,13 @@ func (*InitCommand) Help() string {
 	                     as you use a project under the billing account you are interested in,
 			     and for which the relevant APIs are enabled. The user you are authenticated
 			     as must have access to the project.
-`
+
+	  --timeout=duration %s
+
+	  --call-timeout=duration %s
+
+	  --retries=n %s
+`, timeoutDoc, callTimeoutDoc, retriesDoc)
 	return strings.TrimSpace(helpText)
 }
 
@@ -46,33 +58,48 @@ func (*InitCommand) Synopsis() string {
 // nephomancy gcloud init --project=binderhub-test-275512
 func (c *InitCommand) Run(args []string) int {
 	fs := c.Command.defaultFlagSet("gcloudInit")
+	c.Command.addFetchFlags(fs)
 	fs.Parse(args)
 
 	dbFile, err := c.Command.DbFile()
 	if err != nil {
 		log.Fatalf("Failed to obtain database filename: %v\n", err)
 	}
-	err = cache.CreateOrUpdateDatabase(&dbFile)
-	if err != nil {
-		log.Fatalf("Failed to create database: %v\n", err)
+	project := c.Command.Project
+	if project == "" {
+		log.Fatalf("Need a project ID.\n")
 	}
-	fmt.Printf("Opened database using file %s\n", dbFile)
 
-	db, err := c.DbHandle()
+	// Work on a copy of the database, so that a failed or interrupted
+	// refresh leaves the database as it was.
+	stage, err := staging.Begin(dbFile)
 	if err != nil {
-		log.Fatalf("Failed to get database handle: %v\n", err)
+		log.Fatalf("Failed to create database: %v\n", err)
+	}
+	fail := func(format string, v ...interface{}) {
+		stage.Abort()
+		log.Printf("Database %s was left unchanged.\n", dbFile)
+		log.Fatalf(format, v...)
 	}
-	defer db.Close()
+	if err = cache.MigrateDatabase(stage.DB); err != nil {
+		fail("Failed to create database: %v\n", err)
+	}
+	fmt.Printf("Refreshing database %s\n", dbFile)
 
-	project := c.Command.Project
-	if project == "" {
-		log.Fatalf("Need a project ID.\n")
+	ctx, cancel := c.fetchContext()
+	defer cancel()
+	if err = cache.PopulateDatabase(ctx, stage.DB, project); err != nil {
+		fail("Failed to populate database: %v\n", err)
 	}
 
-	err = cache.PopulateDatabase(db, project)
+	fname, err := snapshot.Save(stage.DB, filepath.Dir(dbFile), time.Now())
 	if err != nil {
-		log.Fatalf("Failed to populate database: %v\n", err)
+		fail("Failed to save price snapshot: %v\n", err)
+	}
+	if err = stage.Commit(); err != nil {
+		log.Fatalf("Failed to replace database %s: %v\n", dbFile, err)
 	}
 	fmt.Println("Populated database.")
+	fmt.Printf("Saved price snapshot to %s\n", fname)
 	return 0
 }
@@ -0,0 +1,155 @@
+package command
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"nephomancy/gcloud/assets"
+	"nephomancy/gcloud/rightsize"
+	"os"
+	"strings"
+	"time"
+)
+
+const windowDoc = `How far back to look at usage, e.g. 72h. Defaults to 7 days.`
+
+const targetDoc = `The usage to aim for, as a fraction of capacity. Instance sets whose 95th percentile cpu and memory usage is below it get smaller machine types, or fewer instances if there are several. Defaults to 0.6.`
+
+const recordDoc = `Directory to save the Monitoring time series to, so they can be replayed with --replay.`
+
+const replayDoc = `Directory with time series saved by --record, to use instead of the Monitoring API.`
+
+type RightsizeCommand struct {
+	Command
+	window time.Duration
+	target float64
+	record string
+	replay string
+}
+
+func (c *RightsizeCommand) Help() string {
+	helpText := fmt.Sprintf(`
+	Usage: nephomancy gcloud rightsize [options]
+
+	Suggest smaller machine types or fewer instances for an imported project.
+
+	Reads cpu and memory usage of the project's instances from
+	Monitoring, prices the instance sets with the suggested changes, and
+	prints a CSV line per instance set with its usage, the suggestion
+	and the costs before and after. The project with the suggestions
+	applied is saved to projectout. Memory usage is only known
+	for instances running the Ops agent.
+
+	Options:
+	  --project=PROJECT  %s
+	  --workingdir=path  %s
+	  --projectin=filename %s
+	  --projectout=filename %s
+	  --window=duration %s
+	  --target=fraction %s
+	  --record=path %s
+	  --replay=path %s
+	  --timeout=duration %s
+`, projectDoc, workingDirDoc, projectInDoc, projectOutDoc, windowDoc, targetDoc,
+		recordDoc, replayDoc, timeoutDoc)
+	return strings.TrimSpace(helpText)
+}
+
+func (*RightsizeCommand) Synopsis() string {
+	return "Suggests smaller machine types or fewer instances for a gcloud project."
+}
+
+// Run this with
+// nephomancy gcloud rightsize --projectin=binderhub-test-275512.json
+func (c *RightsizeCommand) Run(args []string) int {
+	fs := c.Command.defaultFlagSet("gcloudRightsize")
+	c.Command.addFetchFlags(fs)
+	fs.DurationVar(&c.window, "window", 7*24*time.Hour, "How far back to look at usage.")
+	fs.Float64Var(&c.target, "target", 0.6, "The usage to aim for.")
+	fs.StringVar(&c.record, "record", "", "Directory to save the time series to.")
+	fs.StringVar(&c.replay, "replay", "", "Directory with saved time series.")
+	fs.Parse(args)
+
+	project, err := c.loadProject()
+	if err != nil {
+		log.Fatalf("Failed to load project from file: %v\n", err)
+	}
+	if project == nil {
+		log.Fatalf("Need a project, please import one with 'nephomancy gcloud assets' and pass it via --projectin.\n")
+	}
+	projectName := c.Command.Project
+	if projectName == "" {
+		projectName = project.Name
+	}
+	if c.target <= 0 || c.target > 1 {
+		log.Fatalf("The target has to be between 0 and 1.\n")
+	}
+
+	db, err := c.DbHandle()
+	if err != nil {
+		log.Fatalf("Could not open database: %v\n", err)
+	}
+	defer c.CloseDb()
+
+	if c.replay != "" {
+		assets.MonitoringFixture = c.replay
+	}
+	ctx, cancel := c.fetchContext()
+	defer cancel()
+	src, err := assets.NewMonitoring(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to Monitoring: %v\n", err)
+	}
+	defer src.Close()
+	if c.record != "" {
+		src = &assets.RecordingMonitoring{TimeSeriesSource: src, Dir: c.record}
+	}
+	end := time.Now()
+	metrics, err := assets.GetInstanceMetrics(ctx, src, projectName, end.Add(-c.window), end)
+	if err != nil {
+		log.Fatalf("Failed to get metrics: %v\n", err)
+	}
+
+	suggested, suggestions, err := rightsize.Suggest(db, project, metrics,
+		rightsize.Options{Target: c.target})
+	if err != nil {
+		log.Fatalf("Failed to rightsize project: %v\n", err)
+	}
+	if err = c.saveProject(suggested); err != nil {
+		log.Fatalf("Failed to save project: %v\n", err)
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.WriteAll(suggestionLines(suggestions))
+	if err = w.Error(); err != nil {
+		log.Fatalf("Failed to write suggestions: %v\n", err)
+	}
+	return 0
+}
+
+// Returns a CSV line per suggestion and a total line.
+func suggestionLines(suggestions []rightsize.Suggestion) [][]string {
+	usage := func(u float64) string {
+		if u < 0 {
+			return "unknown"
+		}
+		return fmt.Sprintf("%.0f%%", u*100)
+	}
+	money := func(amount float64) string {
+		return fmt.Sprintf("%.2f USD", amount)
+	}
+	lines := [][]string{{"instance set", "machine type", "count", "cpu p95",
+		"memory p95", "suggested machine type", "suggested count",
+		"projected cost", "suggested cost", "savings", "note"}}
+	var cost, newCost float64
+	for _, s := range suggestions {
+		lines = append(lines, []string{s.InstanceSet, s.MachineType,
+			fmt.Sprintf("%d", s.Count), usage(s.Cpu), usage(s.Memory),
+			s.NewMachineType, fmt.Sprintf("%d", s.NewCount),
+			money(s.Cost), money(s.NewCost), money(s.Cost - s.NewCost), s.Note})
+		cost += s.Cost
+		newCost += s.NewCost
+	}
+	lines = append(lines, []string{"total", "", "", "", "", "", "",
+		money(cost), money(newCost), money(cost - newCost), ""})
+	return lines
+}
@@ -12,7 +12,7 @@ type ServicesCommand struct {
 }
 
 func (*ServicesCommand) Help() string {
-	helpText := `
+	helpText := fmt.Sprintf(`
 	Usage: nephomancy gcloud services [options]
 
 	Print a summary of services in use by a project.
@@ -20,7 +20,13 @@ func (*ServicesCommand) Help() string {
 	Options:
 	  --project=PROJECT  ID of a gcloud project. The user you are authenticating as must have
 	                     access to this project. The billing, compute, asset, and monitoring APIs
-			     must be enabled for this project.`
+			     must be enabled for this project.
+
+	  --timeout=duration %s
+
+	  --call-timeout=duration %s
+
+	  --retries=n %s`, timeoutDoc, callTimeoutDoc, retriesDoc)
 	return strings.TrimSpace(helpText)
 }
 
@@ -32,6 +38,7 @@ func (*ServicesCommand) Synopsis() string {
 // nephomancy gcloud services --project=binderhub-test-275512
 func (c *ServicesCommand) Run(args []string) int {
 	fs := c.Command.defaultFlagSet("gcloudServices")
+	c.Command.addFetchFlags(fs)
 	fs.Parse(args)
 
 	project := c.Command.Project
@@ -41,21 +48,24 @@ func (c *ServicesCommand) Run(args []string) int {
 	projectPath := fmt.Sprintf("projects/%s", project)
 	_ = projectPath
 
+	ctx, cancel := c.fetchContext()
+	defer cancel()
+
 	/*
-		regions, err := assets.ListRegions(project)
+		regions, err := assets.ListRegions(ctx, project)
 		if err != nil {
 			log.Fatalf("Failed to get regions: %v", err)
 		}
 		fmt.Printf("regions: %v", regions)
 	*/
-	mt, err := assets.ListMachineTypes(project, "europe-west1-b")
+	mt, err := assets.ListMachineTypes(ctx, project, "europe-west1-b")
 	if err != nil {
 		log.Fatalf("Failed to get machine types: %v", err)
 	}
 	fmt.Printf("machine types: %+v\n", mt)
 
 	/*
-		err = assets.ListServices(projectPath)
+		err = assets.ListServices(ctx, projectPath)
 		if err != nil {
 			log.Fatalf("Failed to get services: %v", err)
 		}
@@ -63,7 +73,7 @@ func (c *ServicesCommand) Run(args []string) int {
 
 	// err = assets.ListMetrics(projectPath, `metric.type=starts_with("compute.googleapis.com")`)
 	/*
-		err = assets.ListMetrics(projectPath)
+		err = assets.ListMetrics(ctx, projectPath)
 		if err != nil {
 			log.Fatalf("Failed to get metrics: %v", err)
 		}
@@ -0,0 +1,47 @@
+// A fake of the Google Cloud APIs that init and assets use: Cloud
+// Billing, Cloud Asset and Compute. Point the fetchers at it by setting
+// assets.Endpoint to the server's URL.
+//
+// The fixtures in testdata are named after the request path, e.g.
+// v1/services.json for the list of billing services, and
+// compute/v1/projects/<project>/zones.json for the zones of a project.
+// Later pages of a list have the page token appended after an @, e.g.
+// v1/services@services-2.json.
+//
+// Monitoring is a gRPC API, so it isn't served; its recorded time series
+// are in testdata/monitoring, for assets.RecordedMonitoring.
+package fake
+
+import (
+	common "nephomancy/common/fake"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// The project the fixtures are for.
+const Project = "binderhub-test-275512"
+
+// Starts a server replaying the fixtures. Close it when done.
+func NewServer() *common.Server {
+	return common.NewServer(fixtureDir(), route)
+}
+
+func route(r *http.Request) ([]string, error) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		name += "@" + token
+	}
+	return []string{name + ".json"}, nil
+}
+
+// Returns the directory with the recorded Monitoring time series.
+func MonitoringDir() string {
+	return filepath.Join(fixtureDir(), "monitoring")
+}
+
+func fixtureDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata")
+}
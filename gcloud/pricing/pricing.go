@@ -6,160 +6,321 @@ import (
 	"github.com/golang/protobuf/ptypes"
 	"math"
 	common "nephomancy/common/resources"
+	"nephomancy/common/utils"
 	"nephomancy/gcloud/assets"
 	"nephomancy/gcloud/cache"
+	"sort"
+	"strings"
 )
 
-func GetCost(db *sql.DB, p *common.Project) ([][]string, error) {
-	costs := make([][]string, 0)
+// Prices the instance sets, disk sets and networks of the project
+// concurrently, with goroutines from the pool. The database handle is
+// shared; database/sql and sqlite allow concurrent reads. The costs are
+// in the same order as the resource sets in the project.
+func GetCost(db *sql.DB, p *common.Project, pool *utils.Pool) ([][]string, error) {
+	lines, err := costLines(db, p, pool)
+	if err != nil {
+		return nil, err
+	}
+	for idx, line := range lines {
+		lines[idx] = line[:len(line)-1]
+	}
+	return lines, nil
+}
+
+// Expected monthly cost of the part of a resource set priced with one sku.
+type SkuCost struct {
+	// The name of the instance set, disk set, network or image.
+	Set string
+	// The resource type column of the cost report, e.g. "VM cpu".
+	Resource string
+	SkuId    string
+	Cost     float64
+	Currency string
+}
+
+// Like GetCost, but returns the expected costs per resource set and sku.
+// Lines of the cost report that could not be priced are left out.
+func GetSkuCosts(db *sql.DB, p *common.Project, pool *utils.Pool) ([]SkuCost, error) {
+	lines, err := costLines(db, p, pool)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]SkuCost, 0, len(lines))
+	for _, line := range lines {
+		sku := line[len(line)-1]
+		if sku == "" || len(line) < 5 {
+			continue
+		}
+		var sc SkuCost
+		if n, err := fmt.Sscanf(line[len(line)-2], "%f %s", &sc.Cost, &sc.Currency); n != 2 || err != nil {
+			return nil, fmt.Errorf("unexpected cost %q for sku %s", line[len(line)-2], sku)
+		}
+		sc.Set = line[2]
+		sc.Resource = line[3]
+		sc.SkuId = sku
+		ret = append(ret, sc)
+	}
+	return ret, nil
+}
+
+// Cost report lines with the sku each one was priced with as an extra
+// last column, empty if there was no price.
+func costLines(db *sql.DB, p *common.Project, pool *utils.Pool) ([][]string, error) {
+	tasks := make([]func() ([][]string, error), 0)
+	// Resources placed with other providers are left to them.
 	for _, vmset := range p.InstanceSets {
-		var gvm assets.GCloudVM
-		if err := ptypes.UnmarshalAny(
-			vmset.Template.ProviderDetails[assets.GcloudProvider], &gvm); err != nil {
-			return nil, err
+		if common.OtherProviders(assets.GcloudProvider, vmset.Template.ProviderDetails) {
+			continue
+		}
+		vmset := vmset
+		tasks = append(tasks, func() ([][]string, error) {
+			return instanceSetCost(db, p.Name, vmset)
+		})
+	}
+	for _, dset := range p.DiskSets {
+		if common.OtherProviders(assets.GcloudProvider, dset.Template.ProviderDetails) {
+			continue
+		}
+		dset := dset
+		tasks = append(tasks, func() ([][]string, error) {
+			return diskSetCost(db, p.Name, dset)
+		})
+	}
+	for _, nw := range p.Networks {
+		if nw.ProviderDetails[assets.GcloudProvider] == nil && placedElsewhere(nw) {
+			continue
 		}
-		skus, _ := cache.GetSkusForInstance(db, gvm)
+		nw := nw
+		tasks = append(tasks, func() ([][]string, error) {
+			return networkCost(db, p.Name, nw)
+		})
+	}
+	results := make([][][]string, len(tasks))
+	err := pool.ForEach(len(tasks), func(i int) error {
+		var err error
+		results[i], err = tasks[i]()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	costs := make([][]string, 0)
+	for _, r := range results {
+		costs = append(costs, r...)
+	}
+	return costs, nil
+}
+
+// Prepends the project, provider and resource set columns to a line from
+// one of the cost functions below. Those end with the sku, or are empty.
+func withPrefix(projectName string, name string, line []string) []string {
+	if len(line) == 0 {
+		line = []string{""}
+	}
+	return append([]string{projectName, assets.GcloudProvider, name}, line...)
+}
+
+// Returns true if a network has been placed with another provider,
+// which may only have put details on its subnetworks or gateways.
+func placedElsewhere(nw *common.Network) bool {
+	if common.OtherProviders(assets.GcloudProvider, nw.ProviderDetails) {
+		return true
+	}
+	for _, snw := range nw.Subnetworks {
+		if common.OtherProviders(assets.GcloudProvider, snw.ProviderDetails) {
+			return true
+		}
+		for _, gw := range snw.Gateways {
+			if common.OtherProviders(assets.GcloudProvider, gw.ProviderDetails) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func instanceSetCost(db *sql.DB, projectName string, vmset *common.InstanceSet) ([][]string, error) {
+	costs := make([][]string, 0)
+	gvm := &assets.GCloudVM{}
+	if err := ptypes.UnmarshalAny(
+		vmset.Template.ProviderDetails[assets.GcloudProvider], gvm); err != nil {
+		return nil, err
+	}
+	skus, err := cache.GetSkusForInstance(db, gvm)
+	if err != nil {
+		return nil, err
+	}
+	pi, err := cache.GetPricingInfo(db, skus)
+	if err != nil {
+		return nil, err
+	}
+	vmcosts, err := vmCostRange(db, vmset, gvm, pi)
+	if err != nil {
+		return nil, err
+	}
+	for idx, vc := range vmcosts {
+		vmcosts[idx] = withPrefix(projectName, vmset.Name, vc)
+	}
+	costs = append(costs, vmcosts...)
+
+	skus, _ = cache.GetSkusForLicense(db, gvm)
+	pi, err = cache.GetPricingInfo(db, skus)
+	if err != nil {
+		return nil, err
+	}
+	licenseCosts, err := licenseCost(db, vmset, gvm, pi)
+	if err != nil {
+		return nil, err
+	}
+	for idx, lc := range licenseCosts {
+		licenseCosts[idx] = withPrefix(projectName, vmset.Name, lc)
+	}
+	costs = append(costs, licenseCosts...)
+
+	if vmset.Template.LocalStorage != nil && len(vmset.Template.LocalStorage) > 0 {
+		skus, _ := cache.GetSkusForLocalDisk(db, gvm)
 		pi, err := cache.GetPricingInfo(db, skus)
 		if err != nil {
 			return nil, err
 		}
-		vmcosts, err := vmCostRange(db, *vmset, gvm, pi)
+		localDiskCosts, err := localDiskCost(db, vmset, gvm, pi)
 		if err != nil {
 			return nil, err
 		}
-		for idx, vc := range vmcosts {
-			vmcosts[idx] = append([]string{
-				p.Name, assets.GcloudProvider, vmset.Name}, vc...)
+		if len(localDiskCosts) > 0 {
+			costs = append(costs, withPrefix(projectName, vmset.Name, localDiskCosts))
 		}
-		costs = append(costs, vmcosts...)
+	}
+	return costs, nil
+}
 
-		skus, _ = cache.GetSkusForLicense(db, gvm)
-		pi, err = cache.GetPricingInfo(db, skus)
+func diskSetCost(db *sql.DB, projectName string, dset *common.DiskSet) ([][]string, error) {
+	costs := make([][]string, 0)
+	gdsk := &assets.GCloudDisk{}
+	if err := ptypes.UnmarshalAny(
+		dset.Template.ProviderDetails[assets.GcloudProvider], gdsk); err != nil {
+		return nil, err
+	}
+	skus, _ := cache.GetSkusForDisk(db, gdsk)
+	pi, err := cache.GetPricingInfo(db, skus)
+	if err != nil {
+		return nil, err
+	}
+	dcosts, err := diskCostRange(db, dset, gdsk, pi)
+	if err != nil {
+		return nil, err
+	}
+	costs = append(costs, withPrefix(projectName, dset.Name, dcosts))
+
+	if dset.Template.Image != nil {
+		skus, _ := cache.GetSkusForImage(db, gdsk)
+		pi, err := cache.GetPricingInfo(db, skus)
 		if err != nil {
 			return nil, err
 		}
-		licenseCosts, err := licenseCost(db, *vmset, gvm, pi)
+		icosts, err := imageCost(db, dset.Template.Image, pi)
 		if err != nil {
 			return nil, err
 		}
-		for idx, lc := range licenseCosts {
-			licenseCosts[idx] = append([]string{
-				p.Name, assets.GcloudProvider, vmset.Name},
-				lc...)
-		}
-		costs = append(costs, licenseCosts...)
+		costs = append(costs, withPrefix(projectName, dset.Template.Image.Name, icosts))
+	}
+	return costs, nil
+}
 
-		if vmset.Template.LocalStorage != nil && len(vmset.Template.LocalStorage) > 0 {
-			skus, _ := cache.GetSkusForLocalDisk(db, gvm)
-			pi, err := cache.GetPricingInfo(db, skus)
-			if err != nil {
-				return nil, err
-			}
-			localDiskCosts, err := localDiskCost(db, *vmset, gvm, pi)
-			if err != nil {
-				return nil, err
-			}
-			localDiskCosts = append([]string{
-				p.Name, assets.GcloudProvider, vmset.Name}, localDiskCosts...)
-			costs = append(costs, localDiskCosts)
-		}
+func networkCost(db *sql.DB, projectName string, nw *common.Network) ([][]string, error) {
+	costs := make([][]string, 0)
+	tier, _ := assets.NetworkTier(nw)
+	var gnw assets.GCloudNetwork
+	if err := ptypes.UnmarshalAny(nw.ProviderDetails[assets.GcloudProvider], &gnw); err != nil {
+		return nil, err
 	}
-	for _, dset := range p.DiskSets {
-		var gdsk assets.GCloudDisk
-		if err := ptypes.UnmarshalAny(
-			dset.Template.ProviderDetails[assets.GcloudProvider], &gdsk); err != nil {
-			return nil, err
+	for _, addr := range gnw.Addresses {
+		if addr.Type != "EXTERNAL" {
+			continue
 		}
-		skus, _ := cache.GetSkusForDisk(db, gdsk)
-		pi, err := cache.GetPricingInfo(db, skus)
+		var region string
+		if addr.Region == "global" {
+			region = ""
+		} else {
+			region = addr.Region
+		}
+		// The usage type of the VM, as in the sku descriptions.
+		var usageType string
+		if addr.Status == "IN_USE" {
+			usageType = "Standard"
+		} else {
+			usageType = ""
+		}
+		addrSkus, _ := cache.GetSkusForIpAddress(db, region, usageType)
+		pi, _ := cache.GetPricingInfo(db, addrSkus)
+		c, err := ipAddrCostRange(db, usageType, pi)
 		if err != nil {
 			return nil, err
 		}
-		dcosts, err := diskCostRange(db, *dset, gdsk, pi)
+		if c != nil {
+			costs = append(costs, withPrefix(projectName, nw.Name, c))
+		}
+	}
+	for _, snw := range nw.Subnetworks {
+		ncosts := make([][]string, 0, 3)
+		region, _ := assets.SubnetworkRegion(snw)
+		if region == "" {
+			fmt.Printf("Missing region in subnetwork %s:%s\n",
+				nw.Name, snw.Name)
+			region = "us-central1"
+		}
+		externalEgressSkus, _ := cache.GetSkusForExternalEgress(
+			db, region, tier)
+		pi, _ := cache.GetPricingInfo(db, externalEgressSkus)
+		c1, err := subnetworkCostRange(db, snw, snw.ExternalEgressGbitsPerMonth,
+			fmt.Sprintf("external egress traffic from %s", region), pi)
 		if err != nil {
 			return nil, err
 		}
-		dcosts = append([]string{
-			p.Name, assets.GcloudProvider, dset.Name}, dcosts...)
-		costs = append(costs, dcosts)
-
-		if dset.Template.Image != nil {
-			skus, _ := cache.GetSkusForImage(db, gdsk)
-			pi, err := cache.GetPricingInfo(db, skus)
-			if err != nil {
-				return nil, err
-			}
-			icosts, err := imageCost(db, *dset.Template.Image, pi)
-			if err != nil {
-				return nil, err
-			}
-			icosts = append([]string{
-				p.Name, assets.GcloudProvider, dset.Template.Image.Name},
-				icosts...)
-			costs = append(costs, icosts)
+		if c1 != nil {
+			ncosts = append(ncosts, withPrefix(projectName, snw.Name, c1))
 		}
-	}
-	for _, nw := range p.Networks {
-		tier, _ := assets.NetworkTier(*nw)
-		var gnw assets.GCloudNetwork
-		if err := ptypes.UnmarshalAny(nw.ProviderDetails[assets.GcloudProvider], &gnw); err != nil {
+		internalEgressSkus, _ := cache.GetSkusForInternalEgress(
+			db, region)
+		pi, _ = cache.GetPricingInfo(db, internalEgressSkus)
+		c2, err := subnetworkCostRange(db, snw, snw.InternalEgressGbitsPerMonth,
+			fmt.Sprintf("internal egress traffic from %s", region), pi)
+		if err != nil {
 			return nil, err
 		}
-		for _, addr := range gnw.Addresses {
-			if addr.Type != "EXTERNAL" {
-				continue
-			}
-			var region string
-			if addr.Region == "global" {
-				region = ""
-			} else {
-				region = addr.Region
-			}
-			var usageType string
-			if addr.Status == "IN_USE" {
-				usageType = "STANDARD"
-			} else {
-				usageType = ""
-			}
-			addrSkus, _ := cache.GetSkusForIpAddress(db, region, usageType)
-			pi, _ := cache.GetPricingInfo(db, addrSkus)
-			c, err := ipAddrCostRange(db, usageType, pi)
-			if err != nil {
-				return nil, err
-			}
-			c = append([]string{p.Name, assets.GcloudProvider, region}, c...)
-			costs = append(costs, c)
-		}
-		for _, snw := range nw.Subnetworks {
-			ncosts := make([][]string, 2)
-			region, _ := assets.SubnetworkRegion(*snw)
-			if region == "" {
-				fmt.Printf("Missing region in subnetwork %s:%s\n",
-					nw.Name, snw.Name)
-				region = "us-central1"
-			}
-			externalEgressSkus, _ := cache.GetSkusForExternalEgress(
-				db, region, tier)
-			pi, _ := cache.GetPricingInfo(db, externalEgressSkus)
-			c1, err := subnetworkCostRange(db, *snw, true, pi)
+		if c2 != nil {
+			ncosts = append(ncosts, withPrefix(projectName, snw.Name, c2))
+		}
+		// Usually only known from observed traffic, see assets.GetSubnetworkTraffic.
+		if snw.SameRegionEgressGbitsPerMonth > 0 {
+			interzoneEgressSkus, _ := cache.GetSkusForInterzoneEgress(db, region)
+			pi, _ = cache.GetPricingInfo(db, interzoneEgressSkus)
+			c3, err := subnetworkCostRange(db, snw, snw.SameRegionEgressGbitsPerMonth,
+				fmt.Sprintf("egress traffic between zones in %s", region), pi)
 			if err != nil {
 				return nil, err
 			}
-			ncosts[0] = append([]string{p.Name, assets.GcloudProvider, snw.Name}, c1...)
-			internalEgressSkus, _ := cache.GetSkusForInternalEgress(
-				db, region)
-			pi, _ = cache.GetPricingInfo(db, internalEgressSkus)
-			c2, err := subnetworkCostRange(db, *snw, false, pi)
-			if err != nil {
-				return nil, err
+			if c3 != nil {
+				ncosts = append(ncosts, withPrefix(projectName, snw.Name, c3))
 			}
-			ncosts[1] = append([]string{p.Name, assets.GcloudProvider, snw.Name}, c2...)
-			costs = append(costs, ncosts...)
 		}
+		costs = append(costs, ncosts...)
 	}
 	return costs, nil
 }
 
+// Returns the skus in pricing in sorted order, so that cost reports list
+// them in the same order every time.
+func sortedSkus(pricing map[string](cache.PricingInfo)) []string {
+	skus := make([]string, 0, len(pricing))
+	for skuId := range pricing {
+		skus = append(skus, skuId)
+	}
+	sort.Strings(skus)
+	return skus
+}
+
 func getTotalsForRate(
 	price cache.PricingInfo, maxUsage uint64, expectedUsage uint64) (
 	maxCost float64, expectedCost float64, err error) {
@@ -221,13 +382,14 @@ func getTotalsForRate(
 
 func ipAddrCostRange(db *sql.DB, usageType string, pricing map[string](cache.PricingInfo)) ([]string, error) {
 	maxUsage := uint64(730)
-	for _, price := range pricing {
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		max, exp, err := getTotalsForRate(price, maxUsage, maxUsage)
 		if err != nil {
 			return nil, err
 		}
 		var spec string
-		if usageType == "RESERVED" {
+		if usageType == "" {
 			spec = fmt.Sprintf("not attached to a VM")
 		} else {
 			spec = fmt.Sprintf("attached to a %s VM", usageType)
@@ -241,31 +403,20 @@ func ipAddrCostRange(db *sql.DB, usageType string, pricing map[string](cache.Pri
 			fmt.Sprintf("%.2f USD", max),
 			fmt.Sprintf("%d h", maxUsage),
 			fmt.Sprintf("%.2f USD", exp),
+			skuId,
 		}, nil
 	}
 	return nil, nil
 }
 
-func subnetworkCostRange(db *sql.DB, subnetwork common.Subnetwork, external bool,
-	pricing map[string](cache.PricingInfo)) ([]string, error) {
-	var usage uint64
-	resourceName := ""
-	region, _ := assets.SubnetworkRegion(subnetwork)
-	if external {
-		usage = subnetwork.ExternalEgressGbitsPerMonth
-		resourceName = fmt.Sprintf("external egress traffic from %s",
-			region)
-	} else {
-		usage = subnetwork.InternalEgressGbitsPerMonth
-		resourceName = fmt.Sprintf("internal egress traffic from %s",
-			region)
-	}
+func subnetworkCostRange(db *sql.DB, subnetwork *common.Subnetwork, usage uint64,
+	resourceName string, pricing map[string](cache.PricingInfo)) ([]string, error) {
 	// There can be several different prices depending on the regions involved,
 	// just use the highest.
 	var highestTotal float64
 	var ncost []string
-	for skuId, price := range pricing {
-		_ = skuId
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		max, _, err := getTotalsForRate(price, usage, 0)
 		if err != nil {
 			return nil, err
@@ -281,21 +432,22 @@ func subnetworkCostRange(db *sql.DB, subnetwork common.Subnetwork, external bool
 				"unknown",
 				fmt.Sprintf("%d Gb", usage),
 				fmt.Sprintf("%.2f USD", max),
+				skuId,
 			}
 		}
 	}
 	return ncost, nil
 }
 
-func imageCost(db *sql.DB, image common.Image, pricing map[string](cache.PricingInfo)) ([]string, error) {
+func imageCost(db *sql.DB, image *common.Image, pricing map[string](cache.PricingInfo)) ([]string, error) {
 	if len(pricing) != 1 {
 		return nil, fmt.Errorf(
 			"expected exactly one price for image but got %d",
 			len(pricing))
 	}
 	sizeGb := uint64(image.SizeGb)
-	for skuId, price := range pricing {
-		_ = skuId
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		max, _, err := getTotalsForRate(price, sizeGb, 0)
 		if err != nil {
 			return nil, err
@@ -310,13 +462,14 @@ func imageCost(db *sql.DB, image common.Image, pricing map[string](cache.Pricing
 			fmt.Sprintf("%.2f USD", max),
 			fmt.Sprintf("%d GB", sizeGb),
 			fmt.Sprintf("%.2f USD", max),
+			skuId,
 		}, nil
 	}
 	return nil, fmt.Errorf("no price found for image")
 }
 
-func diskCostRange(db *sql.DB, disk common.DiskSet,
-	gdsk assets.GCloudDisk, pricing map[string](cache.PricingInfo)) ([]string, error) {
+func diskCostRange(db *sql.DB, disk *common.DiskSet,
+	gdsk *assets.GCloudDisk, pricing map[string](cache.PricingInfo)) ([]string, error) {
 	if len(pricing) != 1 {
 		return nil, fmt.Errorf(
 			"expected exactly one price for disk space but got %d",
@@ -333,15 +486,15 @@ func diskCostRange(db *sql.DB, disk common.DiskSet,
 	maxUsage = sizeGb * uint64(diskCount)
 	var projectedUsage uint64
 	projectedUsage = uint64(diskCount) * maxUsage * uint64(disk.UsageHoursPerMonth) / 730
-	for skuId, price := range pricing {
-		_ = skuId
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		max, exp, err := getTotalsForRate(price, maxUsage, projectedUsage)
 		if err != nil {
 			return nil, err
 		}
 		spec := fmt.Sprintf("%s in %s",
-			common.PrintDiskType(*disk.Template.Type),
-			common.PrintLocation(*disk.Template.Location))
+			common.PrintDiskType(disk.Template.Type),
+			common.PrintLocation(disk.Template.Location))
 		// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
 		// Assume there is only one price
 		return []string{
@@ -352,13 +505,14 @@ func diskCostRange(db *sql.DB, disk common.DiskSet,
 			fmt.Sprintf("%.2f USD", max),
 			fmt.Sprintf("%d GiBy/mo", projectedUsage),
 			fmt.Sprintf("%.2f USD", exp),
+			skuId,
 		}, nil
 	}
 	// Should not get here.
 	return nil, fmt.Errorf("no price found for disk")
 }
 
-func localDiskCost(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
+func localDiskCost(db *sql.DB, vm *common.InstanceSet, gvm *assets.GCloudVM,
 	pricing map[string](cache.PricingInfo)) ([]string, error) {
 	if vm.Template.LocalStorage == nil {
 		return nil, nil
@@ -377,7 +531,8 @@ func localDiskCost(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 	costs := make([]string, 0)
 	var maxUsage uint64
 	var projectedUsage uint64
-	for skuId, price := range pricing {
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		pe := price.PricingExpression
 		fmt.Printf("sku %s pricing: %+v pe: %+v\n", skuId, price, pe)
 		maxUsage = uint64(730 * vmCount * totalSizeGb)
@@ -397,12 +552,13 @@ func localDiskCost(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 			fmt.Sprintf("%.2f USD", max),
 			fmt.Sprintf("%d GB for a month", projectedUsage),
 			fmt.Sprintf("%.2f USD", exp),
+			skuId,
 		}
 	}
 	return costs, nil
 }
 
-func licenseCost(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
+func licenseCost(db *sql.DB, vm *common.InstanceSet, gvm *assets.GCloudVM,
 	pricing map[string](cache.PricingInfo)) ([][]string, error) {
 	vmCount := vm.Count
 	usage := vm.UsageHoursPerMonth
@@ -425,7 +581,8 @@ func licenseCost(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 	var maxUsage uint64
 	var projectedUsage uint64
 	var resourceName string
-	for skuId, price := range pricing {
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		pe := price.PricingExpression
 		fmt.Printf("sku %s pricing: %+v pe: %+v\n", skuId, price, pe)
 		// TODO: handle licenses with a gpu price
@@ -456,12 +613,13 @@ func licenseCost(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 			fmt.Sprintf("%.2f USD", max),
 			fmt.Sprintf("%d %s per month", projectedUsage, pe.UsageUnit),
 			fmt.Sprintf("%.2f USD", exp),
+			skuId,
 		})
 	}
 	return costs, nil
 }
 
-func vmCostRange(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
+func vmCostRange(db *sql.DB, vm *common.InstanceSet, gvm *assets.GCloudVM,
 	pricing map[string](cache.PricingInfo)) ([][]string, error) {
 	mt, err := cache.GetMachineType(db, gvm.MachineType, gvm.Region)
 	if err != nil {
@@ -477,11 +635,16 @@ func vmCostRange(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 		memoryGb = uint32(math.Floor(memoryGbFraction))
 	}
 	usage := vm.UsageHoursPerMonth
+	if strings.HasPrefix(gvm.Scheduling, "Commit") {
+		// Commitments are paid for whether or not the vm is running.
+		usage = 730
+	}
 	vmCount := vm.Count
 	var maxUsage uint64
 	var projectedUsage uint64
 	costs := make([][]string, 0)
-	for skuId, price := range pricing {
+	for _, skuId := range sortedSkus(pricing) {
+		price := pricing[skuId]
 		pe := price.PricingExpression
 		resourceName := ""
 		if pe.UsageUnit == "h" { // cpu hours
@@ -501,8 +664,11 @@ func vmCostRange(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 			return nil, err
 		}
 		spec := fmt.Sprintf("%s in %s",
-			common.PrintMachineType(*vm.Template.Type),
-			common.PrintLocation(*vm.Template.Location))
+			common.PrintMachineType(vm.Template.Type),
+			common.PrintLocation(vm.Template.Location))
+		if gvm.Scheduling != "" && gvm.Scheduling != "OnDemand" {
+			spec = fmt.Sprintf("%s (%s)", spec, gvm.Scheduling)
+		}
 		// resource type | count | spec | max usage | max cost | exp. usage | exp. cost
 		costs = append(costs, []string{
 			fmt.Sprintf("VM %s", resourceName),
@@ -512,6 +678,7 @@ func vmCostRange(db *sql.DB, vm common.InstanceSet, gvm assets.GCloudVM,
 			fmt.Sprintf("%.2f USD", max),
 			fmt.Sprintf("%d %s per month", projectedUsage, pe.UsageUnit),
 			fmt.Sprintf("%.2f USD", exp),
+			skuId,
 		})
 	}
 	return costs, nil
@@ -4,25 +4,33 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"nephomancy/common/metadata"
 	"nephomancy/common/registry"
 	"nephomancy/common/resources"
+	"nephomancy/common/snapshot"
+	"nephomancy/common/utils"
 	"nephomancy/gcloud/cache"
 	"nephomancy/gcloud/pricing"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
 // GcloudProvider implements registry.provider
 type GcloudProvider struct {
 	// db handle for lookups
 	dbHandle *sql.DB
+	// directory with the sku cache and its snapshots
+	dir string
 }
 
 var instance registry.Provider = &GcloudProvider{}
 
 const name = "gcloud"
 
+const dbName = "sku-cache.db"
+
 func (g *GcloudProvider) FillInProviderDetails(p *resources.Project) error {
 	if g.dbHandle == nil {
 		return fmt.Errorf("Provider has not been initialized\n")
@@ -30,11 +38,83 @@ func (g *GcloudProvider) FillInProviderDetails(p *resources.Project) error {
 	return cache.FillInProviderDetails(g.dbHandle, p)
 }
 
+func (g *GcloudProvider) Regions(loc *resources.Location) []string {
+	return cache.PlacementRegions(loc)
+}
+
+func (g *GcloudProvider) FillInProviderDetailsIn(p *resources.Project, region string) error {
+	if g.dbHandle == nil {
+		return fmt.Errorf("Provider has not been initialized\n")
+	}
+	return cache.FillInProviderDetailsIn(g.dbHandle, p, region)
+}
+
+func (g *GcloudProvider) ListMachineTypes(spec *resources.MachineType, loc *resources.Location) (
+	[]registry.MachineType, error) {
+	if g.dbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return cache.ListMachineTypes(g.dbHandle, spec, loc)
+}
+
 func (g *GcloudProvider) GetCost(p *resources.Project) ([][]string, error) {
+	return g.GetCostIn(p, utils.NewPool(runtime.NumCPU()))
+}
+
+func (g *GcloudProvider) GetCostIn(p *resources.Project, pool *utils.Pool) ([][]string, error) {
 	if g.dbHandle == nil {
 		return nil, fmt.Errorf("Provider has not been initialized\n")
 	}
-	return pricing.GetCost(g.dbHandle, p)
+	return pricing.GetCost(g.dbHandle, p, pool)
+}
+
+func (g *GcloudProvider) UseSnapshot(date string) error {
+	if g.dbHandle == nil {
+		return fmt.Errorf("Provider has not been initialized\n")
+	}
+	db, err := snapshot.Open(g.dir, date, cache.LatestSchemaVersion())
+	if err != nil {
+		return err
+	}
+	if err = g.dbHandle.Close(); err != nil {
+		log.Printf("Failed to close price cache: %v\n", err)
+	}
+	g.dbHandle = db
+	return nil
+}
+
+func (g *GcloudProvider) GetPriceChanges(from string, to string) ([][]string, error) {
+	if g.dir == "" {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return snapshot.Changes(g.dir, from, to, cache.LatestSchemaVersion(), cache.ListRates)
+}
+
+func (g *GcloudProvider) GetCacheMetadata() (*metadata.Metadata, error) {
+	if g.dbHandle == nil {
+		return nil, fmt.Errorf("Provider has not been initialized\n")
+	}
+	m, err := metadata.Read(g.dbHandle)
+	if m != nil || err != nil {
+		return m, err
+	}
+	// Caches built before metadata was recorded still know when the
+	// billing services were refreshed.
+	ts, err := cache.GetLastUpdated(g.dbHandle)
+	if err != nil || ts == 0 {
+		return nil, err
+	}
+	return &metadata.Metadata{
+		Refreshed: time.Unix(ts, 0),
+		Source:    "Cloud Billing Catalog API",
+	}, nil
+}
+
+func (g *GcloudProvider) GetSchemaVersion() (int, int, error) {
+	if g.dbHandle == nil {
+		return 0, 0, fmt.Errorf("Provider has not been initialized\n")
+	}
+	return cache.SchemaVersion(g.dbHandle)
 }
 
 func init() {
@@ -50,19 +130,43 @@ func (p *GcloudProvider) Initialize(datadir string) error {
 	if err != nil {
 		return err
 	}
-	dbfile := filepath.Join(mydir, "sku-cache.db")
+	dbfile := filepath.Join(mydir, dbName)
 	db, err := sql.Open("sqlite3", dbfile)
 	if err != nil {
 		return err
 	}
 	p.dbHandle = db
+	p.dir = mydir
 	if p.dbHandle == nil {
 		return fmt.Errorf("Failed to open a database file at %s\n", dbfile)
 	}
+	if err = cache.MigrateDatabase(db); err != nil {
+		return err
+	}
 	runtime.SetFinalizer(p, finalizer)
 	return nil
 }
 
+func (p *GcloudProvider) InitializeReadOnly(datadir string) error {
+	mydir := filepath.Join(datadir, name)
+	dbfile := filepath.Join(mydir, dbName)
+	db, err := sql.Open("sqlite3", "file:"+dbfile+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fmt.Errorf("Failed to open a database file at %s\n", dbfile)
+	}
+	p.dbHandle = db
+	p.dir = mydir
+	runtime.SetFinalizer(p, finalizer)
+	return nil
+}
+
+func (p *GcloudProvider) CacheFile() string {
+	return dbName
+}
+
 func finalizer(p *GcloudProvider) {
 	if p.dbHandle != nil {
 		if err := p.dbHandle.Close(); err != nil {
@@ -0,0 +1,216 @@
+// Package rightsize suggests smaller machine types or fewer instances for
+// gcloud instance sets, based on their usage in Monitoring.
+package rightsize
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/proto"
+	"math"
+	common "nephomancy/common/resources"
+	"nephomancy/common/utils"
+	"nephomancy/gcloud/assets"
+	"nephomancy/gcloud/cache"
+	"nephomancy/gcloud/pricing"
+	"sort"
+)
+
+type Options struct {
+	// The usage suggestions aim for, as a fraction of capacity.
+	// Defaults to 0.6.
+	Target float64
+	// Which percentile of the samples is compared with the target.
+	// Defaults to 0.95.
+	Percentile float64
+}
+
+// A suggestion for one instance set.
+type Suggestion struct {
+	InstanceSet string
+	MachineType string
+	Count       uint32
+	// Usage at the percentile, as fractions of capacity. -1 if unknown.
+	Cpu    float64
+	Memory float64
+	// The same as MachineType and Count if there is nothing to suggest.
+	NewMachineType string
+	NewCount       uint32
+	// What the suggestion is, or why there is none.
+	Note string
+	// Projected monthly costs of the instance set before and after.
+	Cost    float64
+	NewCost float64
+}
+
+// Suggests changes to the instance sets in p that are used less than the
+// target. Returns a copy of p with the suggestions applied.
+func Suggest(db *sql.DB, p *common.Project, metrics []*assets.InstanceMetrics, opts Options) (
+	*common.Project, []Suggestion, error) {
+	if opts.Target <= 0 {
+		opts.Target = 0.6
+	}
+	if opts.Percentile <= 0 {
+		opts.Percentile = 0.95
+	}
+	ret := proto.Clone(p).(*common.Project)
+	suggestions := make([]Suggestion, 0, len(ret.InstanceSets))
+	for idx, vmset := range ret.InstanceSets {
+		s, suggested, err := suggest(db, p.Name, vmset, metrics, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("instance set %s: %v", vmset.Name, err)
+		}
+		if suggested != nil {
+			ret.InstanceSets[idx] = suggested
+		}
+		suggestions = append(suggestions, s)
+	}
+	return ret, suggestions, nil
+}
+
+// Returns the suggestion for an instance set, and the changed instance set
+// if there is something to suggest.
+func suggest(db *sql.DB, projectName string, vmset *common.InstanceSet,
+	metrics []*assets.InstanceMetrics, opts Options) (Suggestion, *common.InstanceSet, error) {
+	s := Suggestion{
+		InstanceSet: vmset.Name,
+		Count:       vmset.Count,
+		NewCount:    vmset.Count,
+		Cpu:         -1,
+		Memory:      -1,
+	}
+	details := vmset.Template.ProviderDetails[assets.GcloudProvider]
+	if details == nil {
+		s.Note = "no gcloud details"
+		return s, nil, nil
+	}
+	var gvm assets.GCloudVM
+	if err := ptypes.UnmarshalAny(details, &gvm); err != nil {
+		return s, nil, err
+	}
+	s.MachineType = gvm.MachineType
+	s.NewMachineType = gvm.MachineType
+	var err error
+	if s.Cost, err = setCost(db, projectName, vmset); err != nil {
+		return s, nil, err
+	}
+	s.NewCost = s.Cost
+
+	if len(gvm.InstanceIds) == 0 {
+		s.Note = "no instance ids, please import the project again"
+		return s, nil, nil
+	}
+	// The samples of all instances in the set.
+	ids := make(map[string]bool)
+	for _, id := range gvm.InstanceIds {
+		ids[id] = true
+	}
+	var cpu, memory []float64
+	found := false
+	for _, m := range metrics {
+		if ids[m.Instance] {
+			cpu = append(cpu, m.Cpu...)
+			memory = append(memory, m.Memory...)
+			found = true
+		}
+	}
+	if !found {
+		s.Note = "no metrics for its instances"
+		return s, nil, nil
+	}
+	s.Cpu = percentile(cpu, opts.Percentile)
+	s.Memory = percentile(memory, opts.Percentile)
+	if s.Cpu < 0 {
+		s.Note = "no cpu metrics"
+		return s, nil, nil
+	}
+	// How much of the set's capacity is needed to stay at the target.
+	load := s.Cpu / opts.Target
+	if s.Memory >= 0 {
+		load = math.Max(load, s.Memory/opts.Target)
+	}
+	if load >= 1 {
+		s.Note = "usage is above the target"
+		return s, nil, nil
+	}
+
+	suggested := proto.Clone(vmset).(*common.InstanceSet)
+	if vmset.Count > 1 {
+		// Spread the load over fewer instances.
+		s.NewCount = uint32(math.Max(1, math.Ceil(float64(vmset.Count)*load)))
+		if s.NewCount == vmset.Count {
+			s.Note = "no instances to spare"
+			return s, nil, nil
+		}
+		suggested.Count = s.NewCount
+		s.Note = fmt.Sprintf("%d instances instead of %d", s.NewCount, vmset.Count)
+	} else {
+		mt, err := cache.GetMachineType(db, gvm.MachineType, gvm.Region)
+		if err != nil {
+			return s, nil, err
+		}
+		spec := proto.Clone(vmset.Template.Type).(*common.MachineType)
+		spec.CpuCount = uint32(math.Max(1, math.Ceil(s.Cpu*float64(mt.CpuCount)/opts.Target)))
+		// Without memory metrics, ask for as much memory as there is.
+		spec.MemoryGb = uint32(mt.MemoryMb / 1000)
+		if s.Memory >= 0 {
+			spec.MemoryGb = uint32(math.Max(1, math.Ceil(
+				s.Memory*float64(mt.MemoryMb)/1000/opts.Target)))
+		}
+		newMt, err := cache.MachineTypeBySpec(db, spec, gvm.Region)
+		if err != nil || newMt == gvm.MachineType {
+			s.Note = "no smaller machine type fits"
+			return s, nil, nil
+		}
+		s.NewMachineType = newMt
+		gvm.MachineType = newMt
+		if suggested.Template.ProviderDetails[assets.GcloudProvider], err = ptypes.MarshalAny(&gvm); err != nil {
+			return s, nil, err
+		}
+		suggested.Template.Type = spec
+		s.Note = fmt.Sprintf("%s instead of %s", newMt, s.MachineType)
+	}
+	if s.NewCost, err = setCost(db, projectName, suggested); err != nil {
+		return s, nil, err
+	}
+	if s.NewCost >= s.Cost {
+		s.Note = fmt.Sprintf("%s would not be cheaper", s.Note)
+		s.NewMachineType = s.MachineType
+		s.NewCount = s.Count
+		s.NewCost = s.Cost
+		return s, nil, nil
+	}
+	return s, suggested, nil
+}
+
+// Returns the projected monthly cost of an instance set.
+func setCost(db *sql.DB, projectName string, vmset *common.InstanceSet) (float64, error) {
+	// A single set has nothing to price concurrently.
+	lines, err := pricing.GetCost(db, &common.Project{
+		Name:         projectName,
+		InstanceSets: []*common.InstanceSet{vmset},
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	_, projected := utils.SumCosts(lines)
+	cost := 0.0
+	for _, amount := range projected {
+		cost += amount
+	}
+	return cost, nil
+}
+
+// Returns the p-th percentile of the samples, or -1 if there are none.
+func percentile(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return -1
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
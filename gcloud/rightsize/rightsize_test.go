@@ -0,0 +1,104 @@
+package rightsize
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/ptypes"
+	"io/ioutil"
+	"nephomancy/gcloud/assets"
+	"nephomancy/gcloud/cache"
+	"nephomancy/gcloud/fake"
+	"testing"
+	"time"
+)
+
+func TestSuggest(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	assets.Endpoint = server.URL
+	defer func() { assets.Endpoint = "" }()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err = cache.MigrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.PopulateDatabase(context.Background(), db, fake.Project); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile("testdata/imported-project.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := assets.UnmarshalProject(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now()
+	metrics, err := assets.GetInstanceMetrics(context.Background(),
+		&assets.RecordedMonitoring{Dir: fake.MonitoringDir()}, fake.Project,
+		end.Add(-7*24*time.Hour), end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suggested, suggestions, err := Suggest(db, p, metrics, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		Set, MachineType string
+		Count            uint32
+		Note             string
+		Cheaper          bool
+	}
+	var got []summary
+	for _, s := range suggestions {
+		got = append(got, summary{s.InstanceSet, s.NewMachineType, s.NewCount, s.Note,
+			s.NewCost < s.Cost})
+	}
+	want := []summary{
+		{"web", "n1-standard-2", 2, "2 instances instead of 3", true},
+		{"db", "n1-standard-1", 1, "n1-standard-1 instead of n1-standard-2", true},
+		{"batch", "e2-standard-2", 1, "usage is above the target", false},
+		{"idle", "n1-standard-1", 1, "no metrics for its instances", false},
+	}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Error(diff)
+	}
+	if s := suggestions[1]; s.Cpu != 0.15 || s.Memory != 0.2 {
+		t.Errorf("unexpected usage for db: %+v", s)
+	}
+
+	if suggested.InstanceSets[0].Count != 2 || p.InstanceSets[0].Count != 3 {
+		t.Errorf("expected only the suggested project to have 2 web instances")
+	}
+	var gvm assets.GCloudVM
+	if err = suggested.InstanceSets[1].Template.ProviderDetails[assets.GcloudProvider].UnmarshalTo(&gvm); err != nil {
+		t.Fatal(err)
+	}
+	if gvm.MachineType != "n1-standard-1" || gvm.Zone != "europe-west1-c" {
+		t.Errorf("unexpected details for db: %v", &gvm)
+	}
+	if ct := suggested.InstanceSets[1].Template.Type; ct.CpuCount != 1 || ct.MemoryGb != 3 {
+		t.Errorf("unexpected spec for db: %v", ct)
+	}
+
+	// Sets of the same machine type in the same zone keep their own metrics.
+	gvm.MachineType = "n1-standard-2"
+	gvm.Zone = "europe-west1-b"
+	if p.InstanceSets[1].Template.ProviderDetails[assets.GcloudProvider], err = ptypes.MarshalAny(&gvm); err != nil {
+		t.Fatal(err)
+	}
+	if _, suggestions, err = Suggest(db, p, metrics, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if web, dbs := suggestions[0], suggestions[1]; web.NewCount != 2 || dbs.Cpu != 0.15 {
+		t.Errorf("unexpected suggestions for web and db in the same zone: %+v %+v", web, dbs)
+	}
+}
@@ -14,5 +14,6 @@ require (
 	golang.org/x/tools v0.1.0 // indirect
 	google.golang.org/api v0.36.0
 	google.golang.org/genproto v0.0.0-20201201144952-b05cb90ed32e
+	google.golang.org/grpc v1.33.2
 	google.golang.org/protobuf v1.25.0
 )
@@ -20,9 +20,30 @@ func main() {
 		"cost": func() (cli.Command, error) {
 			return &command.CostCommand{}, nil
 		},
+		"optimize": func() (cli.Command, error) {
+			return &command.OptimizeCommand{}, nil
+		},
+		"prices": func() (cli.Command, error) {
+			return &command.PricesCommand{}, nil
+		},
+		"cache export": func() (cli.Command, error) {
+			return &command.CacheExportCommand{}, nil
+		},
+		"cache import": func() (cli.Command, error) {
+			return &command.CacheImportCommand{}, nil
+		},
+		"cache status": func() (cli.Command, error) {
+			return &command.CacheStatusCommand{}, nil
+		},
 		"aws init": func() (cli.Command, error) {
 			return &awscmds.InitCommand{}, nil
 		},
+		"aws assets": func() (cli.Command, error) {
+			return &awscmds.AssetsCommand{}, nil
+		},
+		"aws billing": func() (cli.Command, error) {
+			return &awscmds.BillingCommand{}, nil
+		},
 		"aws regions": func() (cli.Command, error) {
 			return &awscmds.RegionsCommand{}, nil
 		},
@@ -38,6 +59,12 @@ func main() {
 		"gcloud assets": func() (cli.Command, error) {
 			return &gcmds.AssetsCommand{}, nil
 		},
+		"gcloud billing": func() (cli.Command, error) {
+			return &gcmds.BillingCommand{}, nil
+		},
+		"gcloud rightsize": func() (cli.Command, error) {
+			return &gcmds.RightsizeCommand{}, nil
+		},
 		"gcloud services": func() (cli.Command, error) {
 			return &gcmds.ServicesCommand{}, nil
 		},